@@ -3,6 +3,8 @@ package main
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/yousaling0624/database-course-project/backend/internal/api"
+	"github.com/yousaling0624/database-course-project/backend/internal/auth"
+	"github.com/yousaling0624/database-course-project/backend/internal/config"
 	"github.com/yousaling0624/database-course-project/backend/internal/database"
 )
 
@@ -10,6 +12,25 @@ func main() {
 	// Connect to Database
 	database.Connect()
 
+	// Initialize token signing (config is loaded by Connect)
+	var authCfg *config.AuthConfig
+	if cfg := config.Get(); cfg != nil {
+		authCfg = &cfg.Auth
+	}
+	auth.Init(authCfg)
+
+	// Payment provider for card, wallet and insurance tenders
+	api.InstallPaymentProvider()
+
+	// Interaction and allergy rules for checkout screening
+	api.LoadSafetyRules()
+
+	// Apply scheduled price changes as they fall due
+	api.StartPriceScheduler()
+
+	// Release lapsed stock reservations of held orders and expire old quotes
+	api.StartDraftScheduler()
+
 	// Initialize Router
 	r := gin.Default()
 
@@ -32,79 +53,199 @@ func main() {
 	// Routes
 	apiGroup := r.Group("/api")
 	{
-		// Auth
+		// Auth (public)
 		apiGroup.POST("/login", api.Login)
+		apiGroup.POST("/auth/refresh", api.RefreshToken)
+	}
+
+	// Everything else requires a valid access token; each group below is
+	// further restricted by the permission matrix in internal/auth
+	authed := apiGroup.Group("")
+	authed.Use(api.AuthRequired())
+	authed.POST("/logout", api.Logout)
 
+	// Read-only business data (admin, staff, viewer)
+	view := authed.Group("", api.RequirePermission(auth.PermViewData))
+	{
 		// Dashboard
-		apiGroup.GET("/dashboard/stats", api.GetStats)
-
-		// Users
-		apiGroup.GET("/users", api.GetUsers)
-		apiGroup.POST("/users", api.CreateUser)
-		apiGroup.PUT("/users/:id", api.UpdateUser)
-		apiGroup.DELETE("/users/:id", api.DeleteUser)
-
-		// Medicines
-		apiGroup.GET("/medicines", api.GetMedicines)
-		apiGroup.POST("/medicines", api.CreateMedicine)
-		apiGroup.PUT("/medicines/:id", api.UpdateMedicine)
-		apiGroup.DELETE("/medicines/:id", api.DeleteMedicine)
-
-		// Customers
-		apiGroup.GET("/customers", api.GetCustomers)
-		apiGroup.POST("/customers", api.CreateCustomer)
-		apiGroup.PUT("/customers/:id", api.UpdateCustomer)
-		apiGroup.DELETE("/customers/:id", api.DeleteCustomer)
-
-		// Suppliers
-		apiGroup.GET("/suppliers", api.GetSuppliers)
-		apiGroup.POST("/suppliers", api.CreateSupplier)
-		apiGroup.PUT("/suppliers/:id", api.UpdateSupplier)
-		apiGroup.DELETE("/suppliers/:id", api.DeleteSupplier)
-
-		// Inbounds
-		apiGroup.GET("/inbounds", api.GetInbounds)
-		apiGroup.POST("/inbounds", api.CreateInbound)
-		apiGroup.PUT("/inbounds/:id", api.UpdateInbound)
-		apiGroup.DELETE("/inbounds/:id", api.DeleteInbound)
-
-		// Sales
-		apiGroup.GET("/sales", api.GetSales)
-		apiGroup.POST("/sales", api.CreateSale)
-		apiGroup.PUT("/sales/:id", api.UpdateSale)
-		apiGroup.DELETE("/sales/:id", api.DeleteSale)
+		view.GET("/dashboard/stats", api.GetStats)
+
+		view.GET("/medicines", api.GetMedicines)
+		view.GET("/medicines/:id/lots", api.GetMedicineLots)
+		view.GET("/medicines/:id/movements", api.GetMedicineMovements)
+		view.GET("/medicines/:id/prices", api.GetMedicinePrices)
+		view.GET("/stocktakes", api.GetStocktakes)
+		view.GET("/stocktakes/:id", api.GetStocktake)
+		view.GET("/stocktakes/:id/variance", api.GetStocktakeVariance)
+		view.GET("/customers", api.GetCustomers)
+		view.GET("/suppliers", api.GetSuppliers)
+		view.GET("/inbounds", api.GetInbounds)
+		view.GET("/sales", api.GetSales)
+		view.GET("/orders", api.GetOrders)
+		view.GET("/orders/:order_no", api.GetOrder)
+		view.GET("/sales/:order_id/receipt", api.GetSaleReceipt)
+		view.GET("/sales/:order_id/invoice", api.GetSaleInvoice)
+		view.GET("/returns/sales", api.GetSalesReturns)
+		view.GET("/returns/purchase", api.GetPurchaseReturns)
+		view.GET("/prescriptions", api.GetPrescriptions)
+		view.GET("/prescriptions/:id", api.GetPrescription)
+		view.GET("/prescriptions/:id/image", api.GetPrescriptionImage)
+		view.GET("/customers/:id/health", api.GetCustomerHealth)
+		view.GET("/customers/:id/points", api.GetCustomerPoints)
+		view.GET("/safety/acknowledgements", api.GetSafetyAcknowledgements)
+		view.GET("/recalls", api.GetRecalls)
+		view.GET("/recalls/:id/customers", api.GetRecallCustomers)
+		view.GET("/reports/recalls/:id", api.GetRecallReport)
+		view.GET("/trace/:code", api.GetTraceCode)
+		view.GET("/reports/trace-codes", api.ExportTraceCodes)
+		view.GET("/reports/price-changes", api.GetPriceImpactReport)
+		view.GET("/promotions", api.GetPromotions)
+		view.GET("/reports/promotions", api.GetPromotionReport)
+		view.GET("/shifts", api.GetShifts)
+		view.GET("/shifts/:id/report", api.GetShiftReport)
+		view.GET("/drafts", api.GetDrafts)
+		view.GET("/drafts/:id", api.GetDraft)
+		view.GET("/voids", api.GetSaleVoids)
+		view.GET("/reports/voids", api.GetVoidReport)
 
 		// Reports
-		apiGroup.GET("/reports/inbound", api.GetInboundReport)
-		apiGroup.GET("/reports/inventory", api.GetInventoryReport)
-		apiGroup.GET("/reports/sales", api.GetSalesReport)
-		apiGroup.GET("/reports/financial", api.GetFinancialReport)
+		view.GET("/reports/inbound", api.GetInboundReport)
+		view.GET("/reports/inventory", api.GetInventoryReport)
+		view.GET("/reports/sales", api.GetSalesReport)
+		view.GET("/reports/financial", api.GetFinancialReport)
+		view.GET("/reports/expiry", api.GetExpiryReport)
+		view.GET("/alerts/expiry", api.GetExpiryAlerts)
+		view.GET("/purchasing/suggestions", api.GetPurchaseSuggestions)
+		view.GET("/purchase-orders", api.GetPurchaseOrders)
+		view.GET("/purchase-orders/:id", api.GetPurchaseOrder)
+		view.GET("/reports/purchase-orders", api.GetPurchaseOrderReport)
+
+		// Fuzzy Search
+		view.GET("/search/customers", api.SearchCustomers)
+		view.GET("/search/suppliers", api.SearchSuppliers)
 
-		// Returns
-		apiGroup.POST("/returns/sales", api.CreateSalesReturn)
-		apiGroup.POST("/returns/purchase", api.CreatePurchaseReturn)
+		// Analysis
+		view.GET("/analysis/top-selling", api.GetTopSellingAnalysis)
+		view.GET("/analysis/trend", api.GetSalesTrendAnalysis)
 
-		// Stock Adjustment
-		apiGroup.POST("/stock/adjust", api.AdjustStock)
+		view.GET("/system/database/status", api.GetDatabaseStatus)
+	}
 
-		// System Maintenance
-		apiGroup.GET("/system/backup", api.BackupDatabase)
-		apiGroup.POST("/system/restore", api.RestoreDatabase)
+	// Sales counter (admin, staff)
+	sales := authed.Group("", api.RequirePermission(auth.PermSalesEntry))
+	{
+		sales.POST("/sales", api.CreateSale)
+		sales.POST("/sales/check", api.CheckSale)
+		sales.POST("/returns/sales", api.CreateSalesReturn)
+		sales.POST("/prescriptions", api.CreatePrescription)
+		sales.POST("/prescriptions/:id/image", api.UploadPrescriptionImage)
+		sales.POST("/shifts/open", api.OpenShift)
+		sales.GET("/shifts/current", api.GetCurrentShift)
+		sales.POST("/shifts/:id/close", api.CloseShift)
+		sales.POST("/drafts", api.CreateDraft)
+		sales.PUT("/drafts/:id", api.UpdateDraft)
+		sales.POST("/drafts/:id/cancel", api.CancelDraft)
+		sales.POST("/drafts/:id/checkout", api.CheckoutDraft)
+		sales.POST("/sales/:id/void", api.RequestSaleVoid)
+	}
 
-		// Fuzzy Search
-		apiGroup.GET("/search/users", api.SearchUsers)
-		apiGroup.GET("/search/customers", api.SearchCustomers)
-		apiGroup.GET("/search/suppliers", api.SearchSuppliers)
+	// Prescription verification (admin, staff)
+	rx := authed.Group("", api.RequirePermission(auth.PermRxVerify))
+	{
+		rx.POST("/prescriptions/:id/verify", api.VerifyPrescription)
+	}
 
-		// Database Configuration (admin only)
-		apiGroup.GET("/system/database/status", api.GetDatabaseStatus)
-		apiGroup.GET("/system/database", api.GetDatabaseConfig)
-		apiGroup.POST("/system/database", api.UpdateDatabaseConfig)
-		apiGroup.POST("/system/database/test", api.TestDatabaseConfig)
+	// Goods receiving (admin, staff)
+	inbound := authed.Group("", api.RequirePermission(auth.PermInboundEntry))
+	{
+		inbound.POST("/inbounds", api.CreateInbound)
+		inbound.POST("/returns/purchase", api.CreatePurchaseReturn)
+		inbound.POST("/purchase-orders", api.CreatePurchaseOrder)
+		inbound.PUT("/purchase-orders/:id", api.UpdatePurchaseOrder)
+	}
 
-		// Analysis
-		apiGroup.GET("/analysis/top-selling", api.GetTopSellingAnalysis)
-		apiGroup.GET("/analysis/trend", api.GetSalesTrendAnalysis)
+	// Purchase order approval (admin only)
+	purchase := authed.Group("", api.RequirePermission(auth.PermPurchaseApprove))
+	{
+		purchase.POST("/purchase-orders/:id/approve", api.ApprovePurchaseOrder)
+		purchase.POST("/purchase-orders/:id/close", api.ClosePurchaseOrder)
+		purchase.POST("/returns/purchase/:id/credit", api.CreditPurchaseReturn)
+	}
+
+	// Master data maintenance (admin, staff)
+	master := authed.Group("", api.RequirePermission(auth.PermMasterData))
+	{
+		master.POST("/medicines", api.CreateMedicine)
+		master.PUT("/medicines/:id", api.UpdateMedicine)
+		master.POST("/medicines/:id/prices", api.SchedulePriceChange)
+		master.POST("/medicines/:id/prices/:price_id/cancel", api.CancelPriceChange)
+		master.POST("/customers", api.CreateCustomer)
+		master.PUT("/customers/:id", api.UpdateCustomer)
+		master.PUT("/customers/:id/health", api.UpdateCustomerHealth)
+		master.POST("/customers/tiers/refresh", api.RefreshMemberTiers)
+		master.POST("/suppliers", api.CreateSupplier)
+		master.PUT("/suppliers/:id", api.UpdateSupplier)
+		master.POST("/promotions", api.CreatePromotion)
+		master.PUT("/promotions/:id", api.UpdatePromotion)
+	}
+
+	// Master data removal (admin only)
+	masterDelete := authed.Group("", api.RequirePermission(auth.PermMasterDelete))
+	{
+		masterDelete.DELETE("/medicines/:id", api.DeleteMedicine)
+		masterDelete.DELETE("/customers/:id", api.DeleteCustomer)
+		masterDelete.DELETE("/suppliers/:id", api.DeleteSupplier)
+		masterDelete.DELETE("/promotions/:id", api.DeletePromotion)
+	}
+
+	// Historical corrections (admin only)
+	history := authed.Group("", api.RequirePermission(auth.PermHistoryEdit))
+	{
+		history.PUT("/sales/:id", api.UpdateSale)
+		history.DELETE("/sales/:id", api.DeleteSale)
+		history.POST("/voids/:id/approve", api.ApproveSaleVoid)
+		history.POST("/voids/:id/reject", api.RejectSaleVoid)
+		history.PUT("/inbounds/:id", api.UpdateInbound)
+		history.DELETE("/inbounds/:id", api.DeleteInbound)
+	}
+
+	// Stocktake counting
+	count := authed.Group("", api.RequirePermission(auth.PermStockCount))
+	{
+		count.POST("/stocktakes", api.CreateStocktake)
+		count.PUT("/stocktakes/:id/counts", api.RecordStocktakeCounts)
+	}
+
+	// Stock Adjustment and stocktake approval (admin only)
+	stock := authed.Group("", api.RequirePermission(auth.PermStockAdjust))
+	{
+		stock.POST("/stock/adjust", api.AdjustStock)
+		stock.POST("/stocktakes/:id/approve", api.ApproveStocktake)
+		stock.POST("/stocktakes/:id/cancel", api.CancelStocktake)
+		stock.POST("/recalls", api.CreateRecall)
+		stock.POST("/recalls/:id/return", api.CreateRecallReturn)
+		stock.POST("/recalls/:id/close", api.CloseRecall)
+	}
+
+	// User management (admin only)
+	users := authed.Group("", api.RequirePermission(auth.PermManageUsers))
+	{
+		users.GET("/users", api.GetUsers)
+		users.POST("/users", api.CreateUser)
+		users.PUT("/users/:id", api.UpdateUser)
+		users.DELETE("/users/:id", api.DeleteUser)
+		users.GET("/search/users", api.SearchUsers)
+	}
+
+	// System maintenance and database configuration (admin only)
+	system := authed.Group("", api.RequirePermission(auth.PermSystem))
+	{
+		system.GET("/system/backup", api.BackupDatabase)
+		system.POST("/system/restore", api.RestoreDatabase)
+		system.GET("/system/database", api.GetDatabaseConfig)
+		system.POST("/system/database", api.UpdateDatabaseConfig)
+		system.POST("/system/database/test", api.TestDatabaseConfig)
+		system.POST("/system/safety/reload", api.ReloadSafetyRules)
 	}
 
 	// Start Server
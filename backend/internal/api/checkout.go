@@ -0,0 +1,386 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ==================== Checkout ====================
+
+// basketLine is one requested line of a sales basket
+type basketLine struct {
+	MedicineID int64    `json:"medicine_id"`
+	Quantity   int      `json:"quantity"`
+	TraceCodes []string `json:"trace_codes"` // 药品追溯码 scanned per box
+}
+
+// checkoutRequest is the body of POST /api/sales.
+// MedicineID/Quantity are still accepted for single-item sales.
+type checkoutRequest struct {
+	CustomerID    int64        `json:"customer_id"`
+	PaymentMethod string       `json:"payment_method"`
+	Items         []basketLine `json:"items"`
+	// PrescriptionID is required when the basket contains 处方药
+	PrescriptionID int64 `json:"prescription_id"`
+	// AcknowledgeWarnings confirms the pharmacist has reviewed the safety warnings
+	AcknowledgeWarnings bool `json:"acknowledge_warnings"`
+	// RedeemPoints pays part of the order with the member's loyalty points;
+	// payment_method "points" with no amount pays the whole order
+	RedeemPoints int `json:"redeem_points"`
+	// Payments splits the amount due across tenders; empty takes it all in PaymentMethod
+	Payments []tenderLine `json:"payments"`
+
+	MedicineID int64 `json:"medicine_id"`
+	Quantity   int   `json:"quantity"`
+
+	// draftID is the held order being checked out, whose own reservation
+	// does not count against it
+	draftID int64
+
+	// Provider payments are charged before the order is placed: a quoting
+	// pass prices the basket and is rolled back, the charges are taken with
+	// no rows locked, then the order is placed as orderNo with them (charged)
+	quoting bool
+	orderNo string
+	charged []model.Payment
+}
+
+// lines returns the basket, folding the legacy single-item fields into it
+func (r *checkoutRequest) lines() []basketLine {
+	if len(r.Items) == 0 && r.MedicineID != 0 {
+		return []basketLine{{MedicineID: r.MedicineID, Quantity: r.Quantity}}
+	}
+	return r.Items
+}
+
+// CreateSale places a multi-line order atomically: either every line is
+// recorded (and the stock triggers fire per line) or nothing is.
+func CreateSale(c *gin.Context) {
+	var req checkoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	submitSale(c, &req, nil)
+}
+
+// errQuoted rolls back the quoting pass of a checkout
+var errQuoted = errors.New("checkout quoted")
+
+// submitSale screens and places an order and writes the response. after, if
+// set, runs in the same transaction once the order is placed.
+func submitSale(c *gin.Context, req *checkoutRequest, after func(tx *gorm.DB, order *model.Order) error) {
+	warnings, ok := checkSafety(c, req)
+	if !ok {
+		return
+	}
+
+	if wantsExternal(req) {
+		if err := chargeUpfront(c, req); err != nil {
+			respondError(c, err)
+			return
+		}
+	}
+
+	var order *model.Order
+	err := stockTransaction(func(tx *gorm.DB) error {
+		if err := setStockContext(tx, currentUserID(c), "", 0, ""); err != nil {
+			return err
+		}
+		var err error
+		if order, err = placeOrder(tx, req, currentUserID(c)); err != nil {
+			return err
+		}
+		if len(warnings) > 0 {
+			if err := recordAcknowledgement(tx, order, warnings, currentUserID(c)); err != nil {
+				return err
+			}
+		}
+		if after != nil {
+			return after(tx, order)
+		}
+		return nil
+	})
+	if err != nil {
+		// Nothing was placed, give back what was charged for it
+		voidPayments(req.charged)
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, order)
+}
+
+// chargeUpfront prices the basket in a transaction that is rolled back, then
+// charges the provider payments it comes to with no locks held
+func chargeUpfront(c *gin.Context, req *checkoutRequest) error {
+	var quoted *model.Order
+	req.quoting = true
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if quoted, err = placeOrder(tx, req, currentUserID(c)); err != nil {
+			return err
+		}
+		return errQuoted
+	})
+	req.quoting = false
+	if !errors.Is(err, errQuoted) {
+		return err
+	}
+	if err := chargePayments(quoted.Payments); err != nil {
+		return err
+	}
+	req.orderNo = quoted.OrderNo
+	req.charged = quoted.Payments
+	return nil
+}
+
+// placeOrder creates the order header and one sales row per basket line
+func placeOrder(tx *gorm.DB, req *checkoutRequest, cashierID int64) (*model.Order, error) {
+	lines := req.lines()
+	if len(lines) == 0 {
+		return nil, newAPIError(http.StatusBadRequest, "Basket is empty")
+	}
+
+	paymentMethod := req.PaymentMethod
+	if paymentMethod == "" {
+		paymentMethod = "cash"
+	}
+	shift, err := activeShift(tx, cashierID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lock every medicine at its current price and work out the promotions
+	// before anything is written, since threshold discounts span the basket
+	priced := make([]*pricedLine, 0, len(lines))
+	for i, line := range lines {
+		if line.Quantity <= 0 {
+			return nil, newAPIError(http.StatusBadRequest, "Line %d: quantity must be positive", i+1)
+		}
+		// Lock the medicine row so concurrent orders see each other's deductions
+		var med model.Medicine
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&med, line.MedicineID).Error; err != nil {
+			return nil, newAPIError(http.StatusBadRequest, "Line %d: medicine not found", i+1)
+		}
+		// Apply a scheduled price change that is due but not yet picked up
+		if err := currentPrice(tx, &med); err != nil {
+			return nil, err
+		}
+		priced = append(priced, &pricedLine{
+			med:      med,
+			quantity: line.Quantity,
+			gross:    roundMoney(med.Price * float64(line.Quantity)),
+		})
+	}
+	if err := checkReservations(tx, priced, req.draftID); err != nil {
+		return nil, err
+	}
+	if err := applyPromotions(tx, req.CustomerID, priced); err != nil {
+		return nil, err
+	}
+
+	order := &model.Order{
+		CustomerID:    req.CustomerID,
+		CashierID:     cashierID,
+		PaymentMethod: paymentMethod,
+		Status:        "completed",
+		ShiftID:       shiftIDOf(shift),
+	}
+	if req.orderNo != "" {
+		// The number the provider payments were charged under
+		order.OrderNo = req.orderNo
+		if err := tx.Create(order).Error; err != nil {
+			return nil, err
+		}
+	} else if err := createOrderHeader(tx, order); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var rx *model.Prescription
+	for i, line := range lines {
+		traceCodes, err := cleanTraceCodes(line.TraceCodes, line.Quantity)
+		if err != nil {
+			return nil, err
+		}
+
+		// Re-read the locked row: an earlier line of the same medicine has
+		// already changed its stock
+		p := priced[i]
+		var med model.Medicine
+		if err := tx.First(&med, p.med.ID).Error; err != nil {
+			return nil, err
+		}
+		if med.Status == medicineStatusRecalled {
+			return nil, newAPIError(http.StatusBadRequest, "%s 已被召回，禁止销售", med.Name)
+		}
+		if med.Type == medicineTypeRx && rx == nil {
+			if rx, err = requirePrescription(tx, req, &med); err != nil {
+				return nil, err
+			}
+		}
+
+		allocs, err := allocateLots(tx, &med, line.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		unitCost, err := saleUnitCost(tx, &med, allocs, line.Quantity)
+		if err != nil {
+			return nil, err
+		}
+
+		// Stock check and update are handled per line by the database triggers
+		// tr_before_sale_check_stock and tr_after_sale_insert
+		sale := model.Sales{
+			OrderID:    order.OrderNo,
+			MedicineID: med.ID,
+			CustomerID: req.CustomerID,
+			Quantity:   line.Quantity,
+			UnitPrice:  med.Price,
+			TotalPrice: roundMoney(p.net()),
+			SaleDate:   now,
+			UnitCost:   roundCost(unitCost),
+			CostAmount: roundMoney(unitCost * float64(line.Quantity)),
+
+			DiscountAmount: p.discount,
+		}
+		if err := tx.Create(&sale).Error; err != nil {
+			if msg, ok := signalMessage(err); ok {
+				return nil, newAPIError(http.StatusBadRequest, "%s: %s", med.Name, msg)
+			}
+			return nil, err
+		}
+		if err := consumeLots(tx, sale.ID, allocs); err != nil {
+			return nil, err
+		}
+		if err := dispenseTraceCodes(tx, &sale, traceCodes, cashierID); err != nil {
+			return nil, err
+		}
+		for _, usage := range p.usages {
+			usage.OrderNo = order.OrderNo
+			usage.SaleID = sale.ID
+			usage.CustomerID = req.CustomerID
+			if err := tx.Create(&usage).Error; err != nil {
+				return nil, err
+			}
+			order.Promotions = append(order.Promotions, usage)
+		}
+
+		order.Items = append(order.Items, sale)
+		order.ItemCount++
+		order.TotalQuantity += sale.Quantity
+		order.TotalAmount += sale.TotalPrice
+		order.DiscountAmount += sale.DiscountAmount
+	}
+
+	order.TotalAmount = roundMoney(order.TotalAmount)
+	order.DiscountAmount = roundMoney(order.DiscountAmount)
+	if req.RedeemPoints == 0 {
+		if amount := pointsTendered(req); amount > 0 {
+			req.RedeemPoints = pointsFor(amount)
+		}
+	}
+	if err := settlePoints(tx, req, order, cashierID); err != nil {
+		return nil, err
+	}
+	if rx != nil {
+		order.PrescriptionID = &rx.ID
+		if err := dispensePrescription(tx, rx, order.OrderNo); err != nil {
+			return nil, err
+		}
+	}
+	if err := recordPayments(tx, req, order); err != nil {
+		return nil, err
+	}
+	if err := tx.Model(order).Select("item_count", "total_quantity", "total_amount", "discount_amount", "paid_amount", "prescription_id",
+		"payment_method", "points_redeemed", "points_amount", "points_earned").Updates(order).Error; err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// createOrderHeader inserts the order with a fresh order number
+func createOrderHeader(tx *gorm.DB, order *model.Order) error {
+	return createNumbered(tx, order, "ORD", func(no string) { order.OrderNo = no })
+}
+
+// createNumbered inserts a record that carries a document number. Numbers are
+// time based with a random suffix; the unique index on the number column is
+// the guarantee, and a collision simply retries with a new suffix.
+func createNumbered(tx *gorm.DB, record any, prefix string, setNo func(string)) error {
+	const maxAttempts = 5
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		setNo(fmt.Sprintf("%s%s%04d", prefix, time.Now().Format("20060102150405"), rand.Intn(10000)))
+		err := tx.Create(record).Error
+		if err == nil {
+			return nil
+		}
+		if !isDuplicateKey(err) {
+			return err
+		}
+	}
+	return newAPIError(http.StatusServiceUnavailable, "Could not allocate a document number, please retry")
+}
+
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// ==================== Orders ====================
+
+// GetOrders lists order headers, newest first
+func GetOrders(c *gin.Context) {
+	page, limit, offset := getPaginationParams(c)
+	keyword := c.Query("keyword")
+
+	query := database.DB.Model(&model.Order{})
+	if keyword != "" {
+		query = query.Where("order_no LIKE ?", "%"+keyword+"%")
+	}
+
+	var total int64
+	query.Count(&total)
+
+	orders := make([]model.Order, 0)
+	if err := query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&orders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	totalPages := math.Ceil(float64(total) / float64(limit))
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": orders,
+		"meta": Pagination{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(totalPages),
+		},
+	})
+}
+
+// GetOrder returns one order header with its lines
+func GetOrder(c *gin.Context) {
+	var order model.Order
+	if err := database.DB.Where("order_no = ?", c.Param("order_no")).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	database.DB.Preload("Medicine").Where("order_id = ?", order.OrderNo).Order("id").Find(&order.Items)
+	database.DB.Where("order_no = ?", order.OrderNo).Order("id").Find(&order.Promotions)
+	database.DB.Where("order_no = ?", order.OrderNo).Order("id").Find(&order.Payments)
+	c.JSON(http.StatusOK, order)
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"github.com/yousaling0624/database-course-project/backend/internal/payment"
+)
+
+// useFake installs a fresh fake provider for one test
+func useFake(t *testing.T) *payment.Fake {
+	t.Helper()
+	fake := payment.NewFake()
+	prev := payment.Current()
+	payment.Use(fake)
+	t.Cleanup(func() { payment.Use(prev) })
+	return fake
+}
+
+func wantStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.Status != status {
+		t.Fatalf("got error %v, want status %d", err, status)
+	}
+}
+
+func TestSplitPaymentApproved(t *testing.T) {
+	fake := useFake(t)
+	req := &checkoutRequest{Payments: []tenderLine{
+		{Tender: payment.TenderWeChat, Amount: 30, Reference: "134567890123456789"},
+		{Tender: payment.TenderCard, Reference: "6222020000000000"},
+	}}
+	order := &model.Order{OrderNo: "ORD1", PaidAmount: 100}
+
+	payments, err := planPayments(req, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 2 || payments[1].Amount != 70 {
+		t.Fatalf("card should take the balance of 70, got %+v", payments)
+	}
+	if err := chargePayments(payments); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Charges) != 2 {
+		t.Fatalf("got %d charges, want 2", len(fake.Charges))
+	}
+	for _, p := range payments {
+		if p.Provider != "fake" || p.ProviderRef == "" {
+			t.Fatalf("payment %s was not captured: %+v", p.Tender, p)
+		}
+	}
+	if len(fake.Voided) != 0 {
+		t.Fatalf("nothing should be voided, got %v", fake.Voided)
+	}
+}
+
+func TestDeclinedTenderVoidsEarlierPayments(t *testing.T) {
+	fake := useFake(t)
+	fake.Decline = map[string]bool{payment.TenderCard: true}
+	req := &checkoutRequest{Payments: []tenderLine{
+		{Tender: payment.TenderAlipay, Amount: 20, Reference: "284567890123456789"},
+		{Tender: payment.TenderCash, Amount: 10},
+		{Tender: payment.TenderCard, Reference: "6222020000000000"},
+	}}
+	order := &model.Order{OrderNo: "ORD2", PaidAmount: 50}
+
+	payments, err := planPayments(req, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, chargePayments(payments), http.StatusPaymentRequired)
+	if len(fake.Charges) != 1 {
+		t.Fatalf("got %d charges, want only the alipay one", len(fake.Charges))
+	}
+	if len(fake.Voided) != 1 || fake.Voided[0] != payments[0].ProviderRef {
+		t.Fatalf("the alipay payment should be voided, got %v", fake.Voided)
+	}
+}
+
+func TestChangedAmountRefusesOrder(t *testing.T) {
+	useFake(t)
+	req := &checkoutRequest{
+		PaymentMethod: payment.TenderCard,
+		charged:       []model.Payment{{Tender: payment.TenderCard, Amount: 12, ProviderRef: "FAKE1"}},
+	}
+	if !wantsExternal(req) {
+		t.Fatal("a card order must be charged before it is placed")
+	}
+	// The price went up between charging and placing the order
+	order := &model.Order{OrderNo: "ORD3", PaidAmount: 15, PaymentMethod: payment.TenderCard}
+	wantStatus(t, recordPayments(nil, req, order), http.StatusConflict)
+}
+
+func TestCashChange(t *testing.T) {
+	useFake(t)
+	req := &checkoutRequest{Payments: []tenderLine{{Tender: payment.TenderCash, Tendered: 100}}}
+	payments, err := planPayments(req, &model.Order{PaidAmount: 63.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 {
+		t.Fatalf("got %d payments, want 1", len(payments))
+	}
+	p := payments[0]
+	if p.Amount != 63.5 || p.Tendered != 100 || p.ChangeAmount != 36.5 {
+		t.Fatalf("got amount %.2f tendered %.2f change %.2f", p.Amount, p.Tendered, p.ChangeAmount)
+	}
+	if wantsExternal(req) {
+		t.Fatal("a cash order needs no provider")
+	}
+
+	req = &checkoutRequest{Payments: []tenderLine{{Tender: payment.TenderCash, Tendered: 50}}}
+	_, err = planPayments(req, &model.Order{PaidAmount: 63.5})
+	wantStatus(t, err, http.StatusBadRequest)
+}
+
+func TestManualPaymentNeedsReference(t *testing.T) {
+	prev := payment.Current()
+	payment.Use(payment.NewManual())
+	t.Cleanup(func() { payment.Use(prev) })
+
+	payments := []model.Payment{{OrderNo: "ORD4", Tender: payment.TenderCard, Amount: 20}}
+	wantStatus(t, chargePayments(payments), http.StatusBadRequest)
+
+	payments[0].Reference = "POS0042"
+	if err := chargePayments(payments); err != nil {
+		t.Fatal(err)
+	}
+	if payments[0].Provider != "manual" || payments[0].ProviderRef != "POS0042" {
+		t.Fatalf("got %+v, want the terminal reference recorded", payments[0])
+	}
+}
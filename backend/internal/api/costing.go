@@ -0,0 +1,59 @@
+package api
+
+import (
+	"math"
+
+	"github.com/yousaling0624/database-course-project/backend/internal/config"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// ==================== Costing (成本核算) ====================
+
+// saleUnitCost works out the unit cost of a sales line at the moment it is
+// sold, so profit reports use recorded COGS rather than estimates.
+//
+// Under weighted_average every unit costs the medicine's moving average,
+// which the stock triggers keep up to date on each receipt and return. Under
+// fifo each unit costs what its allocated lot cost; units drawn from stock
+// received before lot tracking existed fall back to the moving average.
+func saleUnitCost(tx *gorm.DB, med *model.Medicine, allocs []lotAllocation, qty int) (float64, error) {
+	avg := med.AvgCost
+	if avg == 0 {
+		// Never costed (e.g. opening stock): use the latest purchase price
+		var last model.Inbound
+		if err := tx.Where("medicine_id = ?", med.ID).Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+			return 0, err
+		}
+		avg = last.Price
+	}
+	if config.CostingMethod() != config.CostingFIFO || len(allocs) == 0 {
+		return avg, nil
+	}
+
+	ids := make([]int64, 0, len(allocs))
+	for _, a := range allocs {
+		ids = append(ids, a.LotID)
+	}
+	var lots []model.StockLot
+	if err := tx.Where("id IN ?", ids).Find(&lots).Error; err != nil {
+		return 0, err
+	}
+	costs := make(map[int64]float64, len(lots))
+	for _, lot := range lots {
+		costs[lot.ID] = lot.UnitCost
+	}
+
+	total, tracked := 0.0, 0
+	for _, a := range allocs {
+		total += costs[a.LotID] * float64(a.Quantity)
+		tracked += a.Quantity
+	}
+	total += avg * float64(qty-tracked)
+	return total / float64(qty), nil
+}
+
+// roundCost rounds a unit cost to the precision of the cost columns
+func roundCost(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
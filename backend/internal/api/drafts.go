@@ -0,0 +1,474 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/config"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ==================== Held Orders & Quotes (挂单与报价) ====================
+
+// Draft kinds
+const (
+	draftHold  = "hold"  // a basket parked at the till
+	draftQuote = "quote" // a quotation for a customer
+)
+
+// Draft states
+const (
+	draftOpen      = "open"
+	draftConverted = "converted"
+	draftCancelled = "cancelled"
+	draftExpired   = "expired" // a quote past its validity
+)
+
+// draftCheckInterval is how often lapsed reservations and quotes are expired
+const draftCheckInterval = time.Minute
+
+// draftRequest is the body of creating or updating a draft order
+type draftRequest struct {
+	Kind       string       `json:"kind"`
+	CustomerID int64        `json:"customer_id"`
+	Items      []basketLine `json:"items"`
+	Reserve    bool         `json:"reserve"`    // hold the stock back from other checkouts
+	ValidDays  int          `json:"valid_days"` // quotes only, defaults to pos.quote_valid_days
+	Note       string       `json:"note"`
+}
+
+// reservedStock is the quantity of a medicine held back by live
+// reservations, other than those of the draft excluded
+func reservedStock(tx *gorm.DB, medicineID, exceptDraft int64) (int, error) {
+	var reserved int
+	err := tx.Raw(`SELECT COALESCE(SUM(l.quantity), 0)
+		FROM draft_order_lines l JOIN draft_orders d ON d.id = l.draft_id
+		WHERE l.medicine_id = ? AND d.id <> ? AND d.status = ? AND d.reserved = TRUE AND d.reserved_until > ?`,
+		medicineID, exceptDraft, draftOpen, time.Now()).Row().Scan(&reserved)
+	return reserved, err
+}
+
+// checkReservations refuses a basket that would dig into stock other drafts
+// have reserved. The medicines must already be locked.
+func checkReservations(tx *gorm.DB, lines []*pricedLine, exceptDraft int64) error {
+	want := make(map[int64]int)
+	meds := make(map[int64]*model.Medicine)
+	for _, l := range lines {
+		want[l.med.ID] += l.quantity
+		meds[l.med.ID] = &l.med
+	}
+	for id, qty := range want {
+		reserved, err := reservedStock(tx, id, exceptDraft)
+		if err != nil {
+			return err
+		}
+		med := meds[id]
+		if reserved > 0 && med.Stock-reserved < qty {
+			return newAPIError(http.StatusConflict, "%s 可用库存不足：库存 %d，其中 %d 已被挂单预留", med.Name, med.Stock, reserved)
+		}
+	}
+	return nil
+}
+
+// priceDraft prices the lines of a draft at the current prices and
+// promotions and, when it reserves, checks the stock is free to hold
+func priceDraft(tx *gorm.DB, draft *model.DraftOrder, items []basketLine) ([]model.DraftOrderLine, error) {
+	if len(items) == 0 {
+		return nil, newAPIError(http.StatusBadRequest, "Basket is empty")
+	}
+	priced := make([]*pricedLine, 0, len(items))
+	for i, item := range items {
+		if item.Quantity <= 0 {
+			return nil, newAPIError(http.StatusBadRequest, "Line %d: quantity must be positive", i+1)
+		}
+		var med model.Medicine
+		query := tx
+		if draft.Reserved {
+			// Lock like a checkout so the stock cannot be taken meanwhile
+			query = tx.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+		if err := query.First(&med, item.MedicineID).Error; err != nil {
+			return nil, newAPIError(http.StatusBadRequest, "Line %d: medicine not found", i+1)
+		}
+		if med.Status == medicineStatusRecalled {
+			return nil, newAPIError(http.StatusBadRequest, "%s 已被召回，禁止销售", med.Name)
+		}
+		if err := currentPrice(tx, &med); err != nil {
+			return nil, err
+		}
+		priced = append(priced, &pricedLine{
+			med:      med,
+			quantity: item.Quantity,
+			gross:    roundMoney(med.Price * float64(item.Quantity)),
+		})
+	}
+
+	if draft.Reserved {
+		want := make(map[int64]int)
+		for _, p := range priced {
+			want[p.med.ID] += p.quantity
+		}
+		for _, p := range priced {
+			if want[p.med.ID] > p.med.Stock {
+				return nil, newAPIError(http.StatusConflict, "%s 库存不足，当前库存 %d", p.med.Name, p.med.Stock)
+			}
+		}
+		if err := checkReservations(tx, priced, draft.ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyPromotions(tx, draft.CustomerID, priced); err != nil {
+		return nil, err
+	}
+
+	lines := make([]model.DraftOrderLine, 0, len(priced))
+	draft.TotalAmount, draft.DiscountAmount = 0, 0
+	for _, p := range priced {
+		line := model.DraftOrderLine{
+			DraftID:    draft.ID,
+			MedicineID: p.med.ID,
+			Quantity:   p.quantity,
+			UnitPrice:  p.med.Price,
+			Discount:   roundMoney(p.discount),
+			Amount:     roundMoney(p.net()),
+		}
+		lines = append(lines, line)
+		draft.TotalAmount += line.Amount
+		draft.DiscountAmount += line.Discount
+	}
+	draft.TotalAmount = roundMoney(draft.TotalAmount)
+	draft.DiscountAmount = roundMoney(draft.DiscountAmount)
+	return lines, nil
+}
+
+// saveDraftLines replaces the lines of a draft
+func saveDraftLines(tx *gorm.DB, draft *model.DraftOrder, lines []model.DraftOrderLine) error {
+	if err := tx.Where("draft_id = ?", draft.ID).Delete(&model.DraftOrderLine{}).Error; err != nil {
+		return err
+	}
+	for i := range lines {
+		lines[i].DraftID = draft.ID
+	}
+	if err := tx.Create(&lines).Error; err != nil {
+		return err
+	}
+	draft.Lines = lines
+	return nil
+}
+
+// setReservation starts or drops a draft's reservation; a new reservation
+// runs for pos.reservation_ttl_minutes from now
+func setReservation(draft *model.DraftOrder, reserve bool) {
+	draft.Reserved = reserve
+	draft.ReservedUntil = nil
+	if reserve {
+		until := time.Now().Add(config.ReservationTTL())
+		draft.ReservedUntil = &until
+	}
+}
+
+// lockOpenDraft loads a draft for update and checks it can still be changed
+func lockOpenDraft(tx *gorm.DB, id string) (*model.DraftOrder, error) {
+	var draft model.DraftOrder
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&draft, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, newAPIError(http.StatusNotFound, "Draft order not found")
+		}
+		return nil, err
+	}
+	if draft.Status != draftOpen {
+		return nil, newAPIError(http.StatusConflict, "Draft order is %s", draft.Status)
+	}
+	if draft.ValidUntil != nil && !draft.ValidUntil.After(time.Now()) {
+		return nil, newAPIError(http.StatusConflict, "报价单已过期")
+	}
+	return &draft, nil
+}
+
+// CreateDraft parks a basket or saves a quotation
+func CreateDraft(c *gin.Context) {
+	var req draftRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch req.Kind {
+	case "":
+		req.Kind = draftHold
+	case draftHold, draftQuote:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be hold or quote"})
+		return
+	}
+	if req.ValidDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "valid_days cannot be negative"})
+		return
+	}
+
+	draft := model.DraftOrder{
+		Kind:       req.Kind,
+		Status:     draftOpen,
+		CustomerID: req.CustomerID,
+		CashierID:  currentUserID(c),
+		Note:       strings.TrimSpace(req.Note),
+	}
+	setReservation(&draft, req.Reserve)
+	if req.Kind == draftQuote {
+		days := req.ValidDays
+		if days == 0 {
+			days = config.QuoteValidDays()
+		}
+		until := startOfToday().AddDate(0, 0, days+1)
+		draft.ValidUntil = &until
+	}
+
+	prefix := "HLD"
+	if req.Kind == draftQuote {
+		prefix = "QUO"
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		lines, err := priceDraft(tx, &draft, req.Items)
+		if err != nil {
+			return err
+		}
+		if err := createNumbered(tx, &draft, prefix, func(no string) { draft.DraftNo = no }); err != nil {
+			return err
+		}
+		return saveDraftLines(tx, &draft, lines)
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, draft)
+}
+
+// UpdateDraft replaces the basket of an open draft and reprices it; a
+// reserving draft gets a fresh reservation period
+func UpdateDraft(c *gin.Context) {
+	var req draftRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var draft *model.DraftOrder
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if draft, err = lockOpenDraft(tx, c.Param("id")); err != nil {
+			return err
+		}
+		draft.CustomerID = req.CustomerID
+		draft.Note = strings.TrimSpace(req.Note)
+		setReservation(draft, req.Reserve)
+		lines, err := priceDraft(tx, draft, req.Items)
+		if err != nil {
+			return err
+		}
+		if err := tx.Save(draft).Error; err != nil {
+			return err
+		}
+		return saveDraftLines(tx, draft, lines)
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, draft)
+}
+
+// CancelDraft discards an open draft and releases its reservation
+func CancelDraft(c *gin.Context) {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		draft, err := lockOpenDraft(tx, c.Param("id"))
+		if err != nil {
+			return err
+		}
+		return tx.Model(draft).Updates(map[string]any{
+			"status":         draftCancelled,
+			"reserved":       false,
+			"reserved_until": nil,
+		}).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Draft order cancelled"})
+}
+
+// CheckoutDraft turns a held order or quote into a sale. The body is that of
+// POST /api/sales; its items, if any, replace the draft's lines and the
+// customer defaults to the draft's. The sale is priced as of now, so a quote
+// is not binding.
+func CheckoutDraft(c *gin.Context) {
+	var req checkoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var draft model.DraftOrder
+	if err := database.DB.First(&draft, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Draft order not found"})
+		return
+	}
+	if len(req.lines()) == 0 {
+		var lines []model.DraftOrderLine
+		if err := database.DB.Where("draft_id = ?", draft.ID).Order("id").Find(&lines).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, l := range lines {
+			req.Items = append(req.Items, basketLine{MedicineID: l.MedicineID, Quantity: l.Quantity})
+		}
+	}
+	if req.CustomerID == 0 {
+		req.CustomerID = draft.CustomerID
+	}
+	req.draftID = draft.ID
+
+	submitSale(c, &req, func(tx *gorm.DB, order *model.Order) error {
+		locked, err := lockOpenDraft(tx, c.Param("id"))
+		if err != nil {
+			return err
+		}
+		return tx.Model(locked).Updates(map[string]any{
+			"status":         draftConverted,
+			"reserved":       false,
+			"reserved_until": nil,
+			"order_no":       order.OrderNo,
+		}).Error
+	})
+}
+
+// DraftRow is a draft order with display names
+type DraftRow struct {
+	model.DraftOrder
+	CustomerName string `json:"customer_name"`
+	CashierName  string `json:"cashier_name"`
+	LineCount    int    `json:"line_count"`
+}
+
+// GetDrafts lists draft orders, newest first (&kind=, &status= defaulting to
+// open, &cashier_id=, &customer_id=)
+func GetDrafts(c *gin.Context) {
+	page, limit, offset := getPaginationParams(c)
+
+	query := database.DB.Table("draft_orders d").Where("d.status = ?", c.DefaultQuery("status", draftOpen))
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("d.kind = ?", kind)
+	}
+	if cashierID := c.Query("cashier_id"); cashierID != "" {
+		query = query.Where("d.cashier_id = ?", cashierID)
+	}
+	if customerID := c.Query("customer_id"); customerID != "" {
+		query = query.Where("d.customer_id = ?", customerID)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	rows := make([]DraftRow, 0)
+	if err := query.Select(`d.*, COALESCE(cu.name, '') AS customer_name,
+			COALESCE(NULLIF(u.real_name, ''), u.username, '') AS cashier_name,
+			(SELECT COUNT(*) FROM draft_order_lines l WHERE l.draft_id = d.id) AS line_count`).
+		Joins("LEFT JOIN customers cu ON cu.id = d.customer_id").
+		Joins("LEFT JOIN users u ON u.id = d.cashier_id").
+		Order("d.created_at DESC, d.id DESC").Offset(offset).Limit(limit).
+		Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	totalPages := math.Ceil(float64(total) / float64(limit))
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": rows,
+		"meta": Pagination{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(totalPages),
+		},
+	})
+}
+
+// DraftLineRow is a draft line with the medicine and the stock free for it
+type DraftLineRow struct {
+	model.DraftOrderLine
+	MedicineName string `json:"medicine_name"`
+	Spec         string `json:"spec"`
+	Stock        int    `json:"stock"`
+	Available    int    `json:"available"` // stock less what other drafts reserve
+}
+
+// GetDraft returns a draft order with its lines, e.g. to resume it at the till
+func GetDraft(c *gin.Context) {
+	var draft model.DraftOrder
+	if err := database.DB.First(&draft, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Draft order not found"})
+		return
+	}
+
+	lines := make([]DraftLineRow, 0)
+	if err := database.DB.Table("draft_order_lines l").
+		Select("l.*, m.name AS medicine_name, COALESCE(m.spec, '') AS spec, m.stock").
+		Joins("JOIN medicines m ON m.id = l.medicine_id").
+		Where("l.draft_id = ?", draft.ID).Order("l.id").
+		Scan(&lines).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range lines {
+		reserved, err := reservedStock(database.DB, lines[i].MedicineID, draft.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		lines[i].Available = max(lines[i].Stock-reserved, 0)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"draft": draft,
+		"lines": lines,
+	})
+}
+
+// expireDrafts drops reservations past their time and expires quotes past
+// their validity
+func expireDrafts(tx *gorm.DB) error {
+	now := time.Now()
+	if err := tx.Model(&model.DraftOrder{}).
+		Where("status = ? AND reserved = TRUE AND reserved_until <= ?", draftOpen, now).
+		Updates(map[string]any{"reserved": false, "reserved_until": nil}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&model.DraftOrder{}).
+		Where("status = ? AND kind = ? AND valid_until <= ?", draftOpen, draftQuote, now).
+		Updates(map[string]any{"status": draftExpired, "reserved": false, "reserved_until": nil}).Error
+}
+
+// StartDraftScheduler releases lapsed reservations and expires old quotes in
+// the background. It does nothing while the database is not connected.
+func StartDraftScheduler() {
+	go func() {
+		ticker := time.NewTicker(draftCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !database.IsConnected {
+				continue
+			}
+			if err := database.DB.Transaction(expireDrafts); err != nil {
+				log.Printf("Failed to expire draft orders: %v", err)
+			}
+		}
+	}()
+}
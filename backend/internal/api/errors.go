@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQL error numbers the API reacts to
+const (
+	mysqlErrDuplicateKey = 1062
+	mysqlErrSignal       = 1644 // SIGNAL SQLSTATE '45000' from a trigger or procedure
+)
+
+// apiError is returned by shared business helpers so the calling handler can
+// answer with the right status code
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newAPIError(status int, format string, args ...any) *apiError {
+	return &apiError{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// respondError writes err as JSON: apiErrors keep their status, trigger
+// SIGNALs (e.g. 库存不足) become 400 and anything else is a 500
+func respondError(c *gin.Context, err error) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		c.JSON(apiErr.Status, gin.H{"error": apiErr.Message})
+		return
+	}
+	if msg, ok := signalMessage(err); ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// signalMessage extracts the MESSAGE_TEXT of a SIGNAL raised inside MySQL
+func signalMessage(err error) (string, bool) {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrSignal {
+		return mysqlErr.Message, true
+	}
+	return "", false
+}
+
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateKey
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+)
+
+// ==================== Expiry Early Warning (效期预警) ====================
+
+// defaultExpiryHorizons are the day limits of the buckets after "expired"
+var defaultExpiryHorizons = []int{30, 90, 180}
+
+// expiryAlertDays is the window counted by the dashboard and alert endpoint
+const expiryAlertDays = 30
+
+// ExpiryBucket sums the stock falling into one horizon
+type ExpiryBucket struct {
+	Label     string  `json:"label"`
+	MaxDays   *int    `json:"max_days"` // nil for already expired
+	Quantity  int     `json:"quantity"`
+	CostValue float64 `json:"cost_value"`
+	LotCount  int     `json:"lot_count"`
+}
+
+// ExpiryGroup is the at-risk stock of one medicine or supplier, split by bucket
+type ExpiryGroup struct {
+	ID        int64          `json:"id"`
+	Name      string         `json:"name"`
+	Quantity  int            `json:"quantity"`
+	CostValue float64        `json:"cost_value"`
+	Buckets   []ExpiryBucket `json:"buckets"`
+}
+
+// parseHorizons reads ?horizons=30,90,180 into a sorted list of positive day counts
+func parseHorizons(raw string) ([]int, error) {
+	if raw == "" {
+		return defaultExpiryHorizons, nil
+	}
+	var horizons []int
+	for _, part := range strings.Split(raw, ",") {
+		days, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || days <= 0 {
+			return nil, fmt.Errorf("invalid horizon %q", part)
+		}
+		horizons = append(horizons, days)
+	}
+	sort.Ints(horizons)
+	return horizons, nil
+}
+
+// newExpiryBuckets builds the empty bucket list: expired, then one per horizon.
+// Buckets are disjoint, e.g. "within_90" holds lots expiring in 31-90 days.
+func newExpiryBuckets(horizons []int) []ExpiryBucket {
+	buckets := []ExpiryBucket{{Label: "expired"}}
+	for _, days := range horizons {
+		d := days
+		buckets = append(buckets, ExpiryBucket{Label: fmt.Sprintf("within_%d", d), MaxDays: &d})
+	}
+	return buckets
+}
+
+// bucketIndex returns the bucket a lot belongs to, or -1 if beyond the last horizon
+func bucketIndex(daysToExpiry int, horizons []int) int {
+	if daysToExpiry < 0 {
+		return 0
+	}
+	for i, days := range horizons {
+		if daysToExpiry <= days {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func addToBucket(b *ExpiryBucket, lot LotStock) {
+	b.Quantity += lot.Remaining
+	b.CostValue = roundMoney(b.CostValue + lot.CostValue)
+	b.LotCount++
+}
+
+// GetExpiryReport shows stock at risk by expiry horizon, per medicine and per supplier
+func GetExpiryReport(c *gin.Context) {
+	horizons, err := parseHorizons(c.Query("horizons"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	maxDays := horizons[len(horizons)-1]
+
+	lots := make([]LotStock, 0)
+	if err := database.DB.Raw(`SELECT * FROM v_lot_inventory
+		WHERE expiry_date IS NOT NULL AND days_to_expiry <= ?
+		ORDER BY expiry_date, medicine_id`, maxDays).Scan(&lots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	totals := newExpiryBuckets(horizons)
+	byMedicine := make(map[int64]*ExpiryGroup)
+	bySupplier := make(map[int64]*ExpiryGroup)
+	var medicineOrder, supplierOrder []int64
+
+	group := func(groups map[int64]*ExpiryGroup, order *[]int64, id int64, name string) *ExpiryGroup {
+		g, ok := groups[id]
+		if !ok {
+			g = &ExpiryGroup{ID: id, Name: name, Buckets: newExpiryBuckets(horizons)}
+			groups[id] = g
+			*order = append(*order, id)
+		}
+		return g
+	}
+
+	for _, lot := range lots {
+		if lot.DaysToExpiry == nil {
+			continue
+		}
+		idx := bucketIndex(*lot.DaysToExpiry, horizons)
+		if idx < 0 {
+			continue
+		}
+		addToBucket(&totals[idx], lot)
+
+		supplierName := lot.SupplierName
+		if supplierName == "" {
+			supplierName = "未知供应商"
+		}
+		for _, g := range []*ExpiryGroup{
+			group(byMedicine, &medicineOrder, lot.MedicineID, lot.MedicineName),
+			group(bySupplier, &supplierOrder, lot.SupplierID, supplierName),
+		} {
+			g.Quantity += lot.Remaining
+			g.CostValue = roundMoney(g.CostValue + lot.CostValue)
+			addToBucket(&g.Buckets[idx], lot)
+		}
+	}
+
+	collect := func(groups map[int64]*ExpiryGroup, order []int64) []ExpiryGroup {
+		result := make([]ExpiryGroup, 0, len(order))
+		for _, id := range order {
+			result = append(result, *groups[id])
+		}
+		sort.SliceStable(result, func(i, j int) bool { return result[i].CostValue > result[j].CostValue })
+		return result
+	}
+
+	var totalQuantity int
+	var totalValue float64
+	for _, b := range totals {
+		totalQuantity += b.Quantity
+		totalValue += b.CostValue
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"horizons":       horizons,
+		"buckets":        totals,
+		"by_medicine":    collect(byMedicine, medicineOrder),
+		"by_supplier":    collect(bySupplier, supplierOrder),
+		"lots":           lots,
+		"total_quantity": totalQuantity,
+		"total_value":    roundMoney(totalValue),
+	})
+}
+
+// GetExpiryAlerts lists lots that are expired or expire within ?days= (default 30),
+// soonest first, for polling by the front end
+func GetExpiryAlerts(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", strconv.Itoa(expiryAlertDays)))
+	if err != nil || days < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid days"})
+		return
+	}
+
+	lots := make([]LotStock, 0)
+	if err := database.DB.Raw(`SELECT * FROM v_lot_inventory
+		WHERE expiry_date IS NOT NULL AND days_to_expiry <= ?
+		ORDER BY expiry_date, medicine_id`, days).Scan(&lots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	expired := 0
+	for _, lot := range lots {
+		if lot.LotState == "expired" {
+			expired++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"days":          days,
+		"count":         len(lots),
+		"expired_count": expired,
+		"lots":          lots,
+	})
+}
+
+// countExpiringLots counts lots that are expired or expire within the alert window
+func countExpiringLots() int64 {
+	var count int64
+	database.DB.Raw(`SELECT COUNT(*) FROM v_lot_inventory
+		WHERE expiry_date IS NOT NULL AND days_to_expiry <= ?`, expiryAlertDays).Row().Scan(&count)
+	return count
+}
@@ -10,10 +10,13 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/auth"
 	"github.com/yousaling0624/database-course-project/backend/internal/config"
 	"github.com/yousaling0624/database-course-project/backend/internal/database"
 	"github.com/yousaling0624/database-course-project/backend/internal/model"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Pagination Helper
@@ -43,6 +46,7 @@ type SearchSalesRecord struct {
 	MedicineName string `json:"medicine_name"`
 	MedicineType string `json:"medicine_type"`
 	CustomerName string `json:"customer_name"`
+	VoidPending  bool   `json:"void_pending"` // a void request awaits approval
 }
 
 type SearchInboundRecord struct {
@@ -65,16 +69,19 @@ func Login(c *gin.Context) {
 	// If database is not connected, allow admin/password login
 	if !database.IsConnected {
 		if req.Username == "admin" && req.Password == "password" {
-			c.JSON(http.StatusOK, gin.H{
-				"message": "Login successful (offline mode)",
-				"token":   "offline-token",
-				"user": gin.H{
-					"id":        0,
-					"username":  "admin",
-					"real_name": "Administrator",
-					"role":      "admin",
-				},
-			})
+			resp, err := issueTokenPair(0, "admin", "admin")
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue token"})
+				return
+			}
+			resp["message"] = "Login successful (offline mode)"
+			resp["user"] = gin.H{
+				"id":        0,
+				"username":  "admin",
+				"real_name": "Administrator",
+				"role":      "admin",
+			}
+			c.JSON(http.StatusOK, resp)
 			return
 		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Database not connected. Only admin can login."})
@@ -92,11 +99,93 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Login successful",
-		"token":   "dummy-token",
-		"user":    user,
-	})
+	resp, err := issueTokenPair(user.ID, user.Username, user.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue token"})
+		return
+	}
+	resp["message"] = "Login successful"
+	resp["user"] = user
+	c.JSON(http.StatusOK, resp)
+}
+
+// RefreshToken exchanges a refresh token for a new token pair.
+// The presented refresh token is revoked so it cannot be replayed.
+func RefreshToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := auth.Parse(req.RefreshToken, auth.TokenRefresh)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+	if isTokenRevoked(claims.ID) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token has been revoked"})
+		return
+	}
+
+	// Reload the user so role changes and deletions take effect on refresh
+	username, role := claims.Username, claims.Role
+	if database.IsConnected && claims.UserID != 0 {
+		var user model.User
+		if err := database.DB.First(&user, claims.UserID).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User no longer exists"})
+			return
+		}
+		username, role = user.Username, user.Role
+	}
+
+	revokeToken(claims)
+
+	resp, err := issueTokenPair(claims.UserID, username, role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue token"})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Logout revokes the current access token and, if supplied, the refresh token
+func Logout(c *gin.Context) {
+	if claims, ok := c.Get("claims"); ok {
+		revokeToken(claims.(*auth.Claims))
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.ShouldBindJSON(&req); err == nil && req.RefreshToken != "" {
+		if claims, err := auth.Parse(req.RefreshToken, auth.TokenRefresh); err == nil {
+			revokeToken(claims)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// issueTokenPair signs a fresh access/refresh token pair for a user
+func issueTokenPair(userID int64, username, role string) (gin.H, error) {
+	accessToken, _, err := auth.Issue(userID, username, role, auth.TokenAccess)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, _, err := auth.Issue(userID, username, role, auth.TokenRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(auth.AccessTTL().Seconds()),
+	}, nil
 }
 
 // ==================== Users ====================
@@ -225,8 +314,22 @@ func CreateMedicine(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := validateStockLevels(&med); err != nil {
+		respondError(c, err)
+		return
+	}
 
-	if err := database.DB.Create(&med).Error; err != nil {
+	// Opening stock is recorded by tr_after_medicine_stock_insert
+	err := stockTransaction(func(tx *gorm.DB) error {
+		if err := setStockContext(tx, currentUserID(c), "", 0, ""); err != nil {
+			return err
+		}
+		if err := tx.Create(&med).Error; err != nil {
+			return err
+		}
+		return recordPriceChange(tx, med.ID, nil, med.Price, "新建药品", currentUserID(c))
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -242,13 +345,59 @@ func UpdateMedicine(c *gin.Context) {
 		return
 	}
 
-	var input model.Medicine
-	if err := c.ShouldBindJSON(&input); err != nil {
+	var req struct {
+		model.Medicine
+		// The stock levels shadow the embedded fields so that null clears them
+		MinStock     optionalInt `json:"min_stock"`
+		MaxStock     optionalInt `json:"max_stock"`
+		ReorderPoint optionalInt `json:"reorder_point"`
+		// PriceReason is recorded in the price history when the price changes
+		PriceReason string `json:"price_reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	input := req.Medicine
+	// Stock only moves through AdjustStock, stocktakes and documents, which
+	// keep the lots in step; the moving average cost is maintained by the
+	// stock triggers. Zero fields are skipped by Updates.
+	input.Stock = 0
+	input.AvgCost = 0
+	// Status is owned by recalls: only CloseRecall lifts a product recall
+	input.Status = ""
+
+	// Validate the stock levels as they will be after the update
+	levels := med
+	levelCols := applyStockLevels(&levels, req.MinStock, req.MaxStock, req.ReorderPoint)
+	if err := validateStockLevels(&levels); err != nil {
+		respondError(c, err)
+		return
+	}
 
-	database.DB.Model(&med).Updates(input)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		prevPrice := med.Price
+		if err := tx.Model(&med).Updates(input).Error; err != nil {
+			return err
+		}
+		if len(levelCols) > 0 {
+			if err := tx.Model(&med).Updates(levelCols).Error; err != nil {
+				return err
+			}
+		}
+		if input.Price != 0 && roundMoney(input.Price) != roundMoney(prevPrice) {
+			reason := strings.TrimSpace(req.PriceReason)
+			if reason == "" {
+				reason = "编辑药品"
+			}
+			return recordPriceChange(tx, med.ID, &prevPrice, med.Price, reason, currentUserID(c))
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
 	c.JSON(http.StatusOK, med)
 }
 
@@ -292,6 +441,9 @@ func CreateCustomer(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// New members start at the lowest tier with no points
+	customer.Tier = config.MembershipTiers()[0].Code
+	customer.Points = 0
 	if err := database.DB.Create(&customer).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -312,6 +464,14 @@ func UpdateCustomer(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// Points only change through sales and returns; the tier may be set by hand
+	input.Points = 0
+	if input.Tier != "" {
+		if _, idx := memberTier(input.Tier); idx < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown membership tier"})
+			return
+		}
+	}
 
 	database.DB.Model(&customer).Updates(input)
 	c.JSON(http.StatusOK, customer)
@@ -420,40 +580,122 @@ func GetInbounds(c *gin.Context) {
 
 func CreateInbound(c *gin.Context) {
 	var req struct {
-		MedicineID int64   `json:"medicine_id"`
-		SupplierID int64   `json:"supplier_id"`
-		Quantity   int     `json:"quantity"`
-		Price      float64 `json:"price"`
+		MedicineID     int64    `json:"medicine_id"`
+		SupplierID     int64    `json:"supplier_id"`
+		Quantity       int      `json:"quantity"`
+		Price          float64  `json:"price"`
+		LotNo          string   `json:"lot_no"`
+		ProductionDate string   `json:"production_date"` // YYYY-MM-DD
+		ExpiryDate     string   `json:"expiry_date"`     // YYYY-MM-DD
+		POLineID       *int64   `json:"po_line_id"`      // receive against a purchase order line
+		TraceCodes     []string `json:"trace_codes"`     // 药品追溯码 scanned per box
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.Quantity <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be positive"})
+		return
+	}
+
+	productionDate, err := parseDate(req.ProductionDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid production_date, expected YYYY-MM-DD"})
+		return
+	}
+	expiryDate, err := parseDate(req.ExpiryDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expiry_date, expected YYYY-MM-DD"})
+		return
+	}
+	if expiryDate != nil && expiryDate.Before(startOfToday()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot receive goods that have already expired"})
+		return
+	}
+	if productionDate != nil && expiryDate != nil && !expiryDate.After(*productionDate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiry_date must be after production_date"})
+		return
+	}
+	traceCodes, err := cleanTraceCodes(req.TraceCodes, req.Quantity)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
 
 	tx := database.DB.Begin()
+	rollback := func() {
+		clearStockContext(tx)
+		tx.Rollback()
+	}
+
+	if err := setStockContext(tx, currentUserID(c), "", 0, ""); err != nil {
+		rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	inbound := model.Inbound{
+		MedicineID:     req.MedicineID,
+		SupplierID:     req.SupplierID,
+		Quantity:       req.Quantity,
+		Price:          req.Price,
+		InboundDate:    time.Now(),
+		LotNo:          req.LotNo,
+		ProductionDate: productionDate,
+		ExpiryDate:     expiryDate,
+		POLineID:       req.POLineID,
+	}
+
+	// Receiving against a purchase order fills in medicine, supplier and price
+	if inbound.POLineID != nil {
+		if err := applyPOLine(tx, &inbound); err != nil {
+			rollback()
+			respondError(c, err)
+			return
+		}
+	}
 
 	var med model.Medicine
-	if err := tx.First(&med, req.MedicineID).Error; err != nil {
-		tx.Rollback()
+	if err := tx.First(&med, inbound.MedicineID).Error; err != nil {
+		rollback()
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Medicine not found"})
 		return
 	}
 
 	// Stock update is now handled by database trigger tr_after_inbound_insert
-
-	inbound := model.Inbound{
-		MedicineID:  req.MedicineID,
-		SupplierID:  req.SupplierID,
-		Quantity:    req.Quantity,
-		Price:       req.Price,
-		InboundDate: time.Now(),
-	}
 	if err := tx.Create(&inbound).Error; err != nil {
-		tx.Rollback()
+		rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create inbound record"})
 		return
 	}
 
+	// Every receipt opens a stock lot that sales draw down FEFO
+	if err := receiveLot(tx, &inbound); err != nil {
+		rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create stock lot"})
+		return
+	}
+
+	if inbound.POLineID != nil {
+		if err := recordPOReceipt(tx, &inbound); err != nil {
+			rollback()
+			respondError(c, err)
+			return
+		}
+	}
+
+	if err := receiveTraceCodes(tx, &inbound, traceCodes, currentUserID(c)); err != nil {
+		rollback()
+		respondError(c, err)
+		return
+	}
+
+	if err := clearStockContext(tx); err != nil {
+		rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	tx.Commit()
 	c.JSON(http.StatusCreated, inbound)
 }
@@ -486,47 +728,6 @@ func GetSales(c *gin.Context) {
 	})
 }
 
-func CreateSale(c *gin.Context) {
-	var req struct {
-		MedicineID int64 `json:"medicine_id"`
-		CustomerID int64 `json:"customer_id"`
-		Quantity   int   `json:"quantity"`
-	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	tx := database.DB.Begin()
-
-	var med model.Medicine
-	if err := tx.First(&med, req.MedicineID).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Medicine not found"})
-		return
-	}
-
-	// Stock check and update is now handled by database triggers:
-	// tr_before_sale_check_stock (validation) and tr_after_sale_insert (update)
-
-	sale := model.Sales{
-		OrderID:    fmt.Sprintf("ORD-%d", time.Now().Unix()),
-		MedicineID: med.ID,
-		CustomerID: req.CustomerID,
-		Quantity:   req.Quantity,
-		TotalPrice: med.Price * float64(req.Quantity),
-		SaleDate:   time.Now(),
-	}
-	if err := tx.Create(&sale).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sale record"})
-		return
-	}
-
-	tx.Commit()
-	c.JSON(http.StatusCreated, sale)
-}
-
 // Dashboard Stats
 func GetStats(c *gin.Context) {
 	var totalStock int64
@@ -534,8 +735,8 @@ func GetStats(c *gin.Context) {
 	var lowStockCount int64
 
 	database.DB.Model(&model.Medicine{}).Select("COALESCE(sum(stock), 0)").Row().Scan(&totalStock)
-	database.DB.Model(&model.Sales{}).Select("COALESCE(sum(total_price), 0)").Where("sale_date > ?", time.Now().AddDate(0, -1, 0)).Row().Scan(&totalSales)
-	database.DB.Model(&model.Medicine{}).Where("stock < ?", 50).Count(&lowStockCount)
+	database.DB.Model(&model.Sales{}).Select("COALESCE(sum(total_price), 0)").Where("sale_date > ? AND status <> ?", time.Now().AddDate(0, -1, 0), saleVoided).Row().Scan(&totalSales)
+	database.DB.Model(&model.Medicine{}).Where(lowStockCondition()).Count(&lowStockCount)
 
 	// Top Selling (Default last 30 days, sorted by quantity)
 	type TopSellingItem struct {
@@ -558,12 +759,32 @@ func GetStats(c *gin.Context) {
 	startDate := endDate.AddDate(0, 0, -6)
 	database.DB.Raw("CALL sp_sales_trend(?, ?)", startDate.Format("2006-01-02"), endDate.Format("2006-01-02")).Scan(&salesTrend)
 
+	// Stock by lot state (see v_lot_inventory)
+	type StockBreakdown struct {
+		LotTracked int64 `json:"lot_tracked"`
+		Untracked  int64 `json:"untracked"`
+		NearExpiry int64 `json:"near_expiry"`
+		Expired    int64 `json:"expired"`
+	}
+	var breakdown StockBreakdown
+	database.DB.Raw(`SELECT
+			COALESCE(SUM(remaining), 0) AS lot_tracked,
+			COALESCE(SUM(CASE WHEN lot_state = 'near_expiry' THEN remaining END), 0) AS near_expiry,
+			COALESCE(SUM(CASE WHEN lot_state = 'expired' THEN remaining END), 0) AS expired
+		FROM v_lot_inventory`).Scan(&breakdown)
+	database.DB.Raw(`SELECT COALESCE(SUM(GREATEST(m.stock - COALESCE(l.tracked, 0), 0)), 0)
+		FROM medicines m
+		LEFT JOIN (SELECT medicine_id, SUM(remaining) AS tracked FROM v_lot_inventory GROUP BY medicine_id) l
+			ON m.id = l.medicine_id`).Row().Scan(&breakdown.Untracked)
+
 	c.JSON(http.StatusOK, gin.H{
-		"total_stock": totalStock,
-		"month_sales": totalSales,
-		"low_stock":   lowStockCount,
-		"top_selling": topSelling,
-		"sales_trend": salesTrend,
+		"total_stock":     totalStock,
+		"month_sales":     totalSales,
+		"low_stock":       lowStockCount,
+		"expiring":        countExpiringLots(),
+		"stock_breakdown": breakdown,
+		"top_selling":     topSelling,
+		"sales_trend":     salesTrend,
 	})
 }
 
@@ -645,6 +866,19 @@ func GetInboundReport(c *gin.Context) {
 		Price        float64   `json:"price"`
 		TotalCost    float64   `json:"total_cost"`
 		InboundDate  time.Time `json:"inbound_date"`
+		LineType     string    `json:"line_type"` // inbound, or return with negative quantity and cost
+		InboundID    *int64    `json:"inbound_id,omitempty"`
+		ReasonCode   *string   `json:"reason_code,omitempty"`
+	}
+
+	// Net purchases per supplier within the range
+	type SupplierNet struct {
+		SupplierName     string  `json:"supplier_name"`
+		InboundQuantity  int     `json:"inbound_quantity"`
+		InboundCost      float64 `json:"inbound_cost"`
+		ReturnedQuantity int     `json:"returned_quantity"`
+		CreditAmount     float64 `json:"credit_amount"`
+		NetCost          float64 `json:"net_cost"`
 	}
 
 	var inbounds = make([]InboundRecord, 0)
@@ -663,18 +897,38 @@ func GetInboundReport(c *gin.Context) {
 		return
 	}
 
-	// Calculate totals
+	// Calculate totals; return lines are negative so the sums are net
 	var totalQuantity int
-	var totalAmount float64
+	var totalAmount, creditAmount float64
+	bySupplier := make([]*SupplierNet, 0)
+	index := make(map[string]*SupplierNet)
 	for _, inb := range inbounds {
 		totalQuantity += inb.Quantity
 		totalAmount += inb.TotalCost
+
+		sup, ok := index[inb.SupplierName]
+		if !ok {
+			sup = &SupplierNet{SupplierName: inb.SupplierName}
+			index[inb.SupplierName] = sup
+			bySupplier = append(bySupplier, sup)
+		}
+		if inb.LineType == "return" {
+			sup.ReturnedQuantity -= inb.Quantity
+			sup.CreditAmount = roundMoney(sup.CreditAmount - inb.TotalCost)
+			creditAmount -= inb.TotalCost
+		} else {
+			sup.InboundQuantity += inb.Quantity
+			sup.InboundCost = roundMoney(sup.InboundCost + inb.TotalCost)
+		}
+		sup.NetCost = roundMoney(sup.InboundCost - sup.CreditAmount)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"records":        inbounds,
+		"by_supplier":    bySupplier,
 		"total_quantity": totalQuantity,
-		"total_amount":   totalAmount,
+		"total_amount":   roundMoney(totalAmount),
+		"credit_amount":  roundMoney(creditAmount),
 	})
 }
 
@@ -687,23 +941,79 @@ func GetInventoryReport(c *gin.Context) {
 	var totalValue float64
 	var lowStockItems []model.Medicine
 	var outOfStockItems []model.Medicine
+	var overstockItems []model.Medicine
+
+	for i := range medicines {
+		med := &medicines[i]
+		point := effectiveReorderPoint(med)
+		med.EffectiveReorderPoint = &point
 
-	for _, med := range medicines {
 		totalStock += med.Stock
 		totalValue += med.Price * float64(med.Stock)
 		if med.Stock == 0 {
-			outOfStockItems = append(outOfStockItems, med)
-		} else if med.Stock < 50 {
-			lowStockItems = append(lowStockItems, med)
+			outOfStockItems = append(outOfStockItems, *med)
+		} else if isLowStock(med) {
+			lowStockItems = append(lowStockItems, *med)
+		} else if med.MaxStock != nil && med.Stock > *med.MaxStock {
+			overstockItems = append(overstockItems, *med)
 		}
 	}
 
+	// Break stock down by lot; stock received before lot tracking is "untracked"
+	lots, err := loadLotStock(database.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	type MedicineLots struct {
+		MedicineID int64      `json:"medicine_id"`
+		Name       string     `json:"name"`
+		Stock      int        `json:"stock"`
+		Untracked  int        `json:"untracked"`
+		Lots       []LotStock `json:"lots"`
+	}
+
+	lotsByMedicine := make(map[int64][]LotStock)
+	var expiredStock int
+	for _, lot := range lots {
+		lotsByMedicine[lot.MedicineID] = append(lotsByMedicine[lot.MedicineID], lot)
+		if lot.LotState == "expired" {
+			expiredStock += lot.Remaining
+		}
+	}
+
+	stockByLot := make([]MedicineLots, 0)
+	for _, med := range medicines {
+		medLots := lotsByMedicine[med.ID]
+		if med.Stock == 0 && len(medLots) == 0 {
+			continue
+		}
+		tracked := 0
+		for _, lot := range medLots {
+			tracked += lot.Remaining
+		}
+		if medLots == nil {
+			medLots = make([]LotStock, 0)
+		}
+		stockByLot = append(stockByLot, MedicineLots{
+			MedicineID: med.ID,
+			Name:       med.Name,
+			Stock:      med.Stock,
+			Untracked:  max(med.Stock-tracked, 0),
+			Lots:       medLots,
+		})
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"medicines":          medicines,
 		"total_stock":        totalStock,
 		"total_value":        totalValue,
 		"low_stock_items":    lowStockItems,
 		"out_of_stock_items": outOfStockItems,
+		"overstock_items":    overstockItems,
+		"stock_by_lot":       stockByLot,
+		"expired_stock":      expiredStock,
 	})
 }
 
@@ -719,7 +1029,11 @@ func GetSalesReport(c *gin.Context) {
 		CustomerName string    `json:"customer_name"`
 		Quantity     int       `json:"quantity"`
 		TotalPrice   float64   `json:"total_price"`
+		CostAmount   float64   `json:"cost_amount"`
 		SaleDate     time.Time `json:"sale_date"`
+		LineType     string    `json:"line_type"` // sale, or return with negative quantity and amount
+		SaleID       *int64    `json:"sale_id,omitempty"`
+		Reason       *string   `json:"reason,omitempty"`
 	}
 
 	var sales = make([]SalesRecord, 0)
@@ -739,16 +1053,32 @@ func GetSalesReport(c *gin.Context) {
 	}
 
 	var totalQuantity int
-	var totalAmount float64
+	var totalAmount, totalCost float64
 	for _, sale := range sales {
 		totalQuantity += sale.Quantity
 		totalAmount += sale.TotalPrice
+		totalCost += sale.CostAmount
+	}
+
+	// Revenue taken per tender type over the same days
+	from, _ := time.ParseInLocation("2006-01-02", startDate, time.Local)
+	to, err := time.ParseInLocation("2006-01-02", endDate, time.Local)
+	if err != nil {
+		to = time.Now()
+	}
+	byTender, err := tenderBreakdown(from, to.AddDate(0, 0, 1))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"records":        sales,
 		"total_quantity": totalQuantity,
 		"total_amount":   totalAmount,
+		"total_cost":     roundMoney(totalCost),
+		"gross_profit":   roundMoney(totalAmount - totalCost),
+		"by_tender":      byTender,
 	})
 }
 
@@ -759,7 +1089,8 @@ func GetFinancialReport(c *gin.Context) {
 	type FinancialStats struct {
 		PeriodType   string    `json:"period_type"`
 		PeriodStart  time.Time `json:"period_start"`
-		SalesIncome  float64   `json:"sales_income"`
+		SalesIncome  float64   `json:"sales_income"` // net of refunds
+		RefundAmount float64   `json:"refund_amount"`
 		PurchaseCost float64   `json:"purchase_cost"`
 		GrossProfit  float64   `json:"gross_profit"`
 	}
@@ -781,119 +1112,84 @@ func GetFinancialReport(c *gin.Context) {
 	}
 
 	var salesCount int64
-	database.DB.Model(&model.Sales{}).Where("sale_date >= ?", startDate).Count(&salesCount)
+	database.DB.Model(&model.Sales{}).Where("sale_date >= ? AND status <> ?", startDate, saleVoided).Count(&salesCount)
 
 	var purchaseCount int64
 	database.DB.Model(&model.Inbound{}).Where("inbound_date >= ?", startDate).Count(&purchaseCount)
 
+	var returnCount int64
+	database.DB.Model(&model.SalesReturn{}).Where("created_at >= ?", startDate).Count(&returnCount)
+
+	var voidCount int64
+	database.DB.Model(&model.SaleVoid{}).Where("decided_at >= ? AND status = ?", startDate, voidApproved).Count(&voidCount)
+
+	byTender, err := tenderBreakdown(startDate, now.AddDate(0, 0, 1))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"report_type":    stats.PeriodType,
 		"start_date":     stats.PeriodStart,
 		"sales_income":   stats.SalesIncome,
+		"refund_amount":  stats.RefundAmount,
 		"purchase_cost":  stats.PurchaseCost,
 		"gross_profit":   stats.GrossProfit,
 		"sales_count":    salesCount,
+		"return_count":   returnCount,
+		"void_count":     voidCount,
 		"purchase_count": purchaseCount,
+		"by_tender":      byTender,
 	})
 }
 
-// ==================== Returns ====================
-
-// Sales Return - restore stock
-func CreateSalesReturn(c *gin.Context) {
+// ==================== Stock Adjustment (盘点) ====================
+func AdjustStock(c *gin.Context) {
 	var req struct {
-		SaleID int64  `json:"sale_id"`
-		Reason string `json:"reason"`
+		MedicineID int64  `json:"medicine_id"`
+		NewStock   int    `json:"new_stock"`
+		Reason     string `json:"reason"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	tx := database.DB.Begin()
-
-	var sale model.Sales
-	if err := tx.First(&sale, req.SaleID).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Sale not found"})
-		return
-	}
-
-	// Stock restoration is now handled by database trigger tr_after_sale_delete
-
-	// Delete the sale record (or mark as returned)
-	if err := tx.Delete(&sale).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process return"})
-		return
-	}
-
-	tx.Commit()
-	c.JSON(http.StatusOK, gin.H{
-		"message":           "Return processed successfully",
-		"returned_quantity": sale.Quantity,
-		"refund_amount":     sale.TotalPrice,
-	})
-}
-
-// Purchase Return - reduce stock
-func CreatePurchaseReturn(c *gin.Context) {
-	var req struct {
-		InboundID int64  `json:"inbound_id"`
-		Reason    string `json:"reason"`
-	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reason is required"})
 		return
 	}
-
-	tx := database.DB.Begin()
-
-	var inbound model.Inbound
-	if err := tx.First(&inbound, req.InboundID).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Inbound record not found"})
+	if req.NewStock < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Stock cannot be negative"})
 		return
 	}
 
-	// Stock reduction is now handled by database trigger tr_after_inbound_delete
-
-	// Delete the inbound record
-	if err := tx.Delete(&inbound).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process return"})
-		return
-	}
+	var oldStock int
+	err := stockTransaction(func(tx *gorm.DB) error {
+		var med model.Medicine
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&med, req.MedicineID).Error; err != nil {
+			return newAPIError(http.StatusNotFound, "Medicine not found")
+		}
+		oldStock = med.Stock
 
-	tx.Commit()
-	c.JSON(http.StatusOK, gin.H{
-		"message":           "Purchase return processed successfully",
-		"returned_quantity": inbound.Quantity,
+		// The ledger trigger records the difference with this reason
+		if err := setStockContext(tx, currentUserID(c), refAdjustment, 0, req.Reason); err != nil {
+			return err
+		}
+		// A write-down is taken out of the lots as well, so they never
+		// hold more than the stock that is left
+		if err := adjustLots(tx, &med, req.NewStock-oldStock); err != nil {
+			return err
+		}
+		return tx.Model(&med).UpdateColumn("stock", req.NewStock).Error
 	})
-}
-
-// ==================== Stock Adjustment (盘点) ====================
-func AdjustStock(c *gin.Context) {
-	var req struct {
-		MedicineID int64  `json:"medicine_id"`
-		NewStock   int    `json:"new_stock"`
-		Reason     string `json:"reason"`
-	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	var med model.Medicine
-	if err := database.DB.First(&med, req.MedicineID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Medicine not found"})
+	if err != nil {
+		respondError(c, err)
 		return
 	}
 
-	oldStock := med.Stock
-	med.Stock = req.NewStock
-	database.DB.Save(&med)
-
 	c.JSON(http.StatusOK, gin.H{
 		"message":    "Stock adjusted",
 		"old_stock":  oldStock,
@@ -938,10 +1234,10 @@ func BackupDatabase(c *gin.Context) {
 	if len(customers) > 0 {
 		sql.WriteString("-- 客户数据\n")
 		sql.WriteString("TRUNCATE TABLE customers;\n")
-		sql.WriteString("INSERT INTO customers (id, name, phone, created_at) VALUES\n")
+		sql.WriteString("INSERT INTO customers (id, name, phone, tier, points, created_at) VALUES\n")
 		for i, c := range customers {
-			sql.WriteString(fmt.Sprintf("(%d, '%s', '%s', '%s')",
-				c.ID, escapeSQL(c.Name), escapeSQL(c.Phone), c.CreatedAt.Format("2006-01-02 15:04:05")))
+			sql.WriteString(fmt.Sprintf("(%d, '%s', '%s', '%s', %d, '%s')",
+				c.ID, escapeSQL(c.Name), escapeSQL(c.Phone), escapeSQL(c.Tier), c.Points, c.CreatedAt.Format("2006-01-02 15:04:05")))
 			if i < len(customers)-1 {
 				sql.WriteString(",\n")
 			} else {
@@ -956,11 +1252,12 @@ func BackupDatabase(c *gin.Context) {
 	if len(medicines) > 0 {
 		sql.WriteString("-- 药品数据\n")
 		sql.WriteString("TRUNCATE TABLE medicines;\n")
-		sql.WriteString("INSERT INTO medicines (id, code, name, type, spec, price, stock, manufacturer, status) VALUES\n")
+		sql.WriteString("INSERT INTO medicines (id, code, name, type, spec, price, stock, manufacturer, status, ingredients, avg_cost, min_stock, max_stock, reorder_point) VALUES\n")
 		for i, m := range medicines {
-			sql.WriteString(fmt.Sprintf("(%d, '%s', '%s', '%s', '%s', %.2f, %d, '%s', '%s')",
+			sql.WriteString(fmt.Sprintf("(%d, '%s', '%s', '%s', '%s', %.2f, %d, '%s', '%s', '%s', %.4f, %s, %s, %s)",
 				m.ID, escapeSQL(m.Code), escapeSQL(m.Name), escapeSQL(m.Type), escapeSQL(m.Spec),
-				m.Price, m.Stock, escapeSQL(m.Manufacturer), escapeSQL(m.Status)))
+				m.Price, m.Stock, escapeSQL(m.Manufacturer), escapeSQL(m.Status), escapeSQL(m.Ingredients), m.AvgCost,
+				sqlNullInt(m.MinStock), sqlNullInt(m.MaxStock), sqlNullInt(m.ReorderPoint)))
 			if i < len(medicines)-1 {
 				sql.WriteString(",\n")
 			} else {
@@ -970,22 +1267,7 @@ func BackupDatabase(c *gin.Context) {
 	}
 
 	// Backup inbounds
-	var inbounds []model.Inbound
-	database.DB.Find(&inbounds)
-	if len(inbounds) > 0 {
-		sql.WriteString("-- 入库记录\n")
-		sql.WriteString("TRUNCATE TABLE inbounds;\n")
-		sql.WriteString("INSERT INTO inbounds (id, medicine_id, supplier_id, quantity, price, inbound_date) VALUES\n")
-		for i, ib := range inbounds {
-			sql.WriteString(fmt.Sprintf("(%d, %d, %d, %d, %.2f, '%s')",
-				ib.ID, ib.MedicineID, ib.SupplierID, ib.Quantity, ib.Price, ib.InboundDate.Format("2006-01-02 15:04:05")))
-			if i < len(inbounds)-1 {
-				sql.WriteString(",\n")
-			} else {
-				sql.WriteString(";\n\n")
-			}
-		}
-	}
+	dumpTable(&sql, "inbounds", "入库记录")
 
 	// Backup sales
 	var sales []model.Sales
@@ -993,10 +1275,11 @@ func BackupDatabase(c *gin.Context) {
 	if len(sales) > 0 {
 		sql.WriteString("-- 销售记录\n")
 		sql.WriteString("TRUNCATE TABLE sales;\n")
-		sql.WriteString("INSERT INTO sales (id, order_id, medicine_id, customer_id, quantity, total_price, sale_date) VALUES\n")
+		sql.WriteString("INSERT INTO sales (id, order_id, medicine_id, customer_id, quantity, total_price, sale_date, unit_cost, cost_amount, unit_price, discount_amount) VALUES\n")
 		for i, s := range sales {
-			sql.WriteString(fmt.Sprintf("(%d, '%s', %d, %d, %d, %.2f, '%s')",
-				s.ID, escapeSQL(s.OrderID), s.MedicineID, s.CustomerID, s.Quantity, s.TotalPrice, s.SaleDate.Format("2006-01-02 15:04:05")))
+			sql.WriteString(fmt.Sprintf("(%d, '%s', %d, %d, %d, %.2f, '%s', %.4f, %.2f, %.2f, %.2f)",
+				s.ID, escapeSQL(s.OrderID), s.MedicineID, s.CustomerID, s.Quantity, s.TotalPrice, s.SaleDate.Format("2006-01-02 15:04:05"),
+				s.UnitCost, s.CostAmount, s.UnitPrice, s.DiscountAmount))
 			if i < len(sales)-1 {
 				sql.WriteString(",\n")
 			} else {
@@ -1005,6 +1288,67 @@ func BackupDatabase(c *gin.Context) {
 		}
 	}
 
+	// Backup order headers, the prescription archive (image files are not
+	// included) and acknowledged safety warnings
+	dumpTable(&sql, "orders", "订单")
+	dumpTable(&sql, "prescriptions", "处方")
+	dumpTable(&sql, "safety_acknowledgements", "用药安全确认")
+
+	// Backup stock lots and their sale allocations
+	dumpTable(&sql, "stock_lots", "库存批次")
+	dumpTable(&sql, "sale_lots", "销售批次分配")
+
+	// Backup customer health profiles
+	dumpTable(&sql, "customer_health_profiles", "客户健康档案")
+
+	// Backup sales and purchase returns
+	dumpTable(&sql, "sales_returns", "销售退货")
+	dumpTable(&sql, "purchase_returns", "采购退货")
+
+	// Backup purchase orders
+	dumpTable(&sql, "purchase_orders", "采购订单")
+	dumpTable(&sql, "purchase_order_lines", "采购订单行")
+
+	// Backup stocktakes
+	dumpTable(&sql, "stocktakes", "盘点单")
+	dumpTable(&sql, "stocktake_items", "盘点明细")
+
+	// Backup recalls and the lots they froze
+	dumpTable(&sql, "recalls", "药品召回")
+	dumpTable(&sql, "recall_lots", "召回批次")
+
+	// Backup traceability codes and their lifecycle log
+	dumpTable(&sql, "trace_codes", "药品追溯码")
+	dumpTable(&sql, "trace_events", "追溯码流转记录")
+
+	// Backup the price history
+	dumpTable(&sql, "medicine_prices", "药品价格历史")
+
+	// Backup promotions and their usage
+	dumpTable(&sql, "promotions", "促销规则")
+	dumpTable(&sql, "promotion_usages", "促销使用记录")
+
+	// Backup loyalty point history
+	dumpTable(&sql, "point_transactions", "会员积分流水")
+
+	// Backup order payment lines
+	dumpTable(&sql, "payments", "收款明细")
+
+	// Backup cashier shifts and their drawer counts
+	dumpTable(&sql, "shifts", "收银班次")
+	dumpTable(&sql, "shift_counts", "班次对账")
+
+	// Backup held orders and quotes
+	dumpTable(&sql, "draft_orders", "挂单与报价单")
+	dumpTable(&sql, "draft_order_lines", "挂单明细")
+
+	// Backup sale void requests
+	dumpTable(&sql, "sale_voids", "销售作废")
+
+	// The ledger goes last: restoring the rows above fires the stock triggers,
+	// and truncating here discards the movements they log
+	dumpTable(&sql, "stock_movements", "库存流水")
+
 	sql.WriteString("SET FOREIGN_KEY_CHECKS = 1;\n")
 
 	// Return as downloadable SQL file
@@ -1013,6 +1357,77 @@ func BackupDatabase(c *gin.Context) {
 	c.String(http.StatusOK, sql.String())
 }
 
+// sqlNullInt formats an optional integer as a SQL literal
+func sqlNullInt(v *int) string {
+	if v == nil {
+		return "NULL"
+	}
+	return strconv.Itoa(*v)
+}
+
+// dumpTable appends TRUNCATE + INSERT statements for every row of a table,
+// reading columns generically so new tables don't need a hand-written dump
+func dumpTable(sql *strings.Builder, table, comment string) {
+	rows, err := database.DB.Raw("SELECT * FROM " + table).Rows()
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return
+	}
+
+	var values []string
+	for rows.Next() {
+		raw := make([]sqlNullBytes, len(columns))
+		dest := make([]any, len(columns))
+		for i := range raw {
+			dest[i] = &raw[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return
+		}
+
+		fields := make([]string, len(columns))
+		for i, v := range raw {
+			if v == nil {
+				fields[i] = "NULL"
+			} else {
+				fields[i] = "'" + escapeSQL(string(v)) + "'"
+			}
+		}
+		values = append(values, "("+strings.Join(fields, ", ")+")")
+	}
+	if len(values) == 0 {
+		return
+	}
+
+	sql.WriteString(fmt.Sprintf("-- %s\n", comment))
+	sql.WriteString(fmt.Sprintf("TRUNCATE TABLE %s;\n", table))
+	sql.WriteString(fmt.Sprintf("INSERT INTO %s (%s) VALUES\n", table, strings.Join(columns, ", ")))
+	sql.WriteString(strings.Join(values, ",\n"))
+	sql.WriteString(";\n\n")
+}
+
+// sqlNullBytes scans any column as raw bytes, leaving nil for NULL
+type sqlNullBytes []byte
+
+func (b *sqlNullBytes) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*b = nil
+	case []byte:
+		*b = append([]byte{}, v...)
+	case time.Time:
+		*b = []byte(v.Format("2006-01-02 15:04:05"))
+	default:
+		*b = []byte(fmt.Sprint(v))
+	}
+	return nil
+}
+
 // escapeSQL escapes single quotes in SQL strings
 func escapeSQL(s string) string {
 	return strings.ReplaceAll(s, "'", "''")
@@ -1193,15 +1608,17 @@ func UpdateDatabaseConfig(c *gin.Context) {
 		return
 	}
 
-	// Build new config
-	cfg := &config.Config{
-		Database: config.DatabaseConfig{
-			Host:     req.Host,
-			Port:     req.Port,
-			User:     req.User,
-			Password: req.Password,
-			Database: req.Database,
-		},
+	// Build new config, keeping the other sections of the current one
+	cfg := &config.Config{}
+	if current := config.Get(); current != nil {
+		*cfg = *current
+	}
+	cfg.Database = config.DatabaseConfig{
+		Host:     req.Host,
+		Port:     req.Port,
+		User:     req.User,
+		Password: req.Password,
+		Database: req.Database,
 	}
 
 	// Try to connect first
@@ -1229,103 +1646,84 @@ func UpdateDatabaseConfig(c *gin.Context) {
 	})
 }
 
-// ==================== Sales Update/Delete (Admin Only) ====================
+// ==================== Sales Update/Delete (Admin Only, see PermHistoryEdit) ====================
 
 func UpdateSale(c *gin.Context) {
-// Check admin permission
-userRole, exists := c.Get("role")
-if !exists || userRole != "admin" {
-c.JSON(http.StatusForbidden, gin.H{"error": "Admin permission required"})
-return
-}
-
-id := c.Param("id")
-var req struct {
-MedicineID int64 `json:"medicine_id"`
-CustomerID int64 `json:"customer_id"`
-Quantity   int   `json:"quantity"`
-}
-if err := c.ShouldBindJSON(&req); err != nil {
-c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-return
-}
+	id := c.Param("id")
+	var req struct {
+		MedicineID int64 `json:"medicine_id"`
+		CustomerID int64 `json:"customer_id"`
+		Quantity   int   `json:"quantity"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-// Call stored procedure to update sale
-if err := database.DB.Exec("CALL sp_update_sale(?, ?, ?, ?)", 
-id, req.MedicineID, req.CustomerID, req.Quantity).Error; err != nil {
-c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-return
-}
+	// Call stored procedure to update sale
+	err := withStockOperator(c, func(tx *gorm.DB) error {
+		return tx.Exec("CALL sp_update_sale(?, ?, ?, ?)",
+			id, req.MedicineID, req.CustomerID, req.Quantity).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
 
-c.JSON(http.StatusOK, gin.H{"message": "Sale updated successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Sale updated successfully"})
 }
 
+// DeleteSale no longer removes the row: it files a void request
+// (?reason=, required) that another admin has to approve
 func DeleteSale(c *gin.Context) {
-// Check admin permission
-userRole, exists := c.Get("role")
-if !exists || userRole != "admin" {
-c.JSON(http.StatusForbidden, gin.H{"error": "Admin permission required"})
-return
-}
-
-id := c.Param("id")
-
-// Call stored procedure to delete sale
-if err := database.DB.Exec("CALL sp_delete_sale(?)", id).Error; err != nil {
-c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-return
-}
+	v, err := requestVoid(c, c.Param("id"), c.Query("reason"), c.Query("refund_tender"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
 
-c.JSON(http.StatusOK, gin.H{"message": "Sale deleted successfully"})
+	c.JSON(http.StatusAccepted, gin.H{"message": "Void request submitted for approval", "void": v})
 }
 
-// ==================== Inbound Update/Delete (Admin Only) ====================
+// ==================== Inbound Update/Delete (Admin Only, see PermHistoryEdit) ====================
 
 func UpdateInbound(c *gin.Context) {
-// Check admin permission
-userRole, exists := c.Get("role")
-if !exists || userRole != "admin" {
-c.JSON(http.StatusForbidden, gin.H{"error": "Admin permission required"})
-return
-}
-
-id := c.Param("id")
-var req struct {
-MedicineID int64   `json:"medicine_id"`
-SupplierID int64   `json:"supplier_id"`
-Quantity   int     `json:"quantity"`
-Price      float64 `json:"price"`
-}
-if err := c.ShouldBindJSON(&req); err != nil {
-c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-return
-}
+	id := c.Param("id")
+	var req struct {
+		MedicineID int64   `json:"medicine_id"`
+		SupplierID int64   `json:"supplier_id"`
+		Quantity   int     `json:"quantity"`
+		Price      float64 `json:"price"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-// Call stored procedure to update inbound
-if err := database.DB.Exec("CALL sp_update_inbound(?, ?, ?, ?, ?)", 
-id, req.MedicineID, req.SupplierID, req.Quantity, req.Price).Error; err != nil {
-c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-return
-}
+	// Call stored procedure to update inbound
+	err := withStockOperator(c, func(tx *gorm.DB) error {
+		return tx.Exec("CALL sp_update_inbound(?, ?, ?, ?, ?)",
+			id, req.MedicineID, req.SupplierID, req.Quantity, req.Price).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
 
-c.JSON(http.StatusOK, gin.H{"message": "Inbound updated successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Inbound updated successfully"})
 }
 
 func DeleteInbound(c *gin.Context) {
-// Check admin permission
-userRole, exists := c.Get("role")
-if !exists || userRole != "admin" {
-c.JSON(http.StatusForbidden, gin.H{"error": "Admin permission required"})
-return
-}
-
-id := c.Param("id")
+	id := c.Param("id")
 
-// Call stored procedure to delete inbound
-if err := database.DB.Exec("CALL sp_delete_inbound(?)", id).Error; err != nil {
-c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-return
-}
+	// Call stored procedure to delete inbound
+	err := withStockOperator(c, func(tx *gorm.DB) error {
+		return tx.Exec("CALL sp_delete_inbound(?)", id).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
 
-c.JSON(http.StatusOK, gin.H{"message": "Inbound deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Inbound deleted successfully"})
 }
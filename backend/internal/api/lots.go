@@ -0,0 +1,273 @@
+package api
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ==================== Stock Lots (批次) ====================
+
+const lotStatusActive = "active"
+
+// LotStock is one row of the v_lot_inventory view
+type LotStock struct {
+	LotID          int64      `json:"lot_id"`
+	MedicineID     int64      `json:"medicine_id"`
+	MedicineCode   string     `json:"medicine_code"`
+	MedicineName   string     `json:"medicine_name"`
+	InboundID      int64      `json:"inbound_id"`
+	SupplierID     int64      `json:"supplier_id"`
+	SupplierName   string     `json:"supplier_name"`
+	LotNo          string     `json:"lot_no"`
+	ProductionDate *time.Time `json:"production_date"`
+	ExpiryDate     *time.Time `json:"expiry_date"`
+	Remaining      int        `json:"remaining"`
+	UnitCost       float64    `json:"unit_cost"`
+	CostValue      float64    `json:"cost_value"`
+	DaysToExpiry   *int       `json:"days_to_expiry"`
+	LotState       string     `json:"lot_state"` // normal, near_expiry, expired, no_expiry
+	LotStatus      string     `json:"lot_status"`
+}
+
+// lotAllocation is the part of a sales line taken from one lot
+type lotAllocation struct {
+	LotID    int64
+	Quantity int
+}
+
+// parseDate parses an optional YYYY-MM-DD date
+func parseDate(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func startOfToday() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+// receiveLot opens a stock lot for a freshly created inbound
+func receiveLot(tx *gorm.DB, inbound *model.Inbound) error {
+	lot := model.StockLot{
+		MedicineID:     inbound.MedicineID,
+		InboundID:      inbound.ID,
+		LotNo:          inbound.LotNo,
+		ProductionDate: inbound.ProductionDate,
+		ExpiryDate:     inbound.ExpiryDate,
+		Quantity:       inbound.Quantity,
+		Remaining:      inbound.Quantity,
+		UnitCost:       inbound.Price,
+		Status:         lotStatusActive,
+	}
+	return tx.Create(&lot).Error
+}
+
+// allocateLots picks lots for a sale first-expiry-first-out. Expired and
+// non-active lots are never sold. Stock received before lot tracking existed
+// has no lot and is consumed last. med must be locked by the caller.
+func allocateLots(tx *gorm.DB, med *model.Medicine, qty int) ([]lotAllocation, error) {
+	lots, err := lockLots(tx, med.ID)
+	if err != nil {
+		return nil, err
+	}
+	return pickLots(med, lots, qty, startOfToday())
+}
+
+// pickLots allocates qty units of med from its in-stock lots, earliest
+// expiry first; lots without an expiry date go after those with one
+func pickLots(med *model.Medicine, lots []model.StockLot, qty int, today time.Time) ([]lotAllocation, error) {
+	lots = slices.Clone(lots)
+	slices.SortStableFunc(lots, compareFEFO)
+
+	need := qty
+	tracked, blocked, expired := 0, 0, 0
+	var allocs []lotAllocation
+	for _, lot := range lots {
+		tracked += lot.Remaining
+		if lot.Status != lotStatusActive {
+			blocked += lot.Remaining
+			continue
+		}
+		if lot.ExpiryDate != nil && lot.ExpiryDate.Before(today) {
+			expired += lot.Remaining
+			continue
+		}
+		if need == 0 {
+			continue
+		}
+		take := min(need, lot.Remaining)
+		allocs = append(allocs, lotAllocation{LotID: lot.ID, Quantity: take})
+		need -= take
+	}
+
+	untracked := max(med.Stock-tracked, 0)
+	need -= min(need, untracked)
+
+	if need > 0 {
+		if expired > 0 {
+			return nil, newAPIError(http.StatusBadRequest, "%s: 可售库存不足，另有 %d 件属于已过期批次，禁止销售", med.Name, expired)
+		}
+		if blocked > 0 {
+			return nil, newAPIError(http.StatusBadRequest, "%s: 可售库存不足，另有 %d 件属于冻结批次，禁止销售", med.Name, blocked)
+		}
+		return nil, newAPIError(http.StatusBadRequest, "%s: 库存不足，无法完成销售", med.Name)
+	}
+	return allocs, nil
+}
+
+// compareFEFO orders lots as lockLots does: by expiry date with undated lots
+// last, then by id
+func compareFEFO(a, b model.StockLot) int {
+	switch {
+	case a.ExpiryDate == nil && b.ExpiryDate != nil:
+		return 1
+	case a.ExpiryDate != nil && b.ExpiryDate == nil:
+		return -1
+	case a.ExpiryDate != nil && !a.ExpiryDate.Equal(*b.ExpiryDate):
+		return a.ExpiryDate.Compare(*b.ExpiryDate)
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// lockLots locks the in-stock lots of a medicine, FEFO ordered
+func lockLots(tx *gorm.DB, medicineID int64) ([]model.StockLot, error) {
+	var lots []model.StockLot
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("medicine_id = ? AND remaining > 0", medicineID).
+		Order("expiry_date IS NULL, expiry_date, id").
+		Find(&lots).Error
+	return lots, err
+}
+
+// consumeLots records the allocations of a sales line and draws down the lots
+func consumeLots(tx *gorm.DB, saleID int64, allocs []lotAllocation) error {
+	for _, a := range allocs {
+		if err := tx.Create(&model.SaleLot{SaleID: saleID, LotID: a.LotID, Quantity: a.Quantity}).Error; err != nil {
+			return err
+		}
+	}
+	return drawLots(tx, allocs)
+}
+
+// drawLots takes allocated quantities out of their lots
+func drawLots(tx *gorm.DB, allocs []lotAllocation) error {
+	for _, a := range allocs {
+		if err := tx.Model(&model.StockLot{}).Where("id = ?", a.LotID).
+			UpdateColumn("remaining", gorm.Expr("remaining - ?", a.Quantity)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adjustLots keeps the lots in line with a stock adjustment so they never
+// hold more than the medicine's stock. A shortage is drawn like a sale,
+// first-expiry-first-out with the untracked remainder last; when sellable
+// stock cannot cover it, the rest is written off expired and frozen lots.
+// An overage opens no lot and simply becomes untracked stock. med must be
+// locked by the caller and still hold the stock before the adjustment.
+func adjustLots(tx *gorm.DB, med *model.Medicine, variance int) error {
+	if variance >= 0 {
+		return nil
+	}
+	allocs, err := allocateLots(tx, med, -variance)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		allocs, err = writeOffLots(tx, med, -variance)
+	}
+	if err != nil {
+		return err
+	}
+	return drawLots(tx, allocs)
+}
+
+// writeOffLots allocates a loss larger than the sellable stock: every
+// sellable lot and the untracked remainder go first, then expired and frozen
+// lots, earliest expiry first
+func writeOffLots(tx *gorm.DB, med *model.Medicine, qty int) ([]lotAllocation, error) {
+	lots, err := lockLots(tx, med.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	today := startOfToday()
+	tracked := 0
+	var sellable, unsellable []model.StockLot
+	for _, lot := range lots {
+		tracked += lot.Remaining
+		if lot.Status == lotStatusActive && (lot.ExpiryDate == nil || !lot.ExpiryDate.Before(today)) {
+			sellable = append(sellable, lot)
+		} else {
+			unsellable = append(unsellable, lot)
+		}
+	}
+
+	need := qty
+	var allocs []lotAllocation
+	take := func(lots []model.StockLot) {
+		for _, lot := range lots {
+			if need == 0 {
+				return
+			}
+			n := min(need, lot.Remaining)
+			allocs = append(allocs, lotAllocation{LotID: lot.ID, Quantity: n})
+			need -= n
+		}
+	}
+	take(sellable)
+	need -= min(need, max(med.Stock-tracked, 0))
+	take(unsellable)
+
+	if need > 0 {
+		return nil, newAPIError(http.StatusConflict, "%s: 库存不足，无法扣减 %d 件", med.Name, qty)
+	}
+	return allocs, nil
+}
+
+// loadLotStock reads in-stock lots from v_lot_inventory, FEFO ordered per medicine
+func loadLotStock(db *gorm.DB) ([]LotStock, error) {
+	lots := make([]LotStock, 0)
+	err := db.Raw("SELECT * FROM v_lot_inventory ORDER BY medicine_id, expiry_date IS NULL, expiry_date, lot_id").Scan(&lots).Error
+	return lots, err
+}
+
+// GetMedicineLots lists the in-stock lots of one medicine plus its untracked remainder
+func GetMedicineLots(c *gin.Context) {
+	var med model.Medicine
+	if err := database.DB.First(&med, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Medicine not found"})
+		return
+	}
+
+	lots := make([]LotStock, 0)
+	if err := database.DB.Raw("SELECT * FROM v_lot_inventory WHERE medicine_id = ? ORDER BY expiry_date IS NULL, expiry_date, lot_id", med.ID).Scan(&lots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	tracked := 0
+	for _, lot := range lots {
+		tracked += lot.Remaining
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"medicine":  med,
+		"lots":      lots,
+		"untracked": max(med.Stock-tracked, 0),
+	})
+}
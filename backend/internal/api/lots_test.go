@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+)
+
+func day(s string) *time.Time {
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return &t
+}
+
+func TestPickLots(t *testing.T) {
+	today := *day("2024-06-01")
+	lots := []model.StockLot{
+		{ID: 1, Remaining: 5, Status: lotStatusActive}, // no expiry date
+		{ID: 2, Remaining: 4, Status: lotStatusActive, ExpiryDate: day("2025-03-31")},
+		{ID: 3, Remaining: 3, Status: lotStatusActive, ExpiryDate: day("2024-12-31")},
+		{ID: 4, Remaining: 6, Status: lotStatusActive, ExpiryDate: day("2024-05-31")},   // expired
+		{ID: 5, Remaining: 7, Status: lotStatusRecalled, ExpiryDate: day("2024-09-30")}, // frozen
+		{ID: 6, Remaining: 2, Status: lotStatusQuarantine, ExpiryDate: day("2024-08-31")},
+		{ID: 7, Remaining: 2, Status: lotStatusActive, ExpiryDate: day("2024-12-31")},
+		{ID: 8, Remaining: 1, Status: lotStatusActive, ExpiryDate: day("2024-06-01")}, // expires today
+	}
+	tracked := 0
+	for _, lot := range lots {
+		tracked += lot.Remaining
+	}
+
+	tests := []struct {
+		name       string
+		untracked  int
+		qty        int
+		want       []lotAllocation
+		wantStatus int
+	}{
+		{
+			name: "earliest expiry first, ties by id",
+			qty:  5,
+			want: []lotAllocation{{8, 1}, {3, 3}, {7, 1}},
+		},
+		{
+			name: "undated lots after dated ones",
+			qty:  12,
+			want: []lotAllocation{{8, 1}, {3, 3}, {7, 2}, {2, 4}, {1, 2}},
+		},
+		{
+			name:      "untracked stock is consumed last",
+			untracked: 10,
+			qty:       20,
+			want:      []lotAllocation{{8, 1}, {3, 3}, {7, 2}, {2, 4}, {1, 5}},
+		},
+		{
+			name:       "expired and frozen lots are never sold",
+			qty:        16,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			med := &model.Medicine{ID: 1, Name: "阿莫西林胶囊", Stock: tracked + tt.untracked}
+			allocs, err := pickLots(med, lots, tt.qty, today)
+			if tt.wantStatus != 0 {
+				wantStatus(t, err, tt.wantStatus)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(allocs) != len(tt.want) {
+				t.Fatalf("got %v, want %v", allocs, tt.want)
+			}
+			for i := range allocs {
+				if allocs[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", allocs, tt.want)
+				}
+			}
+		})
+	}
+
+	if lots[0].ID != 1 {
+		t.Fatal("pickLots must not reorder the caller's slice")
+	}
+}
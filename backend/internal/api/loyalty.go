@@ -0,0 +1,329 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/config"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ==================== Loyalty Points (会员积分) ====================
+
+// Point transaction types
+const (
+	pointsEarn    = "earn"
+	pointsRedeem  = "redeem"
+	pointsReverse = "reverse" // earned points taken back on a return
+	pointsRefund  = "refund"  // redeemed points given back on a return
+)
+
+// paymentPoints is the payment method of an order paid entirely with points
+const paymentPoints = "points"
+
+// lockMember loads a registered customer for update; walk-in sales have no
+// member and return nil
+func lockMember(tx *gorm.DB, customerID int64) (*model.Customer, error) {
+	if isWalkIn(customerID) {
+		return nil, nil
+	}
+	var cust model.Customer
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cust, customerID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &cust, nil
+}
+
+// addPoints changes a locked member's balance and records why
+func addPoints(tx *gorm.DB, cust *model.Customer, txType string, points int, orderNo string, returnID *int64, operatorID int64) error {
+	if points == 0 {
+		return nil
+	}
+	cust.Points += points
+	if err := tx.Model(cust).Update("points", cust.Points).Error; err != nil {
+		return err
+	}
+	return tx.Create(&model.PointTransaction{
+		CustomerID: cust.ID,
+		Type:       txType,
+		Points:     points,
+		Balance:    cust.Points,
+		OrderNo:    orderNo,
+		ReturnID:   returnID,
+		OperatorID: operatorID,
+	}).Error
+}
+
+// memberTier returns the configured tier with the given code, falling back
+// to the lowest tier for unknown codes
+func memberTier(code string) (config.MembershipTier, int) {
+	tiers := config.MembershipTiers()
+	for i, t := range tiers {
+		if t.Code == code {
+			return t, i
+		}
+	}
+	return tiers[0], -1
+}
+
+// pointsToYuan is the checkout value of a number of points
+func pointsToYuan(points int) float64 {
+	return roundMoney(float64(points) / float64(config.RedeemPointsPerYuan()))
+}
+
+// pointsFor is the fewest points that pay for an amount
+func pointsFor(amount float64) int {
+	cents := int(math.Round(amount * 100))
+	rate := config.RedeemPointsPerYuan()
+	return (cents*rate + 99) / 100
+}
+
+// memberNetSpent is what a customer has spent less what was refunded,
+// using the same fn_customer_total_spent as the customer ranking
+func memberNetSpent(tx *gorm.DB, customerID int64) (float64, error) {
+	var spent float64
+	err := tx.Raw(`SELECT fn_customer_total_spent(?) -
+		COALESCE((SELECT SUM(refund_amount) FROM sales_returns WHERE customer_id = ?), 0)`,
+		customerID, customerID).Row().Scan(&spent)
+	return spent, err
+}
+
+// upgradeTier moves a member up to the highest tier their net spend has
+// reached. Tiers are never lowered automatically.
+func upgradeTier(tx *gorm.DB, cust *model.Customer, spent float64) error {
+	_, current := memberTier(cust.Tier)
+	tiers := config.MembershipTiers()
+	reached := 0
+	for i, t := range tiers {
+		if spent >= t.MinSpent {
+			reached = i
+		}
+	}
+	if reached <= current && current >= 0 {
+		return nil
+	}
+	cust.Tier = tiers[reached].Code
+	return tx.Model(cust).Update("tier", cust.Tier).Error
+}
+
+// settlePoints redeems the points the basket asked for and credits the points
+// earned on the rest of the order. order.TotalAmount must be final.
+func settlePoints(tx *gorm.DB, req *checkoutRequest, order *model.Order, operatorID int64) error {
+	if req.RedeemPoints < 0 {
+		return newAPIError(http.StatusBadRequest, "redeem_points cannot be negative")
+	}
+	member, err := lockMember(tx, req.CustomerID)
+	if err != nil {
+		return err
+	}
+
+	redeem := req.RedeemPoints
+	if redeem == 0 && order.PaymentMethod == paymentPoints {
+		// Paying with points: use as many as the whole order needs
+		redeem = pointsFor(order.TotalAmount)
+	}
+	if redeem > 0 {
+		if member == nil {
+			return newAPIError(http.StatusBadRequest, "积分仅限注册会员使用")
+		}
+		if redeem > member.Points {
+			return newAPIError(http.StatusBadRequest, "积分余额不足，当前可用 %d 分", member.Points)
+		}
+		if redeem > pointsFor(order.TotalAmount) {
+			return newAPIError(http.StatusBadRequest, "积分抵扣金额超过订单金额，最多可用 %d 分", pointsFor(order.TotalAmount))
+		}
+		amount := math.Min(pointsToYuan(redeem), order.TotalAmount)
+		if err := addPoints(tx, member, pointsRedeem, -redeem, order.OrderNo, nil, operatorID); err != nil {
+			return err
+		}
+		order.PointsRedeemed = redeem
+		order.PointsAmount = amount
+	}
+
+	order.PaidAmount = roundMoney(order.TotalAmount - order.PointsAmount)
+	if order.PointsAmount > 0 && order.PaidAmount == 0 {
+		order.PaymentMethod = paymentPoints
+	} else if order.PaymentMethod == paymentPoints {
+		return newAPIError(http.StatusBadRequest, "积分不足以支付整单，请选择其他支付方式")
+	}
+	if member == nil {
+		return nil
+	}
+
+	tier, _ := memberTier(member.Tier)
+	earned := int(math.Floor(order.PaidAmount*config.PointsPerYuan()*tier.Multiplier + 1e-9))
+	if err := addPoints(tx, member, pointsEarn, earned, order.OrderNo, nil, operatorID); err != nil {
+		return err
+	}
+	order.PointsEarned = earned
+
+	spent, err := memberNetSpent(tx, member.ID)
+	if err != nil {
+		return err
+	}
+	return upgradeTier(tx, member, spent)
+}
+
+// returnPoints takes back the points a return's share of the order earned
+// and gives back its share of the points redeemed. It returns the points
+// reversed and refunded.
+func returnPoints(tx *gorm.DB, ret *model.SalesReturn, operatorID int64) (int, int, error) {
+	return unwindPoints(tx, ret.CustomerID, ret.OrderID, ret.RefundAmount, &ret.ID, operatorID)
+}
+
+// unwindPoints reverses the points for amount of an order being refunded,
+// by a return (returnID) or a void (returnID nil). Shares are of the order
+// total as sold, i.e. with lines voided since added back. Earned points the
+// member has already spent are only taken back down to a zero balance.
+func unwindPoints(tx *gorm.DB, customerID int64, orderNo string, amount float64, returnID *int64, operatorID int64) (int, int, error) {
+	member, err := lockMember(tx, customerID)
+	if err != nil || member == nil {
+		return 0, 0, err
+	}
+	var order model.Order
+	if err := tx.Where("order_no = ?", orderNo).Limit(1).Find(&order).Error; err != nil {
+		return 0, 0, err
+	}
+	if order.ID == 0 || (order.PointsEarned == 0 && order.PointsRedeemed == 0) {
+		return 0, 0, nil
+	}
+	var voided float64
+	if err := tx.Model(&model.SaleVoid{}).Where("order_no = ? AND status = ?", order.OrderNo, voidApproved).
+		Select("COALESCE(SUM(amount), 0)").Scan(&voided).Error; err != nil {
+		return 0, 0, err
+	}
+	total := order.TotalAmount + voided
+	if total <= 0 {
+		return 0, 0, nil
+	}
+
+	var done struct {
+		Reversed int
+		Refunded int
+	}
+	if err := tx.Model(&model.PointTransaction{}).Where("order_no = ?", order.OrderNo).
+		Select("COALESCE(-SUM(CASE WHEN type = ? THEN points END), 0) AS reversed, COALESCE(SUM(CASE WHEN type = ? THEN points END), 0) AS refunded",
+			pointsReverse, pointsRefund).
+		Scan(&done).Error; err != nil {
+		return 0, 0, err
+	}
+
+	share := math.Min(amount/total, 1)
+	reverse := min(int(math.Round(float64(order.PointsEarned)*share)), order.PointsEarned-done.Reversed)
+	refund := min(int(math.Round(float64(order.PointsRedeemed)*share)), order.PointsRedeemed-done.Refunded)
+	if err := addPoints(tx, member, pointsRefund, refund, order.OrderNo, returnID, operatorID); err != nil {
+		return 0, 0, err
+	}
+	reverse = min(reverse, member.Points)
+	if err := addPoints(tx, member, pointsReverse, -reverse, order.OrderNo, returnID, operatorID); err != nil {
+		return 0, 0, err
+	}
+	return reverse, refund, nil
+}
+
+// GetCustomerPoints returns a member's tier, points balance and the history
+// of point changes, newest first
+func GetCustomerPoints(c *gin.Context) {
+	var cust model.Customer
+	if err := database.DB.First(&cust, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+	spent, err := memberNetSpent(database.DB, cust.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	tier, idx := memberTier(cust.Tier)
+	var next gin.H
+	if tiers := config.MembershipTiers(); idx >= 0 && idx < len(tiers)-1 {
+		next = gin.H{
+			"code":      tiers[idx+1].Code,
+			"name":      tiers[idx+1].Name,
+			"min_spent": tiers[idx+1].MinSpent,
+			"remaining": roundMoney(math.Max(tiers[idx+1].MinSpent-spent, 0)),
+		}
+	}
+
+	page, limit, offset := getPaginationParams(c)
+	query := database.DB.Model(&model.PointTransaction{}).Where("customer_id = ?", cust.ID)
+	var total int64
+	query.Count(&total)
+
+	history := make([]model.PointTransaction, 0)
+	if err := query.Order("created_at DESC, id DESC").Offset(offset).Limit(limit).Find(&history).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	totalPages := math.Ceil(float64(total) / float64(limit))
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer_id":     cust.ID,
+		"name":            cust.Name,
+		"tier":            tier,
+		"next_tier":       next,
+		"net_spent":       roundMoney(spent),
+		"points":          cust.Points,
+		"points_value":    pointsToYuan(max(cust.Points, 0)),
+		"redeem_rate":     config.RedeemPointsPerYuan(),
+		"points_per_yuan": config.PointsPerYuan(),
+		"data":            history,
+		"meta": Pagination{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(totalPages),
+		},
+	})
+}
+
+// RefreshMemberTiers re-evaluates every member's tier from their purchase
+// history (v_customer_ranking less refunds), e.g. after importing data or
+// changing the tier thresholds. Tiers are only ever raised.
+func RefreshMemberTiers(c *gin.Context) {
+	var rows []struct {
+		ID         int64
+		TotalSpent float64
+	}
+	if err := database.DB.Raw(`SELECT r.id, r.total_spent -
+			COALESCE((SELECT SUM(refund_amount) FROM sales_returns WHERE customer_id = r.id), 0) AS total_spent
+		FROM v_customer_ranking r WHERE r.id <> ?`, walkInCustomerID).Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	upgraded := 0
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		for _, r := range rows {
+			cust, err := lockMember(tx, r.ID)
+			if err != nil {
+				return err
+			}
+			if cust == nil {
+				continue
+			}
+			before := cust.Tier
+			if err := upgradeTier(tx, cust, r.TotalSpent); err != nil {
+				return err
+			}
+			if cust.Tier != before {
+				upgraded++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Member tiers refreshed", "checked": len(rows), "upgraded": upgraded})
+}
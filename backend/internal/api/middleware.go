@@ -0,0 +1,108 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/auth"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+)
+
+// AuthRequired rejects requests without a valid, unrevoked access token and
+// stores the caller's identity in the context for downstream handlers
+func AuthRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found || token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing authorization token"})
+			return
+		}
+
+		claims, err := auth.Parse(token, auth.TokenAccess)
+		if errors.Is(err, auth.ErrExpiredToken) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token expired"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+
+		if isTokenRevoked(claims.ID) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			return
+		}
+
+		// The stored account is authoritative, so role changes and deletions
+		// apply immediately instead of when the token expires
+		username, role := claims.Username, claims.Role
+		if database.IsConnected && claims.UserID != 0 {
+			var user model.User
+			if err := database.DB.First(&user, claims.UserID).Error; err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User no longer exists"})
+				return
+			}
+			username, role = user.Username, user.Role
+		}
+
+		c.Set("claims", claims)
+		c.Set("user_id", claims.UserID)
+		c.Set("username", username)
+		c.Set("role", role)
+		c.Next()
+	}
+}
+
+// RequirePermission rejects callers whose role lacks the given permission.
+// It must run after AuthRequired.
+func RequirePermission(perm auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !auth.HasPermission(c.GetString("role"), perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// currentUserID returns the ID of the authenticated caller (0 in offline mode)
+func currentUserID(c *gin.Context) int64 {
+	return c.GetInt64("user_id")
+}
+
+// revokeToken blacklists a token in memory and, when possible, in the database
+// so the revocation survives a restart
+func revokeToken(claims *auth.Claims) {
+	auth.Revoke(claims.ID, claims.Expiry())
+
+	if !database.IsConnected {
+		return
+	}
+	database.DB.Where("expires_at < ?", time.Now()).Delete(&model.RevokedToken{})
+	database.DB.Create(&model.RevokedToken{
+		JTI:       claims.ID,
+		UserID:    claims.UserID,
+		ExpiresAt: claims.Expiry(),
+	})
+}
+
+// isTokenRevoked checks the in-memory blacklist first and falls back to the database
+func isTokenRevoked(jti string) bool {
+	if auth.IsRevoked(jti) {
+		return true
+	}
+	if !database.IsConnected {
+		return false
+	}
+
+	var token model.RevokedToken
+	if database.DB.Where("jti = ?", jti).Limit(1).Find(&token).RowsAffected == 0 {
+		return false
+	}
+	auth.Revoke(token.JTI, token.ExpiresAt)
+	return true
+}
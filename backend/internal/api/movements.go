@@ -0,0 +1,135 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// ==================== Stock Movement Ledger (库存流水) ====================
+
+// Source document types written to stock_movements.ref_type by the backend.
+// The sale/inbound triggers fill in sale, inbound, sale_delete, inbound_delete,
+// sale_update and inbound_update themselves; stock changed outside the
+// application shows up as manual.
+const (
+	refSaleVoid       = "sale_void"
+	refSalesReturn    = "sales_return"
+	refPurchaseReturn = "purchase_return"
+	refAdjustment     = "adjustment"
+	refStocktake      = "stocktake"
+)
+
+// setStockContext tells the ledger triggers who is changing stock and why.
+// The values are MySQL session variables, so tx must be a transaction (one
+// connection) opened with stockTransaction, and this must run before the
+// statements that move stock.
+// An empty refType lets the triggers label the movement themselves.
+func setStockContext(tx *gorm.DB, operatorID int64, refType string, refID int64, reason string) error {
+	var ref, id, why any
+	if refType != "" {
+		ref = refType
+	}
+	if refID != 0 {
+		id = refID
+	}
+	if reason != "" {
+		why = reason
+	}
+	return tx.Exec("SET @stock_operator_id = ?, @stock_ref_type = ?, @stock_ref_id = ?, @stock_reason = ?",
+		operatorID, ref, id, why).Error
+}
+
+// clearStockContext resets the session variables set by setStockContext.
+// Connections are pooled, so this must run before the transaction ends or
+// the next statement on the connection inherits the operator.
+func clearStockContext(tx *gorm.DB) error {
+	return tx.Exec("SET @stock_operator_id = NULL, @stock_ref_type = NULL, @stock_ref_id = NULL, @stock_reason = NULL").Error
+}
+
+// stockTransaction is database.DB.Transaction for work that calls
+// setStockContext: the context is cleared before commit or rollback
+func stockTransaction(fn func(tx *gorm.DB) error) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		err := fn(tx)
+		if clearErr := clearStockContext(tx); err == nil {
+			err = clearErr
+		}
+		return err
+	})
+}
+
+// withStockOperator runs fn in a transaction whose stock movements are
+// attributed to the caller, for the stored procedures that move stock
+func withStockOperator(c *gin.Context, fn func(tx *gorm.DB) error) error {
+	return stockTransaction(func(tx *gorm.DB) error {
+		if err := setStockContext(tx, currentUserID(c), "", 0, ""); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// StockMovementRow is a ledger row with the operator's name resolved
+type StockMovementRow struct {
+	model.StockMovement
+	OperatorName string `json:"operator_name"`
+}
+
+// GetMedicineMovements lists the ledger of one medicine, newest first.
+// Optional filters: start_date, end_date (YYYY-MM-DD) and ref_type.
+func GetMedicineMovements(c *gin.Context) {
+	var med model.Medicine
+	if err := database.DB.First(&med, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Medicine not found"})
+		return
+	}
+
+	page, limit, offset := getPaginationParams(c)
+
+	query := database.DB.Table("stock_movements m").Where("m.medicine_id = ?", med.ID)
+	if start, err := parseDate(c.Query("start_date")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date, expected YYYY-MM-DD"})
+		return
+	} else if start != nil {
+		query = query.Where("m.created_at >= ?", *start)
+	}
+	if end, err := parseDate(c.Query("end_date")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date, expected YYYY-MM-DD"})
+		return
+	} else if end != nil {
+		query = query.Where("m.created_at < ?", end.Add(24*time.Hour))
+	}
+	if refType := c.Query("ref_type"); refType != "" {
+		query = query.Where("m.ref_type = ?", refType)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	rows := make([]StockMovementRow, 0)
+	if err := query.Select("m.*, COALESCE(NULLIF(u.real_name, ''), u.username, '') AS operator_name").
+		Joins("LEFT JOIN users u ON u.id = m.operator_id").
+		Order("m.id DESC").Offset(offset).Limit(limit).
+		Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	totalPages := math.Ceil(float64(total) / float64(limit))
+
+	c.JSON(http.StatusOK, gin.H{
+		"medicine": med,
+		"data":     rows,
+		"meta": Pagination{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(totalPages),
+		},
+	})
+}
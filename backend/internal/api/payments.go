@@ -0,0 +1,289 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yousaling0624/database-course-project/backend/internal/config"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"github.com/yousaling0624/database-course-project/backend/internal/payment"
+	"gorm.io/gorm"
+)
+
+// ==================== Payments (收款) ====================
+
+// paymentMixed is the order payment method when several tenders were used
+const paymentMixed = "mixed"
+
+// tenderLine is one payment offered at the till
+type tenderLine struct {
+	Tender    string  `json:"tender"`
+	Amount    float64 `json:"amount"`    // 0 takes whatever is still due
+	Tendered  float64 `json:"tendered"`  // cash handed over, change is given from it
+	Reference string  `json:"reference"` // payment code, card or insurance number
+}
+
+// InstallPaymentProvider installs the payment provider selected in config.
+// An unknown name leaves external tenders refused.
+func InstallPaymentProvider() {
+	name := config.PaymentProvider()
+	provider, err := payment.ByName(name)
+	if err != nil {
+		log.Printf("Failed to install payment provider: %v", err)
+		return
+	}
+	payment.Use(provider)
+	log.Printf("Payment provider: %s", provider.Name())
+}
+
+// pointsTendered is the amount the basket asks to pay with points
+func pointsTendered(req *checkoutRequest) float64 {
+	total := 0.0
+	for _, t := range req.Payments {
+		if t.Tender == payment.TenderPoints {
+			total += t.Amount
+		}
+	}
+	return roundMoney(total)
+}
+
+// wantsExternal reports whether a basket may need a provider charge, so its
+// payments have to be charged before the order is placed
+func wantsExternal(req *checkoutRequest) bool {
+	cashOrPoints := true
+	for _, t := range req.Payments {
+		if payment.External(t.Tender) {
+			return true
+		}
+		if t.Tender != payment.TenderPoints {
+			cashOrPoints = false
+		}
+	}
+	return cashOrPoints && payment.External(req.PaymentMethod)
+}
+
+// planPayments works out the payment lines of an order. order.PaidAmount
+// must already be net of points. Without payment lines the whole amount is
+// taken in the order's payment method.
+func planPayments(req *checkoutRequest, order *model.Order) ([]model.Payment, error) {
+	lines := make([]tenderLine, 0, len(req.Payments))
+	for _, t := range req.Payments {
+		if t.Tender != payment.TenderPoints {
+			lines = append(lines, t)
+		}
+	}
+	if len(lines) == 0 && order.PaidAmount > 0 {
+		lines = append(lines, tenderLine{Tender: order.PaymentMethod})
+	}
+
+	// Work out each line's amount; one line may leave it open for the balance
+	due := order.PaidAmount
+	open := -1
+	for i := range lines {
+		t := &lines[i]
+		t.Reference = strings.TrimSpace(t.Reference)
+		if !slices.Contains(payment.Tenders, t.Tender) || t.Tender == payment.TenderPoints {
+			return nil, newAPIError(http.StatusBadRequest, "Unknown tender %q", t.Tender)
+		}
+		if t.Amount < 0 || t.Tendered < 0 {
+			return nil, newAPIError(http.StatusBadRequest, "Payment amounts cannot be negative")
+		}
+		if t.Amount == 0 {
+			if open >= 0 {
+				return nil, newAPIError(http.StatusBadRequest, "Only one payment line may leave its amount open")
+			}
+			open = i
+			continue
+		}
+		t.Amount = roundMoney(t.Amount)
+		due = roundMoney(due - t.Amount)
+	}
+	if open >= 0 {
+		lines[open].Amount = due
+		due = 0
+	}
+	if math.Abs(due) >= 0.005 {
+		return nil, newAPIError(http.StatusBadRequest, "Payments do not add up to the amount due %.2f", order.PaidAmount)
+	}
+
+	payments := make([]model.Payment, 0, len(lines)+1)
+	if order.PointsAmount > 0 {
+		payments = append(payments, model.Payment{
+			OrderNo:   order.OrderNo,
+			Tender:    payment.TenderPoints,
+			Amount:    order.PointsAmount,
+			Tendered:  order.PointsAmount,
+			Reference: strconv.Itoa(order.PointsRedeemed),
+		})
+	}
+	for _, t := range lines {
+		if t.Amount <= 0 {
+			continue
+		}
+		p := model.Payment{
+			OrderNo:   order.OrderNo,
+			Tender:    t.Tender,
+			Amount:    t.Amount,
+			Tendered:  t.Amount,
+			Reference: t.Reference,
+		}
+		switch t.Tender {
+		case payment.TenderCash:
+			if t.Tendered > 0 {
+				if roundMoney(t.Tendered) < t.Amount {
+					return nil, newAPIError(http.StatusBadRequest, "Cash tendered %.2f is less than %.2f", t.Tendered, t.Amount)
+				}
+				p.Tendered = roundMoney(t.Tendered)
+				p.ChangeAmount = roundMoney(p.Tendered - p.Amount)
+			}
+		case payment.TenderWeChat, payment.TenderAlipay:
+			if p.Reference == "" {
+				return nil, newAPIError(http.StatusBadRequest, "%s payment needs the scanned payment code", t.Tender)
+			}
+		case payment.TenderInsurance:
+			if p.Reference == "" {
+				return nil, newAPIError(http.StatusBadRequest, "Insurance payment needs the insurance card number")
+			}
+		}
+		payments = append(payments, p)
+	}
+	return payments, nil
+}
+
+// chargePayments charges the external payments through the provider. It runs
+// outside any transaction, so no stock rows stay locked during the network
+// calls. If one is refused the payments already captured are voided.
+func chargePayments(payments []model.Payment) error {
+	provider := payment.Current()
+	for i := range payments {
+		p := &payments[i]
+		if !payment.External(p.Tender) {
+			continue
+		}
+		res, err := provider.Charge(payment.Charge{OrderNo: p.OrderNo, Tender: p.Tender, Amount: p.Amount, Reference: p.Reference})
+		if err != nil {
+			voidPayments(payments[:i])
+			switch {
+			case errors.Is(err, payment.ErrDeclined):
+				return newAPIError(http.StatusPaymentRequired, "%s payment declined", p.Tender)
+			case errors.Is(err, payment.ErrNotConfigured):
+				return newAPIError(http.StatusServiceUnavailable, "未配置支付通道，暂不能收取 %s", p.Tender)
+			case errors.Is(err, payment.ErrReferenceRequired):
+				return newAPIError(http.StatusBadRequest, "%s payment needs the terminal reference", p.Tender)
+			}
+			return err
+		}
+		p.Provider, p.ProviderRef = res.Provider, res.Reference
+	}
+	return nil
+}
+
+// recordPayments writes the payment lines of an order. External payments
+// must have been charged beforehand (req.charged, for the same amounts); if
+// the amounts have moved since, e.g. a price change in between, the order
+// is refused and the caller voids the charges. With req.quoting the lines
+// are only worked out.
+func recordPayments(tx *gorm.DB, req *checkoutRequest, order *model.Order) error {
+	payments, err := planPayments(req, order)
+	if err != nil {
+		return err
+	}
+	if req.quoting {
+		order.Payments = payments
+		return nil
+	}
+
+	charged := make([]model.Payment, 0, len(req.charged))
+	for _, p := range req.charged {
+		if payment.External(p.Tender) && p.ProviderRef != "" {
+			charged = append(charged, p)
+		}
+	}
+	tenders := make(map[string]bool)
+	for i := range payments {
+		p := &payments[i]
+		tenders[p.Tender] = true
+		if payment.External(p.Tender) {
+			if len(charged) == 0 || charged[0].Tender != p.Tender || math.Abs(charged[0].Amount-p.Amount) >= 0.005 {
+				return newAPIError(http.StatusConflict, "结算期间订单金额发生变化，已撤销收款，请重新结算")
+			}
+			p.Provider, p.ProviderRef = charged[0].Provider, charged[0].ProviderRef
+			charged = charged[1:]
+		}
+		if err := tx.Create(p).Error; err != nil {
+			return err
+		}
+	}
+	if len(charged) > 0 {
+		return newAPIError(http.StatusConflict, "结算期间订单金额发生变化，已撤销收款，请重新结算")
+	}
+
+	order.Payments = payments
+	if len(tenders) > 1 {
+		order.PaymentMethod = paymentMixed
+	} else if len(payments) == 1 {
+		order.PaymentMethod = payments[0].Tender
+	}
+	return nil
+}
+
+// refundTender picks the tender a return is paid back in: the requested one,
+// else the tender the order was paid in when it used a single one, else cash
+func refundTender(tx *gorm.DB, orderNo, requested string) (string, error) {
+	if requested != "" {
+		if !slices.Contains(payment.Tenders, requested) || requested == payment.TenderPoints {
+			return "", newAPIError(http.StatusBadRequest, "Unknown refund tender %q", requested)
+		}
+		return requested, nil
+	}
+	var tenders []string
+	if err := tx.Model(&model.Payment{}).Where("order_no = ? AND tender <> ?", orderNo, payment.TenderPoints).
+		Distinct().Pluck("tender", &tenders).Error; err != nil {
+		return "", err
+	}
+	if len(tenders) == 1 {
+		return tenders[0], nil
+	}
+	return payment.TenderCash, nil
+}
+
+// voidPayments cancels captured external payments of an order that could
+// not be placed
+func voidPayments(payments []model.Payment) {
+	provider := payment.Current()
+	for _, p := range payments {
+		if p.ProviderRef == "" {
+			continue
+		}
+		if err := provider.Void(p.Tender, p.ProviderRef); err != nil {
+			log.Printf("Failed to void %s payment %s of order %s: %v", p.Tender, p.ProviderRef, p.OrderNo, err)
+		}
+	}
+}
+
+// TenderTotal is the revenue taken in one tender type
+type TenderTotal struct {
+	Tender     string  `json:"tender"`
+	OrderCount int     `json:"order_count"`
+	Amount     float64 `json:"amount"`
+}
+
+// tenderBreakdown totals the payments of orders placed in [start, end),
+// leaving out orders voided in full
+func tenderBreakdown(start, end time.Time) ([]TenderTotal, error) {
+	rows := make([]TenderTotal, 0)
+	err := database.DB.Raw(`SELECT p.tender, COUNT(DISTINCT p.order_no) AS order_count, COALESCE(SUM(p.amount), 0) AS amount
+		FROM payments p
+		JOIN orders o ON o.order_no = p.order_no
+		WHERE o.created_at >= ? AND o.created_at < ? AND o.status <> ?
+		GROUP BY p.tender
+		ORDER BY amount DESC`, start, end, saleVoided).Scan(&rows).Error
+	return rows, err
+}
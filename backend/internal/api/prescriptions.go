@@ -0,0 +1,335 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ==================== Prescriptions (处方审核) ====================
+
+// medicineTypeRx is the medicines.type of prescription-only medicines
+const medicineTypeRx = "处方药"
+
+// walkInCustomerID is the shared "未注册" customer used for anonymous sales
+const walkInCustomerID = 9999
+
+// A prescription may be dispensed up to this many days after it was written
+const prescriptionValidDays = 3
+
+// Prescription statuses
+const (
+	rxPending   = "pending"
+	rxVerified  = "verified"
+	rxRejected  = "rejected"
+	rxDispensed = "dispensed"
+)
+
+// Uploaded prescription images
+const (
+	prescriptionImageDir     = "uploads/prescriptions"
+	prescriptionImageMaxSize = 5 << 20
+)
+
+var prescriptionImageTypes = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".pdf": true}
+
+// PrescriptionRow is a prescription with display names
+type PrescriptionRow struct {
+	model.Prescription
+	CustomerName   string `json:"customer_name"`
+	CustomerPhone  string `json:"customer_phone"`
+	PharmacistName string `json:"pharmacist_name"`
+}
+
+// isWalkIn reports whether a sale has no registered customer
+func isWalkIn(customerID int64) bool {
+	return customerID == 0 || customerID == walkInCustomerID
+}
+
+// requirePrescription checks that a basket containing med, a 处方药, comes
+// with a verified, unexpired and unused prescription for the same customer.
+// The prescription row is locked so it cannot be dispensed twice.
+func requirePrescription(tx *gorm.DB, req *checkoutRequest, med *model.Medicine) (*model.Prescription, error) {
+	if isWalkIn(req.CustomerID) {
+		return nil, newAPIError(http.StatusBadRequest, "%s 为处方药，未注册客户不能购买", med.Name)
+	}
+	if req.PrescriptionID == 0 {
+		return nil, newAPIError(http.StatusBadRequest, "%s 为处方药，请先登记处方并经药师审核", med.Name)
+	}
+
+	var rx model.Prescription
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&rx, req.PrescriptionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, newAPIError(http.StatusBadRequest, "Prescription not found")
+		}
+		return nil, err
+	}
+	if rx.CustomerID != req.CustomerID {
+		return nil, newAPIError(http.StatusBadRequest, "处方 %s 不属于该客户", rx.RxNo)
+	}
+	switch rx.Status {
+	case rxVerified:
+	case rxDispensed:
+		return nil, newAPIError(http.StatusBadRequest, "处方 %s 已于订单 %s 调配，不能重复使用", rx.RxNo, rx.OrderNo)
+	case rxRejected:
+		return nil, newAPIError(http.StatusBadRequest, "处方 %s 审核未通过", rx.RxNo)
+	default:
+		return nil, newAPIError(http.StatusBadRequest, "处方 %s 尚未经药师审核", rx.RxNo)
+	}
+	if rx.PrescribedDate.AddDate(0, 0, prescriptionValidDays).Before(startOfToday()) {
+		return nil, newAPIError(http.StatusBadRequest, "处方 %s 已超过 %d 天有效期", rx.RxNo, prescriptionValidDays)
+	}
+	return &rx, nil
+}
+
+// dispensePrescription marks a prescription as used by an order
+func dispensePrescription(tx *gorm.DB, rx *model.Prescription, orderNo string) error {
+	now := time.Now()
+	rx.Status = rxDispensed
+	rx.OrderNo = orderNo
+	rx.DispensedAt = &now
+	return tx.Model(rx).Select("status", "order_no", "dispensed_at").Updates(rx).Error
+}
+
+// CreatePrescription registers a prescription presented at the counter.
+// It starts pending until a pharmacist verifies it.
+func CreatePrescription(c *gin.Context) {
+	var req struct {
+		CustomerID     int64  `json:"customer_id" binding:"required"`
+		Prescriber     string `json:"prescriber" binding:"required"`
+		Hospital       string `json:"hospital" binding:"required"`
+		PrescribedDate string `json:"prescribed_date" binding:"required"`
+		Diagnosis      string `json:"diagnosis"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if isWalkIn(req.CustomerID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "处方必须登记到已注册客户"})
+		return
+	}
+	date, err := parseDate(req.PrescribedDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid prescribed_date, expected YYYY-MM-DD"})
+		return
+	}
+	if date.After(startOfToday()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "prescribed_date cannot be in the future"})
+		return
+	}
+
+	var customer model.Customer
+	if err := database.DB.First(&customer, req.CustomerID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	rx := model.Prescription{
+		CustomerID:     customer.ID,
+		Prescriber:     strings.TrimSpace(req.Prescriber),
+		Hospital:       strings.TrimSpace(req.Hospital),
+		PrescribedDate: *date,
+		Diagnosis:      strings.TrimSpace(req.Diagnosis),
+		Status:         rxPending,
+		CreatedBy:      currentUserID(c),
+	}
+	if err := createNumbered(database.DB, &rx, "RX", func(no string) { rx.RxNo = no }); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, rx)
+}
+
+// UploadPrescriptionImage attaches a scan or photo of the paper prescription.
+// Only pending prescriptions can be changed.
+func UploadPrescriptionImage(c *gin.Context) {
+	var rx model.Prescription
+	if err := database.DB.First(&rx, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Prescription not found"})
+		return
+	}
+	if rx.Status != rxPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only pending prescriptions can be changed"})
+		return
+	}
+
+	file, err := c.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "image file is required"})
+		return
+	}
+	if file.Size > prescriptionImageMaxSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Image must be 5MB or smaller"})
+		return
+	}
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	if !prescriptionImageTypes[ext] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Image must be jpg, png or pdf"})
+		return
+	}
+
+	if err := os.MkdirAll(prescriptionImageDir, 0o755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	name := fmt.Sprintf("%s%s", rx.RxNo, ext)
+	if err := c.SaveUploadedFile(file, filepath.Join(prescriptionImageDir, name)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := database.DB.Model(&rx).Update("image_file", name).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rx)
+}
+
+// GetPrescriptionImage serves the uploaded prescription image
+func GetPrescriptionImage(c *gin.Context) {
+	var rx model.Prescription
+	if err := database.DB.First(&rx, c.Param("id")).Error; err != nil || rx.ImageFile == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Prescription image not found"})
+		return
+	}
+	c.File(filepath.Join(prescriptionImageDir, filepath.Base(rx.ImageFile)))
+}
+
+// VerifyPrescription is the pharmacist sign-off: approve or reject a pending prescription
+func VerifyPrescription(c *gin.Context) {
+	var req struct {
+		Approved bool   `json:"approved"`
+		Note     string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if !req.Approved && req.Note == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A note is required when rejecting"})
+		return
+	}
+
+	var rx model.Prescription
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&rx, c.Param("id")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return newAPIError(http.StatusNotFound, "Prescription not found")
+			}
+			return err
+		}
+		if rx.Status != rxPending {
+			return newAPIError(http.StatusConflict, "Prescription is already %s", rx.Status)
+		}
+		// The sign-off is a second pair of eyes, as with sale voids
+		if rx.CreatedBy == currentUserID(c) {
+			return newAPIError(http.StatusForbidden, "不能审核自己登记的处方")
+		}
+
+		now := time.Now()
+		rx.Status = rxRejected
+		if req.Approved {
+			rx.Status = rxVerified
+		}
+		rx.PharmacistID = currentUserID(c)
+		rx.VerifiedAt = &now
+		rx.ReviewNote = req.Note
+		return tx.Model(&rx).Select("status", "pharmacist_id", "verified_at", "review_note").Updates(&rx).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rx)
+}
+
+// prescriptionQuery selects prescriptions with customer and pharmacist names
+func prescriptionQuery() *gorm.DB {
+	return database.DB.Table("prescriptions p").
+		Select(`p.*, COALESCE(cu.name, '') AS customer_name, COALESCE(cu.phone, '') AS customer_phone,
+			COALESCE(NULLIF(u.real_name, ''), u.username, '') AS pharmacist_name`).
+		Joins("LEFT JOIN customers cu ON cu.id = p.customer_id").
+		Joins("LEFT JOIN users u ON u.id = p.pharmacist_id")
+}
+
+// GetPrescriptions is the prescription archive, newest first.
+// Filters: &customer_id=, &status=, &keyword= (number, prescriber, hospital),
+// &start_date= / &end_date= on the registration date.
+func GetPrescriptions(c *gin.Context) {
+	page, limit, offset := getPaginationParams(c)
+
+	query := prescriptionQuery()
+	if customerID := c.Query("customer_id"); customerID != "" {
+		query = query.Where("p.customer_id = ?", customerID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("p.status = ?", status)
+	}
+	if keyword := c.Query("keyword"); keyword != "" {
+		like := "%" + keyword + "%"
+		query = query.Where("p.rx_no LIKE ? OR p.prescriber LIKE ? OR p.hospital LIKE ?", like, like, like)
+	}
+	if start, err := parseDate(c.Query("start_date")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date, expected YYYY-MM-DD"})
+		return
+	} else if start != nil {
+		query = query.Where("p.created_at >= ?", *start)
+	}
+	if end, err := parseDate(c.Query("end_date")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date, expected YYYY-MM-DD"})
+		return
+	} else if end != nil {
+		query = query.Where("p.created_at < ?", end.Add(24*time.Hour))
+	}
+
+	var total int64
+	query.Count(&total)
+
+	rows := make([]PrescriptionRow, 0)
+	if err := query.Order("p.id DESC").Offset(offset).Limit(limit).Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	totalPages := math.Ceil(float64(total) / float64(limit))
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": rows,
+		"meta": Pagination{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(totalPages),
+		},
+	})
+}
+
+// GetPrescription returns one prescription with the lines dispensed against it
+func GetPrescription(c *gin.Context) {
+	var rx PrescriptionRow
+	if err := prescriptionQuery().Where("p.id = ?", c.Param("id")).Take(&rx).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Prescription not found"})
+		return
+	}
+
+	items := make([]model.Sales, 0)
+	if rx.OrderNo != "" {
+		database.DB.Preload("Medicine").Where("order_id = ?", rx.OrderNo).Order("id").Find(&items)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"prescription": rx,
+		"items":        items,
+	})
+}
@@ -0,0 +1,392 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ==================== Price History (价格历史) ====================
+
+// Price change states
+const (
+	priceScheduled = "scheduled"
+	priceApplied   = "applied"
+	priceCancelled = "cancelled"
+)
+
+// priceCheckInterval is how often scheduled price changes are applied
+const priceCheckInterval = time.Minute
+
+// recordPriceChange writes an immediate price change to the history. The
+// caller has already updated medicines.price.
+func recordPriceChange(tx *gorm.DB, medicineID int64, prev *float64, price float64, reason string, operatorID int64) error {
+	now := time.Now()
+	return tx.Create(&model.MedicinePrice{
+		MedicineID:    medicineID,
+		Price:         price,
+		PrevPrice:     prev,
+		EffectiveFrom: now,
+		Status:        priceApplied,
+		Reason:        reason,
+		OperatorID:    operatorID,
+		AppliedAt:     &now,
+	}).Error
+}
+
+// applyDuePrices applies scheduled price changes whose effective time has
+// passed, oldest first, for one medicine or (medicineID 0) for all of them
+func applyDuePrices(tx *gorm.DB, medicineID int64) error {
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("status = ? AND effective_from <= ?", priceScheduled, time.Now())
+	if medicineID != 0 {
+		query = query.Where("medicine_id = ?", medicineID)
+	}
+	var due []model.MedicinePrice
+	if err := query.Order("effective_from, id").Find(&due).Error; err != nil {
+		return err
+	}
+
+	for _, p := range due {
+		var med model.Medicine
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&med, p.MedicineID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// The medicine was deleted; the change can never apply
+				if err := tx.Model(&p).Update("status", priceCancelled).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+		now := time.Now()
+		prev := med.Price
+		if err := tx.Model(&med).Update("price", p.Price).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&p).Updates(map[string]any{
+			"status":     priceApplied,
+			"prev_price": prev,
+			"applied_at": now,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// currentPrice brings a locked medicine's price up to date with any
+// scheduled change that has fallen due since the scheduler last ran
+func currentPrice(tx *gorm.DB, med *model.Medicine) error {
+	if err := applyDuePrices(tx, med.ID); err != nil {
+		return err
+	}
+	return tx.Model(med).Select("price").First(med).Error
+}
+
+// StartPriceScheduler applies due price changes in the background. It does
+// nothing while the database is not connected.
+func StartPriceScheduler() {
+	go func() {
+		ticker := time.NewTicker(priceCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !database.IsConnected {
+				continue
+			}
+			if err := database.DB.Transaction(func(tx *gorm.DB) error {
+				return applyDuePrices(tx, 0)
+			}); err != nil {
+				log.Printf("Failed to apply scheduled price changes: %v", err)
+			}
+		}
+	}()
+}
+
+// parseEffectiveTime accepts "YYYY-MM-DD HH:MM", "YYYY-MM-DD" (midnight) or RFC 3339
+func parseEffectiveTime(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// SchedulePriceChange sets a new retail price for a medicine, either now or
+// from a future time. A change whose time has already come applies at once.
+func SchedulePriceChange(c *gin.Context) {
+	var req struct {
+		Price         float64 `json:"price" binding:"required"`
+		EffectiveFrom string  `json:"effective_from"` // empty means now
+		Reason        string  `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Price <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Price must be positive"})
+		return
+	}
+	effective := time.Now()
+	if req.EffectiveFrom != "" {
+		t, err := parseEffectiveTime(req.EffectiveFrom)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid effective_from, expected YYYY-MM-DD HH:MM"})
+			return
+		}
+		effective = t
+	}
+
+	change := model.MedicinePrice{
+		Price:         roundMoney(req.Price),
+		EffectiveFrom: effective,
+		Status:        priceScheduled,
+		Reason:        strings.TrimSpace(req.Reason),
+		OperatorID:    currentUserID(c),
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var med model.Medicine
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&med, c.Param("id")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return newAPIError(http.StatusNotFound, "Medicine not found")
+			}
+			return err
+		}
+		change.MedicineID = med.ID
+		if err := tx.Create(&change).Error; err != nil {
+			return err
+		}
+		if !effective.After(time.Now()) {
+			if err := applyDuePrices(tx, med.ID); err != nil {
+				return err
+			}
+			return tx.First(&change, change.ID).Error
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, change)
+}
+
+// CancelPriceChange withdraws a price change that has not taken effect yet
+func CancelPriceChange(c *gin.Context) {
+	var change model.MedicinePrice
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND medicine_id = ?", c.Param("price_id"), c.Param("id")).
+			First(&change).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return newAPIError(http.StatusNotFound, "Price change not found")
+			}
+			return err
+		}
+		if change.Status != priceScheduled {
+			return newAPIError(http.StatusConflict, "Only scheduled price changes can be cancelled")
+		}
+		change.Status = priceCancelled
+		return tx.Model(&change).Update("status", priceCancelled).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, change)
+}
+
+// PriceChangeRow is a price change with the names of the medicine and operator
+type PriceChangeRow struct {
+	model.MedicinePrice
+	MedicineName string `json:"medicine_name"`
+	OperatorName string `json:"operator_name"`
+}
+
+// GetMedicinePrices lists a medicine's price history, newest first, including
+// scheduled and cancelled changes (&status=)
+func GetMedicinePrices(c *gin.Context) {
+	page, limit, offset := getPaginationParams(c)
+
+	query := database.DB.Table("medicine_prices p").Where("p.medicine_id = ?", c.Param("id"))
+	if status := c.Query("status"); status != "" {
+		query = query.Where("p.status = ?", status)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	rows := make([]PriceChangeRow, 0)
+	if err := query.Select(`p.*, m.name AS medicine_name, COALESCE(NULLIF(u.real_name, ''), u.username, '') AS operator_name`).
+		Joins("LEFT JOIN medicines m ON m.id = p.medicine_id").
+		Joins("LEFT JOIN users u ON u.id = p.operator_id").
+		Order("p.effective_from DESC, p.id DESC").Offset(offset).Limit(limit).
+		Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	totalPages := math.Ceil(float64(total) / float64(limit))
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": rows,
+		"meta": Pagination{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(totalPages),
+		},
+	})
+}
+
+// PriceImpact compares a medicine's sales before and after one price change
+type PriceImpact struct {
+	PriceChangeRow
+	WindowDays     int     `json:"window_days"`
+	DaysBefore     float64 `json:"days_before"`
+	DaysAfter      float64 `json:"days_after"`
+	QtyBefore      int     `json:"qty_before"`
+	RevenueBefore  float64 `json:"revenue_before"`
+	QtyAfter       int     `json:"qty_after"`
+	RevenueAfter   float64 `json:"revenue_after"`
+	DailyQtyBefore float64 `json:"daily_qty_before"`
+	DailyQtyAfter  float64 `json:"daily_qty_after"`
+	// PriceEffect is the extra revenue from the new price on the units sold after it
+	PriceEffect float64 `json:"price_effect"`
+	// VolumeEffect is the revenue gained or lost through the change in daily volume
+	VolumeEffect float64 `json:"volume_effect"`
+	// RevenueImpact is the after-period revenue less the before-period daily rate over the same days
+	RevenueImpact float64 `json:"revenue_impact"`
+}
+
+// GetPriceImpactReport measures the sales revenue impact of each applied price
+// change in a date range (&start_date=&end_date=, default the last 90 days;
+// &window= days either side, default 30; &medicine_id=). Each side of a
+// change stops at the neighbouring change of the same medicine, so the
+// comparison is always between two prices.
+func GetPriceImpactReport(c *gin.Context) {
+	start, err := parseDate(c.Query("start_date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date, expected YYYY-MM-DD"})
+		return
+	}
+	end, err := parseDate(c.Query("end_date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date, expected YYYY-MM-DD"})
+		return
+	}
+	today := startOfToday()
+	if end == nil {
+		end = &today
+	}
+	if start == nil {
+		from := end.AddDate(0, 0, -90)
+		start = &from
+	}
+	window, _ := strconv.Atoi(c.DefaultQuery("window", "30"))
+	if window <= 0 {
+		window = 30
+	}
+
+	query := database.DB.Table("medicine_prices p").
+		Select(`p.*, m.name AS medicine_name, COALESCE(NULLIF(u.real_name, ''), u.username, '') AS operator_name`).
+		Joins("LEFT JOIN medicines m ON m.id = p.medicine_id").
+		Joins("LEFT JOIN users u ON u.id = p.operator_id").
+		Where("p.status = ? AND p.prev_price IS NOT NULL AND p.effective_from >= ? AND p.effective_from < ?",
+			priceApplied, *start, end.AddDate(0, 0, 1))
+	if medicineID := c.Query("medicine_id"); medicineID != "" {
+		query = query.Where("p.medicine_id = ?", medicineID)
+	}
+	var changes []PriceChangeRow
+	if err := query.Order("p.effective_from, p.id").Scan(&changes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	impacts := make([]PriceImpact, 0, len(changes))
+	var totalPriceEffect, totalImpact float64
+	for _, ch := range changes {
+		from := ch.EffectiveFrom.AddDate(0, 0, -window)
+		until := ch.EffectiveFrom.AddDate(0, 0, window)
+		if until.After(now) {
+			until = now
+		}
+
+		// Stop at the neighbouring applied changes
+		var prevChange, nextChange model.MedicinePrice
+		database.DB.Where("medicine_id = ? AND status = ? AND effective_from < ?", ch.MedicineID, priceApplied, ch.EffectiveFrom).
+			Order("effective_from DESC").Limit(1).Find(&prevChange)
+		if prevChange.ID != 0 && prevChange.EffectiveFrom.After(from) {
+			from = prevChange.EffectiveFrom
+		}
+		database.DB.Where("medicine_id = ? AND status = ? AND effective_from > ?", ch.MedicineID, priceApplied, ch.EffectiveFrom).
+			Order("effective_from").Limit(1).Find(&nextChange)
+		if nextChange.ID != 0 && nextChange.EffectiveFrom.Before(until) {
+			until = nextChange.EffectiveFrom
+		}
+
+		var sums struct {
+			QtyBefore     int
+			RevenueBefore float64
+			QtyAfter      int
+			RevenueAfter  float64
+		}
+		if err := database.DB.Raw(`SELECT
+				COALESCE(SUM(CASE WHEN sale_date < ? THEN quantity END), 0) AS qty_before,
+				COALESCE(SUM(CASE WHEN sale_date < ? THEN total_price END), 0) AS revenue_before,
+				COALESCE(SUM(CASE WHEN sale_date >= ? THEN quantity END), 0) AS qty_after,
+				COALESCE(SUM(CASE WHEN sale_date >= ? THEN total_price END), 0) AS revenue_after
+			FROM sales WHERE medicine_id = ? AND sale_date >= ? AND sale_date < ? AND status <> ?`,
+			ch.EffectiveFrom, ch.EffectiveFrom, ch.EffectiveFrom, ch.EffectiveFrom,
+			ch.MedicineID, from, until, saleVoided).Scan(&sums).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		imp := PriceImpact{
+			PriceChangeRow: ch,
+			WindowDays:     window,
+			DaysBefore:     math.Round(ch.EffectiveFrom.Sub(from).Hours()/24*10) / 10,
+			DaysAfter:      math.Round(math.Max(until.Sub(ch.EffectiveFrom).Hours(), 0)/24*10) / 10,
+			QtyBefore:      sums.QtyBefore,
+			RevenueBefore:  roundMoney(sums.RevenueBefore),
+			QtyAfter:       sums.QtyAfter,
+			RevenueAfter:   roundMoney(sums.RevenueAfter),
+		}
+		if imp.DaysBefore > 0 {
+			imp.DailyQtyBefore = math.Round(float64(sums.QtyBefore)/imp.DaysBefore*100) / 100
+		}
+		if imp.DaysAfter > 0 {
+			imp.DailyQtyAfter = math.Round(float64(sums.QtyAfter)/imp.DaysAfter*100) / 100
+		}
+		oldPrice := *ch.PrevPrice
+		imp.PriceEffect = roundMoney((ch.Price - oldPrice) * float64(sums.QtyAfter))
+		imp.VolumeEffect = roundMoney((imp.DailyQtyAfter - imp.DailyQtyBefore) * imp.DaysAfter * oldPrice)
+		if imp.DaysBefore > 0 {
+			imp.RevenueImpact = roundMoney(sums.RevenueAfter - sums.RevenueBefore/imp.DaysBefore*imp.DaysAfter)
+		}
+		totalPriceEffect += imp.PriceEffect
+		totalImpact += imp.RevenueImpact
+		impacts = append(impacts, imp)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"start_date":           start.Format("2006-01-02"),
+		"end_date":             end.Format("2006-01-02"),
+		"changes":              impacts,
+		"total_price_effect":   roundMoney(totalPriceEffect),
+		"total_revenue_impact": roundMoney(totalImpact),
+	})
+}
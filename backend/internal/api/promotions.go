@@ -0,0 +1,456 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// ==================== Promotions (促销) ====================
+
+// Promotion rule types
+const (
+	promoPercentage = "percentage" // Value percent off the line
+	promoFixed      = "fixed"      // Value off each unit
+	promoBundle     = "bundle"     // buy BuyQty get FreeQty free, of one medicine
+	promoThreshold  = "threshold"  // Value off once the eligible subtotal reaches Threshold
+)
+
+// Promotion states
+const (
+	promotionActive   = "active"
+	promotionDisabled = "disabled"
+)
+
+// pricedLine is a basket line with its medicine locked at the current price
+// and the promotion discount worked out
+type pricedLine struct {
+	med      model.Medicine
+	quantity int
+	gross    float64
+	discount float64
+	usages   []model.PromotionUsage
+}
+
+func (l *pricedLine) net() float64 {
+	return l.gross - l.discount
+}
+
+// promotionMatches reports whether med falls within a promotion's scope
+func promotionMatches(p *model.Promotion, med *model.Medicine) bool {
+	if p.MedicineID != nil && *p.MedicineID != med.ID {
+		return false
+	}
+	if p.MedicineType != "" && p.MedicineType != med.Type {
+		return false
+	}
+	if p.Manufacturer != "" && p.Manufacturer != med.Manufacturer {
+		return false
+	}
+	if p.NameKeyword != "" && !strings.Contains(med.Name, p.NameKeyword) {
+		return false
+	}
+	return true
+}
+
+// lineDiscount is what a line-level promotion takes off one line, never more
+// than the line itself. free is the line's share of a bundle's free units.
+func lineDiscount(p *model.Promotion, line *pricedLine, free int) float64 {
+	var d float64
+	switch p.RuleType {
+	case promoPercentage:
+		d = line.gross * p.Value / 100
+	case promoFixed:
+		d = p.Value * float64(line.quantity)
+	case promoBundle:
+		d = line.med.Price * float64(free)
+	}
+	return roundMoney(math.Min(d, line.gross))
+}
+
+// bundleFreeUnits works a bundle out over the whole basket: all lines of a
+// medicine count towards buy + free together, and the free units are
+// credited to those lines in basket order
+func bundleFreeUnits(p *model.Promotion, lines []*pricedLine) map[*pricedLine]int {
+	bought := make(map[int64]int)
+	for _, line := range lines {
+		if promotionMatches(p, &line.med) {
+			bought[line.med.ID] += line.quantity
+		}
+	}
+	free := make(map[int64]int, len(bought))
+	for id, qty := range bought {
+		free[id] = qty / (p.BuyQty + p.FreeQty) * p.FreeQty
+	}
+
+	units := make(map[*pricedLine]int)
+	for _, line := range lines {
+		if n := min(free[line.med.ID], line.quantity); n > 0 {
+			units[line] = n
+			free[line.med.ID] -= n
+		}
+	}
+	return units
+}
+
+// applyPromotions discounts a priced basket. Each line gets the single best
+// line-level promotion (percentage, fixed or bundle, the latter counted over
+// every line of its medicine); then the best threshold
+// promotion is applied once to the basket and spread over its eligible lines
+// in proportion to their amounts. Member-only promotions need a registered
+// customer.
+func applyPromotions(tx *gorm.DB, customerID int64, lines []*pricedLine) error {
+	now := time.Now()
+	query := tx.Where("status = ? AND start_at <= ? AND end_at > ?", promotionActive, now, now)
+	if isWalkIn(customerID) {
+		query = query.Where("member_only = ?", false)
+	}
+	var promos []model.Promotion
+	if err := query.Order("id").Find(&promos).Error; err != nil {
+		return err
+	}
+
+	bundles := make(map[int64]map[*pricedLine]int)
+	for i := range promos {
+		if promos[i].RuleType == promoBundle {
+			bundles[promos[i].ID] = bundleFreeUnits(&promos[i], lines)
+		}
+	}
+
+	for _, line := range lines {
+		var best *model.Promotion
+		bestDiscount := 0.0
+		for i := range promos {
+			p := &promos[i]
+			if p.RuleType == promoThreshold || !promotionMatches(p, &line.med) {
+				continue
+			}
+			if d := lineDiscount(p, line, bundles[p.ID][line]); d > bestDiscount {
+				best, bestDiscount = p, d
+			}
+		}
+		if best != nil {
+			line.discount = bestDiscount
+			line.usages = append(line.usages, promotionUsage(best, line, bestDiscount))
+		}
+	}
+
+	var best *model.Promotion
+	var bestLines []*pricedLine
+	bestDiscount, bestSubtotal := 0.0, 0.0
+	for i := range promos {
+		p := &promos[i]
+		if p.RuleType != promoThreshold {
+			continue
+		}
+		var eligible []*pricedLine
+		subtotal := 0.0
+		for _, line := range lines {
+			if promotionMatches(p, &line.med) && line.net() > 0 {
+				eligible = append(eligible, line)
+				subtotal += line.net()
+			}
+		}
+		if len(eligible) == 0 || subtotal < p.Threshold {
+			continue
+		}
+		if d := roundMoney(math.Min(p.Value, subtotal)); d > bestDiscount {
+			best, bestLines, bestDiscount, bestSubtotal = p, eligible, d, subtotal
+		}
+	}
+	if best == nil {
+		return nil
+	}
+
+	// Spread the discount; the last line takes the rounding remainder
+	left := bestDiscount
+	for i, line := range bestLines {
+		share := roundMoney(bestDiscount * line.net() / bestSubtotal)
+		if i == len(bestLines)-1 {
+			share = roundMoney(math.Min(left, line.net()))
+		}
+		left -= share
+		if share <= 0 {
+			continue
+		}
+		line.discount = roundMoney(line.discount + share)
+		line.usages = append(line.usages, promotionUsage(best, line, share))
+	}
+	return nil
+}
+
+// promotionUsage starts the usage record of a discount; the order and sale
+// are filled in once the line is saved
+func promotionUsage(p *model.Promotion, line *pricedLine, discount float64) model.PromotionUsage {
+	return model.PromotionUsage{
+		PromotionID:   p.ID,
+		PromotionName: p.Name,
+		RuleType:      p.RuleType,
+		MedicineID:    line.med.ID,
+		Quantity:      line.quantity,
+		Discount:      discount,
+	}
+}
+
+// promotionRequest is the body of POST and PUT /api/promotions
+type promotionRequest struct {
+	Name         string  `json:"name" binding:"required"`
+	RuleType     string  `json:"rule_type" binding:"required"`
+	Value        float64 `json:"value"`
+	BuyQty       int     `json:"buy_qty"`
+	FreeQty      int     `json:"free_qty"`
+	Threshold    float64 `json:"threshold"`
+	StartAt      string  `json:"start_at" binding:"required"` // YYYY-MM-DD [HH:MM]
+	EndAt        string  `json:"end_at" binding:"required"`   // a bare date runs to the end of that day
+	MemberOnly   bool    `json:"member_only"`
+	MedicineID   *int64  `json:"medicine_id"`
+	MedicineType string  `json:"medicine_type"`
+	Manufacturer string  `json:"manufacturer"`
+	NameKeyword  string  `json:"name_keyword"`
+	Status       string  `json:"status"`
+}
+
+// apply validates the request and copies it onto p
+func (r *promotionRequest) apply(p *model.Promotion) error {
+	switch r.RuleType {
+	case promoPercentage:
+		if r.Value <= 0 || r.Value > 100 {
+			return newAPIError(http.StatusBadRequest, "Percentage must be between 0 and 100")
+		}
+	case promoFixed:
+		if r.Value <= 0 {
+			return newAPIError(http.StatusBadRequest, "Amount off must be positive")
+		}
+	case promoBundle:
+		if r.BuyQty <= 0 || r.FreeQty <= 0 {
+			return newAPIError(http.StatusBadRequest, "Bundle needs positive buy_qty and free_qty")
+		}
+		// Buy-and-get-free is per product; mixing products would leave the
+		// free unit's price undefined
+		if r.MedicineID == nil {
+			return newAPIError(http.StatusBadRequest, "Bundle needs a medicine_id")
+		}
+	case promoThreshold:
+		if r.Value <= 0 || r.Threshold <= 0 {
+			return newAPIError(http.StatusBadRequest, "Threshold promotion needs positive threshold and value")
+		}
+	default:
+		return newAPIError(http.StatusBadRequest, "rule_type must be percentage, fixed, bundle or threshold")
+	}
+
+	start, err := parseEffectiveTime(r.StartAt)
+	if err != nil {
+		return newAPIError(http.StatusBadRequest, "Invalid start_at, expected YYYY-MM-DD HH:MM")
+	}
+	end, err := parseEffectiveTime(r.EndAt)
+	if err != nil {
+		return newAPIError(http.StatusBadRequest, "Invalid end_at, expected YYYY-MM-DD HH:MM")
+	}
+	if len(r.EndAt) == len("2006-01-02") {
+		end = end.AddDate(0, 0, 1)
+	}
+	if !end.After(start) {
+		return newAPIError(http.StatusBadRequest, "end_at must be after start_at")
+	}
+
+	status := r.Status
+	if status == "" {
+		status = promotionActive
+	}
+	if status != promotionActive && status != promotionDisabled {
+		return newAPIError(http.StatusBadRequest, "status must be active or disabled")
+	}
+
+	p.Name = strings.TrimSpace(r.Name)
+	p.RuleType = r.RuleType
+	p.Value = roundMoney(r.Value)
+	p.BuyQty, p.FreeQty = r.BuyQty, r.FreeQty
+	p.Threshold = roundMoney(r.Threshold)
+	p.StartAt, p.EndAt = start, end
+	p.MemberOnly = r.MemberOnly
+	p.MedicineID = r.MedicineID
+	if p.MedicineID != nil && *p.MedicineID == 0 {
+		p.MedicineID = nil
+	}
+	p.MedicineType = strings.TrimSpace(r.MedicineType)
+	p.Manufacturer = strings.TrimSpace(r.Manufacturer)
+	p.NameKeyword = strings.TrimSpace(r.NameKeyword)
+	p.Status = status
+	return nil
+}
+
+// GetPromotions lists promotions, newest first (&status=, &current=1 for those
+// running now)
+func GetPromotions(c *gin.Context) {
+	page, limit, offset := getPaginationParams(c)
+
+	query := database.DB.Model(&model.Promotion{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if c.Query("current") == "1" {
+		now := time.Now()
+		query = query.Where("status = ? AND start_at <= ? AND end_at > ?", promotionActive, now, now)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	promos := make([]model.Promotion, 0)
+	if err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&promos).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	totalPages := math.Ceil(float64(total) / float64(limit))
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": promos,
+		"meta": Pagination{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(totalPages),
+		},
+	})
+}
+
+func CreatePromotion(c *gin.Context) {
+	var req promotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	promo := model.Promotion{CreatedBy: currentUserID(c)}
+	if err := req.apply(&promo); err != nil {
+		respondError(c, err)
+		return
+	}
+	if err := database.DB.Create(&promo).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, promo)
+}
+
+// UpdatePromotion replaces a promotion's rule. Sales already made keep the
+// discount they were given.
+func UpdatePromotion(c *gin.Context) {
+	var promo model.Promotion
+	if err := database.DB.First(&promo, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+	var req promotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.apply(&promo); err != nil {
+		respondError(c, err)
+		return
+	}
+	if err := database.DB.Save(&promo).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, promo)
+}
+
+// DeletePromotion removes a promotion that has never been used; used ones
+// must be disabled so the report keeps its name
+func DeletePromotion(c *gin.Context) {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var promo model.Promotion
+		if err := tx.First(&promo, c.Param("id")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return newAPIError(http.StatusNotFound, "Promotion not found")
+			}
+			return err
+		}
+		var used int64
+		if err := tx.Model(&model.PromotionUsage{}).Where("promotion_id = ?", promo.ID).Count(&used).Error; err != nil {
+			return err
+		}
+		if used > 0 {
+			return newAPIError(http.StatusConflict, "Promotion has been used, disable it instead")
+		}
+		return tx.Delete(&promo).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Promotion deleted"})
+}
+
+// PromotionReportRow is the usage of one promotion over a period
+type PromotionReportRow struct {
+	PromotionID   int64   `json:"promotion_id"`
+	PromotionName string  `json:"promotion_name"`
+	RuleType      string  `json:"rule_type"`
+	OrderCount    int     `json:"order_count"`
+	LineCount     int     `json:"line_count"`
+	Quantity      int     `json:"quantity"`
+	Discount      float64 `json:"discount"`
+	NetRevenue    float64 `json:"net_revenue"` // what the discounted lines brought in
+}
+
+// GetPromotionReport summarises promotion usage per promotion
+// (&start_date=&end_date=, default the current month)
+func GetPromotionReport(c *gin.Context) {
+	start, err := parseDate(c.Query("start_date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date, expected YYYY-MM-DD"})
+		return
+	}
+	end, err := parseDate(c.Query("end_date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date, expected YYYY-MM-DD"})
+		return
+	}
+	today := startOfToday()
+	if end == nil {
+		end = &today
+	}
+	if start == nil {
+		first := time.Date(end.Year(), end.Month(), 1, 0, 0, 0, 0, end.Location())
+		start = &first
+	}
+
+	rows := make([]PromotionReportRow, 0)
+	if err := database.DB.Raw(`SELECT u.promotion_id,
+			COALESCE(p.name, MAX(u.promotion_name)) AS promotion_name,
+			MAX(u.rule_type) AS rule_type,
+			COUNT(DISTINCT u.order_no) AS order_count,
+			COUNT(*) AS line_count,
+			COALESCE(SUM(s.quantity), 0) AS quantity,
+			COALESCE(SUM(u.discount), 0) AS discount,
+			COALESCE(SUM(s.total_price), 0) AS net_revenue
+		FROM promotion_usages u
+		JOIN sales s ON s.id = u.sale_id
+		LEFT JOIN promotions p ON p.id = u.promotion_id
+		WHERE u.created_at >= ? AND u.created_at < ? AND s.status <> ?
+		GROUP BY u.promotion_id, p.name
+		ORDER BY discount DESC`, *start, end.AddDate(0, 0, 1), saleVoided).Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var totalDiscount float64
+	for _, r := range rows {
+		totalDiscount += r.Discount
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"start_date":     start.Format("2006-01-02"),
+		"end_date":       end.Format("2006-01-02"),
+		"promotions":     rows,
+		"total_discount": roundMoney(totalDiscount),
+	})
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+)
+
+func TestBundleCountsWholeBasket(t *testing.T) {
+	medID := int64(7)
+	p := &model.Promotion{RuleType: promoBundle, BuyQty: 2, FreeQty: 1, MedicineID: &medID}
+	med := model.Medicine{ID: medID, Price: 10}
+	other := model.Medicine{ID: 8, Price: 10}
+	lines := []*pricedLine{
+		{med: med, quantity: 2, gross: 20},
+		{med: other, quantity: 3, gross: 30},
+		{med: med, quantity: 4, gross: 40},
+	}
+
+	// Six units of the medicine over two lines earn two free units
+	free := bundleFreeUnits(p, lines)
+	if free[lines[0]] != 2 || free[lines[1]] != 0 || free[lines[2]] != 0 {
+		t.Fatalf("got free units %v, want 2 on the first line only", free)
+	}
+	if d := lineDiscount(p, lines[0], free[lines[0]]); d != 20 {
+		t.Fatalf("got discount %.2f, want 20", d)
+	}
+}
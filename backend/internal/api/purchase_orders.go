@@ -0,0 +1,453 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ==================== Purchase Orders (采购订单) ====================
+
+const (
+	poDraft             = "draft"
+	poApproved          = "approved"
+	poPartiallyReceived = "partially_received"
+	poClosed            = "closed"
+	poCancelled         = "cancelled"
+)
+
+// purchaseOrderRequest is the body of POST and PUT /api/purchase-orders
+type purchaseOrderRequest struct {
+	SupplierID int64  `json:"supplier_id"`
+	Remark     string `json:"remark"`
+	Lines      []struct {
+		MedicineID    int64   `json:"medicine_id"`
+		Quantity      int     `json:"quantity"`
+		ExpectedPrice float64 `json:"expected_price"`
+	} `json:"lines"`
+}
+
+// buildLines validates the requested lines and returns them with the order total
+func (r *purchaseOrderRequest) buildLines(tx *gorm.DB, poID int64) ([]model.PurchaseOrderLine, float64, error) {
+	if len(r.Lines) == 0 {
+		return nil, 0, newAPIError(http.StatusBadRequest, "Purchase order has no lines")
+	}
+
+	seen := make(map[int64]bool)
+	lines := make([]model.PurchaseOrderLine, 0, len(r.Lines))
+	var total float64
+	for i, in := range r.Lines {
+		if in.Quantity <= 0 {
+			return nil, 0, newAPIError(http.StatusBadRequest, "Line %d: quantity must be positive", i+1)
+		}
+		if in.ExpectedPrice < 0 {
+			return nil, 0, newAPIError(http.StatusBadRequest, "Line %d: expected_price cannot be negative", i+1)
+		}
+		if seen[in.MedicineID] {
+			return nil, 0, newAPIError(http.StatusBadRequest, "Line %d: medicine %d is already on this order", i+1, in.MedicineID)
+		}
+		seen[in.MedicineID] = true
+
+		var med model.Medicine
+		if err := tx.First(&med, in.MedicineID).Error; err != nil {
+			return nil, 0, newAPIError(http.StatusBadRequest, "Line %d: medicine not found", i+1)
+		}
+		lines = append(lines, model.PurchaseOrderLine{
+			PurchaseOrderID: poID,
+			MedicineID:      med.ID,
+			Quantity:        in.Quantity,
+			ExpectedPrice:   in.ExpectedPrice,
+		})
+		total += in.ExpectedPrice * float64(in.Quantity)
+	}
+	return lines, roundMoney(total), nil
+}
+
+func checkSupplier(tx *gorm.DB, supplierID int64) error {
+	var supplier model.Supplier
+	if err := tx.First(&supplier, supplierID).Error; err != nil {
+		return newAPIError(http.StatusBadRequest, "Supplier not found")
+	}
+	return nil
+}
+
+// loadPurchaseOrder reads a purchase order header, optionally locking it
+func loadPurchaseOrder(db *gorm.DB, id any, lock bool) (*model.PurchaseOrder, error) {
+	if lock {
+		db = db.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	var po model.PurchaseOrder
+	if err := db.First(&po, id).Error; err != nil {
+		return nil, newAPIError(http.StatusNotFound, "Purchase order not found")
+	}
+	return &po, nil
+}
+
+// CreatePurchaseOrder saves a draft purchase order
+func CreatePurchaseOrder(c *gin.Context) {
+	var req purchaseOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	po := &model.PurchaseOrder{
+		SupplierID: req.SupplierID,
+		Status:     poDraft,
+		Remark:     req.Remark,
+		CreatedBy:  currentUserID(c),
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkSupplier(tx, req.SupplierID); err != nil {
+			return err
+		}
+		if err := createNumbered(tx, po, "PO", func(no string) { po.PONo = no }); err != nil {
+			return err
+		}
+		lines, total, err := req.buildLines(tx, po.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(&lines).Error; err != nil {
+			return err
+		}
+		po.Lines = lines
+		po.TotalAmount = total
+		return tx.Model(po).Update("total_amount", total).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, po)
+}
+
+// UpdatePurchaseOrder replaces the supplier, remark and lines of a draft
+func UpdatePurchaseOrder(c *gin.Context) {
+	var req purchaseOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var po *model.PurchaseOrder
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if po, err = loadPurchaseOrder(tx, c.Param("id"), true); err != nil {
+			return err
+		}
+		if po.Status != poDraft {
+			return newAPIError(http.StatusConflict, "Only draft purchase orders can be edited")
+		}
+		if err := checkSupplier(tx, req.SupplierID); err != nil {
+			return err
+		}
+		lines, total, err := req.buildLines(tx, po.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("purchase_order_id = ?", po.ID).Delete(&model.PurchaseOrderLine{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&lines).Error; err != nil {
+			return err
+		}
+		po.SupplierID, po.Remark, po.TotalAmount, po.Lines = req.SupplierID, req.Remark, total, lines
+		return tx.Model(po).Select("supplier_id", "remark", "total_amount").Updates(po).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, po)
+}
+
+// GetPurchaseOrders lists purchase orders, newest first.
+// Optional filters: status, supplier_id, keyword (PO number).
+func GetPurchaseOrders(c *gin.Context) {
+	page, limit, offset := getPaginationParams(c)
+
+	query := database.DB.Model(&model.PurchaseOrder{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if supplierID := c.Query("supplier_id"); supplierID != "" {
+		query = query.Where("supplier_id = ?", supplierID)
+	}
+	if keyword := c.Query("keyword"); keyword != "" {
+		query = query.Where("po_no LIKE ?", "%"+keyword+"%")
+	}
+
+	var total int64
+	query.Count(&total)
+
+	orders := make([]model.PurchaseOrder, 0)
+	if err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&orders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	totalPages := math.Ceil(float64(total) / float64(limit))
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": orders,
+		"meta": Pagination{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(totalPages),
+		},
+	})
+}
+
+// GetPurchaseOrder returns one purchase order with its lines and their
+// outstanding quantities
+func GetPurchaseOrder(c *gin.Context) {
+	po, err := loadPurchaseOrder(database.DB, c.Param("id"), false)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	var supplier model.Supplier
+	if database.DB.First(&supplier, po.SupplierID).Error == nil {
+		po.Supplier = &supplier
+	}
+
+	lines := make([]POLineStatus, 0)
+	if err := database.DB.Raw("SELECT * FROM v_purchase_order_lines WHERE purchase_order_id = ? ORDER BY line_id", po.ID).
+		Scan(&lines).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var receipts []model.Inbound
+	database.DB.Joins("JOIN purchase_order_lines l ON l.id = inbounds.po_line_id").
+		Where("l.purchase_order_id = ?", po.ID).Order("inbounds.id").Find(&receipts)
+
+	c.JSON(http.StatusOK, gin.H{
+		"purchase_order": po,
+		"lines":          lines,
+		"receipts":       receipts,
+	})
+}
+
+// ApprovePurchaseOrder releases a draft so goods can be received against it
+func ApprovePurchaseOrder(c *gin.Context) {
+	var po *model.PurchaseOrder
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if po, err = loadPurchaseOrder(tx, c.Param("id"), true); err != nil {
+			return err
+		}
+		if po.Status != poDraft {
+			return newAPIError(http.StatusConflict, "Purchase order is already %s", po.Status)
+		}
+		now := time.Now()
+		po.Status, po.ApprovedBy, po.ApprovedAt = poApproved, currentUserID(c), &now
+		return tx.Model(po).Select("status", "approved_by", "approved_at").Updates(po).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, po)
+}
+
+// ClosePurchaseOrder closes an order short (nothing more will be received)
+// or cancels a draft
+func ClosePurchaseOrder(c *gin.Context) {
+	var po *model.PurchaseOrder
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if po, err = loadPurchaseOrder(tx, c.Param("id"), true); err != nil {
+			return err
+		}
+		now := time.Now()
+		switch po.Status {
+		case poDraft:
+			po.Status = poCancelled
+		case poApproved, poPartiallyReceived:
+			po.Status = poClosed
+		default:
+			return newAPIError(http.StatusConflict, "Purchase order is already %s", po.Status)
+		}
+		po.ClosedBy, po.ClosedAt = currentUserID(c), &now
+		return tx.Model(po).Select("status", "closed_by", "closed_at").Updates(po).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, po)
+}
+
+// ==================== Receiving Against Purchase Orders ====================
+
+// applyPOLine checks that an inbound may be received against its purchase
+// order line and fills in the medicine, supplier and price from the order
+// where the request left them empty. The line is locked until commit.
+func applyPOLine(tx *gorm.DB, inbound *model.Inbound) error {
+	var line model.PurchaseOrderLine
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&line, *inbound.POLineID).Error; err != nil {
+		return newAPIError(http.StatusBadRequest, "Purchase order line not found")
+	}
+	po, err := loadPurchaseOrder(tx, line.PurchaseOrderID, true)
+	if err != nil {
+		return err
+	}
+	if po.Status != poApproved && po.Status != poPartiallyReceived {
+		return newAPIError(http.StatusConflict, "Purchase order %s is %s and cannot be received against", po.PONo, po.Status)
+	}
+
+	if inbound.MedicineID == 0 {
+		inbound.MedicineID = line.MedicineID
+	} else if inbound.MedicineID != line.MedicineID {
+		return newAPIError(http.StatusBadRequest, "Medicine does not match purchase order line")
+	}
+	if inbound.SupplierID == 0 {
+		inbound.SupplierID = po.SupplierID
+	} else if inbound.SupplierID != po.SupplierID {
+		return newAPIError(http.StatusBadRequest, "Supplier does not match purchase order %s", po.PONo)
+	}
+	if inbound.Price == 0 {
+		inbound.Price = line.ExpectedPrice
+	}
+	return nil
+}
+
+// recordPOReceipt adds a created inbound to its line's received quantity.
+// Over-receipts are accepted and show up in the purchase order report.
+func recordPOReceipt(tx *gorm.DB, inbound *model.Inbound) error {
+	var line model.PurchaseOrderLine
+	if err := tx.First(&line, *inbound.POLineID).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&line).UpdateColumn("received_qty", gorm.Expr("received_qty + ?", inbound.Quantity)).Error; err != nil {
+		return err
+	}
+	return tx.Exec("CALL sp_refresh_po_status(?)", line.PurchaseOrderID).Error
+}
+
+// ==================== Purchase Order Report ====================
+
+// POLineStatus is one row of the v_purchase_order_lines view
+type POLineStatus struct {
+	LineID          int64     `json:"line_id"`
+	PurchaseOrderID int64     `json:"purchase_order_id"`
+	PONo            string    `gorm:"column:po_no" json:"po_no"`
+	Status          string    `json:"status"`
+	SupplierID      int64     `json:"supplier_id"`
+	SupplierName    string    `json:"supplier_name"`
+	MedicineID      int64     `json:"medicine_id"`
+	MedicineCode    string    `json:"medicine_code"`
+	MedicineName    string    `json:"medicine_name"`
+	Quantity        int       `json:"quantity"`
+	ExpectedPrice   float64   `json:"expected_price"`
+	ReceivedQty     int       `json:"received_qty"`
+	OutstandingQty  int       `json:"outstanding_qty"`
+	OverReceivedQty int       `json:"over_received_qty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// SupplierOutstanding sums what is still to be delivered by one supplier
+type SupplierOutstanding struct {
+	SupplierID     int64          `json:"supplier_id"`
+	SupplierName   string         `json:"supplier_name"`
+	OutstandingQty int            `json:"outstanding_qty"`
+	ExpectedValue  float64        `json:"expected_value"`
+	Lines          []POLineStatus `json:"lines"`
+}
+
+// POPriceVariance is a receipt whose price differs from the purchase order
+type POPriceVariance struct {
+	InboundID      int64     `json:"inbound_id"`
+	InboundDate    time.Time `json:"inbound_date"`
+	PONo           string    `gorm:"column:po_no" json:"po_no"`
+	SupplierID     int64     `json:"supplier_id"`
+	SupplierName   string    `json:"supplier_name"`
+	MedicineID     int64     `json:"medicine_id"`
+	MedicineName   string    `json:"medicine_name"`
+	Quantity       int       `json:"quantity"`
+	ExpectedPrice  float64   `json:"expected_price"`
+	ActualPrice    float64   `json:"actual_price"`
+	VarianceAmount float64   `json:"variance_amount"` // (actual - expected) * quantity
+}
+
+// GetPurchaseOrderReport reports outstanding quantities per supplier,
+// over-received lines and receipts priced differently from the order.
+// Optional filter: supplier_id.
+func GetPurchaseOrderReport(c *gin.Context) {
+	supplierID := c.Query("supplier_id")
+
+	lineQuery := database.DB.Table("v_purchase_order_lines").
+		Where("outstanding_qty > 0 OR over_received_qty > 0")
+	if supplierID != "" {
+		lineQuery = lineQuery.Where("supplier_id = ?", supplierID)
+	}
+	var lines []POLineStatus
+	if err := lineQuery.Order("supplier_id, purchase_order_id, line_id").Scan(&lines).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	bySupplier := make(map[int64]*SupplierOutstanding)
+	overReceipts := make([]POLineStatus, 0)
+	for _, line := range lines {
+		if line.OverReceivedQty > 0 {
+			overReceipts = append(overReceipts, line)
+		}
+		if line.OutstandingQty == 0 {
+			continue
+		}
+		g, ok := bySupplier[line.SupplierID]
+		if !ok {
+			g = &SupplierOutstanding{SupplierID: line.SupplierID, SupplierName: line.SupplierName}
+			bySupplier[line.SupplierID] = g
+		}
+		g.OutstandingQty += line.OutstandingQty
+		g.ExpectedValue = roundMoney(g.ExpectedValue + line.ExpectedPrice*float64(line.OutstandingQty))
+		g.Lines = append(g.Lines, line)
+	}
+	outstanding := make([]SupplierOutstanding, 0, len(bySupplier))
+	for _, g := range bySupplier {
+		outstanding = append(outstanding, *g)
+	}
+	sort.Slice(outstanding, func(i, j int) bool { return outstanding[i].ExpectedValue > outstanding[j].ExpectedValue })
+
+	varianceQuery := database.DB.Table("inbounds i").
+		Select(`i.id AS inbound_id, i.inbound_date, v.po_no, v.supplier_id, v.supplier_name,
+			v.medicine_id, v.medicine_name, i.quantity, v.expected_price, i.price AS actual_price,
+			(i.price - v.expected_price) * i.quantity AS variance_amount`).
+		Joins("JOIN v_purchase_order_lines v ON v.line_id = i.po_line_id").
+		Where("i.price <> v.expected_price")
+	if supplierID != "" {
+		varianceQuery = varianceQuery.Where("v.supplier_id = ?", supplierID)
+	}
+	variances := make([]POPriceVariance, 0)
+	if err := varianceQuery.Order("i.inbound_date DESC").Scan(&variances).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var varianceTotal float64
+	for _, v := range variances {
+		varianceTotal += v.VarianceAmount
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"outstanding_by_supplier": outstanding,
+		"over_receipts":           overReceipts,
+		"price_variances":         variances,
+		"price_variance_total":    roundMoney(varianceTotal),
+	})
+}
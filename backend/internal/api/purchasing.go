@@ -0,0 +1,246 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+)
+
+// ==================== Purchase Suggestions (采购建议) ====================
+
+// Defaults for the suggestion parameters, all in days
+const (
+	suggestionSalesWindow = 30 // sales history used for velocity
+	suggestionLeadTime    = 7  // supplier delivery time
+	suggestionCoverDays   = 14 // demand to cover above the reorder point
+)
+
+// PurchaseSuggestion is the proposed order for one medicine
+type PurchaseSuggestion struct {
+	MedicineID     int64   `json:"medicine_id"`
+	MedicineCode   string  `json:"medicine_code"`
+	MedicineName   string  `json:"medicine_name"`
+	Spec           string  `json:"spec"`
+	Stock          int     `json:"stock"`
+	SellableStock  int     `json:"sellable_stock"` // without recalled, expired and quarantined lots
+	OnOrderQty     int     `json:"on_order_qty"`   // approved but not yet received
+	ReorderPoint   int     `json:"reorder_point"`
+	MaxStock       *int    `json:"max_stock"`
+	SoldQuantity   int     `json:"sold_quantity"`  // within the sales window
+	DailyVelocity  float64 `json:"daily_velocity"` // units per day
+	ProjectedStock float64 `json:"projected_stock"`
+	TargetLevel    int     `json:"target_level"`
+	SuggestedQty   int     `json:"suggested_qty"`
+	LastPrice      float64 `json:"last_price"`
+	LastInboundAt  *string `json:"last_inbound_at"`
+	EstimatedCost  float64 `json:"estimated_cost"`
+}
+
+// SupplierSuggestions groups suggestions by the supplier last bought from
+type SupplierSuggestions struct {
+	SupplierID    int64                `json:"supplier_id"`
+	SupplierName  string               `json:"supplier_name"`
+	ItemCount     int                  `json:"item_count"`
+	TotalQuantity int                  `json:"total_quantity"`
+	EstimatedCost float64              `json:"estimated_cost"`
+	Items         []PurchaseSuggestion `json:"items"`
+}
+
+// queryDays reads a non-negative day count from the query string
+func queryDays(c *gin.Context, key string, def int) (int, error) {
+	days, err := strconv.Atoi(c.DefaultQuery(key, strconv.Itoa(def)))
+	if err != nil || days < 0 {
+		return 0, newAPIError(http.StatusBadRequest, "Invalid %s", key)
+	}
+	return days, nil
+}
+
+// GetPurchaseSuggestions proposes order quantities per supplier.
+//
+// For each active medicine the daily sales velocity over ?days= (default 30)
+// projects the stock left after ?lead_time= days, counting only sellable
+// stock plus what approved purchase orders still have to deliver. When that falls below the
+// reorder point, the suggestion tops stock up to max_stock, or, without one,
+// to the reorder point plus ?cover_days= of demand. Supplier and price come
+// from the medicine's most recent inbound.
+func GetPurchaseSuggestions(c *gin.Context) {
+	window, err := queryDays(c, "days", suggestionSalesWindow)
+	if err == nil && window == 0 {
+		err = newAPIError(http.StatusBadRequest, "days must be positive")
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	leadTime, err := queryDays(c, "lead_time", suggestionLeadTime)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	coverDays, err := queryDays(c, "cover_days", suggestionCoverDays)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	var medicines []model.Medicine
+	if err := database.DB.Where("status = ?", "active").Order("id").Find(&medicines).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Units sold per medicine within the window
+	type soldRow struct {
+		MedicineID int64
+		Quantity   int
+	}
+	var sold []soldRow
+	since := startOfToday().AddDate(0, 0, -window+1)
+	database.DB.Raw(`SELECT medicine_id, SUM(quantity) AS quantity FROM sales
+		WHERE sale_date >= ? AND status <> ? GROUP BY medicine_id`, since, saleVoided).Scan(&sold)
+	soldBy := make(map[int64]int, len(sold))
+	for _, r := range sold {
+		soldBy[r.MedicineID] = r.Quantity
+	}
+
+	// Units held in lots that cannot be sold: recalled, quarantined or expired
+	type heldRow struct {
+		MedicineID int64
+		Quantity   int
+	}
+	var held []heldRow
+	if err := database.DB.Raw(`SELECT medicine_id, SUM(remaining) AS quantity FROM stock_lots
+		WHERE remaining > 0 AND (status <> ? OR expiry_date < ?) GROUP BY medicine_id`,
+		lotStatusActive, startOfToday()).Scan(&held).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	heldBy := make(map[int64]int, len(held))
+	for _, r := range held {
+		heldBy[r.MedicineID] = r.Quantity
+	}
+
+	// Units still to come on approved purchase orders
+	var onOrder []heldRow
+	if err := database.DB.Raw(`SELECT l.medicine_id, SUM(l.quantity - l.received_qty) AS quantity
+		FROM purchase_order_lines l
+		JOIN purchase_orders po ON po.id = l.purchase_order_id
+		WHERE po.status IN ? AND l.quantity > l.received_qty
+		GROUP BY l.medicine_id`, []string{poApproved, poPartiallyReceived}).Scan(&onOrder).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	onOrderBy := make(map[int64]int, len(onOrder))
+	for _, r := range onOrder {
+		onOrderBy[r.MedicineID] = r.Quantity
+	}
+
+	// Most recent inbound per medicine
+	type lastInbound struct {
+		MedicineID   int64
+		SupplierID   int64
+		SupplierName string
+		Price        float64
+		InboundDate  time.Time
+	}
+	var last []lastInbound
+	database.DB.Raw(`SELECT i.medicine_id, i.supplier_id, COALESCE(sup.name, '') AS supplier_name, i.price, i.inbound_date
+		FROM inbounds i
+		JOIN (SELECT medicine_id, MAX(id) AS id FROM inbounds GROUP BY medicine_id) latest ON latest.id = i.id
+		LEFT JOIN suppliers sup ON sup.id = i.supplier_id`).Scan(&last)
+	lastBy := make(map[int64]lastInbound, len(last))
+	for _, r := range last {
+		lastBy[r.MedicineID] = r
+	}
+
+	groups := make(map[int64]*SupplierSuggestions)
+	var totalQuantity int
+	var totalCost float64
+	for i := range medicines {
+		med := &medicines[i]
+		point := effectiveReorderPoint(med)
+		velocity := float64(soldBy[med.ID]) / float64(window)
+		sellable := max(med.Stock-heldBy[med.ID], 0)
+		projected := float64(sellable+onOrderBy[med.ID]) - velocity*float64(leadTime)
+		if projected >= float64(point) {
+			continue
+		}
+
+		target := point + int(math.Ceil(velocity*float64(coverDays)))
+		if med.MaxStock != nil {
+			target = *med.MaxStock
+		}
+		qty := int(math.Ceil(float64(target) - projected))
+		if qty <= 0 {
+			continue
+		}
+
+		s := PurchaseSuggestion{
+			MedicineID:     med.ID,
+			MedicineCode:   med.Code,
+			MedicineName:   med.Name,
+			Spec:           med.Spec,
+			Stock:          med.Stock,
+			SellableStock:  sellable,
+			OnOrderQty:     onOrderBy[med.ID],
+			ReorderPoint:   point,
+			MaxStock:       med.MaxStock,
+			SoldQuantity:   soldBy[med.ID],
+			DailyVelocity:  math.Round(velocity*100) / 100,
+			ProjectedStock: math.Round(projected*100) / 100,
+			TargetLevel:    target,
+			SuggestedQty:   qty,
+		}
+		supplierID, supplierName := int64(0), "未知供应商"
+		if in, ok := lastBy[med.ID]; ok {
+			s.LastPrice = in.Price
+			date := in.InboundDate.Format("2006-01-02")
+			s.LastInboundAt = &date
+			s.EstimatedCost = roundMoney(in.Price * float64(qty))
+			supplierID = in.SupplierID
+			if in.SupplierName != "" {
+				supplierName = in.SupplierName
+			}
+		}
+
+		g, ok := groups[supplierID]
+		if !ok {
+			g = &SupplierSuggestions{SupplierID: supplierID, SupplierName: supplierName}
+			groups[supplierID] = g
+		}
+		g.Items = append(g.Items, s)
+		g.ItemCount++
+		g.TotalQuantity += qty
+		g.EstimatedCost = roundMoney(g.EstimatedCost + s.EstimatedCost)
+		totalQuantity += qty
+		totalCost += s.EstimatedCost
+	}
+
+	suppliers := make([]SupplierSuggestions, 0, len(groups))
+	for _, g := range groups {
+		// Most urgent first: lowest projected stock relative to the reorder point
+		sort.SliceStable(g.Items, func(i, j int) bool {
+			return g.Items[i].ProjectedStock-float64(g.Items[i].ReorderPoint) <
+				g.Items[j].ProjectedStock-float64(g.Items[j].ReorderPoint)
+		})
+		suppliers = append(suppliers, *g)
+	}
+	sort.Slice(suppliers, func(i, j int) bool { return suppliers[i].EstimatedCost > suppliers[j].EstimatedCost })
+
+	c.JSON(http.StatusOK, gin.H{
+		"parameters": gin.H{
+			"days":       window,
+			"lead_time":  leadTime,
+			"cover_days": coverDays,
+		},
+		"suppliers":      suppliers,
+		"total_quantity": totalQuantity,
+		"estimated_cost": roundMoney(totalCost),
+	})
+}
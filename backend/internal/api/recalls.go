@@ -0,0 +1,489 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ==================== Recalls (药品召回) ====================
+
+const (
+	recallActive = "active"
+	recallClosed = "closed"
+
+	lotStatusRecalled      = "recalled"
+	medicineStatusRecalled = "recalled"
+)
+
+// RecallCustomer is one customer who bought recalled stock
+type RecallCustomer struct {
+	CustomerID    int64     `json:"customer_id"`
+	CustomerName  string    `json:"customer_name"`
+	Phone         string    `json:"phone"`
+	Quantity      int       `json:"quantity"` // net of sales returns
+	OrderCount    int       `json:"order_count"`
+	OrderIDs      string    `json:"order_ids"`
+	FirstPurchase time.Time `json:"first_purchase"`
+	LastPurchase  time.Time `json:"last_purchase"`
+}
+
+// isWholeProduct reports whether a recall covers every unit of the medicine
+func isWholeProduct(r *model.Recall) bool {
+	return r.LotNo == "" && r.SupplierID == 0 && r.ReceivedFrom == nil && r.ReceivedTo == nil
+}
+
+// recallLotQuery selects the lots of the recalled medicine that match its
+// lot number, supplier and receiving window
+func recallLotQuery(tx *gorm.DB, r *model.Recall) *gorm.DB {
+	query := tx.Table("stock_lots l").Select("l.*").
+		Joins("LEFT JOIN inbounds i ON i.id = l.inbound_id").
+		Where("l.medicine_id = ?", r.MedicineID)
+	if r.LotNo != "" {
+		query = query.Where("l.lot_no = ?", r.LotNo)
+	}
+	if r.SupplierID != 0 {
+		query = query.Where("i.supplier_id = ?", r.SupplierID)
+	}
+	if r.ReceivedFrom != nil {
+		query = query.Where("i.inbound_date >= ?", *r.ReceivedFrom)
+	}
+	if r.ReceivedTo != nil {
+		query = query.Where("i.inbound_date < ?", r.ReceivedTo.Add(24*time.Hour))
+	}
+	return query
+}
+
+// CreateRecall registers a recall and freezes the matching stock. Lots are
+// set to recalled so allocateLots never sells them; a whole-product recall
+// also marks the medicine recalled, which blocks stock without lots too.
+func CreateRecall(c *gin.Context) {
+	var req struct {
+		MedicineID   int64  `json:"medicine_id" binding:"required"`
+		LotNo        string `json:"lot_no"`
+		SupplierID   int64  `json:"supplier_id"`
+		ReceivedFrom string `json:"received_from"`
+		ReceivedTo   string `json:"received_to"`
+		Level        int    `json:"level"`
+		Reason       string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Level < 1 || req.Level > 3 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "level must be 1, 2 or 3"})
+		return
+	}
+	from, err := parseDate(req.ReceivedFrom)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid received_from, expected YYYY-MM-DD"})
+		return
+	}
+	to, err := parseDate(req.ReceivedTo)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid received_to, expected YYYY-MM-DD"})
+		return
+	}
+
+	recall := model.Recall{
+		MedicineID:   req.MedicineID,
+		LotNo:        strings.TrimSpace(req.LotNo),
+		SupplierID:   req.SupplierID,
+		ReceivedFrom: from,
+		ReceivedTo:   to,
+		Level:        req.Level,
+		Reason:       strings.TrimSpace(req.Reason),
+		Status:       recallActive,
+		CreatedBy:    currentUserID(c),
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var med model.Medicine
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&med, req.MedicineID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return newAPIError(http.StatusBadRequest, "Medicine not found")
+			}
+			return err
+		}
+
+		var lots []model.StockLot
+		if err := recallLotQuery(tx, &recall).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Order("l.id").Scan(&lots).Error; err != nil {
+			return err
+		}
+		whole := isWholeProduct(&recall)
+		if len(lots) == 0 && !whole {
+			return newAPIError(http.StatusBadRequest, "没有符合条件的批次")
+		}
+
+		if whole {
+			recall.PrevMedicineStatus = med.Status
+			recall.FrozenQty = med.Stock
+		}
+		if err := createNumbered(tx, &recall, "RC", func(no string) { recall.RecallNo = no }); err != nil {
+			return err
+		}
+
+		frozen := 0
+		var inboundIDs []int64
+		for _, lot := range lots {
+			rl := model.RecallLot{
+				RecallID:   recall.ID,
+				LotID:      lot.ID,
+				InboundID:  lot.InboundID,
+				LotNo:      lot.LotNo,
+				FrozenQty:  lot.Remaining,
+				PrevStatus: lot.Status,
+			}
+			if err := tx.Create(&rl).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&model.StockLot{}).Where("id = ?", lot.ID).
+				Update("status", lotStatusRecalled).Error; err != nil {
+				return err
+			}
+			frozen += lot.Remaining
+			if lot.InboundID != 0 {
+				inboundIDs = append(inboundIDs, lot.InboundID)
+			}
+		}
+		if err := recallTraceCodes(tx, &recall, inboundIDs, currentUserID(c)); err != nil {
+			return err
+		}
+
+		if whole {
+			return tx.Model(&med).Update("status", medicineStatusRecalled).Error
+		}
+		recall.FrozenQty = frozen
+		return tx.Model(&recall).Update("frozen_qty", frozen).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, recall)
+}
+
+// GetRecalls lists recalls, newest first (&status=, &medicine_id=)
+func GetRecalls(c *gin.Context) {
+	page, limit, offset := getPaginationParams(c)
+
+	query := database.DB.Model(&model.Recall{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if medicineID := c.Query("medicine_id"); medicineID != "" {
+		query = query.Where("medicine_id = ?", medicineID)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	recalls := make([]model.Recall, 0)
+	if err := query.Preload("Medicine").Order("id DESC").Offset(offset).Limit(limit).Find(&recalls).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	totalPages := math.Ceil(float64(total) / float64(limit))
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": recalls,
+		"meta": Pagination{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(totalPages),
+		},
+	})
+}
+
+// loadRecall reads a recall with its medicine
+func loadRecall(db *gorm.DB, id string) (*model.Recall, error) {
+	var recall model.Recall
+	if err := db.Preload("Medicine").First(&recall, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, newAPIError(http.StatusNotFound, "Recall not found")
+		}
+		return nil, err
+	}
+	return &recall, nil
+}
+
+// recallCustomers traces every registered customer who bought recalled stock.
+// Lot-specific recalls follow sale_lots; whole-product recalls take every
+// sale of the medicine. Walk-in sales cannot be traced and are only counted.
+func recallCustomers(db *gorm.DB, recall *model.Recall) ([]RecallCustomer, int, error) {
+	type soldRow struct {
+		CustomerID int64
+		Quantity   int
+		OrderID    string
+		SaleDate   time.Time
+	}
+	var rows []soldRow
+	var err error
+	if isWholeProduct(recall) {
+		err = db.Raw(`SELECT s.customer_id, s.quantity - COALESCE(r.quantity, 0) AS quantity, s.order_id, s.sale_date
+			FROM sales s
+			LEFT JOIN (SELECT sale_id, SUM(quantity) AS quantity FROM sales_returns GROUP BY sale_id) r ON r.sale_id = s.id
+			WHERE s.medicine_id = ? AND s.status <> ?`, recall.MedicineID, saleVoided).Scan(&rows).Error
+	} else {
+		err = db.Raw(`SELECT s.customer_id, sl.quantity - sl.returned_qty AS quantity, s.order_id, s.sale_date
+			FROM recall_lots rl
+			JOIN sale_lots sl ON sl.lot_id = rl.lot_id
+			JOIN sales s ON s.id = sl.sale_id
+			WHERE rl.recall_id = ? AND s.status <> ?`, recall.ID, saleVoided).Scan(&rows).Error
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	byCustomer := make(map[int64]*RecallCustomer)
+	orders := make(map[int64]map[string]bool)
+	customers := make([]*RecallCustomer, 0)
+	anonymous := 0
+	for _, r := range rows {
+		if r.Quantity <= 0 {
+			continue
+		}
+		if isWalkIn(r.CustomerID) {
+			anonymous += r.Quantity
+			continue
+		}
+		rc, ok := byCustomer[r.CustomerID]
+		if !ok {
+			rc = &RecallCustomer{CustomerID: r.CustomerID, FirstPurchase: r.SaleDate, LastPurchase: r.SaleDate}
+			byCustomer[r.CustomerID] = rc
+			orders[r.CustomerID] = make(map[string]bool)
+			customers = append(customers, rc)
+		}
+		rc.Quantity += r.Quantity
+		if !orders[r.CustomerID][r.OrderID] {
+			orders[r.CustomerID][r.OrderID] = true
+			rc.OrderCount++
+			if rc.OrderIDs != "" {
+				rc.OrderIDs += ","
+			}
+			rc.OrderIDs += r.OrderID
+		}
+		if r.SaleDate.Before(rc.FirstPurchase) {
+			rc.FirstPurchase = r.SaleDate
+		}
+		if r.SaleDate.After(rc.LastPurchase) {
+			rc.LastPurchase = r.SaleDate
+		}
+	}
+
+	if len(customers) > 0 {
+		ids := make([]int64, 0, len(customers))
+		for _, rc := range customers {
+			ids = append(ids, rc.CustomerID)
+		}
+		var found []model.Customer
+		if err := db.Where("id IN ?", ids).Find(&found).Error; err != nil {
+			return nil, 0, err
+		}
+		for _, cu := range found {
+			byCustomer[cu.ID].CustomerName = cu.Name
+			byCustomer[cu.ID].Phone = cu.Phone
+		}
+	}
+
+	result := make([]RecallCustomer, 0, len(customers))
+	for _, rc := range customers {
+		result = append(result, *rc)
+	}
+	return result, anonymous, nil
+}
+
+// GetRecallCustomers lists the customers to contact about a recall
+func GetRecallCustomers(c *gin.Context) {
+	recall, err := loadRecall(database.DB, c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	customers, anonymous, err := recallCustomers(database.DB, recall)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"recall":             recall,
+		"customers":          customers,
+		"anonymous_quantity": anonymous,
+	})
+}
+
+// GetRecallReport summarises a recall: stock frozen and still on hand,
+// units sold and to whom, and what has gone back to suppliers
+func GetRecallReport(c *gin.Context) {
+	recall, err := loadRecall(database.DB, c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	type lotRow struct {
+		model.RecallLot
+		Remaining  int        `json:"remaining"`
+		Status     string     `json:"status"`
+		ExpiryDate *time.Time `json:"expiry_date"`
+	}
+	lots := make([]lotRow, 0)
+	if err := database.DB.Raw(`SELECT rl.*, COALESCE(l.remaining, 0) AS remaining, COALESCE(l.status, '') AS status, l.expiry_date
+		FROM recall_lots rl LEFT JOIN stock_lots l ON l.id = rl.lot_id
+		WHERE rl.recall_id = ? ORDER BY rl.id`, recall.ID).Scan(&lots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	onHand := 0
+	for _, l := range lots {
+		onHand += l.Remaining
+	}
+	untracked := 0
+	if isWholeProduct(recall) && recall.Medicine != nil {
+		untracked = max(recall.Medicine.Stock-onHand, 0)
+	}
+
+	customers, anonymous, err := recallCustomers(database.DB, recall)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sold := anonymous
+	for _, rc := range customers {
+		sold += rc.Quantity
+	}
+
+	returns := make([]model.PurchaseReturn, 0)
+	database.DB.Where("recall_id = ?", recall.ID).Order("id").Find(&returns)
+	returned, credit := 0, 0.0
+	for _, r := range returns {
+		returned += r.Quantity
+		credit += r.CreditAmount
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recall":               recall,
+		"lots":                 lots,
+		"frozen_quantity":      recall.FrozenQty,
+		"on_hand_quantity":     onHand + untracked,
+		"untracked_quantity":   untracked,
+		"sold_quantity":        sold,
+		"customer_count":       len(customers),
+		"anonymous_quantity":   anonymous,
+		"returned_quantity":    returned,
+		"credit_amount":        roundMoney(credit),
+		"supplier_returns":     returns,
+		"customers_to_contact": customers,
+	})
+}
+
+// CreateRecallReturn generates return-to-supplier documents, reason
+// "recalled", for the recalled stock still on hand, one per inbound
+func CreateRecallReturn(c *gin.Context) {
+	recall, err := loadRecall(database.DB, c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if recall.Status != recallActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "Recall is closed"})
+		return
+	}
+
+	returns := make([]*model.PurchaseReturn, 0)
+	err = stockTransaction(func(tx *gorm.DB) error {
+		type inboundQty struct {
+			InboundID int64
+			Quantity  int
+		}
+		var pending []inboundQty
+		if err := tx.Raw(`SELECT rl.inbound_id, SUM(l.remaining) AS quantity
+			FROM recall_lots rl JOIN stock_lots l ON l.id = rl.lot_id
+			WHERE rl.recall_id = ? AND rl.inbound_id <> 0 AND l.remaining > 0
+			GROUP BY rl.inbound_id ORDER BY rl.inbound_id`, recall.ID).Scan(&pending).Error; err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return newAPIError(http.StatusBadRequest, "没有可退回供应商的召回库存")
+		}
+		for _, p := range pending {
+			ret, err := returnToSupplier(tx, purchaseReturnInput{
+				InboundID:  p.InboundID,
+				Quantity:   p.Quantity,
+				ReasonCode: "recalled",
+				Remark:     "召回单 " + recall.RecallNo + ": " + recall.Reason,
+				OperatorID: currentUserID(c),
+				RecallID:   &recall.ID,
+			})
+			if err != nil {
+				return err
+			}
+			returns = append(returns, ret)
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Supplier returns created",
+		"returns": returns,
+	})
+}
+
+// CloseRecall ends a recall. Recalled lots stay frozen; a whole-product
+// recall gives the medicine back its previous status.
+func CloseRecall(c *gin.Context) {
+	var recall model.Recall
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&recall, c.Param("id")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return newAPIError(http.StatusNotFound, "Recall not found")
+			}
+			return err
+		}
+		if recall.Status != recallActive {
+			return newAPIError(http.StatusConflict, "Recall is already closed")
+		}
+
+		now := time.Now()
+		recall.Status = recallClosed
+		recall.ClosedBy = currentUserID(c)
+		recall.ClosedAt = &now
+		if err := tx.Model(&recall).Select("status", "closed_by", "closed_at").Updates(&recall).Error; err != nil {
+			return err
+		}
+
+		// Another active whole-product recall keeps the medicine blocked
+		if isWholeProduct(&recall) {
+			var others int64
+			tx.Model(&model.Recall{}).Where("medicine_id = ? AND status = ? AND id <> ? AND lot_no = '' AND supplier_id = 0 AND received_from IS NULL AND received_to IS NULL",
+				recall.MedicineID, recallActive, recall.ID).Count(&others)
+			if others == 0 {
+				status := recall.PrevMedicineStatus
+				if status == "" || status == medicineStatusRecalled {
+					status = "active"
+				}
+				return tx.Model(&model.Medicine{}).Where("id = ? AND status = ?", recall.MedicineID, medicineStatusRecalled).
+					Update("status", status).Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, recall)
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/config"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"github.com/yousaling0624/database-course-project/backend/internal/payment"
+	"github.com/yousaling0624/database-course-project/backend/internal/receipt"
+)
+
+// ==================== Receipts & Invoices (小票与发票) ====================
+
+// tenderLabels are the printed names of the tenders
+var tenderLabels = map[string]string{
+	payment.TenderCash:      "现金",
+	payment.TenderCard:      "银行卡",
+	payment.TenderWeChat:    "微信",
+	payment.TenderAlipay:    "支付宝",
+	payment.TenderInsurance: "医保",
+	payment.TenderPoints:    "积分",
+}
+
+// loadOrderDocument loads an order with its lines, payments, cashier and
+// customer names for printing. Voided lines are left out.
+func loadOrderDocument(orderNo string) (*model.Order, string, string, error) {
+	var order model.Order
+	if err := database.DB.Where("order_no = ?", orderNo).Limit(1).Find(&order).Error; err != nil {
+		return nil, "", "", err
+	}
+	if order.ID == 0 {
+		return nil, "", "", newAPIError(http.StatusNotFound, "Order not found")
+	}
+	if err := database.DB.Preload("Medicine").Where("order_id = ? AND status <> ?", order.OrderNo, saleVoided).Order("id").Find(&order.Items).Error; err != nil {
+		return nil, "", "", err
+	}
+	if err := database.DB.Where("order_no = ?", order.OrderNo).Order("id").Find(&order.Payments).Error; err != nil {
+		return nil, "", "", err
+	}
+
+	var cashier model.User
+	database.DB.Select("id", "username", "real_name").Limit(1).Find(&cashier, order.CashierID)
+	cashierName := cashier.RealName
+	if cashierName == "" {
+		cashierName = cashier.Username
+	}
+	customerName := ""
+	if !isWalkIn(order.CustomerID) {
+		var cust model.Customer
+		database.DB.Select("id", "name").Limit(1).Find(&cust, order.CustomerID)
+		customerName = cust.Name
+	}
+	return &order, cashierName, customerName, nil
+}
+
+// unitPrice is the retail price a line was sold at; lines recorded before
+// prices were kept work it out from the line total
+func unitPrice(s *model.Sales) float64 {
+	if s.UnitPrice > 0 || s.Quantity == 0 {
+		return s.UnitPrice
+	}
+	return roundMoney((s.TotalPrice + s.DiscountAmount) / float64(s.Quantity))
+}
+
+// GetSaleReceipt renders the receipt of an order
+// (?format=text|escpos|html|pdf, default html; &width=58|80 paper roll, default 80)
+func GetSaleReceipt(c *gin.Context) {
+	format := c.DefaultQuery("format", "html")
+	width := receipt.Width80
+	switch c.DefaultQuery("width", "80") {
+	case "80":
+	case "58":
+		width = receipt.Width58
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "width must be 58 or 80"})
+		return
+	}
+
+	order, cashierName, customerName, err := loadOrderDocument(c.Param("order_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	store := config.Store()
+	r := &receipt.Receipt{
+		Store: receipt.Store{
+			Name:    store.Name,
+			Address: store.Address,
+			Phone:   store.Phone,
+			Footer:  store.ReceiptFooter,
+		},
+		OrderNo:        order.OrderNo,
+		Time:           order.CreatedAt,
+		Cashier:        cashierName,
+		Customer:       customerName,
+		Total:          order.TotalAmount,
+		Discount:       order.DiscountAmount,
+		Gross:          roundMoney(order.TotalAmount + order.DiscountAmount),
+		PointsRedeemed: order.PointsRedeemed,
+		PointsEarned:   order.PointsEarned,
+	}
+	for i := range order.Items {
+		s := &order.Items[i]
+		line := receipt.Line{
+			Quantity:  s.Quantity,
+			UnitPrice: unitPrice(s),
+			Discount:  s.DiscountAmount,
+			Amount:    s.TotalPrice,
+		}
+		if s.Medicine != nil {
+			line.Name, line.Spec = s.Medicine.Name, s.Medicine.Spec
+		}
+		r.Lines = append(r.Lines, line)
+	}
+	for _, p := range order.Payments {
+		label := tenderLabels[p.Tender]
+		if label == "" {
+			label = p.Tender
+		}
+		r.Payments = append(r.Payments, receipt.Payment{
+			Label:    label,
+			Amount:   p.Amount,
+			Tendered: p.Tendered,
+			Change:   p.ChangeAmount,
+		})
+	}
+
+	filename := "receipt_" + order.OrderNo
+	switch format {
+	case "text":
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(receipt.Text(r, width)))
+	case "escpos":
+		data, err := receipt.ESCPOS(r, width)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", "attachment; filename="+filename+".bin")
+		c.Data(http.StatusOK, "application/octet-stream", data)
+	case "html":
+		data, err := receipt.HTML(r)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", data)
+	case "pdf":
+		c.Header("Content-Disposition", "inline; filename="+filename+".pdf")
+		c.Data(http.StatusOK, "application/pdf", receipt.PDF(r, width))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be text, escpos, html or pdf"})
+	}
+}
+
+// InvoiceLine is one line of VAT invoice data. Sales prices include VAT, so
+// the amount and unit price here are before tax.
+type InvoiceLine struct {
+	Name      string  `json:"name"`
+	Spec      string  `json:"spec"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice float64 `json:"unit_price"` // before tax
+	Amount    float64 `json:"amount"`     // before tax
+	TaxRate   float64 `json:"tax_rate"`
+	TaxAmount float64 `json:"tax_amount"`
+}
+
+// GetSaleInvoice exports the data for issuing a VAT invoice for an order
+// (?format=json|csv, &buyer_name=&buyer_tax_id= for a company buyer). Lines
+// are net of promotion discounts and of anything since returned; fully
+// returned lines are left out.
+func GetSaleInvoice(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+	order, _, customerName, err := loadOrderDocument(c.Param("order_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	var returned []struct {
+		SaleID   int64
+		Quantity int
+		Refund   float64
+	}
+	if err := database.DB.Model(&model.SalesReturn{}).Where("order_id = ?", order.OrderNo).
+		Select("sale_id, SUM(quantity) AS quantity, SUM(refund_amount) AS refund").
+		Group("sale_id").Scan(&returned).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	returnedQty := make(map[int64]int)
+	returnedAmount := make(map[int64]float64)
+	for _, r := range returned {
+		returnedQty[r.SaleID] = r.Quantity
+		returnedAmount[r.SaleID] = r.Refund
+	}
+
+	store := config.Store()
+	rate := store.VATRate
+	lines := make([]InvoiceLine, 0, len(order.Items))
+	var amount, tax float64
+	for _, s := range order.Items {
+		qty := s.Quantity - returnedQty[s.ID]
+		gross := roundMoney(s.TotalPrice - returnedAmount[s.ID])
+		if qty <= 0 || gross <= 0 {
+			continue
+		}
+		net := roundMoney(gross / (1 + rate))
+		line := InvoiceLine{
+			Quantity:  qty,
+			UnitPrice: roundCost(net / float64(qty)),
+			Amount:    net,
+			TaxRate:   rate,
+			TaxAmount: roundMoney(gross - net),
+		}
+		if s.Medicine != nil {
+			line.Name, line.Spec = s.Medicine.Name, s.Medicine.Spec
+		}
+		lines = append(lines, line)
+		amount += line.Amount
+		tax += line.TaxAmount
+	}
+
+	buyerName := strings.TrimSpace(c.Query("buyer_name"))
+	if buyerName == "" {
+		buyerName = customerName
+	}
+	if buyerName == "" {
+		buyerName = "个人"
+	}
+	buyerTaxID := strings.TrimSpace(c.Query("buyer_tax_id"))
+
+	if format == "csv" {
+		var buf strings.Builder
+		buf.WriteString("\ufeff") // BOM so spreadsheet tools read the file as UTF-8
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"订单号", order.OrderNo, "开票日期", order.CreatedAt.Format("2006-01-02")})
+		w.Write([]string{"销售方", store.Name, "纳税人识别号", store.TaxID})
+		w.Write([]string{"购买方", buyerName, "纳税人识别号", buyerTaxID})
+		w.Write([]string{"项目名称", "规格型号", "数量", "单价(不含税)", "金额(不含税)", "税率", "税额"})
+		for _, l := range lines {
+			w.Write([]string{
+				l.Name, l.Spec, strconv.Itoa(l.Quantity),
+				strconv.FormatFloat(l.UnitPrice, 'f', 4, 64),
+				strconv.FormatFloat(l.Amount, 'f', 2, 64),
+				strconv.FormatFloat(l.TaxRate*100, 'f', -1, 64) + "%",
+				strconv.FormatFloat(l.TaxAmount, 'f', 2, 64),
+			})
+		}
+		w.Write([]string{"合计", "", "", "",
+			strconv.FormatFloat(roundMoney(amount), 'f', 2, 64), "",
+			strconv.FormatFloat(roundMoney(tax), 'f', 2, 64)})
+		w.Write([]string{"价税合计", strconv.FormatFloat(roundMoney(amount+tax), 'f', 2, 64)})
+		w.Flush()
+		c.Header("Content-Disposition", "attachment; filename=invoice_"+order.OrderNo+".csv")
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", []byte(buf.String()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"order_no":   order.OrderNo,
+		"order_date": order.CreatedAt,
+		"seller": gin.H{
+			"name":    store.Name,
+			"tax_id":  store.TaxID,
+			"address": store.Address,
+			"phone":   store.Phone,
+		},
+		"buyer": gin.H{
+			"name":   buyerName,
+			"tax_id": buyerTaxID,
+		},
+		"lines":        lines,
+		"amount":       roundMoney(amount),
+		"tax_amount":   roundMoney(tax),
+		"total_amount": roundMoney(amount + tax),
+	})
+}
@@ -0,0 +1,596 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ==================== Sales Returns (销售退货) ====================
+
+// What happens to returned goods
+const (
+	returnRestock    = "restock"    // back into sellable stock
+	returnQuarantine = "quarantine" // held in a frozen lot, not sellable
+)
+
+const lotStatusQuarantine = "quarantine"
+
+// SalesReturnRow is a sales return with display names
+type SalesReturnRow struct {
+	model.SalesReturn
+	MedicineName string `json:"medicine_name"`
+	CustomerName string `json:"customer_name"`
+	OperatorName string `json:"operator_name"`
+}
+
+// CreateSalesReturn returns all or part of one sales line.
+//
+// The sale is kept; a sales_returns row records the quantity and refund and
+// tr_after_sales_return_insert puts the units back into stock. Quantity 0
+// returns everything still returnable. The refund is the line's price pro
+// rata, with the last return taking whatever is left so rounding never over-
+// or under-refunds the line.
+func CreateSalesReturn(c *gin.Context) {
+	var req struct {
+		SaleID      int64    `json:"sale_id" binding:"required"`
+		Quantity    int      `json:"quantity"`
+		Reason      string   `json:"reason"`
+		Disposition string   `json:"disposition"`
+		TraceCodes  []string `json:"trace_codes"` // codes of the boxes brought back
+		Tender      string   `json:"refund_tender"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Quantity < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity cannot be negative"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	switch req.Disposition {
+	case "":
+		req.Disposition = returnRestock
+	case returnRestock, returnQuarantine:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "disposition must be restock or quarantine"})
+		return
+	}
+
+	var ret model.SalesReturn
+	var remaining, pointsReversed, pointsRefunded int
+	err := stockTransaction(func(tx *gorm.DB) error {
+		var sale model.Sales
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sale, req.SaleID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return newAPIError(http.StatusBadRequest, "Sale not found")
+			}
+			return err
+		}
+		if sale.Status == saleVoided {
+			return newAPIError(http.StatusBadRequest, "该销售已作废，不能退货")
+		}
+
+		var done struct {
+			Quantity int
+			Refund   float64
+			Cost     float64
+		}
+		if err := tx.Model(&model.SalesReturn{}).Where("sale_id = ?", sale.ID).
+			Select("COALESCE(SUM(quantity), 0) AS quantity, COALESCE(SUM(refund_amount), 0) AS refund, COALESCE(SUM(cost_amount), 0) AS cost").
+			Scan(&done).Error; err != nil {
+			return err
+		}
+		returnable := sale.Quantity - done.Quantity
+		if returnable <= 0 {
+			return newAPIError(http.StatusBadRequest, "该销售已全部退货")
+		}
+		qty := req.Quantity
+		if qty == 0 {
+			qty = returnable
+		}
+		if qty > returnable {
+			return newAPIError(http.StatusBadRequest, "退货数量超过可退数量 %d", returnable)
+		}
+
+		refund := roundMoney(sale.TotalPrice * float64(qty) / float64(sale.Quantity))
+		cost := roundMoney(sale.CostAmount * float64(qty) / float64(sale.Quantity))
+		if qty == returnable {
+			refund = roundMoney(sale.TotalPrice - done.Refund)
+			cost = roundMoney(sale.CostAmount - done.Cost)
+		}
+
+		shift, err := activeShift(tx, currentUserID(c))
+		if err != nil {
+			return err
+		}
+		tender, err := refundTender(tx, sale.OrderID, req.Tender)
+		if err != nil {
+			return err
+		}
+
+		if err := setStockContext(tx, currentUserID(c), refSalesReturn, 0, req.Reason); err != nil {
+			return err
+		}
+		ret = model.SalesReturn{
+			SaleID:       sale.ID,
+			OrderID:      sale.OrderID,
+			MedicineID:   sale.MedicineID,
+			CustomerID:   sale.CustomerID,
+			Quantity:     qty,
+			RefundAmount: refund,
+			CostAmount:   cost,
+			Reason:       req.Reason,
+			Disposition:  req.Disposition,
+			OperatorID:   currentUserID(c),
+			RefundTender: tender,
+			TenderRefund: refund,
+			ShiftID:      shiftIDOf(shift),
+		}
+		if err := tx.Create(&ret).Error; err != nil {
+			return err
+		}
+		remaining = returnable - qty
+		if err := returnToLots(tx, &ret); err != nil {
+			return err
+		}
+		codes, err := cleanTraceCodes(req.TraceCodes, qty)
+		if err != nil {
+			return err
+		}
+		if err := returnTraceCodes(tx, &ret, codes); err != nil {
+			return err
+		}
+		if pointsReversed, pointsRefunded, err = returnPoints(tx, &ret, currentUserID(c)); err != nil {
+			return err
+		}
+		if pointsRefunded > 0 {
+			ret.TenderRefund = roundMoney(math.Max(ret.RefundAmount-pointsToYuan(pointsRefunded), 0))
+			return tx.Model(&ret).Update("tender_refund", ret.TenderRefund).Error
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":           "Return processed successfully",
+		"return":            ret,
+		"returned_quantity": ret.Quantity,
+		"refund_amount":     ret.RefundAmount,
+		"cash_refund":       ret.TenderRefund,
+		"points_reversed":   pointsReversed,
+		"points_refunded":   pointsRefunded,
+		"returnable":        remaining,
+	})
+}
+
+// returnToLots puts returned units back into the lots the sale was taken
+// from, most recently allocated first. Restocked units go back into those
+// lots; quarantined units go into new frozen lots carrying the same lot
+// number and expiry. Units the sale took from untracked stock stay untracked
+// when restocked and become a lot without a number when quarantined.
+func returnToLots(tx *gorm.DB, ret *model.SalesReturn) error {
+	var saleLots []model.SaleLot
+	if err := tx.Where("sale_id = ? AND returned_qty < quantity", ret.SaleID).
+		Order("id DESC").Find(&saleLots).Error; err != nil {
+		return err
+	}
+
+	parts, left := splitReturn(saleLots, ret.Quantity)
+	for _, p := range parts {
+		if err := tx.Model(&model.SaleLot{}).Where("id = ?", p.SaleLotID).
+			UpdateColumn("returned_qty", gorm.Expr("returned_qty + ?", p.Quantity)).Error; err != nil {
+			return err
+		}
+		if ret.Disposition == returnRestock {
+			if err := tx.Model(&model.StockLot{}).Where("id = ?", p.LotID).
+				UpdateColumn("remaining", gorm.Expr("remaining + ?", p.Quantity)).Error; err != nil {
+				return err
+			}
+		} else {
+			var src model.StockLot
+			if err := tx.First(&src, p.LotID).Error; err != nil {
+				return err
+			}
+			if err := quarantineLot(tx, ret.MedicineID, &src, p.Quantity); err != nil {
+				return err
+			}
+		}
+	}
+
+	if left > 0 && ret.Disposition == returnQuarantine {
+		return quarantineLot(tx, ret.MedicineID, nil, left)
+	}
+	return nil
+}
+
+// lotReturn is the part of a return that goes back to one sale lot
+type lotReturn struct {
+	SaleLotID int64
+	LotID     int64
+	Quantity  int
+}
+
+// splitReturn spreads qty returned units over the sale lots in the order
+// given, never returning more to a lot than the sale still has out of it.
+// left is what the sale took from untracked stock.
+func splitReturn(saleLots []model.SaleLot, qty int) (parts []lotReturn, left int) {
+	left = qty
+	for _, sl := range saleLots {
+		if left == 0 {
+			break
+		}
+		take := min(left, sl.Quantity-sl.ReturnedQty)
+		if take <= 0 {
+			continue
+		}
+		parts = append(parts, lotReturn{SaleLotID: sl.ID, LotID: sl.LotID, Quantity: take})
+		left -= take
+	}
+	return parts, left
+}
+
+// quarantineLot opens a frozen lot for returned goods, copying src's batch details
+func quarantineLot(tx *gorm.DB, medicineID int64, src *model.StockLot, qty int) error {
+	lot := model.StockLot{
+		MedicineID: medicineID,
+		Quantity:   qty,
+		Remaining:  qty,
+		Status:     lotStatusQuarantine,
+	}
+	if src != nil {
+		lot.InboundID = src.InboundID
+		lot.LotNo = src.LotNo
+		lot.ProductionDate = src.ProductionDate
+		lot.ExpiryDate = src.ExpiryDate
+		lot.UnitCost = src.UnitCost
+	}
+	return tx.Create(&lot).Error
+}
+
+// GetSalesReturns lists sales returns, newest first (&sale_id=, &order_id=)
+func GetSalesReturns(c *gin.Context) {
+	page, limit, offset := getPaginationParams(c)
+
+	query := database.DB.Table("sales_returns r")
+	if saleID := c.Query("sale_id"); saleID != "" {
+		query = query.Where("r.sale_id = ?", saleID)
+	}
+	if orderID := c.Query("order_id"); orderID != "" {
+		query = query.Where("r.order_id = ?", orderID)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	rows := make([]SalesReturnRow, 0)
+	if err := query.Select(`r.*, COALESCE(m.name, '') AS medicine_name, COALESCE(cu.name, '') AS customer_name,
+			COALESCE(NULLIF(u.real_name, ''), u.username, '') AS operator_name`).
+		Joins("LEFT JOIN medicines m ON m.id = r.medicine_id").
+		Joins("LEFT JOIN customers cu ON cu.id = r.customer_id").
+		Joins("LEFT JOIN users u ON u.id = r.operator_id").
+		Order("r.id DESC").Offset(offset).Limit(limit).
+		Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	totalPages := math.Ceil(float64(total) / float64(limit))
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": rows,
+		"meta": Pagination{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(totalPages),
+		},
+	})
+}
+
+// ==================== Purchase Returns (采购退货) ====================
+
+// Reason codes for returning goods to a supplier
+var purchaseReturnReasons = map[string]bool{
+	"damaged":    true,
+	"expired":    true,
+	"recalled":   true,
+	"wrong_item": true,
+}
+
+// Purchase return statuses
+const (
+	purchaseReturnPending  = "pending"  // goods sent back, awaiting the supplier's credit
+	purchaseReturnCredited = "credited" // credit note received
+)
+
+// PurchaseReturnRow is a purchase return with display names
+type PurchaseReturnRow struct {
+	model.PurchaseReturn
+	MedicineName string `json:"medicine_name"`
+	SupplierName string `json:"supplier_name"`
+	OperatorName string `json:"operator_name"`
+}
+
+// CreatePurchaseReturn sends all or part of one inbound back to its supplier.
+//
+// The inbound is kept; a purchase_returns document records the quantity,
+// reason code and expected credit, and tr_after_purchase_return_insert takes
+// the units out of stock. Quantity 0 returns everything still returnable.
+// The credit defaults to the inbound price times the quantity.
+func CreatePurchaseReturn(c *gin.Context) {
+	var req struct {
+		InboundID    int64    `json:"inbound_id" binding:"required"`
+		Quantity     int      `json:"quantity"`
+		ReasonCode   string   `json:"reason_code"`
+		CreditAmount *float64 `json:"credit_amount"`
+		Remark       string   `json:"remark"`
+		TraceCodes   []string `json:"trace_codes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Quantity < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity cannot be negative"})
+		return
+	}
+	if !purchaseReturnReasons[req.ReasonCode] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason_code must be damaged, expired, recalled or wrong_item"})
+		return
+	}
+	if req.CreditAmount != nil && *req.CreditAmount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "credit_amount cannot be negative"})
+		return
+	}
+
+	var ret *model.PurchaseReturn
+	err := stockTransaction(func(tx *gorm.DB) error {
+		var err error
+		ret, err = returnToSupplier(tx, purchaseReturnInput{
+			InboundID:    req.InboundID,
+			Quantity:     req.Quantity,
+			ReasonCode:   req.ReasonCode,
+			CreditAmount: req.CreditAmount,
+			Remark:       req.Remark,
+			OperatorID:   currentUserID(c),
+			TraceCodes:   req.TraceCodes,
+		})
+		return err
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":           "Purchase return processed successfully",
+		"return":            ret,
+		"returned_quantity": ret.Quantity,
+		"credit_amount":     ret.CreditAmount,
+	})
+}
+
+// purchaseReturnInput describes one return to supplier; Quantity 0 returns
+// everything still returnable and a nil CreditAmount uses the inbound price
+type purchaseReturnInput struct {
+	InboundID    int64
+	Quantity     int
+	ReasonCode   string
+	CreditAmount *float64
+	Remark       string
+	OperatorID   int64
+	RecallID     *int64
+	TraceCodes   []string // boxes scanned as they go back, optional
+}
+
+// returnToSupplier creates a purchase return document inside tx, capped at
+// what is left of the inbound and at the medicine's current stock
+func returnToSupplier(tx *gorm.DB, in purchaseReturnInput) (*model.PurchaseReturn, error) {
+	var inbound model.Inbound
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&inbound, in.InboundID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, newAPIError(http.StatusBadRequest, "Inbound record not found")
+		}
+		return nil, err
+	}
+	var med model.Medicine
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&med, inbound.MedicineID).Error; err != nil {
+		return nil, err
+	}
+
+	var returned int
+	if err := tx.Model(&model.PurchaseReturn{}).Where("inbound_id = ?", inbound.ID).
+		Select("COALESCE(SUM(quantity), 0)").Scan(&returned).Error; err != nil {
+		return nil, err
+	}
+	returnable := inbound.Quantity - returned
+	if returnable <= 0 {
+		return nil, newAPIError(http.StatusBadRequest, "该入库单已全部退货")
+	}
+	qty := in.Quantity
+	if qty == 0 {
+		qty = returnable
+	}
+	if qty > returnable {
+		return nil, newAPIError(http.StatusBadRequest, "退货数量超过可退数量 %d", returnable)
+	}
+	if med.Stock < qty {
+		return nil, newAPIError(http.StatusBadRequest, "%s: 当前库存 %d，不足退货数量 %d", med.Name, med.Stock, qty)
+	}
+	codes, err := cleanTraceCodes(in.TraceCodes, qty)
+	if err != nil {
+		return nil, err
+	}
+
+	credit := roundMoney(inbound.Price * float64(qty))
+	if in.CreditAmount != nil {
+		credit = roundMoney(*in.CreditAmount)
+	}
+
+	if err := setStockContext(tx, in.OperatorID, refPurchaseReturn, 0, in.Remark); err != nil {
+		return nil, err
+	}
+	ret := model.PurchaseReturn{
+		InboundID:    inbound.ID,
+		SupplierID:   inbound.SupplierID,
+		MedicineID:   inbound.MedicineID,
+		Quantity:     qty,
+		UnitPrice:    inbound.Price,
+		CreditAmount: credit,
+		ReasonCode:   in.ReasonCode,
+		Remark:       strings.TrimSpace(in.Remark),
+		Status:       purchaseReturnPending,
+		OperatorID:   in.OperatorID,
+		RecallID:     in.RecallID,
+	}
+	if err := takeFromInboundLots(tx, &inbound, qty); err != nil {
+		return nil, err
+	}
+	if err := createNumbered(tx, &ret, "PR", func(no string) { ret.ReturnNo = no }); err != nil {
+		return nil, err
+	}
+	if err := supplierReturnTraceCodes(tx, &ret, codes, qty == returnable); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// takeFromInboundLots draws returned units out of the lots the inbound
+// created, including quarantine lots holding its customer returns. Inbounds
+// received before lot tracking have no lots and only the stock check applies.
+func takeFromInboundLots(tx *gorm.DB, inbound *model.Inbound, qty int) error {
+	var lots []model.StockLot
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("inbound_id = ? AND medicine_id = ?", inbound.ID, inbound.MedicineID).
+		Order("id").Find(&lots).Error; err != nil {
+		return err
+	}
+	if len(lots) == 0 {
+		return nil
+	}
+
+	available := 0
+	for _, lot := range lots {
+		available += lot.Remaining
+	}
+	if available < qty {
+		return newAPIError(http.StatusBadRequest, "该入库批次仅剩 %d 件，无法退货 %d 件", available, qty)
+	}
+
+	left := qty
+	for _, lot := range lots {
+		if left == 0 {
+			break
+		}
+		take := min(left, lot.Remaining)
+		if take == 0 {
+			continue
+		}
+		if err := tx.Model(&model.StockLot{}).Where("id = ?", lot.ID).
+			UpdateColumn("remaining", gorm.Expr("remaining - ?", take)).Error; err != nil {
+			return err
+		}
+		left -= take
+	}
+	return nil
+}
+
+// CreditPurchaseReturn marks a purchase return as credited by the supplier,
+// optionally correcting the credit amount to what the credit note says
+func CreditPurchaseReturn(c *gin.Context) {
+	var req struct {
+		CreditAmount *float64 `json:"credit_amount"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.CreditAmount != nil && *req.CreditAmount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "credit_amount cannot be negative"})
+		return
+	}
+
+	var ret model.PurchaseReturn
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&ret, c.Param("id")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return newAPIError(http.StatusNotFound, "Purchase return not found")
+			}
+			return err
+		}
+		if ret.Status != purchaseReturnPending {
+			return newAPIError(http.StatusConflict, "Purchase return is already %s", ret.Status)
+		}
+
+		now := time.Now()
+		updates := map[string]any{
+			"status":      purchaseReturnCredited,
+			"credited_by": currentUserID(c),
+			"credited_at": now,
+		}
+		if req.CreditAmount != nil {
+			updates["credit_amount"] = roundMoney(*req.CreditAmount)
+		}
+		if err := tx.Model(&ret).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.First(&ret, ret.ID).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ret)
+}
+
+// GetPurchaseReturns lists purchase returns, newest first
+// (&supplier_id=, &inbound_id=, &status=, &reason_code=)
+func GetPurchaseReturns(c *gin.Context) {
+	page, limit, offset := getPaginationParams(c)
+
+	query := database.DB.Table("purchase_returns r")
+	for _, key := range []string{"supplier_id", "inbound_id", "status", "reason_code"} {
+		if v := c.Query(key); v != "" {
+			query = query.Where("r."+key+" = ?", v)
+		}
+	}
+
+	var total int64
+	query.Count(&total)
+
+	rows := make([]PurchaseReturnRow, 0)
+	if err := query.Select(`r.*, COALESCE(m.name, '') AS medicine_name, COALESCE(sup.name, '') AS supplier_name,
+			COALESCE(NULLIF(u.real_name, ''), u.username, '') AS operator_name`).
+		Joins("LEFT JOIN medicines m ON m.id = r.medicine_id").
+		Joins("LEFT JOIN suppliers sup ON sup.id = r.supplier_id").
+		Joins("LEFT JOIN users u ON u.id = r.operator_id").
+		Order("r.id DESC").Offset(offset).Limit(limit).
+		Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	totalPages := math.Ceil(float64(total) / float64(limit))
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": rows,
+		"meta": Pagination{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(totalPages),
+		},
+	})
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+)
+
+func TestSplitReturnCapsAtReturnable(t *testing.T) {
+	// Most recently allocated first, as returnToLots reads them
+	saleLots := []model.SaleLot{
+		{ID: 12, LotID: 3, Quantity: 4, ReturnedQty: 1},
+		{ID: 11, LotID: 2, Quantity: 2, ReturnedQty: 2}, // already returned in full
+		{ID: 10, LotID: 1, Quantity: 5},
+	}
+
+	tests := []struct {
+		name     string
+		qty      int
+		want     []lotReturn
+		wantLeft int
+	}{
+		{
+			name: "within the latest lot",
+			qty:  2,
+			want: []lotReturn{{12, 3, 2}},
+		},
+		{
+			name: "spills over into earlier lots",
+			qty:  6,
+			want: []lotReturn{{12, 3, 3}, {10, 1, 3}},
+		},
+		{
+			name:     "beyond the lots comes from untracked stock",
+			qty:      10,
+			want:     []lotReturn{{12, 3, 3}, {10, 1, 5}},
+			wantLeft: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, left := splitReturn(saleLots, tt.qty)
+			if left != tt.wantLeft {
+				t.Fatalf("got %d left over, want %d", left, tt.wantLeft)
+			}
+			if len(parts) != len(tt.want) {
+				t.Fatalf("got %v, want %v", parts, tt.want)
+			}
+			for i := range parts {
+				if parts[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", parts, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/auth"
+	"github.com/yousaling0624/database-course-project/backend/internal/config"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"github.com/yousaling0624/database-course-project/backend/internal/safety"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ==================== Checkout Safety (用药安全) ====================
+
+// LoadSafetyRules installs the interaction rules from the configured file.
+// A missing file leaves the previous rules (initially none) in place.
+func LoadSafetyRules() error {
+	path := config.SafetyRulesFile()
+	rules, err := safety.LoadRules(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("Interaction rule file %s not found, safety checks use no rules", path)
+		return err
+	}
+	if err != nil {
+		log.Printf("Failed to load interaction rules: %v", err)
+		return err
+	}
+	safety.Use(rules)
+	log.Printf("Loaded %d interaction and %d contraindication rules from %s",
+		len(rules.Interactions), len(rules.Contraindications), path)
+	return nil
+}
+
+// ReloadSafetyRules re-reads the interaction rule file
+func ReloadSafetyRules(c *gin.Context) {
+	if err := LoadSafetyRules(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Safety rules reloaded", "file": config.SafetyRulesFile()})
+}
+
+// safetyItem describes a medicine to the checker
+func safetyItem(med *model.Medicine) safety.Item {
+	return safety.Item{
+		MedicineID:  med.ID,
+		Code:        med.Code,
+		Name:        med.Name,
+		Ingredients: safety.SplitTerms(med.Ingredients),
+	}
+}
+
+// screenBasket runs the active checker over a basket, the customer's health
+// profile and what the customer bought in the configured look-back window.
+// Walk-in sales have no profile or history, so only the basket is screened.
+func screenBasket(db *gorm.DB, req *checkoutRequest) ([]safety.Warning, error) {
+	ids := make([]int64, 0)
+	seen := make(map[int64]bool)
+	for _, line := range req.lines() {
+		if !seen[line.MedicineID] {
+			seen[line.MedicineID] = true
+			ids = append(ids, line.MedicineID)
+		}
+	}
+	var meds []model.Medicine
+	if err := db.Where("id IN ?", ids).Order("id").Find(&meds).Error; err != nil {
+		return nil, err
+	}
+	basket := make([]safety.Item, 0, len(meds))
+	for i := range meds {
+		basket = append(basket, safetyItem(&meds[i]))
+	}
+
+	var profile safety.Profile
+	recent := make([]safety.Item, 0)
+	if !isWalkIn(req.CustomerID) {
+		var hp model.CustomerHealthProfile
+		if err := db.Where("customer_id = ?", req.CustomerID).Limit(1).Find(&hp).Error; err != nil {
+			return nil, err
+		}
+		profile.Allergies = safety.SplitTerms(hp.Allergies)
+		profile.Conditions = safety.SplitTerms(hp.Conditions)
+
+		type boughtRow struct {
+			model.Medicine
+			SoldAt time.Time
+		}
+		var bought []boughtRow
+		since := startOfToday().AddDate(0, 0, -config.SafetyHistoryDays())
+		if err := db.Raw(`SELECT m.*, MAX(s.sale_date) AS sold_at FROM sales s
+			JOIN medicines m ON m.id = s.medicine_id
+			WHERE s.customer_id = ? AND s.sale_date >= ? AND s.status <> ?
+			GROUP BY m.id`, req.CustomerID, since, saleVoided).Scan(&bought).Error; err != nil {
+			return nil, err
+		}
+		for i := range bought {
+			item := safetyItem(&bought[i].Medicine)
+			item.SoldAt = &bought[i].SoldAt
+			recent = append(recent, item)
+		}
+	}
+
+	return safety.Current().Check(basket, recent, profile), nil
+}
+
+// checkSafety screens a checkout and decides whether it may proceed. Any
+// warning blocks the sale until it is resubmitted with acknowledge_warnings
+// by a user with pharmacist sign-off rights.
+func checkSafety(c *gin.Context, req *checkoutRequest) ([]safety.Warning, bool) {
+	warnings, err := screenBasket(database.DB, req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if len(warnings) == 0 {
+		return warnings, true
+	}
+	if !req.AcknowledgeWarnings {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":                    "存在用药安全警示，须经药师确认后方可结算",
+			"requires_acknowledgement": true,
+			"warnings":                 warnings,
+		})
+		return nil, false
+	}
+	if !auth.HasPermission(c.GetString("role"), auth.PermRxVerify) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":    "用药安全警示须由药师确认",
+			"warnings": warnings,
+		})
+		return nil, false
+	}
+	return warnings, true
+}
+
+// recordAcknowledgement keeps the warnings a pharmacist accepted for an order
+func recordAcknowledgement(tx *gorm.DB, order *model.Order, warnings []safety.Warning, userID int64) error {
+	data, err := json.Marshal(warnings)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&model.SafetyAcknowledgement{
+		OrderNo:        order.OrderNo,
+		CustomerID:     order.CustomerID,
+		Warnings:       string(data),
+		AcknowledgedBy: userID,
+	}).Error
+}
+
+// CheckSale screens a basket without placing it, so the counter can show
+// warnings before checkout. The body is the same as POST /api/sales.
+func CheckSale(c *gin.Context) {
+	var req checkoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	warnings, err := screenBasket(database.DB, &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"warnings":                 warnings,
+		"requires_acknowledgement": len(warnings) > 0,
+	})
+}
+
+// GetCustomerHealth returns a customer's health profile (empty if none is recorded)
+func GetCustomerHealth(c *gin.Context) {
+	var customer model.Customer
+	if err := database.DB.First(&customer, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+	profile := model.CustomerHealthProfile{CustomerID: customer.ID}
+	database.DB.Where("customer_id = ?", customer.ID).Limit(1).Find(&profile)
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdateCustomerHealth replaces a customer's health profile
+func UpdateCustomerHealth(c *gin.Context) {
+	var customer model.Customer
+	if err := database.DB.First(&customer, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+	if isWalkIn(customer.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未注册客户不能登记健康档案"})
+		return
+	}
+
+	var req struct {
+		Allergies  string `json:"allergies"`
+		Conditions string `json:"conditions"`
+		Notes      string `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile := model.CustomerHealthProfile{
+		CustomerID: customer.ID,
+		Allergies:  strings.Join(safety.SplitTerms(req.Allergies), ","),
+		Conditions: strings.Join(safety.SplitTerms(req.Conditions), ","),
+		Notes:      strings.TrimSpace(req.Notes),
+		UpdatedBy:  currentUserID(c),
+	}
+	if err := database.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// GetSafetyAcknowledgements lists acknowledged warnings, newest first (&order_no=, &customer_id=)
+func GetSafetyAcknowledgements(c *gin.Context) {
+	page, limit, offset := getPaginationParams(c)
+
+	query := database.DB.Model(&model.SafetyAcknowledgement{})
+	if orderNo := c.Query("order_no"); orderNo != "" {
+		query = query.Where("order_no = ?", orderNo)
+	}
+	if customerID := c.Query("customer_id"); customerID != "" {
+		query = query.Where("customer_id = ?", customerID)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	acks := make([]model.SafetyAcknowledgement, 0)
+	if err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&acks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	totalPages := math.Ceil(float64(total) / float64(limit))
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": acks,
+		"meta": Pagination{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(totalPages),
+		},
+	})
+}
@@ -0,0 +1,450 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/auth"
+	"github.com/yousaling0624/database-course-project/backend/internal/config"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"github.com/yousaling0624/database-course-project/backend/internal/payment"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ==================== Cashier Shifts (收银班次) ====================
+
+// Shift states
+const (
+	shiftOpen   = "open"
+	shiftClosed = "closed"
+)
+
+// activeShift returns the cashier's open shift, or nil. The row is share
+// locked so a concurrent close waits until the sale or return is committed.
+// When config pos.require_shift is on, having no open shift is an error.
+func activeShift(tx *gorm.DB, cashierID int64) (*model.Shift, error) {
+	shift, err := openShift(tx, cashierID)
+	if err == nil && shift == nil && config.RequireShift() {
+		return nil, newAPIError(http.StatusConflict, "请先开班再收银")
+	}
+	return shift, err
+}
+
+// openShift is activeShift without the pos.require_shift check, for
+// documents that move no money
+func openShift(tx *gorm.DB, cashierID int64) (*model.Shift, error) {
+	var shift model.Shift
+	if err := tx.Clauses(clause.Locking{Strength: "SHARE"}).
+		Where("cashier_id = ? AND status = ?", cashierID, shiftOpen).
+		Limit(1).Find(&shift).Error; err != nil {
+		return nil, err
+	}
+	if shift.ID == 0 {
+		return nil, nil
+	}
+	return &shift, nil
+}
+
+// shiftIDOf is the ID to store on documents processed in a shift
+func shiftIDOf(shift *model.Shift) *int64 {
+	if shift == nil {
+		return nil
+	}
+	return &shift.ID
+}
+
+// OpenShift starts a till session for the current user with an opening float
+func OpenShift(c *gin.Context) {
+	var req struct {
+		OpeningFloat float64 `json:"opening_float"`
+		Note         string  `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.OpeningFloat < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Opening float cannot be negative"})
+		return
+	}
+
+	shift := model.Shift{
+		CashierID:    currentUserID(c),
+		Status:       shiftOpen,
+		OpeningFloat: roundMoney(req.OpeningFloat),
+		OpenedAt:     time.Now(),
+		Note:         strings.TrimSpace(req.Note),
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the user so two opens cannot race
+		var user model.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, shift.CashierID).Error; err != nil {
+			return err
+		}
+		var open int64
+		if err := tx.Model(&model.Shift{}).Where("cashier_id = ? AND status = ?", user.ID, shiftOpen).Count(&open).Error; err != nil {
+			return err
+		}
+		if open > 0 {
+			return newAPIError(http.StatusConflict, "已有未结班次，请先结班")
+		}
+		return tx.Create(&shift).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, shift)
+}
+
+// shiftTenders totals what a shift took and refunded per tender. Expected is
+// what should be in the drawer or on the settlement slips; the opening float
+// is included in cash.
+func shiftTenders(db *gorm.DB, shift *model.Shift) ([]model.ShiftCount, error) {
+	var taken []struct {
+		Tender string
+		Amount float64
+	}
+	if err := db.Raw(`SELECT p.tender, SUM(p.amount) AS amount
+		FROM payments p JOIN orders o ON o.order_no = p.order_no
+		WHERE o.shift_id = ? GROUP BY p.tender`, shift.ID).Scan(&taken).Error; err != nil {
+		return nil, err
+	}
+	var refunded []struct {
+		Tender string
+		Amount float64
+	}
+	if err := db.Model(&model.SalesReturn{}).
+		Select("refund_tender AS tender, SUM(tender_refund) AS amount").
+		Where("shift_id = ?", shift.ID).Group("refund_tender").
+		Scan(&refunded).Error; err != nil {
+		return nil, err
+	}
+	var voided []struct {
+		Tender string
+		Amount float64
+	}
+	if err := db.Model(&model.SaleVoid{}).
+		Select("refund_tender AS tender, SUM(tender_refund) AS amount").
+		Where("refund_shift_id = ? AND status = ?", shift.ID, voidApproved).Group("refund_tender").
+		Scan(&voided).Error; err != nil {
+		return nil, err
+	}
+
+	byTender := make(map[string]*model.ShiftCount)
+	counts := make([]model.ShiftCount, 0, len(payment.Tenders))
+	for _, t := range payment.Tenders {
+		counts = append(counts, model.ShiftCount{ShiftID: shift.ID, Tender: t})
+	}
+	for i := range counts {
+		byTender[counts[i].Tender] = &counts[i]
+	}
+	tally := func(tender string) *model.ShiftCount {
+		if sc, ok := byTender[tender]; ok {
+			return sc
+		}
+		counts = append(counts, model.ShiftCount{ShiftID: shift.ID, Tender: tender})
+		// Re-point the map, append may have moved the slice
+		for i := range counts {
+			byTender[counts[i].Tender] = &counts[i]
+		}
+		return byTender[tender]
+	}
+	for _, t := range taken {
+		tally(t.Tender).Sales += t.Amount
+	}
+	for _, r := range refunded {
+		tally(r.Tender).Refunds += r.Amount
+	}
+	for _, v := range voided {
+		tally(v.Tender).Refunds += v.Amount
+	}
+	for i := range counts {
+		sc := &counts[i]
+		sc.Sales = roundMoney(sc.Sales)
+		sc.Refunds = roundMoney(sc.Refunds)
+		sc.Expected = roundMoney(sc.Sales - sc.Refunds)
+		if sc.Tender == payment.TenderCash {
+			sc.Expected = roundMoney(sc.Expected + shift.OpeningFloat)
+		}
+	}
+	return counts, nil
+}
+
+// CloseShift ends a shift with the counted drawer. counted maps tenders to
+// the counted total; counted_cash is a shorthand for counted.cash. A shift
+// can be closed by its cashier or by an admin.
+func CloseShift(c *gin.Context) {
+	var req struct {
+		CountedCash *float64           `json:"counted_cash"`
+		Counted     map[string]float64 `json:"counted"`
+		Note        string             `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Counted == nil {
+		req.Counted = make(map[string]float64)
+	}
+	if req.CountedCash != nil {
+		req.Counted[payment.TenderCash] = *req.CountedCash
+	}
+	if _, ok := req.Counted[payment.TenderCash]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "counted_cash is required"})
+		return
+	}
+	for tender, amount := range req.Counted {
+		if amount < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Counted " + tender + " cannot be negative"})
+			return
+		}
+	}
+
+	var shift model.Shift
+	var counts []model.ShiftCount
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&shift, c.Param("id")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return newAPIError(http.StatusNotFound, "Shift not found")
+			}
+			return err
+		}
+		if shift.CashierID != currentUserID(c) && !auth.HasPermission(c.GetString("role"), auth.PermHistoryEdit) {
+			return newAPIError(http.StatusForbidden, "只能结自己的班次")
+		}
+		if shift.Status != shiftOpen {
+			return newAPIError(http.StatusConflict, "班次已结")
+		}
+
+		var err error
+		if counts, err = shiftTenders(tx, &shift); err != nil {
+			return err
+		}
+		for i := range counts {
+			sc := &counts[i]
+			if amount, ok := req.Counted[sc.Tender]; ok {
+				counted := roundMoney(amount)
+				sc.Counted = &counted
+				sc.Variance = roundMoney(counted - sc.Expected)
+			}
+			if sc.Tender == payment.TenderCash {
+				shift.ExpectedCash = sc.Expected
+				shift.CountedCash = sc.Counted
+				shift.CashVariance = sc.Variance
+			}
+		}
+		if err := tx.Create(&counts).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		shift.Status = shiftClosed
+		shift.ClosedAt = &now
+		shift.ClosedBy = currentUserID(c)
+		if note := strings.TrimSpace(req.Note); note != "" {
+			shift.Note = strings.TrimSpace(shift.Note + "\n" + note)
+		}
+		return tx.Save(&shift).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	report, err := shiftReport(database.DB, &shift)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// ShiftRow is a shift with its cashier's name
+type ShiftRow struct {
+	model.Shift
+	CashierName string `json:"cashier_name"`
+}
+
+// GetShifts lists shifts, newest first (&cashier_id=, &status=, &start_date=&end_date= on the open time)
+func GetShifts(c *gin.Context) {
+	page, limit, offset := getPaginationParams(c)
+
+	query := database.DB.Table("shifts s")
+	if cashierID := c.Query("cashier_id"); cashierID != "" {
+		query = query.Where("s.cashier_id = ?", cashierID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("s.status = ?", status)
+	}
+	start, err := parseDate(c.Query("start_date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date, expected YYYY-MM-DD"})
+		return
+	}
+	end, err := parseDate(c.Query("end_date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date, expected YYYY-MM-DD"})
+		return
+	}
+	if start != nil {
+		query = query.Where("s.opened_at >= ?", *start)
+	}
+	if end != nil {
+		query = query.Where("s.opened_at < ?", end.AddDate(0, 0, 1))
+	}
+
+	var total int64
+	query.Count(&total)
+
+	rows := make([]ShiftRow, 0)
+	if err := query.Select("s.*, COALESCE(NULLIF(u.real_name, ''), u.username, '') AS cashier_name").
+		Joins("LEFT JOIN users u ON u.id = s.cashier_id").
+		Order("s.opened_at DESC, s.id DESC").Offset(offset).Limit(limit).
+		Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	totalPages := math.Ceil(float64(total) / float64(limit))
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": rows,
+		"meta": Pagination{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(totalPages),
+		},
+	})
+}
+
+// GetCurrentShift returns the current user's open shift with its running
+// totals (an X-report), or 404 when no shift is open
+func GetCurrentShift(c *gin.Context) {
+	var shift model.Shift
+	if err := database.DB.Where("cashier_id = ? AND status = ?", currentUserID(c), shiftOpen).
+		First(&shift).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No open shift"})
+		return
+	}
+	report, err := shiftReport(database.DB, &shift)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// GetShiftReport returns the Z-report of a shift; an open shift shows its
+// running totals
+func GetShiftReport(c *gin.Context) {
+	var shift model.Shift
+	if err := database.DB.First(&shift, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shift not found"})
+		return
+	}
+	report, err := shiftReport(database.DB, &shift)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// shiftReport builds the Z-report: sales, tenders expected against counted,
+// returns and voids of one shift
+func shiftReport(db *gorm.DB, shift *model.Shift) (gin.H, error) {
+	var cashier model.User
+	db.Select("id", "username", "real_name").Limit(1).Find(&cashier, shift.CashierID)
+	cashierName := cashier.RealName
+	if cashierName == "" {
+		cashierName = cashier.Username
+	}
+
+	var sales struct {
+		OrderCount int
+		Quantity   int
+		NetSales   float64
+		Discounts  float64
+		PointsPaid float64
+	}
+	if err := db.Model(&model.Order{}).Where("shift_id = ? AND status <> ?", shift.ID, saleVoided).
+		Select(`COUNT(*) AS order_count, COALESCE(SUM(total_quantity), 0) AS quantity,
+			COALESCE(SUM(total_amount), 0) AS net_sales, COALESCE(SUM(discount_amount), 0) AS discounts,
+			COALESCE(SUM(points_amount), 0) AS points_paid`).
+		Scan(&sales).Error; err != nil {
+		return nil, err
+	}
+
+	var tenders []model.ShiftCount
+	if shift.Status == shiftClosed {
+		if err := db.Where("shift_id = ?", shift.ID).Order("id").Find(&tenders).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if tenders, err = shiftTenders(db, shift); err != nil {
+			return nil, err
+		}
+	}
+
+	returns := make([]SalesReturnRow, 0)
+	if err := db.Table("sales_returns r").
+		Select(`r.*, COALESCE(m.name, '') AS medicine_name, COALESCE(cu.name, '') AS customer_name,
+			COALESCE(NULLIF(u.real_name, ''), u.username, '') AS operator_name`).
+		Joins("LEFT JOIN medicines m ON m.id = r.medicine_id").
+		Joins("LEFT JOIN customers cu ON cu.id = r.customer_id").
+		Joins("LEFT JOIN users u ON u.id = r.operator_id").
+		Where("r.shift_id = ?", shift.ID).Order("r.id").
+		Scan(&returns).Error; err != nil {
+		return nil, err
+	}
+	var returnQty int
+	var refundTotal float64
+	for _, r := range returns {
+		returnQty += r.Quantity
+		refundTotal += r.RefundAmount
+	}
+
+	// Void requests filed in the shift, whatever became of them, and the
+	// voids refunded from its drawer; only the latter count in the totals
+	voids := make([]SaleVoidRow, 0)
+	if err := withVoidNames(db.Table("sale_voids v")).
+		Where("v.shift_id = ? OR v.refund_shift_id = ?", shift.ID, shift.ID).Order("v.id").
+		Scan(&voids).Error; err != nil {
+		return nil, err
+	}
+	var voidQty int
+	var voidTotal float64
+	for _, v := range voids {
+		if v.Status == voidApproved && v.RefundShiftID != nil && *v.RefundShiftID == shift.ID {
+			voidQty += v.Quantity
+			voidTotal += v.Amount
+		}
+	}
+
+	return gin.H{
+		"shift":        ShiftRow{Shift: *shift, CashierName: cashierName},
+		"order_count":  sales.OrderCount,
+		"quantity":     sales.Quantity,
+		"gross_sales":  roundMoney(sales.NetSales + sales.Discounts),
+		"discounts":    roundMoney(sales.Discounts),
+		"net_sales":    roundMoney(sales.NetSales),
+		"points_paid":  roundMoney(sales.PointsPaid),
+		"tenders":      tenders,
+		"returns":      returns,
+		"return_count": len(returns),
+		"return_qty":   returnQty,
+		"refund_total": roundMoney(refundTotal),
+		"voids":        voids,
+		"void_count":   len(voids),
+		"void_qty":     voidQty,
+		"void_total":   roundMoney(voidTotal),
+	}, nil
+}
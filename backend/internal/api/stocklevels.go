@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/yousaling0624/database-course-project/backend/internal/config"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+)
+
+// ==================== Stock Levels (安全库存 / 补货点) ====================
+
+// effectiveReorderPoint mirrors fn_reorder_point: the medicine's own reorder
+// point or the configured default, never below its safety stock
+func effectiveReorderPoint(med *model.Medicine) int {
+	point := config.DefaultReorderPoint()
+	if med.ReorderPoint != nil {
+		point = *med.ReorderPoint
+	}
+	if med.MinStock != nil && *med.MinStock > point {
+		point = *med.MinStock
+	}
+	return point
+}
+
+// isLowStock reports whether a medicine is below its reorder point
+func isLowStock(med *model.Medicine) bool {
+	return med.Stock < effectiveReorderPoint(med)
+}
+
+// lowStockCondition is the SQL equivalent of isLowStock for medicines rows
+func lowStockCondition() (string, int) {
+	return "stock < GREATEST(COALESCE(min_stock, 0), COALESCE(reorder_point, ?))", config.DefaultReorderPoint()
+}
+
+// optionalInt is a nullable JSON number that tells an explicit null apart
+// from a missing field, so an update can clear a stock level
+type optionalInt struct {
+	Set   bool
+	Value *int
+}
+
+func (o *optionalInt) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	o.Value = nil
+	if string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, &o.Value)
+}
+
+// applyStockLevels copies the levels present in an update onto med and
+// returns the columns to write. Omitted levels are kept; null clears a level
+// (reorder_point then falls back to the configured default).
+func applyStockLevels(med *model.Medicine, minStock, maxStock, reorderPoint optionalInt) map[string]any {
+	cols := map[string]any{}
+	for _, f := range []struct {
+		col string
+		in  optionalInt
+		dst **int
+	}{
+		{"min_stock", minStock, &med.MinStock},
+		{"max_stock", maxStock, &med.MaxStock},
+		{"reorder_point", reorderPoint, &med.ReorderPoint},
+	} {
+		if f.in.Set {
+			*f.dst = f.in.Value
+			cols[f.col] = f.in.Value
+		}
+	}
+	return cols
+}
+
+// validateStockLevels checks min <= reorder point <= max for the levels that are set
+func validateStockLevels(med *model.Medicine) error {
+	for _, v := range []*int{med.MinStock, med.MaxStock, med.ReorderPoint} {
+		if v != nil && *v < 0 {
+			return newAPIError(http.StatusBadRequest, "Stock levels cannot be negative")
+		}
+	}
+	if med.MinStock != nil && med.ReorderPoint != nil && *med.MinStock > *med.ReorderPoint {
+		return newAPIError(http.StatusBadRequest, "min_stock cannot exceed reorder_point")
+	}
+	if med.MaxStock != nil {
+		if med.ReorderPoint != nil && *med.ReorderPoint > *med.MaxStock {
+			return newAPIError(http.StatusBadRequest, "reorder_point cannot exceed max_stock")
+		}
+		if med.MinStock != nil && *med.MinStock > *med.MaxStock {
+			return newAPIError(http.StatusBadRequest, "min_stock cannot exceed max_stock")
+		}
+	}
+	return nil
+}
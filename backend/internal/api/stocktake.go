@@ -0,0 +1,403 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ==================== Stocktake (盘点) ====================
+
+const (
+	stocktakeCounting  = "counting"
+	stocktakeApproved  = "approved"
+	stocktakeCancelled = "cancelled"
+)
+
+// StocktakeLine is a stocktake item with its variance against the book snapshot
+type StocktakeLine struct {
+	model.StocktakeItem
+	Variance       *int    `json:"variance"` // counted - book, nil while uncounted
+	VarianceAmount float64 `json:"variance_amount"`
+}
+
+func newStocktakeLine(item model.StocktakeItem) StocktakeLine {
+	line := StocktakeLine{StocktakeItem: item}
+	if item.CountedQty != nil {
+		v := *item.CountedQty - item.BookQty
+		line.Variance = &v
+		if item.Medicine != nil {
+			line.VarianceAmount = roundMoney(float64(v) * item.Medicine.Price)
+		}
+	}
+	return line
+}
+
+// loadStocktake reads a stocktake header, optionally locking it
+func loadStocktake(db *gorm.DB, id string, lock bool) (*model.Stocktake, error) {
+	if lock {
+		db = db.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	var st model.Stocktake
+	if err := db.First(&st, id).Error; err != nil {
+		return nil, newAPIError(http.StatusNotFound, "Stocktake not found")
+	}
+	return &st, nil
+}
+
+func loadStocktakeItems(db *gorm.DB, stocktakeID int64) ([]model.StocktakeItem, error) {
+	items := make([]model.StocktakeItem, 0)
+	err := db.Preload("Medicine").Where("stocktake_id = ?", stocktakeID).Order("medicine_id").Find(&items).Error
+	return items, err
+}
+
+// CreateStocktake opens a session and snapshots the book quantity of every
+// medicine in scope: all, one type (scope_value) or one manufacturer
+func CreateStocktake(c *gin.Context) {
+	var req struct {
+		Scope      string `json:"scope"`
+		ScopeValue string `json:"scope_value"`
+		Remark     string `json:"remark"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = "all"
+	}
+	req.ScopeValue = strings.TrimSpace(req.ScopeValue)
+
+	var st model.Stocktake
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the medicines in scope so the snapshot is consistent
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Model(&model.Medicine{})
+		switch req.Scope {
+		case "all":
+		case "type":
+			query = query.Where("type = ?", req.ScopeValue)
+		case "manufacturer":
+			query = query.Where("manufacturer = ?", req.ScopeValue)
+		default:
+			return newAPIError(http.StatusBadRequest, "Invalid scope, expected all, type or manufacturer")
+		}
+		if req.Scope != "all" && req.ScopeValue == "" {
+			return newAPIError(http.StatusBadRequest, "scope_value is required for scope %s", req.Scope)
+		}
+
+		var meds []model.Medicine
+		if err := query.Order("id").Find(&meds).Error; err != nil {
+			return err
+		}
+		if len(meds) == 0 {
+			return newAPIError(http.StatusBadRequest, "No medicines match the stocktake scope")
+		}
+
+		// A medicine may only be in one open stocktake, otherwise its
+		// variance would be posted twice
+		ids := make([]int64, len(meds))
+		for i, m := range meds {
+			ids[i] = m.ID
+		}
+		var busy int64
+		if err := tx.Model(&model.StocktakeItem{}).
+			Joins("JOIN stocktakes ON stocktakes.id = stocktake_items.stocktake_id").
+			Where("stocktakes.status = ? AND stocktake_items.medicine_id IN ?", stocktakeCounting, ids).
+			Count(&busy).Error; err != nil {
+			return err
+		}
+		if busy > 0 {
+			return newAPIError(http.StatusConflict, "%d medicines in scope are already in an open stocktake", busy)
+		}
+
+		st = model.Stocktake{
+			Scope:      req.Scope,
+			ScopeValue: req.ScopeValue,
+			Status:     stocktakeCounting,
+			Remark:     req.Remark,
+			CreatedBy:  currentUserID(c),
+		}
+		if err := tx.Create(&st).Error; err != nil {
+			return err
+		}
+
+		items := make([]model.StocktakeItem, len(meds))
+		for i, m := range meds {
+			items[i] = model.StocktakeItem{StocktakeID: st.ID, MedicineID: m.ID, BookQty: m.Stock}
+		}
+		return tx.CreateInBatches(items, 200).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, st)
+}
+
+// GetStocktakes lists stocktake sessions, newest first; ?status= filters
+func GetStocktakes(c *gin.Context) {
+	page, limit, offset := getPaginationParams(c)
+
+	query := database.DB.Model(&model.Stocktake{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	stocktakes := make([]model.Stocktake, 0)
+	if err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&stocktakes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	totalPages := math.Ceil(float64(total) / float64(limit))
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": stocktakes,
+		"meta": Pagination{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(totalPages),
+		},
+	})
+}
+
+// GetStocktake returns one session with all its lines
+func GetStocktake(c *gin.Context) {
+	st, err := loadStocktake(database.DB, c.Param("id"), false)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	items, err := loadStocktakeItems(database.DB, st.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	lines := make([]StocktakeLine, len(items))
+	counted := 0
+	for i, item := range items {
+		lines[i] = newStocktakeLine(item)
+		if item.CountedQty != nil {
+			counted++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stocktake": st,
+		"items":     lines,
+		"counted":   counted,
+		"total":     len(lines),
+	})
+}
+
+// RecordStocktakeCounts saves counted quantities for some lines of an open
+// session. It can be called repeatedly; a later count overwrites an earlier one.
+func RecordStocktakeCounts(c *gin.Context) {
+	var req struct {
+		Items []struct {
+			MedicineID int64  `json:"medicine_id"`
+			CountedQty *int   `json:"counted_qty"`
+			Reason     string `json:"reason"`
+		} `json:"items"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No counts given"})
+		return
+	}
+
+	now := time.Now()
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		st, err := loadStocktake(tx, c.Param("id"), true)
+		if err != nil {
+			return err
+		}
+		if st.Status != stocktakeCounting {
+			return newAPIError(http.StatusConflict, "Stocktake is %s and can no longer be counted", st.Status)
+		}
+
+		for i, in := range req.Items {
+			if in.CountedQty == nil || *in.CountedQty < 0 {
+				return newAPIError(http.StatusBadRequest, "Item %d: counted_qty must be zero or more", i+1)
+			}
+			var item model.StocktakeItem
+			if err := tx.Where("stocktake_id = ? AND medicine_id = ?", st.ID, in.MedicineID).First(&item).Error; err != nil {
+				return newAPIError(http.StatusBadRequest, "Item %d: medicine %d is not part of this stocktake", i+1, in.MedicineID)
+			}
+			if err := tx.Model(&item).Updates(map[string]any{
+				"counted_qty": *in.CountedQty,
+				"reason":      strings.TrimSpace(in.Reason),
+				"counted_by":  currentUserID(c),
+				"counted_at":  now,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Counts recorded", "count": len(req.Items)})
+}
+
+// GetStocktakeVariance reports the lines whose count differs from the book
+// snapshot, plus the lines still waiting to be counted
+func GetStocktakeVariance(c *gin.Context) {
+	st, err := loadStocktake(database.DB, c.Param("id"), false)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	items, err := loadStocktakeItems(database.DB, st.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	variances := make([]StocktakeLine, 0)
+	uncounted := make([]StocktakeLine, 0)
+	var surplusQty, shortageQty int
+	var surplusAmount, shortageAmount float64
+	for _, item := range items {
+		line := newStocktakeLine(item)
+		switch {
+		case line.Variance == nil:
+			uncounted = append(uncounted, line)
+		case *line.Variance > 0:
+			surplusQty += *line.Variance
+			surplusAmount += line.VarianceAmount
+			variances = append(variances, line)
+		case *line.Variance < 0:
+			shortageQty -= *line.Variance
+			shortageAmount -= line.VarianceAmount
+			variances = append(variances, line)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stocktake":       st,
+		"variances":       variances,
+		"uncounted":       uncounted,
+		"matched_count":   len(items) - len(variances) - len(uncounted),
+		"surplus_qty":     surplusQty,
+		"surplus_amount":  roundMoney(surplusAmount),
+		"shortage_qty":    shortageQty,
+		"shortage_amount": roundMoney(shortageAmount),
+		"net_amount":      roundMoney(surplusAmount - shortageAmount),
+	})
+}
+
+// ApproveStocktake posts every counted difference as a stock adjustment in
+// one transaction. The variance (counted - book) is applied to the current
+// stock, so sales and receipts made while counting are kept. Shortages are
+// also taken out of the lots (see adjustLots). Uncounted lines are left
+// untouched.
+func ApproveStocktake(c *gin.Context) {
+	var posted, unchanged, skipped int
+	err := stockTransaction(func(tx *gorm.DB) error {
+		st, err := loadStocktake(tx, c.Param("id"), true)
+		if err != nil {
+			return err
+		}
+		if st.Status != stocktakeCounting {
+			return newAPIError(http.StatusConflict, "Stocktake is already %s", st.Status)
+		}
+
+		items, err := loadStocktakeItems(tx, st.ID)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if item.CountedQty == nil {
+				skipped++
+				continue
+			}
+			variance := *item.CountedQty - item.BookQty
+			if variance == 0 {
+				unchanged++
+				continue
+			}
+
+			var med model.Medicine
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&med, item.MedicineID).Error; err != nil {
+				return err
+			}
+			if med.Stock+variance < 0 {
+				return newAPIError(http.StatusConflict, "%s: posting a variance of %d would make stock negative (current %d)", med.Name, variance, med.Stock)
+			}
+
+			reason := fmt.Sprintf("盘点单 #%d", st.ID)
+			if item.Reason != "" {
+				reason += ": " + item.Reason
+			}
+			if err := setStockContext(tx, currentUserID(c), refStocktake, st.ID, reason); err != nil {
+				return err
+			}
+			if err := adjustLots(tx, &med, variance); err != nil {
+				return err
+			}
+			if err := tx.Model(&med).UpdateColumn("stock", gorm.Expr("stock + ?", variance)).Error; err != nil {
+				return err
+			}
+			posted++
+		}
+
+		now := time.Now()
+		return tx.Model(st).Updates(map[string]any{
+			"status":      stocktakeApproved,
+			"approved_by": currentUserID(c),
+			"closed_at":   now,
+		}).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Stocktake approved",
+		"posted":    posted,
+		"unchanged": unchanged,
+		"uncounted": skipped,
+	})
+}
+
+// CancelStocktake closes an open session without touching stock
+func CancelStocktake(c *gin.Context) {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		st, err := loadStocktake(tx, c.Param("id"), true)
+		if err != nil {
+			return err
+		}
+		if st.Status != stocktakeCounting {
+			return newAPIError(http.StatusConflict, "Stocktake is already %s", st.Status)
+		}
+		now := time.Now()
+		return tx.Model(st).Updates(map[string]any{"status": stocktakeCancelled, "closed_at": now}).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stocktake cancelled"})
+}
@@ -0,0 +1,481 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ==================== Traceability Codes (药品追溯码) ====================
+
+// Trace code states
+const (
+	traceInStock    = "in_stock"
+	traceSold       = "sold"
+	traceQuarantine = "quarantine"
+	traceRecalled   = "recalled" // in a lot frozen by a recall
+	traceReturned   = "returned" // sent back to the supplier
+)
+
+// Trace events. void is written by sp_void_sale and sp_delete_inbound.
+const (
+	traceReceive        = "receive"
+	traceDispense       = "dispense"
+	traceReturn         = "return"
+	traceRecall         = "recall"
+	traceSupplierReturn = "supplier_return"
+)
+
+// A 药品追溯码 is 20 digits; the first 7 identify the product and package
+var traceCodePattern = regexp.MustCompile(`^\d{20}$`)
+
+// cleanTraceCodes validates the codes scanned for one line: each must be a
+// well-formed 20 digit code, scanned once, and there cannot be more codes than
+// boxes. Scanning is optional, so fewer codes than boxes is accepted.
+func cleanTraceCodes(codes []string, qty int) ([]string, error) {
+	if len(codes) > qty {
+		return nil, newAPIError(http.StatusBadRequest, "扫码 %d 个，超过数量 %d", len(codes), qty)
+	}
+	seen := make(map[string]bool, len(codes))
+	cleaned := make([]string, 0, len(codes))
+	for _, code := range codes {
+		code = strings.TrimSpace(code)
+		if !traceCodePattern.MatchString(code) {
+			return nil, newAPIError(http.StatusBadRequest, "追溯码格式错误: %q，应为 20 位数字", code)
+		}
+		if seen[code] {
+			return nil, newAPIError(http.StatusBadRequest, "追溯码重复扫描: %s", code)
+		}
+		seen[code] = true
+		cleaned = append(cleaned, code)
+	}
+	return cleaned, nil
+}
+
+// receiveTraceCodes registers the codes scanned on receipt against the inbound
+func receiveTraceCodes(tx *gorm.DB, inbound *model.Inbound, codes []string, operatorID int64) error {
+	if len(codes) == 0 {
+		return nil
+	}
+	var taken []string
+	if err := tx.Model(&model.TraceCode{}).Where("code IN ?", codes).Pluck("code", &taken).Error; err != nil {
+		return err
+	}
+	if len(taken) > 0 {
+		return newAPIError(http.StatusConflict, "追溯码已登记: %s", strings.Join(taken, ", "))
+	}
+
+	rows := make([]model.TraceCode, 0, len(codes))
+	events := make([]model.TraceEvent, 0, len(codes))
+	for _, code := range codes {
+		rows = append(rows, model.TraceCode{
+			Code:       code,
+			MedicineID: inbound.MedicineID,
+			InboundID:  &inbound.ID,
+			Status:     traceInStock,
+		})
+		events = append(events, model.TraceEvent{
+			Code:       code,
+			Event:      traceReceive,
+			MedicineID: inbound.MedicineID,
+			RefID:      inbound.ID,
+			OperatorID: operatorID,
+		})
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return err
+	}
+	return tx.Create(&events).Error
+}
+
+// dispenseTraceCodes binds the codes scanned at the counter to a sale. A code
+// that was never received is stock from before codes were captured and is
+// registered as it is sold. A box from a lot that is frozen or expired
+// cannot be sold.
+func dispenseTraceCodes(tx *gorm.DB, sale *model.Sales, codes []string, operatorID int64) error {
+	if len(codes) == 0 {
+		return nil
+	}
+	var known []model.TraceCode
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code IN ?", codes).Find(&known).Error; err != nil {
+		return err
+	}
+	byCode := make(map[string]*model.TraceCode, len(known))
+	var inboundIDs []int64
+	for i := range known {
+		byCode[known[i].Code] = &known[i]
+		if known[i].InboundID != nil {
+			inboundIDs = append(inboundIDs, *known[i].InboundID)
+		}
+	}
+	lots, err := receiptLots(tx, inboundIDs)
+	if err != nil {
+		return err
+	}
+	today := startOfToday()
+
+	events := make([]model.TraceEvent, 0, len(codes))
+	for _, code := range codes {
+		tc, ok := byCode[code]
+		switch {
+		case !ok:
+			if err := tx.Create(&model.TraceCode{
+				Code:       code,
+				MedicineID: sale.MedicineID,
+				SaleID:     &sale.ID,
+				Status:     traceSold,
+			}).Error; err != nil {
+				return err
+			}
+		case tc.MedicineID != sale.MedicineID:
+			return newAPIError(http.StatusBadRequest, "追溯码 %s 不属于该药品", code)
+		case tc.Status != traceInStock:
+			return newAPIError(http.StatusConflict, "追溯码 %s 已售出或不可销售", code)
+		case tc.InboundID != nil && lots[*tc.InboundID] != nil && lots[*tc.InboundID].Status != lotStatusActive:
+			return newAPIError(http.StatusConflict, "追溯码 %s 所属批次已冻结，不可销售", code)
+		case tc.InboundID != nil && lots[*tc.InboundID] != nil && lots[*tc.InboundID].ExpiryDate != nil &&
+			lots[*tc.InboundID].ExpiryDate.Before(today):
+			return newAPIError(http.StatusConflict, "追溯码 %s 所属批次已过期，不可销售", code)
+		default:
+			if err := tx.Model(tc).Updates(map[string]any{"sale_id": sale.ID, "status": traceSold}).Error; err != nil {
+				return err
+			}
+		}
+		events = append(events, model.TraceEvent{
+			Code:       code,
+			Event:      traceDispense,
+			MedicineID: sale.MedicineID,
+			RefID:      sale.ID,
+			OrderNo:    sale.OrderID,
+			OperatorID: operatorID,
+		})
+	}
+	return tx.Create(&events).Error
+}
+
+// receiptLots loads the lot each inbound opened on receipt (its first lot;
+// later ones hold quarantined customer returns), keyed by inbound
+func receiptLots(tx *gorm.DB, inboundIDs []int64) (map[int64]*model.StockLot, error) {
+	byInbound := make(map[int64]*model.StockLot)
+	if len(inboundIDs) == 0 {
+		return byInbound, nil
+	}
+	var lots []model.StockLot
+	if err := tx.Where("id IN (?)", tx.Model(&model.StockLot{}).Select("MIN(id)").
+		Where("inbound_id IN ?", inboundIDs).Group("inbound_id")).
+		Find(&lots).Error; err != nil {
+		return nil, err
+	}
+	for i := range lots {
+		byInbound[lots[i].InboundID] = &lots[i]
+	}
+	return byInbound, nil
+}
+
+// recallTraceCodes marks the boxes in stock from the inbounds of recalled
+// lots as recalled, so they cannot be scanned at the counter
+func recallTraceCodes(tx *gorm.DB, recall *model.Recall, inboundIDs []int64, operatorID int64) error {
+	if len(inboundIDs) == 0 {
+		return nil
+	}
+	var codes []string
+	if err := tx.Model(&model.TraceCode{}).Where("inbound_id IN ? AND status = ?", inboundIDs, traceInStock).
+		Pluck("code", &codes).Error; err != nil {
+		return err
+	}
+	return markTraceCodes(tx, codes, traceRecalled, model.TraceEvent{
+		Event:      traceRecall,
+		MedicineID: recall.MedicineID,
+		RefID:      recall.ID,
+		OperatorID: operatorID,
+	})
+}
+
+// supplierReturnTraceCodes marks the boxes of a purchase return as sent back.
+// Scanned codes must be unsold boxes of the inbound. Once the inbound's lots
+// hold fewer units than it still has codes for, the codes left over are
+// marked too, so a recall return with nothing scanned still retires them all.
+func supplierReturnTraceCodes(tx *gorm.DB, ret *model.PurchaseReturn, codes []string, fullyReturned bool) error {
+	held := []string{traceInStock, traceQuarantine, traceRecalled}
+	var known []model.TraceCode
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("inbound_id = ? AND status IN ?", ret.InboundID, held).
+		Order("id DESC").Find(&known).Error; err != nil {
+		return err
+	}
+	isHeld := make(map[string]bool, len(known))
+	for _, tc := range known {
+		isHeld[tc.Code] = true
+	}
+	for _, code := range codes {
+		if !isHeld[code] {
+			return newAPIError(http.StatusBadRequest, "追溯码 %s 不是该入库单的在库药品", code)
+		}
+	}
+
+	left := len(known)
+	if fullyReturned {
+		left = 0
+	} else {
+		var lots []model.StockLot
+		if err := tx.Where("inbound_id = ?", ret.InboundID).Find(&lots).Error; err != nil {
+			return err
+		}
+		if len(lots) > 0 {
+			left = 0
+			for _, lot := range lots {
+				left += lot.Remaining
+			}
+		}
+	}
+
+	marked := make(map[string]bool, len(codes))
+	out := append([]string(nil), codes...)
+	for _, code := range codes {
+		marked[code] = true
+	}
+	excess := len(known) - len(codes) - left
+	for _, tc := range known {
+		if excess <= 0 {
+			break
+		}
+		if !marked[tc.Code] {
+			out = append(out, tc.Code)
+			excess--
+		}
+	}
+	return markTraceCodes(tx, out, traceReturned, model.TraceEvent{
+		Event:      traceSupplierReturn,
+		MedicineID: ret.MedicineID,
+		RefID:      ret.ID,
+		OperatorID: ret.OperatorID,
+	})
+}
+
+// markTraceCodes moves codes to status and logs event for each of them
+func markTraceCodes(tx *gorm.DB, codes []string, status string, event model.TraceEvent) error {
+	if len(codes) == 0 {
+		return nil
+	}
+	if err := tx.Model(&model.TraceCode{}).Where("code IN ?", codes).Update("status", status).Error; err != nil {
+		return err
+	}
+	events := make([]model.TraceEvent, 0, len(codes))
+	for _, code := range codes {
+		e := event
+		e.Code = code
+		events = append(events, e)
+	}
+	return tx.Create(&events).Error
+}
+
+// returnTraceCodes releases the codes of boxes a customer brought back. Each
+// code must be bound to the returned sale. Restocked boxes can be sold again;
+// quarantined ones cannot.
+func returnTraceCodes(tx *gorm.DB, ret *model.SalesReturn, codes []string) error {
+	if len(codes) == 0 {
+		return nil
+	}
+	var bound []string
+	if err := tx.Model(&model.TraceCode{}).Where("code IN ? AND sale_id = ?", codes, ret.SaleID).
+		Pluck("code", &bound).Error; err != nil {
+		return err
+	}
+	if len(bound) != len(codes) {
+		isBound := make(map[string]bool, len(bound))
+		for _, code := range bound {
+			isBound[code] = true
+		}
+		for _, code := range codes {
+			if !isBound[code] {
+				return newAPIError(http.StatusBadRequest, "追溯码 %s 不属于该销售", code)
+			}
+		}
+	}
+
+	status := traceInStock
+	if ret.Disposition == returnQuarantine {
+		status = traceQuarantine
+	}
+	if err := tx.Model(&model.TraceCode{}).Where("code IN ?", codes).
+		Updates(map[string]any{"sale_id": nil, "status": status}).Error; err != nil {
+		return err
+	}
+	events := make([]model.TraceEvent, 0, len(codes))
+	for _, code := range codes {
+		events = append(events, model.TraceEvent{
+			Code:       code,
+			Event:      traceReturn,
+			MedicineID: ret.MedicineID,
+			RefID:      ret.ID,
+			OrderNo:    ret.OrderID,
+			OperatorID: ret.OperatorID,
+		})
+	}
+	return tx.Create(&events).Error
+}
+
+// TraceEventRow is a lifecycle step with the supplier or customer it involved
+type TraceEventRow struct {
+	model.TraceEvent
+	LotNo         string     `json:"lot_no"`
+	ExpiryDate    *time.Time `json:"expiry_date"`
+	SupplierName  string     `json:"supplier_name"`
+	CustomerID    int64      `json:"customer_id"`
+	CustomerName  string     `json:"customer_name"`
+	CustomerPhone string     `json:"customer_phone"`
+	OperatorName  string     `json:"operator_name"`
+}
+
+// GetTraceCode shows the lifecycle of one box: where it was received from,
+// who bought it, and any returns
+func GetTraceCode(c *gin.Context) {
+	var tc model.TraceCode
+	if err := database.DB.Where("code = ?", c.Param("code")).First(&tc).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trace code not found"})
+		return
+	}
+	var med model.Medicine
+	database.DB.First(&med, tc.MedicineID)
+
+	events := make([]TraceEventRow, 0)
+	if err := database.DB.Raw(`SELECT e.*, COALESCE(i.lot_no, '') AS lot_no, i.expiry_date,
+			COALESCE(sup.name, '') AS supplier_name,
+			COALESCE(s.customer_id, r.customer_id, 0) AS customer_id,
+			COALESCE(cu.name, '') AS customer_name, COALESCE(cu.phone, '') AS customer_phone,
+			COALESCE(NULLIF(u.real_name, ''), u.username, '') AS operator_name
+		FROM trace_events e
+		LEFT JOIN inbounds i ON e.event = 'receive' AND i.id = e.ref_id
+		LEFT JOIN suppliers sup ON sup.id = i.supplier_id
+		LEFT JOIN sales s ON e.event = 'dispense' AND s.id = e.ref_id
+		LEFT JOIN sales_returns r ON e.event = 'return' AND r.id = e.ref_id
+		LEFT JOIN customers cu ON cu.id = COALESCE(s.customer_id, r.customer_id)
+		LEFT JOIN users u ON u.id = e.operator_id
+		WHERE e.code = ? ORDER BY e.id`, tc.Code).Scan(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var receipt, sale *TraceEventRow
+	for i := range events {
+		switch events[i].Event {
+		case traceReceive:
+			receipt = &events[i]
+		case traceDispense:
+			sale = &events[i]
+		}
+	}
+	if tc.Status != traceSold {
+		sale = nil
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"trace_code": tc,
+		"medicine":   med,
+		"receipt":    receipt,
+		"sale":       sale,
+		"events":     events,
+	})
+}
+
+// ExportTraceCodes writes the codes received or dispensed in a date range as
+// a CSV file for upload to the regulator's traceability platform
+// (?type=receive|dispense&start_date=&end_date=, defaulting to today).
+// Codes whose inbound or sale has since been deleted are left out.
+func ExportTraceCodes(c *gin.Context) {
+	kind := c.DefaultQuery("type", traceReceive)
+	if kind != traceReceive && kind != traceDispense {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be receive or dispense"})
+		return
+	}
+	start, err := parseDate(c.Query("start_date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date, expected YYYY-MM-DD"})
+		return
+	}
+	end, err := parseDate(c.Query("end_date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date, expected YYYY-MM-DD"})
+		return
+	}
+	today := startOfToday()
+	if start == nil {
+		start = &today
+	}
+	if end == nil {
+		end = &today
+	}
+	until := end.AddDate(0, 0, 1)
+
+	type exportRow struct {
+		Code         string
+		MedicineCode string
+		MedicineName string
+		LotNo        string
+		ExpiryDate   *time.Time
+		RefID        int64
+		OrderNo      string
+		Party        string
+		CreatedAt    time.Time
+	}
+	var rows []exportRow
+	var header []string
+	if kind == traceReceive {
+		header = []string{"追溯码", "药品编码", "药品名称", "批号", "有效期", "入库单ID", "供应商", "入库时间"}
+		err = database.DB.Raw(`SELECT e.code, m.code AS medicine_code, m.name AS medicine_name,
+				COALESCE(i.lot_no, '') AS lot_no, i.expiry_date, e.ref_id, COALESCE(sup.name, '') AS party, e.created_at
+			FROM trace_events e
+			JOIN inbounds i ON i.id = e.ref_id
+			JOIN medicines m ON m.id = e.medicine_id
+			LEFT JOIN suppliers sup ON sup.id = i.supplier_id
+			WHERE e.event = 'receive' AND e.created_at >= ? AND e.created_at < ?
+			ORDER BY e.id`, *start, until).Scan(&rows).Error
+	} else {
+		header = []string{"追溯码", "药品编码", "药品名称", "订单号", "销售明细ID", "客户", "销售时间"}
+		err = database.DB.Raw(`SELECT e.code, m.code AS medicine_code, m.name AS medicine_name,
+				e.ref_id, e.order_no, COALESCE(cu.name, '') AS party, e.created_at
+			FROM trace_events e
+			JOIN sales s ON s.id = e.ref_id
+			JOIN medicines m ON m.id = e.medicine_id
+			LEFT JOIN customers cu ON cu.id = s.customer_id
+			WHERE e.event = 'dispense' AND e.created_at >= ? AND e.created_at < ? AND s.status <> ?
+			ORDER BY e.id`, *start, until, saleVoided).Scan(&rows).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var buf strings.Builder
+	buf.WriteString("\ufeff") // BOM so spreadsheet tools read the file as UTF-8
+	w := csv.NewWriter(&buf)
+	w.Write(header)
+	for _, r := range rows {
+		ref := strconv.FormatInt(r.RefID, 10)
+		at := r.CreatedAt.Format("2006-01-02 15:04:05")
+		if kind == traceReceive {
+			expiry := ""
+			if r.ExpiryDate != nil {
+				expiry = r.ExpiryDate.Format("2006-01-02")
+			}
+			w.Write([]string{r.Code, r.MedicineCode, r.MedicineName, r.LotNo, expiry, ref, r.Party, at})
+		} else {
+			w.Write([]string{r.Code, r.MedicineCode, r.MedicineName, r.OrderNo, ref, r.Party, at})
+		}
+	}
+	w.Flush()
+
+	filename := fmt.Sprintf("trace_%s_%s_%s.csv", kind, start.Format("20060102"), end.Format("20060102"))
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", []byte(buf.String()))
+}
@@ -0,0 +1,420 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yousaling0624/database-course-project/backend/internal/database"
+	"github.com/yousaling0624/database-course-project/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ==================== Sale Voids (销售作废) ====================
+
+// saleVoided is the status of a voided sales line (and of an order all of
+// whose lines are voided)
+const saleVoided = "voided"
+
+// Void request states
+const (
+	voidPending  = "pending"
+	voidApproved = "approved"
+	voidRejected = "rejected"
+)
+
+// requestVoid files a void request for a sales line. It always waits for
+// another admin to approve it; nobody approves their own request.
+func requestVoid(c *gin.Context, saleID string, reason, tender string) (*model.SaleVoid, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, newAPIError(http.StatusBadRequest, "请填写作废原因")
+	}
+	if tender != "" {
+		if _, err := refundTender(database.DB, "", tender); err != nil {
+			return nil, err
+		}
+	}
+
+	var v model.SaleVoid
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		sale, order, err := lockVoidableSale(tx, saleID)
+		if err != nil {
+			return err
+		}
+		var pending int64
+		if err := tx.Model(&model.SaleVoid{}).Where("sale_id = ? AND status = ?", sale.ID, voidPending).
+			Count(&pending).Error; err != nil {
+			return err
+		}
+		if pending > 0 {
+			return newAPIError(http.StatusConflict, "该销售已有待审批的作废申请")
+		}
+
+		// Filing moves no money, so no open shift is needed; the request
+		// shows up in the requester's Z-report when they have one
+		shift, err := openShift(tx, currentUserID(c))
+		if err != nil {
+			return err
+		}
+		v = model.SaleVoid{
+			SaleID:       sale.ID,
+			OrderNo:      sale.OrderID,
+			MedicineID:   sale.MedicineID,
+			Quantity:     sale.Quantity,
+			Amount:       sale.TotalPrice,
+			CashierID:    order.CashierID,
+			ShiftID:      shiftIDOf(shift),
+			Reason:       reason,
+			Status:       voidPending,
+			RequestedBy:  currentUserID(c),
+			RefundTender: tender,
+		}
+		return tx.Create(&v).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// lockVoidableSale locks a sales line that can still be voided, with its order
+func lockVoidableSale(tx *gorm.DB, saleID any) (*model.Sales, *model.Order, error) {
+	var sale model.Sales
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sale, saleID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, newAPIError(http.StatusNotFound, "Sale not found")
+		}
+		return nil, nil, err
+	}
+	if sale.Status == saleVoided {
+		return nil, nil, newAPIError(http.StatusConflict, "该销售已作废")
+	}
+	var returned int64
+	if err := tx.Model(&model.SalesReturn{}).Where("sale_id = ?", sale.ID).Count(&returned).Error; err != nil {
+		return nil, nil, err
+	}
+	if returned > 0 {
+		return nil, nil, newAPIError(http.StatusConflict, "该销售已有退货记录，不能作废，请继续走退货流程")
+	}
+	var order model.Order
+	if err := tx.Where("order_no = ?", sale.OrderID).Limit(1).Find(&order).Error; err != nil {
+		return nil, nil, err
+	}
+	return &sale, &order, nil
+}
+
+// approveVoid voids the sale of a pending request: points are unwound,
+// sp_void_sale marks the line voided (tr_after_sale_void puts the stock back
+// into the ledger as sale_void) and the refund is recorded against the
+// approver's open shift (RefundShiftID). As with a return, the money is
+// handed back at the till; nothing is reversed with the payment provider.
+func approveVoid(tx *gorm.DB, v *model.SaleVoid, approverID int64, note string) error {
+	if approverID == v.RequestedBy {
+		return newAPIError(http.StatusForbidden, "不能审批自己提交的作废申请")
+	}
+	sale, order, err := lockVoidableSale(tx, v.SaleID)
+	if err != nil {
+		return err
+	}
+	// The refund comes out of the approver's drawer. Once the till works in
+	// shifts that drawer must be open, or the refund would miss every
+	// reconciliation.
+	shift, err := activeShift(tx, approverID)
+	if err != nil {
+		return err
+	}
+	if shift == nil && (v.ShiftID != nil || order.ShiftID != nil) {
+		return newAPIError(http.StatusConflict, "请先开班再审批作废，退款须计入当班钱箱")
+	}
+	tender, err := refundTender(tx, sale.OrderID, v.RefundTender)
+	if err != nil {
+		return err
+	}
+	if err := setStockContext(tx, approverID, refSaleVoid, v.ID, v.Reason); err != nil {
+		return err
+	}
+	// Points first, while the order total still includes this line
+	_, pointsRefunded, err := unwindPoints(tx, sale.CustomerID, sale.OrderID, sale.TotalPrice, nil, approverID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Exec("CALL sp_void_sale(?)", sale.ID).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	v.Status = voidApproved
+	v.ApprovedBy = &approverID
+	v.DecidedAt = &now
+	v.Note = strings.TrimSpace(note)
+	v.RefundShiftID = shiftIDOf(shift)
+	v.Amount = sale.TotalPrice
+	v.RefundTender = tender
+	v.TenderRefund = roundMoney(math.Max(sale.TotalPrice-pointsToYuan(pointsRefunded), 0))
+	return tx.Save(v).Error
+}
+
+// RequestSaleVoid asks for a sales line to be voided ({reason, refund_tender}).
+// The request waits for an admin other than the requester. Lines already
+// returned in part or full cannot be voided.
+func RequestSaleVoid(c *gin.Context) {
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+		Tender string `json:"refund_tender"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	v, err := requestVoid(c, c.Param("id"), req.Reason, req.Tender)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Void request submitted for approval", "void": v})
+}
+
+// ApproveSaleVoid approves a pending void request ({note}) filed by someone else
+func ApproveSaleVoid(c *gin.Context) {
+	var req struct {
+		Note string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var v model.SaleVoid
+	err := stockTransaction(func(tx *gorm.DB) error {
+		if err := lockPendingVoid(tx, c.Param("id"), &v); err != nil {
+			return err
+		}
+		return approveVoid(tx, &v, currentUserID(c), req.Note)
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Sale voided", "void": v})
+}
+
+// RejectSaleVoid turns down a pending void request ({note}); the sale stands
+func RejectSaleVoid(c *gin.Context) {
+	var req struct {
+		Note string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var v model.SaleVoid
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockPendingVoid(tx, c.Param("id"), &v); err != nil {
+			return err
+		}
+		approver := currentUserID(c)
+		now := time.Now()
+		v.Status = voidRejected
+		v.ApprovedBy = &approver
+		v.DecidedAt = &now
+		v.Note = strings.TrimSpace(req.Note)
+		return tx.Save(&v).Error
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Void request rejected", "void": v})
+}
+
+// lockPendingVoid loads and locks a void request that is still pending
+func lockPendingVoid(tx *gorm.DB, id string, v *model.SaleVoid) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(v, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return newAPIError(http.StatusNotFound, "Void request not found")
+		}
+		return err
+	}
+	if v.Status != voidPending {
+		return newAPIError(http.StatusConflict, "Void request is already %s", v.Status)
+	}
+	return nil
+}
+
+// SaleVoidRow is a void request with display names
+type SaleVoidRow struct {
+	model.SaleVoid
+	MedicineName  string `json:"medicine_name"`
+	CashierName   string `json:"cashier_name"`
+	RequesterName string `json:"requester_name"`
+	ApproverName  string `json:"approver_name"`
+}
+
+// withVoidNames selects void rows of a query on "sale_voids v" with their
+// display names
+func withVoidNames(query *gorm.DB) *gorm.DB {
+	return query.Select(`v.*, COALESCE(m.name, '') AS medicine_name,
+		COALESCE(NULLIF(uc.real_name, ''), uc.username, '') AS cashier_name,
+		COALESCE(NULLIF(ur.real_name, ''), ur.username, '') AS requester_name,
+		COALESCE(NULLIF(ua.real_name, ''), ua.username, '') AS approver_name`).
+		Joins("LEFT JOIN medicines m ON m.id = v.medicine_id").
+		Joins("LEFT JOIN users uc ON uc.id = v.cashier_id").
+		Joins("LEFT JOIN users ur ON ur.id = v.requested_by").
+		Joins("LEFT JOIN users ua ON ua.id = v.approved_by")
+}
+
+// GetSaleVoids lists void requests, newest first
+// (&status=pending|approved|rejected, &cashier_id=, &order_no=)
+func GetSaleVoids(c *gin.Context) {
+	page, limit, offset := getPaginationParams(c)
+
+	query := database.DB.Table("sale_voids v")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("v.status = ?", status)
+	}
+	if cashierID := c.Query("cashier_id"); cashierID != "" {
+		query = query.Where("v.cashier_id = ?", cashierID)
+	}
+	if orderNo := c.Query("order_no"); orderNo != "" {
+		query = query.Where("v.order_no = ?", orderNo)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	rows := make([]SaleVoidRow, 0)
+	if err := withVoidNames(query).Order("v.created_at DESC, v.id DESC").Offset(offset).Limit(limit).
+		Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	totalPages := math.Ceil(float64(total) / float64(limit))
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": rows,
+		"meta": Pagination{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(totalPages),
+		},
+	})
+}
+
+// VoidReportRow is one cashier's sales and voids over the report period
+type VoidReportRow struct {
+	CashierID     int64   `json:"cashier_id"`
+	CashierName   string  `json:"cashier_name"`
+	SalesLines    int     `json:"sales_lines"`
+	SalesAmount   float64 `json:"sales_amount"` // everything rung up, voided lines included
+	RequestCount  int     `json:"request_count"`
+	ApprovedCount int     `json:"approved_count"`
+	PendingCount  int     `json:"pending_count"`
+	RejectedCount int     `json:"rejected_count"`
+	SelfRequested int     `json:"self_requested"` // requests the cashier filed on their own sales
+	VoidedQty     int     `json:"voided_qty"`
+	VoidedAmount  float64 `json:"voided_amount"`
+	VoidRate      float64 `json:"void_rate"` // voided share of SalesAmount
+}
+
+// GetVoidReport summarises void requests per cashier who made the sale, with
+// the share of their takings voided, highest voided amount first
+// (&start_date=&end_date=, default the current month)
+func GetVoidReport(c *gin.Context) {
+	start, err := parseDate(c.Query("start_date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date, expected YYYY-MM-DD"})
+		return
+	}
+	end, err := parseDate(c.Query("end_date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date, expected YYYY-MM-DD"})
+		return
+	}
+	today := startOfToday()
+	if end == nil {
+		end = &today
+	}
+	if start == nil {
+		first := time.Date(end.Year(), end.Month(), 1, 0, 0, 0, 0, end.Location())
+		start = &first
+	}
+	until := end.AddDate(0, 0, 1)
+
+	rows := make([]VoidReportRow, 0)
+	if err := database.DB.Raw(`SELECT v.cashier_id,
+			COALESCE(NULLIF(MAX(u.real_name), ''), MAX(u.username), '') AS cashier_name,
+			COUNT(*) AS request_count,
+			COALESCE(SUM(v.status = ?), 0) AS approved_count,
+			COALESCE(SUM(v.status = ?), 0) AS pending_count,
+			COALESCE(SUM(v.status = ?), 0) AS rejected_count,
+			COALESCE(SUM(v.requested_by = v.cashier_id), 0) AS self_requested,
+			COALESCE(SUM(CASE WHEN v.status = ? THEN v.quantity END), 0) AS voided_qty,
+			COALESCE(SUM(CASE WHEN v.status = ? THEN v.amount END), 0) AS voided_amount
+		FROM sale_voids v
+		LEFT JOIN users u ON u.id = v.cashier_id
+		WHERE v.created_at >= ? AND v.created_at < ?
+		GROUP BY v.cashier_id`,
+		voidApproved, voidPending, voidRejected, voidApproved, voidApproved,
+		*start, until).Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var sold []struct {
+		CashierID   int64
+		SalesLines  int
+		SalesAmount float64
+	}
+	if err := database.DB.Raw(`SELECT o.cashier_id, COUNT(*) AS sales_lines, COALESCE(SUM(s.total_price), 0) AS sales_amount
+		FROM sales s
+		JOIN orders o ON o.order_no = s.order_id
+		WHERE s.sale_date >= ? AND s.sale_date < ?
+		GROUP BY o.cashier_id`, *start, until).Scan(&sold).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Only cashiers with voids are listed; their takings come from the sales
+	byCashier := make(map[int64]*VoidReportRow, len(rows))
+	for i := range rows {
+		byCashier[rows[i].CashierID] = &rows[i]
+	}
+	for _, s := range sold {
+		if r, ok := byCashier[s.CashierID]; ok {
+			r.SalesLines = s.SalesLines
+			r.SalesAmount = roundMoney(s.SalesAmount)
+		}
+	}
+	var totalVoided float64
+	for i := range rows {
+		r := &rows[i]
+		r.VoidedAmount = roundMoney(r.VoidedAmount)
+		if r.SalesAmount > 0 {
+			r.VoidRate = math.Round(r.VoidedAmount/r.SalesAmount*10000) / 10000
+		}
+		totalVoided += r.VoidedAmount
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].VoidedAmount != rows[j].VoidedAmount {
+			return rows[i].VoidedAmount > rows[j].VoidedAmount
+		}
+		return rows[i].RequestCount > rows[j].RequestCount
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"start_date":    start.Format("2006-01-02"),
+		"end_date":      end.Format("2006-01-02"),
+		"data":          rows,
+		"voided_amount": roundMoney(totalVoided),
+	})
+}
@@ -0,0 +1,58 @@
+package auth
+
+// Roles, mirroring role_admin / role_staff / role_viewer in advanced_features.sql.
+// A pharmacist is staff who may also sign off prescriptions and safety warnings.
+const (
+	RoleAdmin      = "admin"
+	RoleStaff      = "staff"
+	RolePharmacist = "pharmacist"
+	RoleViewer     = "viewer"
+)
+
+// Permission names a capability that a route group requires
+type Permission string
+
+const (
+	PermViewData        Permission = "data:view"        // dashboard, lists, reports, analysis
+	PermSalesEntry      Permission = "sales:create"     // new sales, sales returns and prescriptions
+	PermInboundEntry    Permission = "inbound:create"   // new inbounds, purchase orders and purchase returns
+	PermMasterData      Permission = "master:edit"      // create/update medicines, customers, suppliers
+	PermMasterDelete    Permission = "master:delete"    // delete medicines, customers, suppliers
+	PermHistoryEdit     Permission = "history:edit"     // correct or delete recorded sales and inbounds
+	PermStockCount      Permission = "stock:count"      // open stocktakes and enter counts
+	PermStockAdjust     Permission = "stock:adjust"     // direct stock corrections, stocktake approval
+	PermPurchaseApprove Permission = "purchase:approve" // approve and close purchase orders
+	PermRxVerify        Permission = "rx:verify"        // pharmacist sign-off on prescriptions
+	PermManageUsers     Permission = "users:manage"     // staff accounts
+	PermSystem          Permission = "system:manage"    // backup, restore, database configuration
+)
+
+// rolePermissions is the permission matrix; admin is granted everything
+var rolePermissions = map[string]map[Permission]bool{
+	RoleStaff: {
+		PermViewData:     true,
+		PermSalesEntry:   true,
+		PermInboundEntry: true,
+		PermMasterData:   true,
+		PermStockCount:   true,
+	},
+	RolePharmacist: {
+		PermViewData:     true,
+		PermSalesEntry:   true,
+		PermInboundEntry: true,
+		PermMasterData:   true,
+		PermStockCount:   true,
+		PermRxVerify:     true,
+	},
+	RoleViewer: {
+		PermViewData: true,
+	},
+}
+
+// HasPermission reports whether a role is granted a permission
+func HasPermission(role string, perm Permission) bool {
+	if role == RoleAdmin {
+		return true
+	}
+	return rolePermissions[role][perm]
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// revoked keeps token IDs that were logged out before they expired.
+// Entries are dropped once the token would have expired anyway.
+var (
+	revoked   = make(map[string]time.Time)
+	revokedMu sync.Mutex
+)
+
+// Revoke marks a token ID as revoked until its expiry
+func Revoke(jti string, expiresAt time.Time) {
+	revokedMu.Lock()
+	defer revokedMu.Unlock()
+
+	pruneLocked(time.Now())
+	revoked[jti] = expiresAt
+}
+
+// IsRevoked reports whether a token ID has been revoked
+func IsRevoked(jti string) bool {
+	revokedMu.Lock()
+	defer revokedMu.Unlock()
+
+	exp, ok := revoked[jti]
+	if !ok {
+		return false
+	}
+	if time.Now().After(exp) {
+		delete(revoked, jti)
+		return false
+	}
+	return true
+}
+
+func pruneLocked(now time.Time) {
+	for jti, exp := range revoked {
+		if now.After(exp) {
+			delete(revoked, jti)
+		}
+	}
+}
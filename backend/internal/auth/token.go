@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yousaling0624/database-course-project/backend/internal/config"
+)
+
+// Token types
+const (
+	TokenAccess  = "access"
+	TokenRefresh = "refresh"
+)
+
+const (
+	defaultAccessTTL  = 2 * time.Hour
+	defaultRefreshTTL = 7 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token expired")
+)
+
+// Claims is the payload carried by every signed token
+type Claims struct {
+	ID        string `json:"jti"`
+	UserID    int64  `json:"uid"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	Type      string `json:"typ"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Expiry returns the expiration time of the token
+func (c *Claims) Expiry() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+var (
+	secret     []byte
+	accessTTL  = defaultAccessTTL
+	refreshTTL = defaultRefreshTTL
+	settingsMu sync.RWMutex
+)
+
+// header is fixed because only HS256 tokens are issued
+var encodedHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Init configures the signing secret and token lifetimes.
+// The secret comes from config, then the AUTH_SECRET env var; if neither is set
+// a random secret is generated, which invalidates all tokens on restart.
+func Init(cfg *config.AuthConfig) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	var key string
+	if cfg != nil {
+		key = cfg.Secret
+		if cfg.AccessTTLMinutes > 0 {
+			accessTTL = time.Duration(cfg.AccessTTLMinutes) * time.Minute
+		}
+		if cfg.RefreshTTLHours > 0 {
+			refreshTTL = time.Duration(cfg.RefreshTTLHours) * time.Hour
+		}
+	}
+	if key == "" {
+		key = os.Getenv("AUTH_SECRET")
+	}
+	if key == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			log.Fatalf("Failed to generate token secret: %v", err)
+		}
+		key = hex.EncodeToString(buf)
+		log.Println("No auth secret configured, generated a random one (tokens will not survive a restart)")
+	}
+	secret = []byte(key)
+}
+
+// AccessTTL returns the configured access token lifetime
+func AccessTTL() time.Duration {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return accessTTL
+}
+
+// Issue signs a new token of the given type for a user
+func Issue(userID int64, username, role, tokenType string) (string, *Claims, error) {
+	settingsMu.RLock()
+	key := secret
+	ttl := accessTTL
+	if tokenType == TokenRefresh {
+		ttl = refreshTTL
+	}
+	settingsMu.RUnlock()
+
+	if len(key) == 0 {
+		return "", nil, errors.New("auth not initialized")
+	}
+
+	jti, err := newTokenID()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	claims := &Claims{
+		ID:        jti,
+		UserID:    userID,
+		Username:  username,
+		Role:      role,
+		Type:      tokenType,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	unsigned := encodedHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + sign(key, unsigned), claims, nil
+}
+
+// Parse verifies the signature, type and expiry of a token and returns its claims
+func Parse(token, tokenType string) (*Claims, error) {
+	settingsMu.RLock()
+	key := secret
+	settingsMu.RUnlock()
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != encodedHeader {
+		return nil, ErrInvalidToken
+	}
+
+	expected := sign(key, parts[0]+"."+parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.Type != tokenType || claims.ID == "" {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+func sign(key []byte, data string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newTokenID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
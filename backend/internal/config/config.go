@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 	"sync"
+	"time"
 )
 
 // DatabaseConfig holds MySQL connection settings
@@ -16,9 +18,135 @@ type DatabaseConfig struct {
 	Database string `json:"database"`
 }
 
+// AuthConfig holds token signing settings
+type AuthConfig struct {
+	Secret           string `json:"secret"`
+	AccessTTLMinutes int    `json:"access_ttl_minutes"`
+	RefreshTTLHours  int    `json:"refresh_ttl_hours"`
+}
+
+// InventoryConfig holds stock level defaults
+type InventoryConfig struct {
+	// DefaultReorderPoint applies to medicines without their own reorder point
+	DefaultReorderPoint int `json:"default_reorder_point"`
+}
+
+// FallbackReorderPoint is used when config.json does not set a default
+const FallbackReorderPoint = 50
+
+// SafetyConfig holds the checkout interaction and allergy screening settings
+type SafetyConfig struct {
+	// RulesFile is the local JSON interaction rule file
+	RulesFile string `json:"rules_file"`
+	// HistoryDays is how far back a customer's purchases are screened
+	HistoryDays int `json:"history_days"`
+}
+
+// Defaults used when config.json does not set the safety options
+const (
+	FallbackRulesFile   = "config/interaction_rules.json"
+	FallbackHistoryDays = 30
+)
+
+// CostingConfig selects how the cost of goods sold is measured
+type CostingConfig struct {
+	// Method is "weighted_average" (moving weighted average, the default) or
+	// "fifo" (the cost of the lots each sale is allocated from)
+	Method string `json:"method"`
+}
+
+// Costing methods
+const (
+	CostingWeightedAverage = "weighted_average"
+	CostingFIFO            = "fifo"
+)
+
+// LoyaltyConfig holds the membership points and tier settings
+type LoyaltyConfig struct {
+	// PointsPerYuan is the points earned per yuan paid, before the tier multiplier
+	PointsPerYuan float64 `json:"points_per_yuan"`
+	// RedeemPointsPerYuan is how many points pay for one yuan at checkout
+	RedeemPointsPerYuan int `json:"redeem_points_per_yuan"`
+	// Tiers are the membership tiers; a customer reaches a tier once their
+	// net spend is at least MinSpent
+	Tiers []MembershipTier `json:"tiers"`
+}
+
+// MembershipTier is one membership level
+type MembershipTier struct {
+	Code       string  `json:"code"`
+	Name       string  `json:"name"`
+	MinSpent   float64 `json:"min_spent"`
+	Multiplier float64 `json:"multiplier"` // applied to the points earned
+}
+
+// Defaults used when config.json does not set the loyalty options
+const (
+	FallbackPointsPerYuan       = 1
+	FallbackRedeemPointsPerYuan = 100
+)
+
+// FallbackTiers are used when config.json defines no tiers
+var FallbackTiers = []MembershipTier{
+	{Code: "standard", Name: "普通会员", MinSpent: 0, Multiplier: 1},
+	{Code: "silver", Name: "银卡会员", MinSpent: 1000, Multiplier: 1.2},
+	{Code: "gold", Name: "金卡会员", MinSpent: 5000, Multiplier: 1.5},
+	{Code: "diamond", Name: "钻石会员", MinSpent: 20000, Multiplier: 2},
+}
+
+// POSConfig holds till settings
+type POSConfig struct {
+	// RequireShift refuses sales and returns from cashiers without an open shift
+	RequireShift bool `json:"require_shift"`
+	// ReservationTTLMinutes is how long a held order keeps its stock reserved
+	ReservationTTLMinutes int `json:"reservation_ttl_minutes"`
+	// QuoteValidDays is how long a quotation stays valid unless it sets its own
+	QuoteValidDays int `json:"quote_valid_days"`
+}
+
+// Defaults used when config.json does not set the till options
+const (
+	FallbackReservationTTLMinutes = 30
+	FallbackQuoteValidDays        = 7
+)
+
+// StoreConfig is the shop's identity printed on receipts and invoices
+type StoreConfig struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Phone   string `json:"phone"`
+	// TaxID is the unified social credit code shown as the invoice seller
+	TaxID string `json:"tax_id"`
+	// VATRate is the VAT included in sales prices, e.g. 0.13
+	VATRate float64 `json:"vat_rate"`
+	// ReceiptFooter is printed at the bottom of every receipt
+	ReceiptFooter string `json:"receipt_footer"`
+}
+
+// Defaults used when config.json does not set the store options
+const (
+	FallbackStoreName = "康源医药"
+	FallbackVATRate   = 0.13
+)
+
+// PaymentConfig selects how card, wallet and insurance payments are taken
+type PaymentConfig struct {
+	// Provider is "manual" (payments taken on standalone terminals, recorded
+	// with the reference on their slip) or empty for none, which refuses them
+	Provider string `json:"provider"`
+}
+
 // Config holds all application configuration
 type Config struct {
-	Database DatabaseConfig `json:"database"`
+	Database  DatabaseConfig  `json:"database"`
+	Auth      AuthConfig      `json:"auth"`
+	Inventory InventoryConfig `json:"inventory"`
+	Safety    SafetyConfig    `json:"safety"`
+	Costing   CostingConfig   `json:"costing"`
+	Loyalty   LoyaltyConfig   `json:"loyalty"`
+	POS       POSConfig       `json:"pos"`
+	Store     StoreConfig     `json:"store"`
+	Payment   PaymentConfig   `json:"payment"`
 }
 
 var (
@@ -81,6 +209,111 @@ func Get() *Config {
 	return current
 }
 
+// DefaultReorderPoint returns the configured global reorder point
+func DefaultReorderPoint() int {
+	if cfg := Get(); cfg != nil && cfg.Inventory.DefaultReorderPoint > 0 {
+		return cfg.Inventory.DefaultReorderPoint
+	}
+	return FallbackReorderPoint
+}
+
+// SafetyRulesFile returns the path of the interaction rule file
+func SafetyRulesFile() string {
+	if cfg := Get(); cfg != nil && cfg.Safety.RulesFile != "" {
+		return cfg.Safety.RulesFile
+	}
+	return FallbackRulesFile
+}
+
+// SafetyHistoryDays returns the purchase history window screened at checkout
+func SafetyHistoryDays() int {
+	if cfg := Get(); cfg != nil && cfg.Safety.HistoryDays > 0 {
+		return cfg.Safety.HistoryDays
+	}
+	return FallbackHistoryDays
+}
+
+// CostingMethod returns the configured costing method
+func CostingMethod() string {
+	if cfg := Get(); cfg != nil && cfg.Costing.Method == CostingFIFO {
+		return CostingFIFO
+	}
+	return CostingWeightedAverage
+}
+
+// PointsPerYuan returns the points earned per yuan paid
+func PointsPerYuan() float64 {
+	if cfg := Get(); cfg != nil && cfg.Loyalty.PointsPerYuan > 0 {
+		return cfg.Loyalty.PointsPerYuan
+	}
+	return FallbackPointsPerYuan
+}
+
+// RedeemPointsPerYuan returns how many points pay for one yuan
+func RedeemPointsPerYuan() int {
+	if cfg := Get(); cfg != nil && cfg.Loyalty.RedeemPointsPerYuan > 0 {
+		return cfg.Loyalty.RedeemPointsPerYuan
+	}
+	return FallbackRedeemPointsPerYuan
+}
+
+// MembershipTiers returns the tiers ordered from the lowest spend threshold up
+func MembershipTiers() []MembershipTier {
+	tiers := FallbackTiers
+	if cfg := Get(); cfg != nil && len(cfg.Loyalty.Tiers) > 0 {
+		tiers = cfg.Loyalty.Tiers
+	}
+	sorted := append([]MembershipTier(nil), tiers...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].MinSpent < sorted[j].MinSpent })
+	return sorted
+}
+
+// RequireShift reports whether sales need an open cashier shift
+func RequireShift() bool {
+	cfg := Get()
+	return cfg != nil && cfg.POS.RequireShift
+}
+
+// ReservationTTL returns how long held orders reserve stock
+func ReservationTTL() time.Duration {
+	minutes := FallbackReservationTTLMinutes
+	if cfg := Get(); cfg != nil && cfg.POS.ReservationTTLMinutes > 0 {
+		minutes = cfg.POS.ReservationTTLMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// QuoteValidDays returns the default validity of a quotation in days
+func QuoteValidDays() int {
+	if cfg := Get(); cfg != nil && cfg.POS.QuoteValidDays > 0 {
+		return cfg.POS.QuoteValidDays
+	}
+	return FallbackQuoteValidDays
+}
+
+// Store returns the store details with defaults filled in
+func Store() StoreConfig {
+	var store StoreConfig
+	if cfg := Get(); cfg != nil {
+		store = cfg.Store
+	}
+	if store.Name == "" {
+		store.Name = FallbackStoreName
+	}
+	if store.VATRate <= 0 {
+		store.VATRate = FallbackVATRate
+	}
+	return store
+}
+
+// PaymentProvider returns the configured payment provider name
+func PaymentProvider() string {
+	if cfg := Get(); cfg != nil {
+		return cfg.Payment.Provider
+	}
+	return ""
+}
+
 // GetDSN builds MySQL DSN from config
 func (c *DatabaseConfig) GetDSN() string {
 	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
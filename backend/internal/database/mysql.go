@@ -3,6 +3,7 @@ package database
 import (
 	"log"
 	"os"
+	"strconv"
 	"sync"
 
 	"github.com/yousaling0624/database-course-project/backend/internal/config"
@@ -10,6 +11,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
@@ -56,20 +58,14 @@ func TryConnect() error {
 	log.Println("Database connected successfully")
 
 	// Auto Migrate
-	err = DB.AutoMigrate(
-		&model.User{},
-		&model.Medicine{},
-		&model.Customer{},
-		&model.Supplier{},
-		&model.Inbound{},
-		&model.Sales{},
-	)
+	err = migrate()
 	if err != nil {
 		log.Printf("Failed to migrate database: %v", err)
 		return err
 	}
 
 	seedAdmin()
+	syncSettings()
 
 	return nil
 }
@@ -94,20 +90,14 @@ func Reconnect(dsn string) error {
 	log.Println("Database reconnected successfully")
 
 	// Auto Migrate
-	err = DB.AutoMigrate(
-		&model.User{},
-		&model.Medicine{},
-		&model.Customer{},
-		&model.Supplier{},
-		&model.Inbound{},
-		&model.Sales{},
-	)
+	err = migrate()
 	if err != nil {
 		log.Printf("Failed to migrate database: %v", err)
 		return err
 	}
 
 	seedAdmin()
+	syncSettings()
 
 	return nil
 }
@@ -129,6 +119,47 @@ func TestConnection(dsn string) error {
 	return sqlDB.Close()
 }
 
+// migrate creates or updates all tables managed by GORM
+func migrate() error {
+	return DB.AutoMigrate(
+		&model.User{},
+		&model.Medicine{},
+		&model.Customer{},
+		&model.Supplier{},
+		&model.Inbound{},
+		&model.Sales{},
+		&model.Order{},
+		&model.StockLot{},
+		&model.SaleLot{},
+		&model.RevokedToken{},
+		&model.StockMovement{},
+		&model.Stocktake{},
+		&model.StocktakeItem{},
+		&model.Setting{},
+		&model.PurchaseOrder{},
+		&model.PurchaseOrderLine{},
+		&model.SalesReturn{},
+		&model.PurchaseReturn{},
+		&model.Prescription{},
+		&model.CustomerHealthProfile{},
+		&model.SafetyAcknowledgement{},
+		&model.Recall{},
+		&model.RecallLot{},
+		&model.TraceCode{},
+		&model.TraceEvent{},
+		&model.MedicinePrice{},
+		&model.Promotion{},
+		&model.PromotionUsage{},
+		&model.PointTransaction{},
+		&model.Payment{},
+		&model.Shift{},
+		&model.ShiftCount{},
+		&model.DraftOrder{},
+		&model.DraftOrderLine{},
+		&model.SaleVoid{},
+	)
+}
+
 // Connect is kept for backward compatibility
 func Connect() {
 	if err := TryConnect(); err != nil {
@@ -153,3 +184,18 @@ func seedAdmin() {
 	}
 	// If admin exists, do nothing - don't reset password
 }
+
+// syncSettings mirrors config values that SQL code depends on into the
+// settings table (read by fn_reorder_point; costing_method is informational)
+func syncSettings() {
+	settings := []model.Setting{
+		{Name: "default_reorder_point", Value: strconv.Itoa(config.DefaultReorderPoint())},
+		{Name: "costing_method", Value: config.CostingMethod()},
+		{Name: "points_per_yuan", Value: strconv.FormatFloat(config.PointsPerYuan(), 'f', -1, 64)},
+		{Name: "redeem_points_per_yuan", Value: strconv.Itoa(config.RedeemPointsPerYuan())},
+		{Name: "require_shift", Value: strconv.FormatBool(config.RequireShift())},
+	}
+	if err := DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&settings).Error; err != nil {
+		log.Printf("Failed to sync settings: %v", err)
+	}
+}
@@ -25,12 +25,23 @@ type Medicine struct {
 	Stock        int     `gorm:"not null;default:0" json:"stock"`
 	Manufacturer string  `json:"manufacturer"`
 	Status       string  `gorm:"default:active" json:"status"`
+	Ingredients  string  `gorm:"size:255" json:"ingredients"`
+	// AvgCost is the moving weighted average unit cost, kept by the stock triggers
+	AvgCost      float64 `gorm:"type:decimal(12,4);not null;default:0" json:"avg_cost"`
+	MinStock     *int    `json:"min_stock"`     // safety stock
+	MaxStock     *int    `json:"max_stock"`     // order-up-to level
+	ReorderPoint *int    `json:"reorder_point"` // nil falls back to the configured default
+
+	// EffectiveReorderPoint is filled by queries that select fn_reorder_point
+	EffectiveReorderPoint *int `gorm:"->;-:migration" json:"effective_reorder_point,omitempty"`
 }
 
 type Customer struct {
 	ID        int64     `gorm:"primaryKey" json:"id"`
 	Name      string    `gorm:"not null" json:"name"`
 	Phone     string    `json:"phone"`
+	Tier      string    `gorm:"size:20;default:standard" json:"tier"` // membership tier code
+	Points    int       `gorm:"not null;default:0" json:"points"`     // loyalty points balance
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -43,26 +54,516 @@ type Supplier struct {
 }
 
 type Inbound struct {
-	ID          int64     `gorm:"primaryKey" json:"id"`
-	MedicineID  int64     `gorm:"not null" json:"medicine_id"`
-	SupplierID  int64     `json:"supplier_id"`
-	Quantity    int       `gorm:"not null" json:"quantity"`
-	Price       float64   `gorm:"type:decimal(10,2);not null" json:"price"`
-	InboundDate time.Time `json:"inbound_date"`
+	ID             int64      `gorm:"primaryKey" json:"id"`
+	MedicineID     int64      `gorm:"not null" json:"medicine_id"`
+	SupplierID     int64      `json:"supplier_id"`
+	Quantity       int        `gorm:"not null" json:"quantity"`
+	Price          float64    `gorm:"type:decimal(10,2);not null" json:"price"`
+	InboundDate    time.Time  `json:"inbound_date"`
+	LotNo          string     `gorm:"size:50" json:"lot_no"`
+	ProductionDate *time.Time `gorm:"type:date" json:"production_date"`
+	ExpiryDate     *time.Time `gorm:"type:date" json:"expiry_date"`
+	POLineID       *int64     `gorm:"column:po_line_id;index" json:"po_line_id"` // purchase order line received against
 
 	Medicine *Medicine `gorm:"foreignKey:MedicineID" json:"medicine,omitempty"`
 	Supplier *Supplier `gorm:"foreignKey:SupplierID" json:"supplier,omitempty"`
 }
 
+// Order is the header of a sales order; its lines are the Sales rows sharing its OrderNo
+type Order struct {
+	ID             int64     `gorm:"primaryKey" json:"id"`
+	OrderNo        string    `gorm:"size:32;unique;not null" json:"order_no"`
+	CustomerID     int64     `json:"customer_id"`
+	CashierID      int64     `json:"cashier_id"`
+	ItemCount      int       `gorm:"not null" json:"item_count"`
+	TotalQuantity  int       `gorm:"not null" json:"total_quantity"`
+	TotalAmount    float64   `gorm:"type:decimal(10,2);not null" json:"total_amount"`
+	DiscountAmount float64   `gorm:"type:decimal(10,2);not null;default:0" json:"discount_amount"` // promotion discounts, already deducted
+	PaymentMethod  string    `gorm:"default:cash" json:"payment_method"`
+	PaidAmount     float64   `gorm:"type:decimal(10,2)" json:"paid_amount"`
+	Status         string    `gorm:"default:completed" json:"status"`
+	PrescriptionID *int64    `gorm:"index" json:"prescription_id,omitempty"` // prescription dispensed by this order
+	PointsRedeemed int       `gorm:"not null;default:0" json:"points_redeemed"`
+	PointsAmount   float64   `gorm:"type:decimal(10,2);not null;default:0" json:"points_amount"` // paid with points
+	PointsEarned   int       `gorm:"not null;default:0" json:"points_earned"`
+	ShiftID        *int64    `gorm:"index" json:"shift_id,omitempty"` // cashier shift the order was taken in
+	CreatedAt      time.Time `json:"created_at"`
+
+	Items      []Sales          `gorm:"-" json:"items,omitempty"`
+	Promotions []PromotionUsage `gorm:"-" json:"promotions,omitempty"`
+	Payments   []Payment        `gorm:"-" json:"payments,omitempty"`
+}
+
 type Sales struct {
 	ID         int64     `gorm:"primaryKey" json:"id"`
 	OrderID    string    `gorm:"not null" json:"order_id"`
 	MedicineID int64     `gorm:"not null" json:"medicine_id"`
 	Quantity   int       `gorm:"not null" json:"quantity"`
+	UnitPrice  float64   `gorm:"type:decimal(10,2)" json:"unit_price"` // retail price when sold
 	TotalPrice float64   `gorm:"type:decimal(10,2);not null" json:"total_price"`
 	SaleDate   time.Time `json:"sale_date"`
 	CustomerID int64     `json:"customer_id"`
+	UnitCost   float64   `gorm:"type:decimal(12,4)" json:"unit_cost"`   // cost per unit when sold
+	CostAmount float64   `gorm:"type:decimal(10,2)" json:"cost_amount"` // COGS of the line
+
+	// DiscountAmount is the promotion discount; TotalPrice is net of it
+	DiscountAmount float64 `gorm:"type:decimal(10,2);not null;default:0" json:"discount_amount"`
+
+	// Status is completed, or voided once an approved void has put the stock
+	// back; voided lines stay on the order but count for nothing
+	Status string `gorm:"size:20;not null;default:completed;index" json:"status"`
 
 	Medicine *Medicine `gorm:"foreignKey:MedicineID" json:"medicine,omitempty"`
 	Customer *Customer `gorm:"foreignKey:CustomerID" json:"customer,omitempty"`
 }
+
+// StockLot is a batch of one medicine created by an inbound receipt.
+// Sales consume lots first-expiry-first-out; Remaining is what is left.
+type StockLot struct {
+	ID             int64      `gorm:"primaryKey" json:"id"`
+	MedicineID     int64      `gorm:"not null;index" json:"medicine_id"`
+	InboundID      int64      `gorm:"index" json:"inbound_id"`
+	LotNo          string     `gorm:"size:50" json:"lot_no"`
+	ProductionDate *time.Time `gorm:"type:date" json:"production_date"`
+	ExpiryDate     *time.Time `gorm:"type:date;index" json:"expiry_date"`
+	Quantity       int        `gorm:"not null" json:"quantity"`
+	Remaining      int        `gorm:"not null" json:"remaining"`
+	UnitCost       float64    `gorm:"type:decimal(10,2)" json:"unit_cost"`
+	Status         string     `gorm:"size:20;default:active" json:"status"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// SaleLot records how many units of a sales line were taken from a lot
+type SaleLot struct {
+	ID          int64 `gorm:"primaryKey" json:"id"`
+	SaleID      int64 `gorm:"not null;index" json:"sale_id"`
+	LotID       int64 `gorm:"not null;index" json:"lot_id"`
+	Quantity    int   `gorm:"not null" json:"quantity"`
+	ReturnedQty int   `gorm:"not null;default:0" json:"returned_qty"`
+}
+
+// RevokedToken records a token that was logged out before it expired
+type RevokedToken struct {
+	ID        int64     `gorm:"primaryKey" json:"id"`
+	JTI       string    `gorm:"size:64;unique;not null" json:"jti"`
+	UserID    int64     `json:"user_id"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StockMovement is one row of the append-only stock ledger. Rows are written
+// by the tr_after_medicine_stock_* triggers whenever medicines.stock changes.
+type StockMovement struct {
+	ID         int64     `gorm:"primaryKey" json:"id"`
+	MedicineID int64     `gorm:"not null;index:idx_stock_movements_medicine" json:"medicine_id"`
+	Delta      int       `gorm:"not null" json:"delta"`
+	Balance    int       `gorm:"not null" json:"balance"`
+	RefType    string    `gorm:"size:32;not null" json:"ref_type"`
+	RefID      *int64    `json:"ref_id"`
+	OperatorID int64     `gorm:"default:0" json:"operator_id"`
+	Reason     string    `gorm:"size:255" json:"reason"`
+	CreatedAt  time.Time `gorm:"index:idx_stock_movements_medicine" json:"created_at"`
+}
+
+// Stocktake is an inventory count session. Book quantities are snapshotted
+// when it is opened; counts are entered over time and the differences are
+// posted as stock adjustments when an admin approves it.
+type Stocktake struct {
+	ID         int64      `gorm:"primaryKey" json:"id"`
+	Scope      string     `gorm:"size:20;not null" json:"scope"` // all, type, manufacturer
+	ScopeValue string     `gorm:"size:100" json:"scope_value"`
+	Status     string     `gorm:"size:20;default:counting;index" json:"status"`
+	Remark     string     `gorm:"size:255" json:"remark"`
+	CreatedBy  int64      `json:"created_by"`
+	ApprovedBy int64      `json:"approved_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ClosedAt   *time.Time `json:"closed_at"`
+
+	Items []StocktakeItem `gorm:"-" json:"items,omitempty"`
+}
+
+// StocktakeItem is one medicine of a stocktake; CountedQty is nil until counted
+type StocktakeItem struct {
+	ID          int64      `gorm:"primaryKey" json:"id"`
+	StocktakeID int64      `gorm:"not null;uniqueIndex:idx_stocktake_medicine" json:"stocktake_id"`
+	MedicineID  int64      `gorm:"not null;uniqueIndex:idx_stocktake_medicine" json:"medicine_id"`
+	BookQty     int        `gorm:"not null" json:"book_qty"`
+	CountedQty  *int       `json:"counted_qty"`
+	Reason      string     `gorm:"size:255" json:"reason"`
+	CountedBy   int64      `json:"counted_by"`
+	CountedAt   *time.Time `json:"counted_at"`
+
+	Medicine *Medicine `gorm:"foreignKey:MedicineID" json:"medicine,omitempty"`
+}
+
+// Setting is a system parameter mirrored from config.json so that stored
+// procedures and views can read it
+type Setting struct {
+	Name      string    `gorm:"primaryKey;size:64" json:"name"`
+	Value     string    `gorm:"size:255" json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PurchaseOrder is an order placed with a supplier. It moves from draft to
+// approved, then partially_received and closed as inbounds are received
+// against its lines; drafts that are dropped become cancelled.
+type PurchaseOrder struct {
+	ID          int64      `gorm:"primaryKey" json:"id"`
+	PONo        string     `gorm:"column:po_no;size:32;unique;not null" json:"po_no"`
+	SupplierID  int64      `gorm:"not null;index" json:"supplier_id"`
+	Status      string     `gorm:"size:20;default:draft;index" json:"status"`
+	Remark      string     `gorm:"size:255" json:"remark"`
+	TotalAmount float64    `gorm:"type:decimal(12,2)" json:"total_amount"` // at expected prices
+	CreatedBy   int64      `json:"created_by"`
+	ApprovedBy  int64      `json:"approved_by"`
+	ApprovedAt  *time.Time `json:"approved_at"`
+	ClosedBy    int64      `json:"closed_by"` // 0 when closed by receiving everything
+	ClosedAt    *time.Time `json:"closed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	Supplier *Supplier           `gorm:"-" json:"supplier,omitempty"`
+	Lines    []PurchaseOrderLine `gorm:"-" json:"lines,omitempty"`
+}
+
+// PurchaseOrderLine is one medicine of a purchase order
+type PurchaseOrderLine struct {
+	ID              int64   `gorm:"primaryKey" json:"id"`
+	PurchaseOrderID int64   `gorm:"not null;index" json:"purchase_order_id"`
+	MedicineID      int64   `gorm:"not null;index" json:"medicine_id"`
+	Quantity        int     `gorm:"not null" json:"quantity"`
+	ExpectedPrice   float64 `gorm:"type:decimal(10,2);not null" json:"expected_price"`
+	ReceivedQty     int     `gorm:"not null;default:0" json:"received_qty"`
+
+	Medicine *Medicine `gorm:"foreignKey:MedicineID" json:"medicine,omitempty"`
+}
+
+// SalesReturn is a full or partial return of one sales line. The sale is
+// kept; reports show the return as a negative line.
+type SalesReturn struct {
+	ID           int64     `gorm:"primaryKey" json:"id"`
+	SaleID       int64     `gorm:"not null;index" json:"sale_id"`
+	OrderID      string    `gorm:"size:50;index" json:"order_id"`
+	MedicineID   int64     `gorm:"not null;index" json:"medicine_id"`
+	CustomerID   int64     `json:"customer_id"`
+	Quantity     int       `gorm:"not null" json:"quantity"`
+	RefundAmount float64   `gorm:"type:decimal(10,2);not null" json:"refund_amount"`
+	CostAmount   float64   `gorm:"type:decimal(10,2)" json:"cost_amount"` // COGS reversed by the return
+	Reason       string    `gorm:"size:255" json:"reason"`
+	Disposition  string    `gorm:"size:20;default:restock" json:"disposition"` // restock or quarantine
+	OperatorID   int64     `json:"operator_id"`
+	CreatedAt    time.Time `gorm:"index" json:"created_at"`
+
+	// The refund handed back at the till, less any points returned, and the
+	// shift it came out of
+	RefundTender string  `gorm:"size:20" json:"refund_tender"`
+	TenderRefund float64 `gorm:"type:decimal(10,2)" json:"tender_refund"`
+	ShiftID      *int64  `gorm:"index" json:"shift_id,omitempty"`
+}
+
+// PurchaseReturn is a return-to-supplier document for part or all of one
+// inbound. Stock leaves when it is created; Status moves from pending to
+// credited once the supplier's credit note is confirmed.
+type PurchaseReturn struct {
+	ID           int64      `gorm:"primaryKey" json:"id"`
+	ReturnNo     string     `gorm:"size:30;uniqueIndex;not null" json:"return_no"`
+	InboundID    int64      `gorm:"not null;index" json:"inbound_id"`
+	SupplierID   int64      `gorm:"not null;index" json:"supplier_id"`
+	MedicineID   int64      `gorm:"not null;index" json:"medicine_id"`
+	Quantity     int        `gorm:"not null" json:"quantity"`
+	UnitPrice    float64    `gorm:"type:decimal(10,2);not null" json:"unit_price"`
+	CreditAmount float64    `gorm:"type:decimal(10,2);not null" json:"credit_amount"`
+	ReasonCode   string     `gorm:"size:20;not null" json:"reason_code"` // damaged, expired, recalled, wrong_item
+	Remark       string     `gorm:"size:255" json:"remark"`
+	Status       string     `gorm:"size:20;default:pending;index" json:"status"`
+	OperatorID   int64      `json:"operator_id"`
+	CreditedBy   int64      `json:"credited_by"`
+	CreditedAt   *time.Time `json:"credited_at"`
+	RecallID     *int64     `gorm:"index" json:"recall_id,omitempty"` // set when generated by a recall
+	CreatedAt    time.Time  `gorm:"index" json:"created_at"`
+}
+
+// Prescription is a doctor's prescription presented for 处方药. A pharmacist
+// verifies it before it can be dispensed, and it is dispensed by exactly one
+// order. Prescriptions are never deleted so they form the audit archive.
+type Prescription struct {
+	ID             int64      `gorm:"primaryKey" json:"id"`
+	RxNo           string     `gorm:"size:30;uniqueIndex;not null" json:"rx_no"`
+	CustomerID     int64      `gorm:"not null;index" json:"customer_id"`
+	Prescriber     string     `gorm:"size:50;not null" json:"prescriber"`
+	Hospital       string     `gorm:"size:100;not null" json:"hospital"`
+	PrescribedDate time.Time  `gorm:"type:date;not null" json:"prescribed_date"`
+	Diagnosis      string     `gorm:"size:255" json:"diagnosis"`
+	ImageFile      string     `gorm:"size:255" json:"image_file"`
+	Status         string     `gorm:"size:20;default:pending;index" json:"status"` // pending, verified, rejected, dispensed
+	CreatedBy      int64      `json:"created_by"`
+	PharmacistID   int64      `json:"pharmacist_id"`
+	VerifiedAt     *time.Time `json:"verified_at"`
+	ReviewNote     string     `gorm:"size:255" json:"review_note"`
+	OrderNo        string     `gorm:"size:32;index" json:"order_no"`
+	DispensedAt    *time.Time `json:"dispensed_at"`
+	CreatedAt      time.Time  `gorm:"index" json:"created_at"`
+}
+
+// CustomerHealthProfile holds what the checkout safety checks need to know
+// about a customer. Allergies and conditions are comma separated terms.
+type CustomerHealthProfile struct {
+	CustomerID int64     `gorm:"primaryKey;autoIncrement:false" json:"customer_id"`
+	Allergies  string    `gorm:"size:500" json:"allergies"`
+	Conditions string    `gorm:"size:500" json:"conditions"` // chronic conditions
+	Notes      string    `gorm:"size:255" json:"notes"`
+	UpdatedBy  int64     `json:"updated_by"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// SafetyAcknowledgement records a pharmacist accepting the interaction and
+// allergy warnings raised for an order
+type SafetyAcknowledgement struct {
+	ID             int64     `gorm:"primaryKey" json:"id"`
+	OrderNo        string    `gorm:"size:32;index" json:"order_no"`
+	CustomerID     int64     `gorm:"index" json:"customer_id"`
+	Warnings       string    `gorm:"type:text" json:"warnings"` // JSON array of safety.Warning
+	AcknowledgedBy int64     `json:"acknowledged_by"`
+	CreatedAt      time.Time `gorm:"index" json:"created_at"`
+}
+
+// Recall is a manufacturer or regulator recall of one medicine. Without a lot
+// number, supplier or receiving window it covers the whole product. The
+// matching lots are frozen from sale when it is registered.
+type Recall struct {
+	ID                 int64      `gorm:"primaryKey" json:"id"`
+	RecallNo           string     `gorm:"size:30;uniqueIndex;not null" json:"recall_no"`
+	MedicineID         int64      `gorm:"not null;index" json:"medicine_id"`
+	LotNo              string     `gorm:"size:50" json:"lot_no"`
+	SupplierID         int64      `json:"supplier_id"`
+	ReceivedFrom       *time.Time `gorm:"type:date" json:"received_from"`
+	ReceivedTo         *time.Time `gorm:"type:date" json:"received_to"`
+	Level              int        `json:"level"` // 1-3, 一级 being the most serious
+	Reason             string     `gorm:"size:255" json:"reason"`
+	Status             string     `gorm:"size:20;default:active;index" json:"status"` // active, closed
+	FrozenQty          int        `json:"frozen_qty"`
+	PrevMedicineStatus string     `gorm:"size:20" json:"-"`
+	CreatedBy          int64      `json:"created_by"`
+	ClosedBy           int64      `json:"closed_by"`
+	ClosedAt           *time.Time `json:"closed_at"`
+	CreatedAt          time.Time  `json:"created_at"`
+
+	Medicine *Medicine `gorm:"foreignKey:MedicineID" json:"medicine,omitempty"`
+}
+
+// RecallLot is a stock lot covered by a recall, including lots already sold
+// out, which are still needed to trace customers
+type RecallLot struct {
+	ID         int64  `gorm:"primaryKey" json:"id"`
+	RecallID   int64  `gorm:"not null;uniqueIndex:idx_recall_lot" json:"recall_id"`
+	LotID      int64  `gorm:"not null;uniqueIndex:idx_recall_lot" json:"lot_id"`
+	InboundID  int64  `json:"inbound_id"`
+	LotNo      string `gorm:"size:50" json:"lot_no"`
+	FrozenQty  int    `json:"frozen_qty"`
+	PrevStatus string `gorm:"size:20" json:"prev_status"`
+}
+
+// TraceCode is the current state of one box's drug traceability code
+// (药品追溯码): the inbound that received it and, once dispensed, the sale
+type TraceCode struct {
+	ID         int64     `gorm:"primaryKey" json:"id"`
+	Code       string    `gorm:"size:20;uniqueIndex;not null" json:"code"`
+	MedicineID int64     `gorm:"not null;index" json:"medicine_id"`
+	InboundID  *int64    `gorm:"index" json:"inbound_id"` // nil for stock received before codes were captured
+	SaleID     *int64    `gorm:"index" json:"sale_id"`
+	Status     string    `gorm:"size:20;default:in_stock;index" json:"status"` // in_stock, sold, quarantine, recalled, returned
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// TraceEvent is one step in a box's lifecycle. The log is append-only, so it
+// keeps earlier sales of a box that was returned and sold again.
+type TraceEvent struct {
+	ID         int64     `gorm:"primaryKey" json:"id"`
+	Code       string    `gorm:"size:20;not null;index" json:"code"`
+	Event      string    `gorm:"size:20;not null;index:idx_trace_event_time" json:"event"` // receive, dispense, return, recall, supplier_return, void
+	MedicineID int64     `gorm:"not null" json:"medicine_id"`
+	RefID      int64     `json:"ref_id"` // inbound, sale, sales return, recall or purchase return id
+	OrderNo    string    `gorm:"size:32" json:"order_no"`
+	OperatorID int64     `json:"operator_id"`
+	CreatedAt  time.Time `gorm:"index:idx_trace_event_time" json:"created_at"`
+}
+
+// MedicinePrice is one retail price change. Changes can be scheduled ahead;
+// once EffectiveFrom has passed the price is applied to Medicine.Price.
+type MedicinePrice struct {
+	ID            int64      `gorm:"primaryKey" json:"id"`
+	MedicineID    int64      `gorm:"not null;index:idx_medicine_price_effective" json:"medicine_id"`
+	Price         float64    `gorm:"type:decimal(10,2);not null" json:"price"`
+	PrevPrice     *float64   `gorm:"type:decimal(10,2)" json:"prev_price"` // price it replaced, set when applied
+	EffectiveFrom time.Time  `gorm:"not null;index:idx_medicine_price_effective" json:"effective_from"`
+	Status        string     `gorm:"size:20;default:scheduled;index" json:"status"` // scheduled, applied, cancelled
+	Reason        string     `gorm:"size:255" json:"reason"`
+	OperatorID    int64      `json:"operator_id"`
+	AppliedAt     *time.Time `json:"applied_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+
+	Medicine *Medicine `gorm:"foreignKey:MedicineID" json:"medicine,omitempty"`
+}
+
+// Promotion is a discount rule applied automatically at checkout while it is
+// active and within its validity window. Scope fields left empty match any
+// medicine; all set scope fields must match.
+type Promotion struct {
+	ID       int64  `gorm:"primaryKey" json:"id"`
+	Name     string `gorm:"size:100;not null" json:"name"`
+	RuleType string `gorm:"size:20;not null" json:"rule_type"` // percentage, fixed, bundle, threshold
+	// Value is the percent off (percentage), the amount off per unit (fixed)
+	// or the amount off the eligible subtotal (threshold)
+	Value     float64 `gorm:"type:decimal(10,2)" json:"value"`
+	BuyQty    int     `json:"buy_qty"`                             // bundle: units paid for
+	FreeQty   int     `json:"free_qty"`                            // bundle: units given free per BuyQty
+	Threshold float64 `gorm:"type:decimal(10,2)" json:"threshold"` // threshold: minimum eligible subtotal
+
+	StartAt      time.Time `gorm:"index" json:"start_at"`
+	EndAt        time.Time `gorm:"index" json:"end_at"`
+	MemberOnly   bool      `json:"member_only"`
+	MedicineID   *int64    `gorm:"index" json:"medicine_id"`
+	MedicineType string    `gorm:"size:20" json:"medicine_type"`
+	Manufacturer string    `gorm:"size:100" json:"manufacturer"`
+	NameKeyword  string    `gorm:"size:50" json:"name_keyword"`                // e.g. 感冒 matches every 感冒 product
+	Status       string    `gorm:"size:20;default:active;index" json:"status"` // active, disabled
+	CreatedBy    int64     `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// PromotionUsage records the discount a promotion gave on one sales line
+type PromotionUsage struct {
+	ID            int64     `gorm:"primaryKey" json:"id"`
+	PromotionID   int64     `gorm:"not null;index" json:"promotion_id"`
+	PromotionName string    `gorm:"size:100" json:"promotion_name"`
+	RuleType      string    `gorm:"size:20" json:"rule_type"`
+	OrderNo       string    `gorm:"size:32;index" json:"order_no"`
+	SaleID        int64     `gorm:"index" json:"sale_id"`
+	MedicineID    int64     `json:"medicine_id"`
+	CustomerID    int64     `json:"customer_id"`
+	Quantity      int       `json:"quantity"`
+	Discount      float64   `gorm:"type:decimal(10,2);not null" json:"discount"`
+	CreatedAt     time.Time `gorm:"index" json:"created_at"`
+}
+
+// PointTransaction is one change to a customer's loyalty points balance
+type PointTransaction struct {
+	ID         int64     `gorm:"primaryKey" json:"id"`
+	CustomerID int64     `gorm:"not null;index:idx_point_customer_time" json:"customer_id"`
+	Type       string    `gorm:"size:20;not null" json:"type"` // earn, redeem, reverse, refund
+	Points     int       `gorm:"not null" json:"points"`       // signed change
+	Balance    int       `gorm:"not null" json:"balance"`      // balance after the change
+	OrderNo    string    `gorm:"size:32;index" json:"order_no"`
+	ReturnID   *int64    `json:"return_id"`
+	OperatorID int64     `json:"operator_id"`
+	CreatedAt  time.Time `gorm:"index:idx_point_customer_time" json:"created_at"`
+}
+
+// Payment is one tender line of an order. Amount is what the line paid
+// towards the order; for cash, Tendered is what was handed over and
+// ChangeAmount what was given back.
+type Payment struct {
+	ID           int64     `gorm:"primaryKey" json:"id"`
+	OrderNo      string    `gorm:"size:32;not null;index" json:"order_no"`
+	Tender       string    `gorm:"size:20;not null;index" json:"tender"` // cash, card, wechat, alipay, insurance, points
+	Amount       float64   `gorm:"type:decimal(10,2);not null" json:"amount"`
+	Tendered     float64   `gorm:"type:decimal(10,2)" json:"tendered"`
+	ChangeAmount float64   `gorm:"type:decimal(10,2)" json:"change"`
+	Reference    string    `gorm:"size:64" json:"reference"` // payment code, card or insurance number given at the till
+	Provider     string    `gorm:"size:30" json:"provider"`
+	ProviderRef  string    `gorm:"size:64" json:"provider_ref"` // the provider's transaction reference
+	CreatedAt    time.Time `gorm:"index" json:"created_at"`
+}
+
+// Shift is a cashier's till session. Orders and returns processed while it
+// is open carry its ID; closing it records the counted drawer.
+type Shift struct {
+	ID           int64      `gorm:"primaryKey" json:"id"`
+	CashierID    int64      `gorm:"not null;index" json:"cashier_id"`
+	Status       string     `gorm:"size:20;default:open;index" json:"status"` // open, closed
+	OpeningFloat float64    `gorm:"type:decimal(10,2);not null" json:"opening_float"`
+	OpenedAt     time.Time  `gorm:"index" json:"opened_at"`
+	ClosedAt     *time.Time `json:"closed_at"`
+	ClosedBy     int64      `json:"closed_by"`
+	ExpectedCash float64    `gorm:"type:decimal(10,2)" json:"expected_cash"` // float plus cash taken less cash refunded
+	CountedCash  *float64   `gorm:"type:decimal(10,2)" json:"counted_cash"`
+	CashVariance float64    `gorm:"type:decimal(10,2)" json:"cash_variance"` // counted less expected
+	Note         string     `gorm:"size:255" json:"note"`
+}
+
+// ShiftCount is the expected and counted total of one tender at shift close
+type ShiftCount struct {
+	ID       int64    `gorm:"primaryKey" json:"id"`
+	ShiftID  int64    `gorm:"not null;uniqueIndex:idx_shift_tender" json:"shift_id"`
+	Tender   string   `gorm:"size:20;not null;uniqueIndex:idx_shift_tender" json:"tender"`
+	Sales    float64  `gorm:"type:decimal(10,2)" json:"sales"`
+	Refunds  float64  `gorm:"type:decimal(10,2)" json:"refunds"`
+	Expected float64  `gorm:"type:decimal(10,2)" json:"expected"`
+	Counted  *float64 `gorm:"type:decimal(10,2)" json:"counted"` // nil when the tender was not counted
+	Variance float64  `gorm:"type:decimal(10,2)" json:"variance"`
+}
+
+// DraftOrder is a parked basket (hold) or a quotation (quote). Its lines are
+// kept apart from sales so no stock trigger fires; a reserving draft holds
+// its quantities back from other checkouts until ReservedUntil.
+type DraftOrder struct {
+	ID             int64      `gorm:"primaryKey" json:"id"`
+	DraftNo        string     `gorm:"size:32;unique;not null" json:"draft_no"`
+	Kind           string     `gorm:"size:20;not null;index" json:"kind"`       // hold, quote
+	Status         string     `gorm:"size:20;default:open;index" json:"status"` // open, converted, cancelled, expired
+	CustomerID     int64      `json:"customer_id"`
+	CashierID      int64      `gorm:"index" json:"cashier_id"`
+	Reserved       bool       `gorm:"not null;default:false" json:"reserved"`
+	ReservedUntil  *time.Time `gorm:"index" json:"reserved_until"`
+	ValidUntil     *time.Time `json:"valid_until"`                            // quotes only
+	TotalAmount    float64    `gorm:"type:decimal(10,2)" json:"total_amount"` // at the prices and promotions when last saved
+	DiscountAmount float64    `gorm:"type:decimal(10,2)" json:"discount_amount"`
+	Note           string     `gorm:"size:255" json:"note"`
+	OrderNo        string     `gorm:"size:32" json:"order_no"` // the order it was checked out as
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	Lines []DraftOrderLine `gorm:"-" json:"lines,omitempty"`
+}
+
+// DraftOrderLine is one line of a draft order
+type DraftOrderLine struct {
+	ID         int64   `gorm:"primaryKey" json:"id"`
+	DraftID    int64   `gorm:"not null;index" json:"draft_id"`
+	MedicineID int64   `gorm:"not null;index" json:"medicine_id"`
+	Quantity   int     `gorm:"not null" json:"quantity"`
+	UnitPrice  float64 `gorm:"type:decimal(10,2)" json:"unit_price"`
+	Discount   float64 `gorm:"type:decimal(10,2)" json:"discount"`
+	Amount     float64 `gorm:"type:decimal(10,2)" json:"amount"` // after the discount
+}
+
+// SaleVoid is a request to void one sales line. A cashier's request waits
+// for an admin; an admin's is approved at once. Approving it marks the sale
+// voided, which puts the stock back, and refunds the line.
+type SaleVoid struct {
+	ID          int64      `gorm:"primaryKey" json:"id"`
+	SaleID      int64      `gorm:"not null;index" json:"sale_id"`
+	OrderNo     string     `gorm:"size:50;index" json:"order_no"`
+	MedicineID  int64      `gorm:"not null" json:"medicine_id"`
+	Quantity    int        `gorm:"not null" json:"quantity"`
+	Amount      float64    `gorm:"type:decimal(10,2);not null" json:"amount"`
+	CashierID   int64      `gorm:"index" json:"cashier_id"` // who made the sale
+	ShiftID     *int64     `gorm:"index" json:"shift_id,omitempty"`
+	Reason      string     `gorm:"size:255;not null" json:"reason"`
+	Status      string     `gorm:"size:20;not null;default:pending;index" json:"status"` // pending, approved, rejected
+	RequestedBy int64      `gorm:"index" json:"requested_by"`
+	ApprovedBy  *int64     `json:"approved_by"`
+	DecidedAt   *time.Time `json:"decided_at"`
+	Note        string     `gorm:"size:255" json:"note"`
+	CreatedAt   time.Time  `gorm:"index" json:"created_at"`
+
+	// The refund handed back, less any points returned
+	RefundTender string  `gorm:"size:20" json:"refund_tender"`
+	TenderRefund float64 `gorm:"type:decimal(10,2)" json:"tender_refund"`
+
+	// ShiftID is the requester's shift; the refund is paid out of the
+	// approver's open shift
+	RefundShiftID *int64 `gorm:"index" json:"refund_shift_id,omitempty"`
+}
@@ -0,0 +1,49 @@
+package payment
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Fake is a local provider for tests: it approves every payment without
+// contacting anyone. Tests install it with Use, set Decline to refuse a
+// tender and inspect Charges and Voided. Never install it in production.
+type Fake struct {
+	mu      sync.Mutex
+	seq     int
+	Charges []Charge
+	Voided  []string
+	// Decline, when set, refuses payments of that tender
+	Decline map[string]bool
+}
+
+// NewFake returns an empty fake provider
+func NewFake() *Fake {
+	return &Fake{}
+}
+
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+func (f *Fake) Charge(c Charge) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Decline[c.Tender] {
+		return Result{}, fmt.Errorf("%w: %s", ErrDeclined, c.Tender)
+	}
+	f.seq++
+	f.Charges = append(f.Charges, c)
+	return Result{
+		Provider:  f.Name(),
+		Reference: fmt.Sprintf("FAKE%s%04d", time.Now().Format("20060102150405"), f.seq),
+	}, nil
+}
+
+func (f *Fake) Void(tender, reference string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Voided = append(f.Voided, reference)
+	return nil
+}
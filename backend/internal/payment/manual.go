@@ -0,0 +1,40 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrReferenceRequired is returned when a manual payment has no reference
+var ErrReferenceRequired = errors.New("payment reference required")
+
+// Manual records payments taken on standalone terminals that are not wired
+// to the till: a bank card machine, a WeChat / Alipay merchant QR code or the
+// insurance terminal. The cashier takes the payment there and keys in the
+// reference printed on its slip, which becomes the transaction reference.
+// Nothing is charged or checked against the terminal.
+type Manual struct{}
+
+// NewManual returns the manual provider
+func NewManual() Manual {
+	return Manual{}
+}
+
+func (Manual) Name() string {
+	return "manual"
+}
+
+func (m Manual) Charge(c Charge) (Result, error) {
+	ref := strings.TrimSpace(c.Reference)
+	if ref == "" {
+		return Result{}, fmt.Errorf("%w: %s", ErrReferenceRequired, c.Tender)
+	}
+	return Result{Provider: m.Name(), Reference: ref}, nil
+}
+
+// Void cannot reach the terminal; the error is logged so the cashier knows
+// to reverse the payment there
+func (Manual) Void(tender, reference string) error {
+	return fmt.Errorf("reverse %s payment %s on the terminal", tender, reference)
+}
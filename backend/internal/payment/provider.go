@@ -0,0 +1,108 @@
+// Package payment takes non-cash payments at checkout through a pluggable
+// provider: bank card terminals, WeChat Pay / Alipay and medical insurance.
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Tender types
+const (
+	TenderCash      = "cash"
+	TenderCard      = "card"
+	TenderWeChat    = "wechat"
+	TenderAlipay    = "alipay"
+	TenderInsurance = "insurance" // 医保卡
+	TenderPoints    = "points"    // loyalty points, settled locally
+)
+
+// Tenders lists every tender type in report order
+var Tenders = []string{TenderCash, TenderCard, TenderWeChat, TenderAlipay, TenderInsurance, TenderPoints}
+
+// External reports whether a tender is charged through the provider; cash
+// and points are settled by the till itself
+func External(tender string) bool {
+	switch tender {
+	case TenderCard, TenderWeChat, TenderAlipay, TenderInsurance:
+		return true
+	}
+	return false
+}
+
+// ErrDeclined is returned (possibly wrapped) when a payment is refused
+var ErrDeclined = errors.New("payment declined")
+
+// ErrNotConfigured is returned when no payment provider has been installed
+var ErrNotConfigured = errors.New("no payment provider configured")
+
+// Charge is one payment to take
+type Charge struct {
+	OrderNo   string
+	Tender    string
+	Amount    float64
+	Reference string // payment code scanned, card or insurance number
+}
+
+// Result is a captured payment
+type Result struct {
+	Provider  string
+	Reference string // the provider's transaction reference
+}
+
+// Provider charges and voids external payments
+type Provider interface {
+	Name() string
+	Charge(c Charge) (Result, error)
+	// Void cancels a payment captured for an order that was then not placed
+	Void(tender, reference string) error
+}
+
+// unconfigured refuses every external payment. It is the provider until a
+// real one is installed with Use or selected in config, so the till can never approve a card or
+// wallet payment nobody actually took.
+type unconfigured struct{}
+
+func (unconfigured) Name() string {
+	return "none"
+}
+
+func (unconfigured) Charge(c Charge) (Result, error) {
+	return Result{}, ErrNotConfigured
+}
+
+func (unconfigured) Void(tender, reference string) error {
+	return ErrNotConfigured
+}
+
+var (
+	current Provider = unconfigured{}
+	mu      sync.RWMutex
+)
+
+// Use replaces the active provider
+func Use(p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = p
+}
+
+// Current returns the active provider
+func Current() Provider {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// ByName returns the provider selected in config: "manual", or "" / "none"
+// for no provider
+func ByName(name string) (Provider, error) {
+	switch name {
+	case "", "none":
+		return unconfigured{}, nil
+	case "manual":
+		return NewManual(), nil
+	}
+	return nil, fmt.Errorf("unknown payment provider %q", name)
+}
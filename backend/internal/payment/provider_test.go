@@ -0,0 +1,47 @@
+package payment
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNoProviderRefusesExternalTenders(t *testing.T) {
+	for _, tender := range []string{TenderCard, TenderWeChat, TenderAlipay, TenderInsurance} {
+		_, err := Current().Charge(Charge{OrderNo: "ORD1", Tender: tender, Amount: 10, Reference: "X"})
+		if !errors.Is(err, ErrNotConfigured) {
+			t.Fatalf("%s: got %v, want ErrNotConfigured", tender, err)
+		}
+	}
+}
+
+func TestFakeDecline(t *testing.T) {
+	f := NewFake()
+	f.Decline = map[string]bool{TenderCard: true}
+	if _, err := f.Charge(Charge{Tender: TenderCard, Amount: 1}); !errors.Is(err, ErrDeclined) {
+		t.Fatalf("got %v, want ErrDeclined", err)
+	}
+	res, err := f.Charge(Charge{Tender: TenderAlipay, Amount: 1})
+	if err != nil || res.Reference == "" {
+		t.Fatalf("alipay should be approved, got %+v %v", res, err)
+	}
+}
+
+func TestManualRecordsTerminalReference(t *testing.T) {
+	p, err := ByName("manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := p.Charge(Charge{OrderNo: "ORD1", Tender: TenderCard, Amount: 10, Reference: " 000123456789 "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Provider != "manual" || res.Reference != "000123456789" {
+		t.Fatalf("got %+v, want the slip reference recorded", res)
+	}
+	if _, err := p.Charge(Charge{Tender: TenderWeChat, Amount: 10}); !errors.Is(err, ErrReferenceRequired) {
+		t.Fatalf("got %v, want ErrReferenceRequired", err)
+	}
+	if _, err := ByName("acme"); err == nil {
+		t.Fatal("an unknown provider name should be refused")
+	}
+}
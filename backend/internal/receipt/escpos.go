@@ -0,0 +1,66 @@
+package receipt
+
+import (
+	"bytes"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// ESC/POS commands
+var (
+	escInit        = []byte{0x1B, 0x40}       // ESC @, reset the printer
+	escChinese     = []byte{0x1C, 0x26}       // FS &, Chinese character mode
+	escAlignLeft   = []byte{0x1B, 0x61, 0x00} // ESC a 0
+	escAlignCenter = []byte{0x1B, 0x61, 0x01} // ESC a 1
+	escDouble      = []byte{0x1D, 0x21, 0x11} // GS ! 0x11, double width and height
+	escNormal      = []byte{0x1D, 0x21, 0x00} // GS ! 0
+	escFeed        = []byte{0x1B, 0x64, 0x04} // ESC d 4, feed past the cutter
+	escCut         = []byte{0x1D, 0x56, 0x42, 0x00}
+)
+
+// ESCPOS renders a receipt as an ESC/POS byte stream for a thermal printer
+// with a paper roll width characters wide. Text is sent in GBK, which the
+// Chinese printer fonts use; characters outside GBK print as '?'.
+func ESCPOS(r *Receipt, width int) ([]byte, error) {
+	header, body := layout(r, width)
+	enc := encoding.ReplaceUnsupported(simplifiedchinese.GBK.NewEncoder())
+
+	var buf bytes.Buffer
+	write := func(s string) error {
+		out, err := enc.String(s + "\n")
+		if err != nil {
+			return err
+		}
+		buf.WriteString(out)
+		return nil
+	}
+
+	buf.Write(escInit)
+	buf.Write(escChinese)
+	buf.Write(escAlignCenter)
+	buf.Write(escDouble)
+	if err := write(truncate(header[0], width/2)); err != nil {
+		return nil, err
+	}
+	buf.Write(escNormal)
+	for _, h := range header[1:] {
+		if err := write(h); err != nil {
+			return nil, err
+		}
+	}
+	buf.Write(escAlignLeft)
+	for _, l := range body {
+		if err := write(l); err != nil {
+			return nil, err
+		}
+	}
+	buf.Write(escAlignCenter)
+	if err := write(truncate(footer(r), width)); err != nil {
+		return nil, err
+	}
+	buf.Write(escAlignLeft)
+	buf.Write(escFeed)
+	buf.Write(escCut)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,76 @@
+package receipt
+
+import (
+	"bytes"
+	"html/template"
+)
+
+var htmlTemplate = template.Must(template.New("receipt").Funcs(template.FuncMap{
+	"add": func(a, b float64) float64 { return a + b },
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>{{.OrderNo}}</title>
+<style>
+  body { width: 72mm; margin: 0 auto; padding: 4mm 0; font: 12px/1.5 "SimSun", "Songti SC", monospace; }
+  h1 { font-size: 16px; text-align: center; margin: 0; }
+  .center { text-align: center; }
+  hr { border: none; border-top: 1px dashed #000; margin: 4px 0; }
+  table { width: 100%; border-collapse: collapse; }
+  td { padding: 0; vertical-align: top; }
+  td.num { text-align: right; white-space: nowrap; }
+  .sub td { padding-left: 1em; }
+  @media print { body { padding: 0; } }
+</style>
+</head>
+<body>
+<h1>{{.Store.Name}}</h1>
+{{with .Store.Address}}<div class="center">{{.}}</div>{{end}}
+{{with .Store.Phone}}<div class="center">电话: {{.}}</div>{{end}}
+<hr>
+<div>单号: {{.OrderNo}}</div>
+<div>时间: {{.Time.Format "2006-01-02 15:04:05"}}</div>
+<div>收银: {{.Cashier}}</div>
+{{with .Customer}}<div>会员: {{.}}</div>{{end}}
+<hr>
+<table>
+{{range .Lines}}
+<tr><td colspan="2">{{.Name}}{{with .Spec}} {{.}}{{end}}</td></tr>
+<tr class="sub"><td>{{.Quantity}} x {{printf "%.2f" .UnitPrice}}</td><td class="num">{{printf "%.2f" (add .Amount .Discount)}}</td></tr>
+{{if gt .Discount 0.0}}<tr class="sub"><td>优惠</td><td class="num">-{{printf "%.2f" .Discount}}</td></tr>{{end}}
+{{end}}
+</table>
+<hr>
+<table>
+<tr><td>商品合计</td><td class="num">{{printf "%.2f" .Gross}}</td></tr>
+{{if gt .Discount 0.0}}<tr><td>优惠合计</td><td class="num">-{{printf "%.2f" .Discount}}</td></tr>{{end}}
+<tr><td><b>应付</b></td><td class="num"><b>{{printf "%.2f" .Total}}</b></td></tr>
+{{range .Payments}}
+<tr><td>{{.Label}}</td><td class="num">{{printf "%.2f" .Amount}}</td></tr>
+{{if gt .Change 0.0}}<tr class="sub"><td>实收</td><td class="num">{{printf "%.2f" .Tendered}}</td></tr>
+<tr class="sub"><td>找零</td><td class="num">{{printf "%.2f" .Change}}</td></tr>{{end}}
+{{end}}
+</table>
+{{if or .PointsRedeemed .PointsEarned}}
+<hr>
+<table>
+{{if .PointsRedeemed}}<tr><td>积分抵扣</td><td class="num">{{.PointsRedeemed}}分</td></tr>{{end}}
+{{if .PointsEarned}}<tr><td>本次积分</td><td class="num">+{{.PointsEarned}}</td></tr>{{end}}
+</table>
+{{end}}
+<hr>
+<div class="center">{{.Footer}}</div>
+</body>
+</html>
+`))
+
+// HTML renders a receipt as a printable web page sized for an 80mm roll
+func HTML(r *Receipt) ([]byte, error) {
+	var buf bytes.Buffer
+	err := htmlTemplate.Execute(&buf, struct {
+		*Receipt
+		Footer string
+	}{r, footer(r)})
+	return buf.Bytes(), err
+}
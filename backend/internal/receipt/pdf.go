@@ -0,0 +1,68 @@
+package receipt
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf16"
+)
+
+// PDF page geometry in points. The text is set in the Adobe STSong-Light
+// font, which PDF readers supply for Simplified Chinese without embedding;
+// Latin characters are forced to half the width of a Chinese one so the
+// plain-text layout lines up.
+const (
+	pdfFontSize = 8.0
+	pdfLeading  = 11.0
+	pdfMargin   = 16.0
+)
+
+// PDF renders a receipt as a single-page PDF the width of the paper roll,
+// as long as the receipt needs
+func PDF(r *Receipt, width int) []byte {
+	header, body := layout(r, width)
+	lines := make([]string, 0, len(header)+len(body)+1)
+	for _, h := range header {
+		lines = append(lines, center(h, width))
+	}
+	lines = append(lines, body...)
+	lines = append(lines, center(footer(r), width))
+
+	pageWidth := float64(width)*pdfFontSize/2 + 2*pdfMargin
+	pageHeight := float64(len(lines))*pdfLeading + 2*pdfMargin
+
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "BT /F1 %.0f Tf %.0f TL %.2f %.2f Td\n", pdfFontSize, pdfLeading, pdfMargin, pageHeight-pdfMargin)
+	for _, l := range lines {
+		content.WriteString("T* <")
+		for _, u := range utf16.Encode([]rune(l)) {
+			fmt.Fprintf(&content, "%04X", u)
+		}
+		content.WriteString("> Tj\n")
+	}
+	content.WriteString("ET\n")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 5 0 R >> >> /Contents 4 0 R >>", pageWidth, pageHeight),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		"<< /Type /Font /Subtype /Type0 /BaseFont /STSong-Light /Encoding /UniGB-UCS2-H /DescendantFonts [6 0 R] >>",
+		"<< /Type /Font /Subtype /CIDFontType0 /BaseFont /STSong-Light /CIDSystemInfo << /Registry (Adobe) /Ordering (GB1) /Supplement 2 >> /FontDescriptor 7 0 R /DW 1000 /W [1 95 500] >>",
+		"<< /Type /FontDescriptor /FontName /STSong-Light /Flags 6 /FontBBox [-25 -254 1000 880] /ItalicAngle 0 /Ascent 880 /Descent -120 /CapHeight 880 /StemV 93 >>",
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
@@ -0,0 +1,205 @@
+// Package receipt lays out sales receipts for thermal printers (plain text
+// and ESC/POS), the browser (HTML) and PDF.
+package receipt
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Paper widths in characters of the printer's standard font; a Chinese
+// character takes two
+const (
+	Width58 = 32 // 58mm roll
+	Width80 = 48 // 80mm roll
+)
+
+// Store is the shop printed at the top of the receipt
+type Store struct {
+	Name    string
+	Address string
+	Phone   string
+	Footer  string
+}
+
+// Line is one item sold
+type Line struct {
+	Name      string
+	Spec      string
+	Quantity  int
+	UnitPrice float64
+	Discount  float64
+	Amount    float64 // after the discount
+}
+
+// Payment is one tender taken
+type Payment struct {
+	Label    string
+	Amount   float64
+	Tendered float64
+	Change   float64
+}
+
+// Receipt is everything printed for one order
+type Receipt struct {
+	Store          Store
+	OrderNo        string
+	Time           time.Time
+	Cashier        string
+	Customer       string // empty for walk-in sales
+	Lines          []Line
+	Gross          float64 // before discounts
+	Discount       float64
+	Total          float64
+	PointsRedeemed int
+	PointsEarned   int
+	Payments       []Payment
+}
+
+// layout returns the centred header lines and the body lines of a receipt
+func layout(r *Receipt, width int) (header, body []string) {
+	header = append(header, r.Store.Name)
+	if r.Store.Address != "" {
+		header = append(header, wrap(r.Store.Address, width)...)
+	}
+	if r.Store.Phone != "" {
+		header = append(header, "电话: "+r.Store.Phone)
+	}
+
+	rule := strings.Repeat("-", width)
+	body = append(body, rule,
+		"单号: "+r.OrderNo,
+		"时间: "+r.Time.Format("2006-01-02 15:04:05"),
+		"收银: "+r.Cashier)
+	if r.Customer != "" {
+		body = append(body, "会员: "+r.Customer)
+	}
+	body = append(body, rule)
+
+	for _, l := range r.Lines {
+		name := l.Name
+		if l.Spec != "" {
+			name += " " + l.Spec
+		}
+		body = append(body, wrap(name, width)...)
+		// Quantity x price is followed by the gross amount, the discount by its own line
+		body = append(body, justify(fmt.Sprintf("  %d x %.2f", l.Quantity, l.UnitPrice), fmt.Sprintf("%.2f", l.Amount+l.Discount), width))
+		if l.Discount > 0 {
+			body = append(body, justify("  优惠", fmt.Sprintf("-%.2f", l.Discount), width))
+		}
+	}
+	body = append(body, rule,
+		justify("商品合计", fmt.Sprintf("%.2f", r.Gross), width))
+	if r.Discount > 0 {
+		body = append(body, justify("优惠合计", fmt.Sprintf("-%.2f", r.Discount), width))
+	}
+	body = append(body, justify("应付", fmt.Sprintf("%.2f", r.Total), width))
+	for _, p := range r.Payments {
+		body = append(body, justify(p.Label, fmt.Sprintf("%.2f", p.Amount), width))
+		if p.Change > 0 {
+			body = append(body,
+				justify("  实收", fmt.Sprintf("%.2f", p.Tendered), width),
+				justify("  找零", fmt.Sprintf("%.2f", p.Change), width))
+		}
+	}
+	if r.PointsRedeemed > 0 || r.PointsEarned > 0 {
+		body = append(body, rule)
+		if r.PointsRedeemed > 0 {
+			body = append(body, justify("积分抵扣", fmt.Sprintf("%d分", r.PointsRedeemed), width))
+		}
+		if r.PointsEarned > 0 {
+			body = append(body, justify("本次积分", fmt.Sprintf("+%d", r.PointsEarned), width))
+		}
+	}
+	body = append(body, rule)
+	return header, body
+}
+
+// footer is the closing line, centred
+func footer(r *Receipt) string {
+	if r.Store.Footer != "" {
+		return r.Store.Footer
+	}
+	return "谢谢惠顾，请保留小票"
+}
+
+// Text renders a receipt as plain text for a paper roll width characters wide
+func Text(r *Receipt, width int) string {
+	header, body := layout(r, width)
+	var b strings.Builder
+	for _, h := range header {
+		b.WriteString(center(h, width))
+		b.WriteByte('\n')
+	}
+	for _, l := range body {
+		b.WriteString(l)
+		b.WriteByte('\n')
+	}
+	b.WriteString(center(footer(r), width))
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// runeWidth is the printed width of a rune: 2 for East Asian wide characters
+func runeWidth(r rune) int {
+	switch {
+	case r >= 0x1100 && r <= 0x115F,
+		r >= 0x2E80 && r <= 0xA4CF,
+		r >= 0xAC00 && r <= 0xD7A3,
+		r >= 0xF900 && r <= 0xFAFF,
+		r >= 0xFE30 && r <= 0xFE4F,
+		r >= 0xFF00 && r <= 0xFF60,
+		r >= 0xFFE0 && r <= 0xFFE6:
+		return 2
+	}
+	return 1
+}
+
+// textWidth is the printed width of a string
+func textWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		w += runeWidth(r)
+	}
+	return w
+}
+
+// truncate cuts s to at most width columns
+func truncate(s string, width int) string {
+	w := 0
+	for i, r := range s {
+		if w+runeWidth(r) > width {
+			return s[:i]
+		}
+		w += runeWidth(r)
+	}
+	return s
+}
+
+// wrap breaks s into lines of at most width columns
+func wrap(s string, width int) []string {
+	var lines []string
+	for textWidth(s) > width {
+		head := truncate(s, width)
+		lines = append(lines, head)
+		s = s[len(head):]
+	}
+	return append(lines, s)
+}
+
+// justify puts left and right at the two ends of a line, shortening left
+// when both do not fit
+func justify(left, right string, width int) string {
+	room := width - textWidth(right) - 1
+	if textWidth(left) > room {
+		left = truncate(left, room)
+	}
+	return left + strings.Repeat(" ", width-textWidth(left)-textWidth(right)) + right
+}
+
+// center pads s to sit in the middle of a line
+func center(s string, width int) string {
+	s = truncate(s, width)
+	return strings.Repeat(" ", (width-textWidth(s))/2) + s
+}
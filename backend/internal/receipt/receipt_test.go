@@ -0,0 +1,141 @@
+package receipt
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden receipts in testdata")
+
+func TestTextWidthHelpers(t *testing.T) {
+	for _, width := range []int{Width58, Width80} {
+		tests := []struct {
+			left, right string
+		}{
+			{"阿莫西林胶囊 0.25g*24粒", "18.50"},
+			{"  2 x 9.25", "18.50"},
+			{"Vitamin C 维生素C泡腾片 10片/支 x 3 盒装 (橙味) 特惠", "-12.00"},
+			{"积分抵扣", "300分"},
+		}
+		for _, tt := range tests {
+			line := justify(tt.left, tt.right, width)
+			if got := textWidth(line); got != width {
+				t.Errorf("width %d: justify(%q, %q) is %d columns wide: %q", width, tt.left, tt.right, got, line)
+			}
+			if !strings.HasSuffix(line, tt.right) {
+				t.Errorf("width %d: justify(%q, %q) lost the amount: %q", width, tt.left, tt.right, line)
+			}
+		}
+
+		name := "复方甘草片 Compound Liquorice Tablets 100片/瓶 国药准字H12345678 天津"
+		lines := wrap(name, width)
+		if strings.Join(lines, "") != name {
+			t.Errorf("width %d: wrap lost text: %q", width, lines)
+		}
+		for _, l := range lines[:len(lines)-1] {
+			// A line is only cut short when the next character is wide
+			if w := textWidth(l); w != width && w != width-1 {
+				t.Errorf("width %d: wrapped line %q is %d columns", width, l, w)
+			}
+		}
+		if w := textWidth(lines[len(lines)-1]); w > width {
+			t.Errorf("width %d: last line %q is %d columns", width, lines[len(lines)-1], w)
+		}
+	}
+
+	tests := []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"abc", 5, "abc"},
+		{"维生素C", 5, "维生"},
+		{"维生素C", 7, "维生素C"},
+		{"a维生素", 4, "a维"},
+		{"a维生素", 3, "a维"},
+		{"维生素", 1, ""},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.s, tt.width); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.want)
+		}
+	}
+}
+
+func sampleReceipt() *Receipt {
+	return &Receipt{
+		Store: Store{
+			Name:    "康源医药",
+			Address: "北京市海淀区学院路 37 号康源大厦一层",
+			Phone:   "010-62345678",
+		},
+		OrderNo:  "ORD202405201030001",
+		Time:     time.Date(2024, 5, 20, 10, 30, 0, 0, time.Local),
+		Cashier:  "张敏",
+		Customer: "李华",
+		Lines: []Line{
+			{Name: "阿莫西林胶囊", Spec: "0.25g*24粒", Quantity: 2, UnitPrice: 18.5, Amount: 37},
+			{Name: "维生素C泡腾片", Spec: "1g*10片", Quantity: 3, UnitPrice: 12, Discount: 4, Amount: 32},
+		},
+		Gross:          73,
+		Discount:       4,
+		Total:          66,
+		PointsRedeemed: 300,
+		PointsEarned:   66,
+		Payments: []Payment{
+			{Label: "积分", Amount: 3, Tendered: 3},
+			{Label: "现金", Amount: 63, Tendered: 100, Change: 37},
+		},
+	}
+}
+
+func TestTextGolden(t *testing.T) {
+	for _, tt := range []struct {
+		file  string
+		width int
+	}{
+		{"receipt58.golden", Width58},
+		{"receipt80.golden", Width80},
+	} {
+		got := Text(sampleReceipt(), tt.width)
+		path := filepath.Join("testdata", tt.file)
+		if *update {
+			if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s differs, got:\n%s", tt.file, got)
+		}
+		for _, l := range strings.Split(strings.TrimSuffix(got, "\n"), "\n") {
+			if textWidth(l) > tt.width {
+				t.Errorf("%s: line %q is wider than %d", tt.file, l, tt.width)
+			}
+		}
+	}
+}
+
+func TestESCPOSFraming(t *testing.T) {
+	out, err := ESCPOS(sampleReceipt(), Width58)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out, escInit) {
+		t.Fatalf("output should start with ESC @, got % x", out[:4])
+	}
+	if !bytes.HasSuffix(out, escCut) {
+		t.Fatalf("output should end with the cut command, got % x", out[len(out)-4:])
+	}
+	if !bytes.HasSuffix(out[:len(out)-len(escCut)], escFeed) {
+		t.Fatal("paper should be fed past the cutter before the cut")
+	}
+}
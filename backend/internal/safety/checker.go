@@ -0,0 +1,96 @@
+// Package safety screens a sales basket for drug interactions, allergies and
+// contraindicated chronic conditions before it is sold.
+package safety
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Warning kinds
+const (
+	KindInteraction = "interaction"
+	KindAllergy     = "allergy"
+	KindCondition   = "condition"
+)
+
+// Severities, mildest first
+const (
+	SeverityMinor           = "minor"
+	SeverityModerate        = "moderate"
+	SeverityMajor           = "major"
+	SeverityContraindicated = "contraindicated"
+)
+
+// Item is a medicine in the basket, or one the customer bought recently
+type Item struct {
+	MedicineID  int64      `json:"medicine_id"`
+	Code        string     `json:"code"`
+	Name        string     `json:"name"`
+	Ingredients []string   `json:"ingredients"`
+	SoldAt      *time.Time `json:"sold_at,omitempty"` // set for recent purchases
+}
+
+// Profile is the part of a customer's health profile the checks use
+type Profile struct {
+	Allergies  []string
+	Conditions []string
+}
+
+// Warning is one finding a pharmacist must acknowledge
+type Warning struct {
+	Kind        string   `json:"kind"`
+	Severity    string   `json:"severity"`
+	MedicineIDs []int64  `json:"medicine_ids"`
+	Subjects    []string `json:"subjects"` // the rule terms that matched
+	Message     string   `json:"message"`
+}
+
+// Checker screens a basket. recent holds the customer's purchases within the
+// look-back window; interactions between two recent items are not reported.
+type Checker interface {
+	Check(basket, recent []Item, profile Profile) []Warning
+}
+
+var (
+	current Checker = &RuleSet{}
+	mu      sync.RWMutex
+)
+
+// Use replaces the active checker
+func Use(c Checker) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = c
+}
+
+// Current returns the active checker
+func Current() Checker {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// SplitTerms splits a free-text list such as "青霉素, 磺胺、阿司匹林" into terms
+func SplitTerms(s string) []string {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		switch r {
+		case ',', '，', '、', ';', '；', '\n':
+			return true
+		}
+		return false
+	})
+	terms := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f = strings.TrimSpace(f); f != "" {
+			terms = append(terms, f)
+		}
+	}
+	return terms
+}
+
+// normalize folds a term for comparison
+func normalize(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
@@ -0,0 +1,180 @@
+package safety
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// RuleSet is a Checker driven by a local JSON rule file. Rule subjects match
+// a medicine by code, name or active ingredient, case-insensitively.
+type RuleSet struct {
+	Interactions      []InteractionRule `json:"interactions"`
+	Contraindications []ConditionRule   `json:"contraindications"`
+	// AllergyGroups maps an allergen to the ingredients that cross-react
+	// with it, e.g. 青霉素 -> 阿莫西林, 氨苄西林
+	AllergyGroups map[string][]string `json:"allergy_groups"`
+}
+
+// InteractionRule flags two subjects that should not be taken together
+type InteractionRule struct {
+	A           string `json:"a"`
+	B           string `json:"b"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+}
+
+// ConditionRule flags a subject that is contraindicated for a chronic condition
+type ConditionRule struct {
+	Condition   string `json:"condition"`
+	Subject     string `json:"subject"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+}
+
+// LoadRules reads a rule file
+func LoadRules(path string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules RuleSet
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i, r := range rules.Interactions {
+		if r.A == "" || r.B == "" {
+			return nil, fmt.Errorf("%s: interaction %d needs both a and b", path, i+1)
+		}
+	}
+	for i, r := range rules.Contraindications {
+		if r.Condition == "" || r.Subject == "" {
+			return nil, fmt.Errorf("%s: contraindication %d needs condition and subject", path, i+1)
+		}
+	}
+	return &rules, nil
+}
+
+// matches reports whether a rule subject names the item
+func matches(subject string, item *Item) bool {
+	s := normalize(subject)
+	if s == normalize(item.Code) || s == normalize(item.Name) {
+		return true
+	}
+	for _, ing := range item.Ingredients {
+		if s == normalize(ing) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check implements Checker
+func (r *RuleSet) Check(basket, recent []Item, profile Profile) []Warning {
+	warnings := make([]Warning, 0)
+
+	// Interactions within the basket and between the basket and recent purchases
+	for _, rule := range r.Interactions {
+		for i := range basket {
+			for j := i + 1; j < len(basket); j++ {
+				if w, ok := interaction(rule, &basket[i], &basket[j]); ok {
+					warnings = append(warnings, w)
+				}
+			}
+			for j := range recent {
+				if recent[j].MedicineID == basket[i].MedicineID {
+					continue
+				}
+				if w, ok := interaction(rule, &basket[i], &recent[j]); ok {
+					if recent[j].SoldAt != nil {
+						w.Message += fmt.Sprintf("（客户已于 %s 购买 %s）", recent[j].SoldAt.Format("2006-01-02"), recent[j].Name)
+					}
+					warnings = append(warnings, w)
+				}
+			}
+		}
+	}
+
+	// Allergies, including cross-reacting ingredients
+	for _, allergen := range profile.Allergies {
+		for i := range basket {
+			if r.allergic(allergen, &basket[i]) {
+				warnings = append(warnings, Warning{
+					Kind:        KindAllergy,
+					Severity:    SeverityContraindicated,
+					MedicineIDs: []int64{basket[i].MedicineID},
+					Subjects:    []string{allergen},
+					Message:     fmt.Sprintf("客户对 %s 过敏，%s 含有或可能交叉过敏", allergen, basket[i].Name),
+				})
+			}
+		}
+	}
+
+	// Chronic conditions
+	for _, rule := range r.Contraindications {
+		if !hasTerm(profile.Conditions, rule.Condition) {
+			continue
+		}
+		for i := range basket {
+			if matches(rule.Subject, &basket[i]) {
+				warnings = append(warnings, Warning{
+					Kind:        KindCondition,
+					Severity:    severityOr(rule.Severity, SeverityMajor),
+					MedicineIDs: []int64{basket[i].MedicineID},
+					Subjects:    []string{rule.Condition, rule.Subject},
+					Message:     fmt.Sprintf("客户患有 %s，慎用 %s：%s", rule.Condition, basket[i].Name, rule.Description),
+				})
+			}
+		}
+	}
+	return warnings
+}
+
+// interaction applies one rule to a pair of items in either order
+func interaction(rule InteractionRule, x, y *Item) (Warning, bool) {
+	if !(matches(rule.A, x) && matches(rule.B, y)) && !(matches(rule.B, x) && matches(rule.A, y)) {
+		return Warning{}, false
+	}
+	return Warning{
+		Kind:        KindInteraction,
+		Severity:    severityOr(rule.Severity, SeverityModerate),
+		MedicineIDs: []int64{x.MedicineID, y.MedicineID},
+		Subjects:    []string{rule.A, rule.B},
+		Message:     fmt.Sprintf("%s 与 %s 存在相互作用：%s", x.Name, y.Name, rule.Description),
+	}, true
+}
+
+// allergic reports whether an item contains the allergen or a cross-reacting ingredient
+func (r *RuleSet) allergic(allergen string, item *Item) bool {
+	if strings.Contains(normalize(item.Name), normalize(allergen)) || matches(allergen, item) {
+		return true
+	}
+	for k, group := range r.AllergyGroups {
+		if normalize(k) != normalize(allergen) {
+			continue
+		}
+		for _, term := range group {
+			if matches(term, item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasTerm(terms []string, want string) bool {
+	for _, t := range terms {
+		if normalize(t) == normalize(want) {
+			return true
+		}
+	}
+	return false
+}
+
+func severityOr(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
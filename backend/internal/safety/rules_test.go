@@ -0,0 +1,196 @@
+package safety
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRules() *RuleSet {
+	return &RuleSet{
+		Interactions: []InteractionRule{
+			{A: "华法林", B: "阿司匹林", Severity: SeverityMajor, Description: "增加出血风险"},
+			// Duplicate therapy: two products with the same ingredient
+			{A: "对乙酰氨基酚", B: "对乙酰氨基酚", Description: "重复用药，注意每日总剂量"},
+		},
+		Contraindications: []ConditionRule{
+			{Condition: "哮喘", Subject: "普萘洛尔", Description: "可诱发支气管痉挛"},
+		},
+		AllergyGroups: map[string][]string{
+			"青霉素": {"阿莫西林", "氨苄西林"},
+		},
+	}
+}
+
+var (
+	warfarin    = Item{MedicineID: 1, Code: "W001", Name: "华法林钠片", Ingredients: []string{"华法林"}}
+	aspirin     = Item{MedicineID: 2, Code: "A001", Name: "阿司匹林肠溶片", Ingredients: []string{"阿司匹林"}}
+	tylenol     = Item{MedicineID: 3, Code: "T001", Name: "泰诺", Ingredients: []string{"对乙酰氨基酚", "伪麻黄碱"}}
+	panadol     = Item{MedicineID: 4, Code: "P001", Name: "必理通", Ingredients: []string{"对乙酰氨基酚"}}
+	amoxicillin = Item{MedicineID: 5, Code: "AMX01", Name: "阿莫西林胶囊", Ingredients: []string{"阿莫西林"}}
+	propranolol = Item{MedicineID: 6, Code: "PR01", Name: "盐酸普萘洛尔片", Ingredients: []string{"普萘洛尔"}}
+)
+
+// bought returns an item as a recent purchase
+func bought(item Item, daysAgo int) Item {
+	at := time.Date(2024, 5, 20, 10, 0, 0, 0, time.Local).AddDate(0, 0, -daysAgo)
+	item.SoldAt = &at
+	return item
+}
+
+func TestRuleSetCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		basket  []Item
+		recent  []Item
+		profile Profile
+		// want lists the expected warnings as kind:severity, in order
+		want    []string
+		wantIDs [][]int64
+		wantMsg string
+	}{
+		{
+			name:    "interaction between two basket items",
+			basket:  []Item{aspirin, warfarin},
+			want:    []string{"interaction:major"},
+			wantIDs: [][]int64{{2, 1}},
+			wantMsg: "增加出血风险",
+		},
+		{
+			name:    "interaction with a recent purchase",
+			basket:  []Item{aspirin},
+			recent:  []Item{bought(warfarin, 3)},
+			want:    []string{"interaction:major"},
+			wantIDs: [][]int64{{2, 1}},
+			wantMsg: "客户已于 2024-05-17 购买 华法林钠片",
+		},
+		{
+			name:   "no interaction between two recent purchases",
+			basket: []Item{amoxicillin},
+			recent: []Item{bought(warfarin, 3), bought(aspirin, 5)},
+		},
+		{
+			name:   "same medicine repeated in the history",
+			basket: []Item{tylenol},
+			recent: []Item{bought(tylenol, 2), bought(tylenol, 9)},
+		},
+		{
+			name:    "same ingredient in another product",
+			basket:  []Item{tylenol},
+			recent:  []Item{bought(tylenol, 2), bought(panadol, 4)},
+			want:    []string{"interaction:moderate"},
+			wantIDs: [][]int64{{3, 4}},
+		},
+		{
+			name:    "allergy cross-reaction through allergy_groups",
+			basket:  []Item{warfarin, amoxicillin},
+			profile: Profile{Allergies: []string{"青霉素"}},
+			want:    []string{"allergy:contraindicated"},
+			wantIDs: [][]int64{{5}},
+			wantMsg: "客户对 青霉素 过敏",
+		},
+		{
+			name:    "allergy to the ingredient itself",
+			basket:  []Item{aspirin},
+			profile: Profile{Allergies: []string{"阿司匹林"}},
+			want:    []string{"allergy:contraindicated"},
+			wantIDs: [][]int64{{2}},
+		},
+		{
+			name:    "condition contraindication",
+			basket:  []Item{propranolol, aspirin},
+			profile: Profile{Conditions: []string{"高血压", "哮喘"}},
+			want:    []string{"condition:major"},
+			wantIDs: [][]int64{{6}},
+			wantMsg: "客户患有 哮喘",
+		},
+		{
+			name:    "condition the customer does not have",
+			basket:  []Item{propranolol},
+			profile: Profile{Conditions: []string{"高血压"}},
+		},
+	}
+
+	rules := testRules()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings := rules.Check(tt.basket, tt.recent, tt.profile)
+			if len(warnings) != len(tt.want) {
+				t.Fatalf("got %d warnings %+v, want %v", len(warnings), warnings, tt.want)
+			}
+			for i, w := range warnings {
+				if got := w.Kind + ":" + w.Severity; got != tt.want[i] {
+					t.Errorf("warning %d: got %s, want %s", i, got, tt.want[i])
+				}
+				if !equalIDs(w.MedicineIDs, tt.wantIDs[i]) {
+					t.Errorf("warning %d: got medicines %v, want %v", i, w.MedicineIDs, tt.wantIDs[i])
+				}
+			}
+			if tt.wantMsg != "" && !strings.Contains(warnings[0].Message, tt.wantMsg) {
+				t.Errorf("got message %q, want it to mention %q", warnings[0].Message, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func equalIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLoadRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr string
+	}{
+		{
+			name: "complete rules",
+			json: `{"interactions": [{"a": "华法林", "b": "阿司匹林"}],
+				"contraindications": [{"condition": "哮喘", "subject": "普萘洛尔"}]}`,
+		},
+		{
+			name:    "interaction without b",
+			json:    `{"interactions": [{"a": "华法林", "b": "阿司匹林"}, {"a": "华法林"}]}`,
+			wantErr: "interaction 2 needs both a and b",
+		},
+		{
+			name:    "contraindication without condition",
+			json:    `{"contraindications": [{"subject": "普萘洛尔"}]}`,
+			wantErr: "contraindication 1 needs condition and subject",
+		},
+		{
+			name:    "invalid json",
+			json:    `{"interactions": [`,
+			wantErr: "parse",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.json")
+			if err := os.WriteFile(path, []byte(tt.json), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			rules, err := LoadRules(path)
+			if tt.wantErr == "" {
+				if err != nil || rules == nil {
+					t.Fatalf("got %v, want the rules loaded", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,6 +3,22 @@
 
 -- ==================== 视图 ====================
 
+-- 函数：药品的有效补货点（视图依赖，需先创建）
+-- 未单独设置补货点时取系统默认值（后端启动时由 config.json 同步到 settings 表），
+-- 且补货点不低于该药品的安全库存
+DROP FUNCTION IF EXISTS fn_reorder_point;
+DELIMITER //
+CREATE FUNCTION fn_reorder_point(min_stock INT, reorder_point INT)
+RETURNS INT
+READS SQL DATA
+BEGIN
+    DECLARE default_point INT;
+    SELECT CAST(value AS SIGNED) INTO default_point
+    FROM settings WHERE name = 'default_reorder_point';
+    RETURN GREATEST(COALESCE(min_stock, 0), COALESCE(reorder_point, default_point, 50));
+END //
+DELIMITER ;
+
 -- 药品库存视图：显示药品详细信息和库存状态
 CREATE OR REPLACE VIEW v_medicine_inventory AS
 SELECT 
@@ -15,14 +31,48 @@ SELECT
     m.stock,
     m.manufacturer,
     m.status,
+    m.min_stock,
+    m.max_stock,
+    fn_reorder_point(m.min_stock, m.reorder_point) AS reorder_point,
     CASE 
         WHEN m.stock = 0 THEN '缺货'
-        WHEN m.stock < 50 THEN '库存不足'
+        WHEN m.stock < fn_reorder_point(m.min_stock, m.reorder_point) THEN '库存不足'
+        WHEN m.max_stock IS NOT NULL AND m.stock > m.max_stock THEN '库存积压'
         ELSE '库存充足'
     END AS stock_status,
     m.price * m.stock AS stock_value
 FROM medicines m;
 
+-- 批次库存视图：每个在库批次的剩余数量、成本与效期状态
+CREATE OR REPLACE VIEW v_lot_inventory AS
+SELECT 
+    l.id AS lot_id,
+    l.medicine_id,
+    m.code AS medicine_code,
+    m.name AS medicine_name,
+    l.inbound_id,
+    i.supplier_id,
+    sup.name AS supplier_name,
+    l.lot_no,
+    l.production_date,
+    l.expiry_date,
+    l.remaining,
+    l.unit_cost,
+    l.remaining * l.unit_cost AS cost_value,
+    DATEDIFF(l.expiry_date, CURDATE()) AS days_to_expiry,
+    CASE 
+        WHEN l.expiry_date IS NULL THEN 'no_expiry'
+        WHEN l.expiry_date < CURDATE() THEN 'expired'
+        WHEN l.expiry_date <= DATE_ADD(CURDATE(), INTERVAL 90 DAY) THEN 'near_expiry'
+        ELSE 'normal'
+    END AS lot_state,
+    l.status AS lot_status
+FROM stock_lots l
+JOIN medicines m ON l.medicine_id = m.id
+LEFT JOIN inbounds i ON l.inbound_id = i.id
+LEFT JOIN suppliers sup ON i.supplier_id = sup.id
+WHERE l.remaining > 0;
+
 -- 销售汇总视图：按药品统计销售情况
 CREATE OR REPLACE VIEW v_sales_summary AS
 SELECT 
@@ -34,21 +84,31 @@ SELECT
     SUM(s.total_price) AS total_revenue,
     AVG(s.total_price) AS avg_order_value
 FROM medicines m
-LEFT JOIN sales s ON m.id = s.medicine_id
+LEFT JOIN sales s ON m.id = s.medicine_id AND s.status <> 'voided'
 GROUP BY m.id, m.name, m.code;
 
--- 入库汇总视图：按供应商统计入库情况
+-- 入库汇总视图：按供应商统计入库情况（扣除退货后的净采购额）
 CREATE OR REPLACE VIEW v_inbound_summary AS
 SELECT 
     sup.id AS supplier_id,
     sup.name AS supplier_name,
     sup.contact,
-    COUNT(i.id) AS inbound_count,
-    SUM(i.quantity) AS total_quantity,
-    SUM(i.price * i.quantity) AS total_cost
+    COALESCE(inb.inbound_count, 0) AS inbound_count,
+    COALESCE(inb.total_quantity, 0) AS total_quantity,
+    COALESCE(inb.total_cost, 0) AS total_cost,
+    COALESCE(ret.returned_quantity, 0) AS returned_quantity,
+    COALESCE(ret.credit_amount, 0) AS credit_amount,
+    COALESCE(inb.total_quantity, 0) - COALESCE(ret.returned_quantity, 0) AS net_quantity,
+    COALESCE(inb.total_cost, 0) - COALESCE(ret.credit_amount, 0) AS net_cost
 FROM suppliers sup
-LEFT JOIN inbounds i ON sup.id = i.supplier_id
-GROUP BY sup.id, sup.name, sup.contact;
+LEFT JOIN (
+    SELECT supplier_id, COUNT(*) AS inbound_count, SUM(quantity) AS total_quantity, SUM(price * quantity) AS total_cost
+    FROM inbounds GROUP BY supplier_id
+) inb ON inb.supplier_id = sup.id
+LEFT JOIN (
+    SELECT supplier_id, SUM(quantity) AS returned_quantity, SUM(credit_amount) AS credit_amount
+    FROM purchase_returns GROUP BY supplier_id
+) ret ON ret.supplier_id = sup.id;
 
 -- 客户消费视图：按客户统计消费情况
 CREATE OR REPLACE VIEW v_customer_purchases AS
@@ -56,21 +116,22 @@ SELECT
     c.id AS customer_id,
     c.name AS customer_name,
     c.phone,
-    COUNT(s.id) AS order_count,
+    COUNT(DISTINCT s.order_id) AS order_count,
     SUM(s.quantity) AS total_quantity,
     SUM(s.total_price) AS total_spent
 FROM customers c
-LEFT JOIN sales s ON c.id = s.customer_id
+LEFT JOIN sales s ON c.id = s.customer_id AND s.status <> 'voided'
 GROUP BY c.id, c.name, c.phone;
 
 -- 每日销售报表视图
 CREATE OR REPLACE VIEW v_daily_sales AS
 SELECT 
     DATE(sale_date) AS sale_day,
-    COUNT(*) AS order_count,
+    COUNT(DISTINCT order_id) AS order_count,
     SUM(quantity) AS total_quantity,
     SUM(total_price) AS total_revenue
 FROM sales
+WHERE status <> 'voided'
 GROUP BY DATE(sale_date)
 ORDER BY sale_day DESC;
 
@@ -86,13 +147,14 @@ CREATE PROCEDURE sp_search_medicines(
     IN offset_num INT
 )
 BEGIN
-    SELECT * FROM medicines 
+    SELECT *, fn_reorder_point(min_stock, reorder_point) AS effective_reorder_point
+    FROM medicines 
     WHERE (name LIKE CONCAT('%', keyword, '%') 
        OR code LIKE CONCAT('%', keyword, '%')
        OR manufacturer LIKE CONCAT('%', keyword, '%'))
        AND (
            filter_status = 'all' 
-           OR (filter_status = 'low_stock' AND stock < 50)
+           OR (filter_status = 'low_stock' AND stock < fn_reorder_point(min_stock, reorder_point))
            OR (filter_status = 'out_of_stock' AND stock = 0)
        )
     ORDER BY id DESC
@@ -114,7 +176,7 @@ BEGIN
        OR manufacturer LIKE CONCAT('%', keyword, '%'))
        AND (
            filter_status = 'all' 
-           OR (filter_status = 'low_stock' AND stock < 50)
+           OR (filter_status = 'low_stock' AND stock < fn_reorder_point(min_stock, reorder_point))
            OR (filter_status = 'out_of_stock' AND stock = 0)
        );
 END //
@@ -198,11 +260,13 @@ CREATE PROCEDURE sp_search_sales(
     IN offset_num INT
 )
 BEGIN
+    -- 已作废的销售仍然列出（status = voided），void_pending 标出待审批的作废申请
     SELECT 
         s.*,
         m.name AS medicine_name,
         m.type AS medicine_type,
-        c.name AS customer_name
+        c.name AS customer_name,
+        EXISTS (SELECT 1 FROM sale_voids v WHERE v.sale_id = s.id AND v.status = 'pending') AS void_pending
     FROM sales s
     LEFT JOIN medicines m ON s.medicine_id = m.id
     LEFT JOIN customers c ON s.customer_id = c.id
@@ -276,19 +340,44 @@ DROP PROCEDURE IF EXISTS sp_sales_report;
 DELIMITER //
 CREATE PROCEDURE sp_sales_report(IN start_date DATE, IN end_date DATE)
 BEGIN
-    SELECT 
-        s.id,
-        s.order_id,
-        m.name AS medicine_name,
-        c.name AS customer_name,
-        s.quantity,
-        s.total_price,
-        s.sale_date
-    FROM sales s
-    LEFT JOIN medicines m ON s.medicine_id = m.id
-    LEFT JOIN customers c ON s.customer_id = c.id
-    WHERE DATE(s.sale_date) BETWEEN start_date AND end_date
-    ORDER BY s.sale_date DESC;
+    -- 销售明细与退货（退货以负数量、负金额列出）
+    SELECT * FROM (
+        SELECT 
+            s.id,
+            s.order_id,
+            m.name AS medicine_name,
+            c.name AS customer_name,
+            s.quantity,
+            s.total_price,
+            s.cost_amount,
+            s.sale_date,
+            'sale' AS line_type,
+            NULL AS sale_id,
+            NULL AS reason
+        FROM sales s
+        LEFT JOIN medicines m ON s.medicine_id = m.id
+        LEFT JOIN customers c ON s.customer_id = c.id
+        WHERE DATE(s.sale_date) BETWEEN start_date AND end_date
+          AND s.status <> 'voided'
+        UNION ALL
+        SELECT 
+            r.id,
+            r.order_id,
+            m.name AS medicine_name,
+            c.name AS customer_name,
+            -r.quantity,
+            -r.refund_amount,
+            -r.cost_amount,
+            r.created_at,
+            'return' AS line_type,
+            r.sale_id,
+            r.reason
+        FROM sales_returns r
+        LEFT JOIN medicines m ON r.medicine_id = m.id
+        LEFT JOIN customers c ON r.customer_id = c.id
+        WHERE DATE(r.created_at) BETWEEN start_date AND end_date
+    ) report
+    ORDER BY sale_date DESC;
 END //
 DELIMITER ;
 
@@ -297,19 +386,41 @@ DROP PROCEDURE IF EXISTS sp_inbound_report;
 DELIMITER //
 CREATE PROCEDURE sp_inbound_report(IN start_date DATE, IN end_date DATE)
 BEGIN
-    SELECT 
-        i.id,
-        m.name AS medicine_name,
-        sup.name AS supplier_name,
-        i.quantity,
-        i.price,
-        i.price * i.quantity AS total_cost,
-        i.inbound_date
-    FROM inbounds i
-    LEFT JOIN medicines m ON i.medicine_id = m.id
-    LEFT JOIN suppliers sup ON i.supplier_id = sup.id
-    WHERE DATE(i.inbound_date) BETWEEN start_date AND end_date
-    ORDER BY i.inbound_date DESC;
+    -- 入库明细与采购退货（退货以负数量、负金额列出）
+    SELECT * FROM (
+        SELECT 
+            i.id,
+            m.name AS medicine_name,
+            sup.name AS supplier_name,
+            i.quantity,
+            i.price,
+            i.price * i.quantity AS total_cost,
+            i.inbound_date,
+            'inbound' AS line_type,
+            NULL AS inbound_id,
+            NULL AS reason_code
+        FROM inbounds i
+        LEFT JOIN medicines m ON i.medicine_id = m.id
+        LEFT JOIN suppliers sup ON i.supplier_id = sup.id
+        WHERE DATE(i.inbound_date) BETWEEN start_date AND end_date
+        UNION ALL
+        SELECT 
+            r.id,
+            m.name AS medicine_name,
+            sup.name AS supplier_name,
+            -r.quantity,
+            r.unit_price,
+            -r.credit_amount,
+            r.created_at,
+            'return' AS line_type,
+            r.inbound_id,
+            r.reason_code
+        FROM purchase_returns r
+        LEFT JOIN medicines m ON r.medicine_id = m.id
+        LEFT JOIN suppliers sup ON r.supplier_id = sup.id
+        WHERE DATE(r.created_at) BETWEEN start_date AND end_date
+    ) report
+    ORDER BY inbound_date DESC;
 END //
 DELIMITER ;
 
@@ -319,30 +430,35 @@ DELIMITER //
 CREATE PROCEDURE sp_financial_stats(IN report_type VARCHAR(10))
 BEGIN
     DECLARE start_dt DATETIME;
+    DECLARE gross_sales DECIMAL(12, 2);
+    DECLARE refunds DECIMAL(12, 2);
+    DECLARE sold_cost DECIMAL(12, 2);
+    DECLARE returned_cost DECIMAL(12, 2);
     
     IF report_type = 'daily' THEN
         SET start_dt = CURDATE();
     ELSE
         SET start_dt = DATE_FORMAT(NOW(), '%Y-%m-01');
     END IF;
+
+    -- 已作废的销售不计收入与成本
+    SELECT COALESCE(SUM(total_price), 0) INTO gross_sales FROM sales WHERE sale_date >= start_dt AND status <> 'voided';
+    SELECT COALESCE(SUM(refund_amount), 0) INTO refunds FROM sales_returns WHERE created_at >= start_dt;
+
+    -- 销售成本取销售时记录的成本 (cost_amount)
+    SELECT COALESCE(SUM(cost_amount), 0) INTO sold_cost FROM sales WHERE sale_date >= start_dt AND status <> 'voided';
+
+    -- 退货冲减收入的同时冲回成本
+    SELECT COALESCE(SUM(cost_amount), 0) INTO returned_cost FROM sales_returns WHERE created_at >= start_dt;
     
     SELECT 
         report_type AS period_type,
         start_dt AS period_start,
-        (SELECT COALESCE(SUM(total_price), 0) FROM sales WHERE sale_date >= start_dt) AS sales_income,
-        (SELECT COALESCE(SUM(price * quantity), 0) FROM inbounds WHERE inbound_date >= start_dt) AS purchase_cost,
-        (
-            (SELECT COALESCE(SUM(total_price), 0) FROM sales WHERE sale_date >= start_dt) - 
-            (
-                SELECT COALESCE(SUM(s.quantity * IFNULL(avg_cost.avg_price, (m.price * 0.6))), 0)
-                FROM sales s
-                JOIN medicines m ON s.medicine_id = m.id
-                LEFT JOIN (
-                    SELECT medicine_id, AVG(price) as avg_price FROM inbounds GROUP BY medicine_id
-                ) avg_cost ON s.medicine_id = avg_cost.medicine_id
-                WHERE s.sale_date >= start_dt
-            )
-        ) AS gross_profit;
+        gross_sales - refunds AS sales_income,
+        refunds AS refund_amount,
+        (SELECT COALESCE(SUM(price * quantity), 0) FROM inbounds WHERE inbound_date >= start_dt) -
+            (SELECT COALESCE(SUM(credit_amount), 0) FROM purchase_returns WHERE created_at >= start_dt) AS purchase_cost,
+        (gross_sales - refunds) - (sold_cost - returned_cost) AS gross_profit;
 END //
 DELIMITER ;
 
@@ -355,6 +471,9 @@ CREATE TRIGGER tr_after_sale_insert
 AFTER INSERT ON sales
 FOR EACH ROW
 BEGIN
+    -- 库存流水来源（调用方已设置时以调用方为准）
+    SET @stock_ref_type = COALESCE(@stock_ref_type, 'sale'),
+        @stock_ref_id = COALESCE(@stock_ref_id, NEW.id);
     UPDATE medicines 
     SET stock = stock - NEW.quantity 
     WHERE id = NEW.medicine_id;
@@ -368,8 +487,12 @@ CREATE TRIGGER tr_after_inbound_insert
 AFTER INSERT ON inbounds
 FOR EACH ROW
 BEGIN
+    SET @stock_ref_type = COALESCE(@stock_ref_type, 'inbound'),
+        @stock_ref_id = COALESCE(@stock_ref_id, NEW.id);
+    -- 移动加权平均成本须在库存变动前计算（SET 从左到右求值）
     UPDATE medicines 
-    SET stock = stock + NEW.quantity 
+    SET avg_cost = fn_moving_avg_cost(stock, avg_cost, NEW.quantity, NEW.price),
+        stock = stock + NEW.quantity 
     WHERE id = NEW.medicine_id;
 END //
 DELIMITER ;
@@ -381,6 +504,8 @@ CREATE TRIGGER tr_after_sale_delete
 AFTER DELETE ON sales
 FOR EACH ROW
 BEGIN
+    SET @stock_ref_type = COALESCE(@stock_ref_type, 'sale_delete'),
+        @stock_ref_id = COALESCE(@stock_ref_id, OLD.id);
     UPDATE medicines 
     SET stock = stock + OLD.quantity 
     WHERE id = OLD.medicine_id;
@@ -394,9 +519,27 @@ CREATE TRIGGER tr_after_inbound_delete
 AFTER DELETE ON inbounds
 FOR EACH ROW
 BEGIN
+    DECLARE po_id BIGINT;
+    SET @stock_ref_type = COALESCE(@stock_ref_type, 'inbound_delete'),
+        @stock_ref_id = COALESCE(@stock_ref_id, OLD.id);
     UPDATE medicines 
-    SET stock = stock - OLD.quantity 
+    SET avg_cost = fn_moving_avg_cost(stock, avg_cost, -OLD.quantity, OLD.price),
+        stock = stock - OLD.quantity 
     WHERE id = OLD.medicine_id;
+
+    -- 入库单作废后对应批次不再可售
+    UPDATE stock_lots
+    SET remaining = 0, status = 'removed'
+    WHERE inbound_id = OLD.id;
+
+    -- 按采购订单收货的入库单作废后，冲减已收数量
+    IF OLD.po_line_id IS NOT NULL THEN
+        UPDATE purchase_order_lines
+        SET received_qty = GREATEST(received_qty - OLD.quantity, 0)
+        WHERE id = OLD.po_line_id;
+        SELECT purchase_order_id INTO po_id FROM purchase_order_lines WHERE id = OLD.po_line_id;
+        CALL sp_refresh_po_status(po_id);
+    END IF;
 END //
 DELIMITER ;
 
@@ -438,7 +581,7 @@ RETURNS DECIMAL(10, 2)
 DETERMINISTIC
 BEGIN
     DECLARE total DECIMAL(10, 2);
-    SELECT COALESCE(SUM(total_price), 0) INTO total FROM sales WHERE customer_id = cust_id;
+    SELECT COALESCE(SUM(total_price), 0) INTO total FROM sales WHERE customer_id = cust_id AND status <> 'voided';
     RETURN total;
 END //
 DELIMITER ;
@@ -454,12 +597,33 @@ BEGIN
     SELECT COALESCE(SUM(quantity), 0) INTO qty 
     FROM sales 
     WHERE medicine_id = med_id 
+    AND status <> 'voided'
     AND YEAR(sale_date) = YEAR(NOW()) 
     AND MONTH(sale_date) = MONTH(NOW());
     RETURN qty;
 END //
 DELIMITER ;
 
+-- 函数：移动加权平均成本
+-- 按 qty 件、单位成本 unit_cost 入库（qty 为负表示按原价冲回）后的平均成本。
+-- 尚无成本或无库存时直接取本次成本；冲回后库存归零时保持原成本。
+DROP FUNCTION IF EXISTS fn_moving_avg_cost;
+DELIMITER //
+CREATE FUNCTION fn_moving_avg_cost(cur_stock INT, cur_avg DECIMAL(12, 4), qty INT, unit_cost DECIMAL(12, 4))
+RETURNS DECIMAL(12, 4)
+DETERMINISTIC
+BEGIN
+    DECLARE on_hand INT DEFAULT GREATEST(COALESCE(cur_stock, 0), 0);
+    IF on_hand + qty <= 0 THEN
+        RETURN COALESCE(cur_avg, 0);
+    END IF;
+    IF qty > 0 AND (on_hand = 0 OR COALESCE(cur_avg, 0) = 0) THEN
+        RETURN unit_cost;
+    END IF;
+    RETURN GREATEST((on_hand * COALESCE(cur_avg, 0) + qty * unit_cost) / (on_hand + qty), 0);
+END //
+DELIMITER ;
+
 -- ==================== 额外视图 ====================
 
 -- 普通员工药品视图（隐藏进货价）
@@ -473,13 +637,10 @@ CREATE OR REPLACE VIEW v_admin_financials AS
 SELECT 
     DATE(s.sale_date) AS sale_day,
     SUM(s.total_price) AS daily_revenue,
-    (SELECT COALESCE(SUM(i.price * i.quantity), 0) 
-     FROM inbounds i 
-     WHERE DATE(i.inbound_date) = DATE(s.sale_date)) AS daily_cost,
-    SUM(s.total_price) - (SELECT COALESCE(SUM(i.price * i.quantity), 0) 
-     FROM inbounds i 
-     WHERE DATE(i.inbound_date) = DATE(s.sale_date)) AS daily_profit
+    SUM(COALESCE(s.cost_amount, 0)) AS daily_cost,
+    SUM(s.total_price) - SUM(COALESCE(s.cost_amount, 0)) AS daily_profit
 FROM sales s
+WHERE s.status <> 'voided'
 GROUP BY DATE(s.sale_date)
 ORDER BY sale_day DESC;
 
@@ -516,10 +677,10 @@ CREATE OR REPLACE VIEW v_customer_ranking AS
 SELECT 
     c.id,
     c.name,
-    COUNT(s.id) AS order_count,
+    COUNT(DISTINCT s.order_id) AS order_count,
     COALESCE(SUM(s.total_price), 0) AS total_spent
 FROM customers c
-LEFT JOIN sales s ON c.id = s.customer_id
+LEFT JOIN sales s ON c.id = s.customer_id AND s.status <> 'voided'
 GROUP BY c.id, c.name
 ORDER BY total_spent DESC;
 
@@ -532,18 +693,13 @@ CREATE PROCEDURE sp_sales_trend(IN start_date DATE, IN end_date DATE)
 BEGIN
     SELECT 
         DATE(s.sale_date) AS sale_day,
-        COUNT(s.id) AS order_count,
+        COUNT(DISTINCT s.order_id) AS order_count,
         SUM(COALESCE(s.quantity, 0)) AS total_quantity,
         SUM(COALESCE(s.total_price, 0)) AS total_revenue,
-        SUM(COALESCE(s.total_price, 0) - (COALESCE(s.quantity, 0) * COALESCE(avg_cost.avg_price, m.price * 0.65, 0))) AS total_profit
+        SUM(COALESCE(s.total_price, 0) - COALESCE(s.cost_amount, 0)) AS total_profit
     FROM sales s
-    JOIN medicines m ON s.medicine_id = m.id
-    LEFT JOIN (
-        SELECT medicine_id, AVG(price) as avg_price 
-        FROM inbounds 
-        GROUP BY medicine_id
-    ) avg_cost ON s.medicine_id = avg_cost.medicine_id
     WHERE DATE(s.sale_date) BETWEEN start_date AND end_date
+      AND s.status <> 'voided'
     GROUP BY DATE(s.sale_date)
     ORDER BY sale_day;
 END //
@@ -567,23 +723,19 @@ BEGIN
         m.type,
         SUM(s.quantity) AS total_sold,
         SUM(s.total_price) AS total_revenue,
-        SUM(s.total_price - (s.quantity * IFNULL(avg_cost.avg_price, m.price * 0.65))) AS total_profit
+        SUM(s.total_price - COALESCE(s.cost_amount, 0)) AS total_profit
     FROM medicines m
     JOIN sales s ON m.id = s.medicine_id
-    LEFT JOIN (
-        SELECT medicine_id, AVG(price) as avg_price 
-        FROM inbounds 
-        GROUP BY medicine_id
-    ) avg_cost ON m.id = avg_cost.medicine_id
     WHERE DATE(s.sale_date) BETWEEN start_date AND end_date
-    GROUP BY m.id, m.code, m.name, m.type, avg_cost.avg_price, m.price
+      AND s.status <> 'voided'
+    GROUP BY m.id, m.code, m.name, m.type
     ORDER BY 
         CASE WHEN sort_column = 'total_sold' AND sort_order = 'DESC' THEN SUM(s.quantity) END DESC,
         CASE WHEN sort_column = 'total_sold' AND sort_order = 'ASC' THEN SUM(s.quantity) END ASC,
         CASE WHEN sort_column = 'total_revenue' AND sort_order = 'DESC' THEN SUM(s.total_price) END DESC,
         CASE WHEN sort_column = 'total_revenue' AND sort_order = 'ASC' THEN SUM(s.total_price) END ASC,
-        CASE WHEN sort_column = 'total_profit' AND sort_order = 'DESC' THEN SUM(s.total_price - (s.quantity * IFNULL(avg_cost.avg_price, m.price * 0.65))) END DESC,
-        CASE WHEN sort_column = 'total_profit' AND sort_order = 'ASC' THEN SUM(s.total_price - (s.quantity * IFNULL(avg_cost.avg_price, m.price * 0.65))) END ASC,
+        CASE WHEN sort_column = 'total_profit' AND sort_order = 'DESC' THEN SUM(s.total_price - COALESCE(s.cost_amount, 0)) END DESC,
+        CASE WHEN sort_column = 'total_profit' AND sort_order = 'ASC' THEN SUM(s.total_price - COALESCE(s.cost_amount, 0)) END ASC,
         SUM(s.quantity) DESC
     LIMIT limit_count;
 END //
@@ -657,6 +809,42 @@ SELECT 'Advanced database features (Views, SPs, Permissions) created successfull
 
 -- ==================== 销售和入库记录的更新/删除存储过程 ====================
 
+-- 存储过程：按明细行重新计算订单头合计（不计已作废的行；明细全部删除时订单头一并删除，
+-- 全部作废时订单头合计清零并标记为 voided）
+DROP PROCEDURE IF EXISTS sp_refresh_order_totals;
+DELIMITER //
+CREATE PROCEDURE sp_refresh_order_totals(IN ord_no VARCHAR(50))
+BEGIN
+    IF (SELECT COUNT(*) FROM sales WHERE order_id = ord_no) = 0 THEN
+        DELETE FROM orders WHERE order_no = ord_no;
+    ELSEIF (SELECT COUNT(*) FROM sales WHERE order_id = ord_no AND status <> 'voided') = 0 THEN
+        UPDATE orders
+        SET item_count = 0, total_quantity = 0, total_amount = 0, discount_amount = 0,
+            paid_amount = 0, status = 'voided'
+        WHERE order_no = ord_no;
+    ELSE
+        UPDATE orders o
+        JOIN (
+            SELECT order_id,
+                   COUNT(*) AS item_count,
+                   SUM(quantity) AS total_quantity,
+                   SUM(total_price) AS total_amount,
+                   SUM(discount_amount) AS discount_amount,
+                   MAX(customer_id) AS customer_id
+            FROM sales
+            WHERE order_id = ord_no AND status <> 'voided'
+            GROUP BY order_id
+        ) t ON o.order_no = t.order_id
+        SET o.item_count = t.item_count,
+            o.total_quantity = t.total_quantity,
+            o.total_amount = t.total_amount,
+            o.discount_amount = t.discount_amount,
+            o.paid_amount = GREATEST(t.total_amount - o.points_amount, 0),
+            o.customer_id = t.customer_id;
+    END IF;
+END //
+DELIMITER ;
+
 -- 存储过程：更新销售记录
 DROP PROCEDURE IF EXISTS sp_update_sale;
 DELIMITER //
@@ -671,12 +859,41 @@ BEGIN
     DECLARE old_quantity INT;
     DECLARE new_price DECIMAL(10,2);
     DECLARE new_total DECIMAL(10,2);
+    DECLARE new_discount DECIMAL(10,2) DEFAULT 0;
+    DECLARE ord_no VARCHAR(50);
+    
+    DECLARE returned_qty INT;
     
     -- 获取旧的销售信息
-    SELECT medicine_id, quantity INTO old_medicine_id, old_quantity
+    SELECT medicine_id, quantity, order_id INTO old_medicine_id, old_quantity, ord_no
     FROM sales WHERE id = sale_id;
+
+    IF (SELECT sales.status FROM sales WHERE sales.id = sale_id) = 'voided' THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = '该销售已作废，不能修改';
+    END IF;
+
+    -- 已有退货的销售不能换药品，数量也不能低于已退数量
+    SELECT COALESCE(SUM(quantity), 0) INTO returned_qty FROM sales_returns WHERE sales_returns.sale_id = sale_id;
+    IF returned_qty > 0 AND (new_medicine_id <> old_medicine_id OR new_quantity < returned_qty) THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = '该销售已有退货记录，不能更换药品或改为低于已退数量';
+    END IF;
+
+    -- 已绑定追溯码的销售不能换药品，数量也不能低于已扫码盒数
+    IF EXISTS (SELECT 1 FROM trace_codes WHERE trace_codes.sale_id = sale_id)
+        AND (new_medicine_id <> old_medicine_id
+             OR new_quantity < (SELECT COUNT(*) FROM trace_codes WHERE trace_codes.sale_id = sale_id)) THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = '该销售已绑定追溯码，不能更换药品或改为低于已扫码数量';
+    END IF;
+
+    -- 已从批次出库的销售不能换药品或改数量，否则批次余量与 sale_lots 对不上；
+    -- 请作废后重新结算
+    IF EXISTS (SELECT 1 FROM sale_lots WHERE sale_lots.sale_id = sale_id)
+        AND (new_medicine_id <> old_medicine_id OR new_quantity <> old_quantity) THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = '该销售已按批次出库，不能更换药品或修改数量，请作废后重新结算';
+    END IF;
     
     -- 恢复旧药品库存
+    SET @stock_ref_type = 'sale_update', @stock_ref_id = sale_id;
     UPDATE medicines SET stock = stock + old_quantity WHERE id = old_medicine_id;
     
     -- 获取新药品价格
@@ -689,37 +906,39 @@ BEGIN
     END IF;
     
     -- 扣减新药品库存
+    SET @stock_ref_type = 'sale_update', @stock_ref_id = sale_id;
     UPDATE medicines SET stock = stock - new_quantity WHERE id = new_medicine_id;
     
     -- 更新销售记录
+    -- 换药品时按新药品当前平均成本、当前售价重新计算且不再享受促销，
+    -- 否则沿用原单位成本和成交单价，促销优惠按数量比例调整
+    IF new_medicine_id = old_medicine_id THEN
+        SELECT COALESCE(sales.unit_price, ROUND((sales.total_price + sales.discount_amount) / sales.quantity, 2)),
+               ROUND(sales.discount_amount * new_quantity / sales.quantity, 2)
+        INTO new_price, new_discount
+        FROM sales WHERE sales.id = sale_id;
+        SET new_total = new_price * new_quantity - new_discount;
+    END IF;
+
     UPDATE sales 
-    SET medicine_id = new_medicine_id,
+    SET unit_cost = IF(new_medicine_id = old_medicine_id, unit_cost,
+                       (SELECT avg_cost FROM medicines WHERE id = new_medicine_id)),
+        cost_amount = ROUND(unit_cost * new_quantity, 2),
+        medicine_id = new_medicine_id,
         customer_id = new_customer_id,
         quantity = new_quantity,
+        unit_price = new_price,
+        discount_amount = new_discount,
         total_price = new_total
     WHERE id = sale_id;
+
+    -- 同步订单头合计
+    CALL sp_refresh_order_totals(ord_no);
 END //
 DELIMITER ;
 
--- 存储过程：删除销售记录
+-- 销售记录不再删除，改由 sp_void_sale 作废；清理旧库中的删除过程
 DROP PROCEDURE IF EXISTS sp_delete_sale;
-DELIMITER //
-CREATE PROCEDURE sp_delete_sale(IN sale_id BIGINT)
-BEGIN
-    DECLARE med_id BIGINT;
-    DECLARE qty INT;
-    
-    -- 获取销售信息
-    SELECT medicine_id, quantity INTO med_id, qty
-    FROM sales WHERE id = sale_id;
-    
-    -- 恢复库存
-    UPDATE medicines SET stock = stock + qty WHERE id = med_id;
-    
-    -- 删除销售记录
-    DELETE FROM sales WHERE id = sale_id;
-END //
-DELIMITER ;
 
 -- 存储过程：更新入库记录
 DROP PROCEDURE IF EXISTS sp_update_inbound;
@@ -734,16 +953,50 @@ CREATE PROCEDURE sp_update_inbound(
 BEGIN
     DECLARE old_medicine_id BIGINT;
     DECLARE old_quantity INT;
+    DECLARE old_price DECIMAL(10,2);
+    DECLARE line_id BIGINT;
+    DECLARE po_id BIGINT;
+    DECLARE returned_qty INT;
     
     -- 获取旧的入库信息
-    SELECT medicine_id, quantity INTO old_medicine_id, old_quantity
+    SELECT medicine_id, quantity, price, po_line_id INTO old_medicine_id, old_quantity, old_price, line_id
     FROM inbounds WHERE id = inbound_id;
+
+    -- 已退供应商的入库单不能换药品，数量也不能低于已退数量
+    SELECT COALESCE(SUM(quantity), 0) INTO returned_qty FROM purchase_returns WHERE purchase_returns.inbound_id = inbound_id;
+    IF returned_qty > 0 AND (new_medicine_id <> old_medicine_id OR new_quantity < returned_qty) THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = '该入库单已有采购退货，不能更换药品或改为低于已退数量';
+    END IF;
+
+    -- 已登记追溯码的入库单不能换药品，数量也不能低于已扫码盒数
+    IF EXISTS (SELECT 1 FROM trace_codes WHERE trace_codes.inbound_id = inbound_id)
+        AND (new_medicine_id <> old_medicine_id
+             OR new_quantity < (SELECT COUNT(*) FROM trace_codes WHERE trace_codes.inbound_id = inbound_id)) THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = '该入库单已登记追溯码，不能更换药品或改为低于已扫码数量';
+    END IF;
+
+    -- 按采购订单收货的入库单不能改成订单行以外的药品或供应商
+    IF line_id IS NOT NULL THEN
+        IF (SELECT COUNT(*) FROM purchase_order_lines l
+            JOIN purchase_orders po ON po.id = l.purchase_order_id
+            WHERE l.id = line_id AND l.medicine_id = new_medicine_id AND po.supplier_id = new_supplier_id) = 0 THEN
+            SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = '入库单关联采购订单行，药品和供应商必须与订单一致';
+        END IF;
+    END IF;
     
     -- 调整旧药品库存（减去旧入库量）
-    UPDATE medicines SET stock = stock - old_quantity WHERE id = old_medicine_id;
+    SET @stock_ref_type = 'inbound_update', @stock_ref_id = inbound_id;
+    UPDATE medicines
+    SET avg_cost = fn_moving_avg_cost(stock, avg_cost, -old_quantity, old_price),
+        stock = stock - old_quantity
+    WHERE id = old_medicine_id;
     
     -- 增加新药品库存
-    UPDATE medicines SET stock = stock + new_quantity WHERE id = new_medicine_id;
+    SET @stock_ref_type = 'inbound_update', @stock_ref_id = inbound_id;
+    UPDATE medicines
+    SET avg_cost = fn_moving_avg_cost(stock, avg_cost, new_quantity, new_price),
+        stock = stock + new_quantity
+    WHERE id = new_medicine_id;
     
     -- 更新入库记录
     UPDATE inbounds 
@@ -752,6 +1005,23 @@ BEGIN
         quantity = new_quantity,
         price = new_price
     WHERE id = inbound_id;
+
+    -- 同步对应批次（剩余量按入库数量差额调整）
+    UPDATE stock_lots
+    SET medicine_id = new_medicine_id,
+        quantity = new_quantity,
+        remaining = GREATEST(remaining + new_quantity - old_quantity, 0),
+        unit_cost = new_price
+    WHERE stock_lots.inbound_id = inbound_id;
+
+    -- 同步采购订单行的已收数量
+    IF line_id IS NOT NULL THEN
+        UPDATE purchase_order_lines
+        SET received_qty = GREATEST(received_qty + new_quantity - old_quantity, 0)
+        WHERE id = line_id;
+        SELECT purchase_order_id INTO po_id FROM purchase_order_lines WHERE id = line_id;
+        CALL sp_refresh_po_status(po_id);
+    END IF;
 END //
 DELIMITER ;
 
@@ -760,19 +1030,392 @@ DROP PROCEDURE IF EXISTS sp_delete_inbound;
 DELIMITER //
 CREATE PROCEDURE sp_delete_inbound(IN inbound_id BIGINT)
 BEGIN
-    DECLARE med_id BIGINT;
-    DECLARE qty INT;
-    
-    -- 获取入库信息
-    SELECT medicine_id, quantity INTO med_id, qty
-    FROM inbounds WHERE id = inbound_id;
-    
-    -- 减少库存
-    UPDATE medicines SET stock = stock - qty WHERE id = med_id;
-    
-    -- 删除入库记录
+    IF EXISTS (SELECT 1 FROM purchase_returns WHERE purchase_returns.inbound_id = inbound_id) THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = '该入库单已有采购退货，不能删除';
+    END IF;
+
+    -- 未售出的追溯码随入库单注销；已售出的保留，以便追溯到客户
+    INSERT INTO trace_events (code, event, medicine_id, ref_id, created_at)
+    SELECT code, 'void', medicine_id, inbound_id, NOW(3)
+    FROM trace_codes WHERE trace_codes.inbound_id = inbound_id AND status <> 'sold';
+    DELETE FROM trace_codes WHERE trace_codes.inbound_id = inbound_id AND status <> 'sold';
+
+    -- 删除入库记录（库存由 tr_after_inbound_delete 扣减，此处不可重复扣减）
     DELETE FROM inbounds WHERE id = inbound_id;
 END //
 DELIMITER ;
 
 SELECT 'Update/Delete stored procedures for sales and inbounds created successfully!' AS Status;
+
+
+-- ==================== 订单头 ====================
+
+-- 为历史销售记录补建订单头（按 order_id 归并，可重复执行）
+INSERT IGNORE INTO orders (order_no, customer_id, cashier_id, item_count, total_quantity, total_amount, payment_method, paid_amount, status, created_at)
+SELECT
+    s.order_id,
+    MAX(s.customer_id),
+    0,
+    COUNT(*),
+    SUM(s.quantity),
+    SUM(s.total_price),
+    'cash',
+    SUM(s.total_price),
+    'completed',
+    MIN(s.sale_date)
+FROM sales s
+GROUP BY s.order_id;
+
+SELECT 'Order headers backfilled successfully!' AS Status;
+
+
+-- ==================== 库存流水 ====================
+-- medicines.stock 的每一次变化都会追加一条 stock_movements 记录。
+-- 来源单据、操作人和原因通过会话变量传入：
+--   @stock_ref_type / @stock_ref_id  来源单据类型与 ID（销售、入库触发器会自动设置）
+--   @stock_operator_id               操作人（后端在事务开始时设置、提交或回滚前清空）
+--   @stock_reason                    调整原因
+-- 单据类型与原因在写入流水后清空，避免同一连接上的后续变动沿用旧值。
+
+-- 触发器：新建药品时记录期初库存
+DROP TRIGGER IF EXISTS tr_after_medicine_stock_insert;
+DELIMITER //
+CREATE TRIGGER tr_after_medicine_stock_insert
+AFTER INSERT ON medicines
+FOR EACH ROW
+BEGIN
+    IF NEW.stock <> 0 THEN
+        INSERT INTO stock_movements (medicine_id, delta, balance, ref_type, ref_id, operator_id, reason, created_at)
+        VALUES (NEW.id, NEW.stock, NEW.stock, COALESCE(@stock_ref_type, 'opening'), @stock_ref_id,
+                COALESCE(@stock_operator_id, 0), @stock_reason, NOW(3));
+        SET @stock_ref_type = NULL, @stock_ref_id = NULL, @stock_reason = NULL;
+    END IF;
+END //
+DELIMITER ;
+
+-- 触发器：库存变化时记录流水
+DROP TRIGGER IF EXISTS tr_after_medicine_stock_update;
+DELIMITER //
+CREATE TRIGGER tr_after_medicine_stock_update
+AFTER UPDATE ON medicines
+FOR EACH ROW
+BEGIN
+    IF NEW.stock <> OLD.stock THEN
+        INSERT INTO stock_movements (medicine_id, delta, balance, ref_type, ref_id, operator_id, reason, created_at)
+        VALUES (NEW.id, NEW.stock - OLD.stock, NEW.stock, COALESCE(@stock_ref_type, 'manual'), @stock_ref_id,
+                COALESCE(@stock_operator_id, 0), @stock_reason, NOW(3));
+        SET @stock_ref_type = NULL, @stock_ref_id = NULL, @stock_reason = NULL;
+    END IF;
+END //
+DELIMITER ;
+
+-- 触发器：库存流水只允许追加，禁止修改
+DROP TRIGGER IF EXISTS tr_before_stock_movement_update;
+DELIMITER //
+CREATE TRIGGER tr_before_stock_movement_update
+BEFORE UPDATE ON stock_movements
+FOR EACH ROW
+BEGIN
+    SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = '库存流水不可修改';
+END //
+DELIMITER ;
+
+-- 触发器：库存流水只允许追加，禁止删除
+DROP TRIGGER IF EXISTS tr_before_stock_movement_delete;
+DELIMITER //
+CREATE TRIGGER tr_before_stock_movement_delete
+BEFORE DELETE ON stock_movements
+FOR EACH ROW
+BEGIN
+    SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = '库存流水不可删除';
+END //
+DELIMITER ;
+
+SELECT 'Stock movement ledger triggers created successfully!' AS Status;
+
+
+-- ==================== 采购订单 ====================
+
+-- 存储过程：按订单行收货情况刷新采购订单状态
+-- 全部行收足后自动关闭；手工关闭 (closed_by <> 0)、草稿和已取消的订单不受影响
+DROP PROCEDURE IF EXISTS sp_refresh_po_status;
+DELIMITER //
+CREATE PROCEDURE sp_refresh_po_status(IN po_id BIGINT)
+BEGIN
+    DECLARE total_received INT;
+    DECLARE open_lines INT;
+
+    SELECT COALESCE(SUM(received_qty), 0), COALESCE(SUM(received_qty < quantity), 0)
+    INTO total_received, open_lines
+    FROM purchase_order_lines
+    WHERE purchase_order_id = po_id;
+
+    UPDATE purchase_orders
+    SET status = CASE
+            WHEN open_lines = 0 THEN 'closed'
+            WHEN total_received > 0 THEN 'partially_received'
+            ELSE 'approved'
+        END,
+        closed_at = CASE WHEN open_lines = 0 THEN COALESCE(closed_at, NOW()) ELSE NULL END
+    WHERE id = po_id
+      AND status IN ('approved', 'partially_received', 'closed')
+      AND closed_by = 0;
+END //
+DELIMITER ;
+
+-- 采购订单行视图：未到货数量与超收数量
+CREATE OR REPLACE VIEW v_purchase_order_lines AS
+SELECT 
+    l.id AS line_id,
+    po.id AS purchase_order_id,
+    po.po_no,
+    po.status,
+    po.supplier_id,
+    sup.name AS supplier_name,
+    l.medicine_id,
+    m.code AS medicine_code,
+    m.name AS medicine_name,
+    l.quantity,
+    l.expected_price,
+    l.received_qty,
+    CASE WHEN po.status IN ('approved', 'partially_received')
+         THEN GREATEST(l.quantity - l.received_qty, 0) ELSE 0 END AS outstanding_qty,
+    GREATEST(l.received_qty - l.quantity, 0) AS over_received_qty,
+    po.created_at
+FROM purchase_order_lines l
+JOIN purchase_orders po ON po.id = l.purchase_order_id
+JOIN medicines m ON m.id = l.medicine_id
+LEFT JOIN suppliers sup ON sup.id = po.supplier_id;
+
+SELECT 'Purchase order procedures and views created successfully!' AS Status;
+
+
+-- ==================== 销售退货 ====================
+
+-- 触发器：销售退货后增加库存（隔离退货由后端放入冻结批次，不可销售）
+DROP TRIGGER IF EXISTS tr_after_sales_return_insert;
+DELIMITER //
+CREATE TRIGGER tr_after_sales_return_insert
+AFTER INSERT ON sales_returns
+FOR EACH ROW
+BEGIN
+    SET @stock_ref_type = COALESCE(@stock_ref_type, 'sales_return'),
+        @stock_ref_id = COALESCE(@stock_ref_id, NEW.id);
+    -- 退回的货按原销售成本计入移动平均
+    UPDATE medicines 
+    SET avg_cost = fn_moving_avg_cost(stock, avg_cost, NEW.quantity, COALESCE(NEW.cost_amount / NEW.quantity, avg_cost)),
+        stock = stock + NEW.quantity 
+    WHERE id = NEW.medicine_id;
+END //
+DELIMITER ;
+
+SELECT 'Sales return trigger created successfully!' AS Status;
+
+
+-- ==================== 采购退货 ====================
+
+-- 触发器：采购退货前校验库存，现有库存不足退货数量时拒绝
+DROP TRIGGER IF EXISTS tr_before_purchase_return_insert;
+DELIMITER //
+CREATE TRIGGER tr_before_purchase_return_insert
+BEFORE INSERT ON purchase_returns
+FOR EACH ROW
+BEGIN
+    DECLARE current_stock INT;
+    SELECT stock INTO current_stock FROM medicines WHERE id = NEW.medicine_id;
+    IF current_stock IS NULL OR current_stock < NEW.quantity THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = '库存不足，无法退货给供应商';
+    END IF;
+END //
+DELIMITER ;
+
+-- 触发器：采购退货后扣减库存
+DROP TRIGGER IF EXISTS tr_after_purchase_return_insert;
+DELIMITER //
+CREATE TRIGGER tr_after_purchase_return_insert
+AFTER INSERT ON purchase_returns
+FOR EACH ROW
+BEGIN
+    SET @stock_ref_type = COALESCE(@stock_ref_type, 'purchase_return'),
+        @stock_ref_id = COALESCE(@stock_ref_id, NEW.id);
+    UPDATE medicines 
+    SET avg_cost = fn_moving_avg_cost(stock, avg_cost, -NEW.quantity, NEW.unit_price),
+        stock = stock - NEW.quantity 
+    WHERE id = NEW.medicine_id;
+END //
+DELIMITER ;
+
+SELECT 'Purchase return triggers created successfully!' AS Status;
+
+
+-- ==================== 成本核算 ====================
+
+-- 销售成本在结算时由后端按配置的核算方法 (costing.method) 写入 sales.unit_cost / cost_amount：
+--   weighted_average：药品的移动加权平均成本 medicines.avg_cost（由入库、退货触发器维护）
+--   fifo：所分配批次的进价，未建批次的旧库存按移动平均成本
+
+-- 存储过程：回填历史数据的成本（只处理尚未计成本的记录，可重复执行；导入示例数据后亦需调用）
+DROP PROCEDURE IF EXISTS sp_backfill_costs;
+DELIMITER //
+CREATE PROCEDURE sp_backfill_costs()
+BEGIN
+    -- 药品平均成本：按全部入库数量加权
+    UPDATE medicines m
+    JOIN (
+        SELECT medicine_id, SUM(price * quantity) / SUM(quantity) AS cost
+        FROM inbounds WHERE quantity > 0
+        GROUP BY medicine_id
+    ) c ON c.medicine_id = m.id
+    SET m.avg_cost = c.cost
+    WHERE m.avg_cost = 0;
+
+    -- 历史销售：有批次分配的按批次进价，其余按平均成本
+    UPDATE sales s
+    JOIN medicines m ON m.id = s.medicine_id
+    LEFT JOIN (
+        SELECT sl.sale_id, SUM(sl.quantity * l.unit_cost) AS lot_cost, SUM(sl.quantity) AS lot_qty
+        FROM sale_lots sl JOIN stock_lots l ON l.id = sl.lot_id
+        GROUP BY sl.sale_id
+    ) a ON a.sale_id = s.id
+    SET s.unit_cost = ROUND((COALESCE(a.lot_cost, 0) + (s.quantity - COALESCE(a.lot_qty, 0)) * m.avg_cost) / s.quantity, 4),
+        s.cost_amount = ROUND(COALESCE(a.lot_cost, 0) + (s.quantity - COALESCE(a.lot_qty, 0)) * m.avg_cost, 2)
+    WHERE s.cost_amount IS NULL AND s.quantity > 0;
+
+    -- 历史退货：按原销售的单位成本冲回
+    UPDATE sales_returns r
+    JOIN sales s ON s.id = r.sale_id
+    SET r.cost_amount = ROUND(s.unit_cost * r.quantity, 2)
+    WHERE r.cost_amount IS NULL;
+END //
+DELIMITER ;
+
+CALL sp_backfill_costs();
+
+SELECT 'Costing function and backfill completed successfully!' AS Status;
+
+
+-- ==================== 价格历史 ====================
+
+-- 售价变更记录在 medicine_prices：立即生效的调价直接写 applied 记录，
+-- 定时调价先写 scheduled 记录，到期后由后端调度任务（或结算时）应用并记下原价。
+-- sales.unit_price 保存成交时的单价，调价不影响历史销售。
+
+-- 存储过程：回填历史数据的成交单价和初始价格记录（可重复执行；导入示例数据后亦需调用）
+DROP PROCEDURE IF EXISTS sp_backfill_prices;
+DELIMITER //
+CREATE PROCEDURE sp_backfill_prices()
+BEGIN
+    UPDATE sales
+    SET unit_price = ROUND(total_price / quantity, 2)
+    WHERE unit_price IS NULL AND quantity > 0;
+
+    -- 尚无价格记录的药品，以当前售价作为初始记录
+    INSERT INTO medicine_prices (medicine_id, price, effective_from, status, reason, operator_id, applied_at, created_at)
+    SELECT m.id, m.price, NOW(3), 'applied', '初始价格', 0, NOW(3), NOW(3)
+    FROM medicines m
+    WHERE NOT EXISTS (SELECT 1 FROM medicine_prices p WHERE p.medicine_id = m.id);
+END //
+DELIMITER ;
+
+CALL sp_backfill_prices();
+
+SELECT 'Price history backfill completed successfully!' AS Status;
+
+
+-- ==================== 收款明细 ====================
+
+-- 每个订单的收款记录在 payments，一单可拆分多种支付方式：
+--   cash 现金（记录实收与找零）、card 银行卡、wechat 微信、alipay 支付宝、insurance 医保卡、points 积分
+-- 银行卡、微信、支付宝与医保经后端的支付通道 (payment.Provider) 扣款，流水号写入 provider_ref。
+
+-- 存储过程：为尚无收款记录的历史订单按订单支付方式补建收款（可重复执行；导入示例数据后亦需调用）
+DROP PROCEDURE IF EXISTS sp_backfill_payments;
+DELIMITER //
+CREATE PROCEDURE sp_backfill_payments()
+BEGIN
+    INSERT INTO payments (order_no, tender, amount, tendered, change_amount, created_at)
+    SELECT o.order_no,
+           IF(o.payment_method IN ('cash', 'card', 'wechat', 'alipay', 'insurance'), o.payment_method, 'cash'),
+           o.paid_amount, o.paid_amount, 0, o.created_at
+    FROM orders o
+    WHERE o.paid_amount > 0
+      AND NOT EXISTS (SELECT 1 FROM payments p WHERE p.order_no = o.order_no);
+
+    INSERT INTO payments (order_no, tender, amount, tendered, change_amount, reference, created_at)
+    SELECT o.order_no, 'points', o.points_amount, o.points_amount, 0, o.points_redeemed, o.created_at
+    FROM orders o
+    WHERE o.points_amount > 0
+      AND NOT EXISTS (SELECT 1 FROM payments p WHERE p.order_no = o.order_no AND p.tender = 'points');
+END //
+DELIMITER ;
+
+CALL sp_backfill_payments();
+
+SELECT 'Payment lines backfilled successfully!' AS Status;
+
+
+-- ==================== 销售作废 ====================
+
+-- 作废不删除销售记录：sales.status 置为 voided，订单合计与各类收入统计不再计入该行。
+-- 收银员提交 sale_voids 申请，管理员批准后由后端调用 sp_void_sale；管理员本人作废即时批准。
+
+-- 触发器：销售行作废时恢复库存（与删除销售走同一条库存流水路径）
+DROP TRIGGER IF EXISTS tr_after_sale_void;
+DELIMITER //
+CREATE TRIGGER tr_after_sale_void
+AFTER UPDATE ON sales
+FOR EACH ROW
+BEGIN
+    IF OLD.status <> 'voided' AND NEW.status = 'voided' THEN
+        SET @stock_ref_type = COALESCE(@stock_ref_type, 'sale_void'),
+            @stock_ref_id = COALESCE(@stock_ref_id, OLD.id);
+        UPDATE medicines
+        SET stock = stock + OLD.quantity
+        WHERE id = OLD.medicine_id;
+    END IF;
+END //
+DELIMITER ;
+
+-- 存储过程：作废销售行（库存由 tr_after_sale_void 恢复，批次与追溯码在此归还）
+DROP PROCEDURE IF EXISTS sp_void_sale;
+DELIMITER //
+CREATE PROCEDURE sp_void_sale(IN sale_id BIGINT)
+BEGIN
+    DECLARE ord_no VARCHAR(50);
+    DECLARE cur_status VARCHAR(20);
+
+    SELECT sales.order_id, sales.status INTO ord_no, cur_status
+    FROM sales WHERE sales.id = sale_id FOR UPDATE;
+
+    IF ord_no IS NULL THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = '销售记录不存在';
+    END IF;
+    IF cur_status = 'voided' THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = '该销售已作废';
+    END IF;
+    IF EXISTS (SELECT 1 FROM sales_returns WHERE sales_returns.sale_id = sale_id) THEN
+        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = '该销售已有退货记录，不能作废';
+    END IF;
+
+    -- 释放绑定的追溯码，追溯日志记一笔 void
+    INSERT INTO trace_events (code, event, medicine_id, ref_id, order_no, created_at)
+    SELECT code, 'void', medicine_id, sale_id, ord_no, NOW(3)
+    FROM trace_codes WHERE trace_codes.sale_id = sale_id;
+    UPDATE trace_codes SET sale_id = NULL, status = 'in_stock', updated_at = NOW(3)
+    WHERE trace_codes.sale_id = sale_id;
+
+    -- 已分配的批次数量放回原批次
+    UPDATE stock_lots l
+    JOIN sale_lots sl ON sl.lot_id = l.id
+    SET l.remaining = l.remaining + (sl.quantity - sl.returned_qty)
+    WHERE sl.sale_id = sale_id;
+    UPDATE sale_lots SET returned_qty = quantity WHERE sale_lots.sale_id = sale_id;
+
+    UPDATE sales SET status = 'voided' WHERE sales.id = sale_id;
+
+    -- 同步订单头合计
+    CALL sp_refresh_order_totals(ord_no);
+END //
+DELIMITER ;
+
+SELECT 'Sale void trigger and procedure created successfully!' AS Status;
@@ -12,6 +12,17 @@ CREATE TABLE IF NOT EXISTS users (
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
 );
 
+-- 已注销令牌（登出、刷新后作废的 JWT，过期后可清理）
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    jti VARCHAR(64) NOT NULL,
+    user_id BIGINT,
+    expires_at DATETIME(3),
+    created_at DATETIME(3),
+    UNIQUE INDEX idx_revoked_tokens_jti (jti),
+    INDEX idx_revoked_tokens_expires_at (expires_at)
+);
+
 -- 药品表
 CREATE TABLE IF NOT EXISTS medicines (
     id BIGINT AUTO_INCREMENT PRIMARY KEY,
@@ -23,6 +34,11 @@ CREATE TABLE IF NOT EXISTS medicines (
     stock INT NOT NULL DEFAULT 0,
     manufacturer VARCHAR(100),
     status VARCHAR(20) DEFAULT 'active',
+    ingredients VARCHAR(255),
+    avg_cost DECIMAL(12, 4) NOT NULL DEFAULT 0,
+    min_stock INT,
+    max_stock INT,
+    reorder_point INT,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
 );
@@ -32,6 +48,8 @@ CREATE TABLE IF NOT EXISTS customers (
     id BIGINT AUTO_INCREMENT PRIMARY KEY,
     name VARCHAR(100) NOT NULL,
     phone VARCHAR(20),
+    tier VARCHAR(20) DEFAULT 'standard',
+    points INT NOT NULL DEFAULT 0,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -52,6 +70,11 @@ CREATE TABLE IF NOT EXISTS inbounds (
     quantity INT NOT NULL,
     price DECIMAL(10, 2) NOT NULL,
     inbound_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    lot_no VARCHAR(50),
+    production_date DATE,
+    expiry_date DATE,
+    po_line_id BIGINT,
+    INDEX idx_inbounds_po_line_id (po_line_id),
     FOREIGN KEY (medicine_id) REFERENCES medicines(id),
     FOREIGN KEY (supplier_id) REFERENCES suppliers(id)
 );
@@ -65,10 +88,498 @@ CREATE TABLE IF NOT EXISTS sales (
     total_price DECIMAL(10, 2) NOT NULL,
     sale_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     customer_id BIGINT,
+    unit_cost DECIMAL(12, 4),
+    cost_amount DECIMAL(10, 2),
+    unit_price DECIMAL(10, 2),
+    discount_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'completed',
+    INDEX idx_sales_status (status),
     FOREIGN KEY (medicine_id) REFERENCES medicines(id),
     FOREIGN KEY (customer_id) REFERENCES customers(id)
 );
 
+-- 订单头（一单多行，明细行为 sales 中 order_id 相同的记录）
+CREATE TABLE IF NOT EXISTS orders (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    order_no VARCHAR(32) NOT NULL UNIQUE,
+    customer_id BIGINT,
+    cashier_id BIGINT,
+    item_count INT NOT NULL DEFAULT 0,
+    total_quantity INT NOT NULL DEFAULT 0,
+    total_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    discount_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    points_redeemed INT NOT NULL DEFAULT 0,
+    points_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    points_earned INT NOT NULL DEFAULT 0,
+    payment_method VARCHAR(20) DEFAULT 'cash',
+    paid_amount DECIMAL(10, 2),
+    status VARCHAR(20) DEFAULT 'completed',
+    prescription_id BIGINT,
+    shift_id BIGINT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_orders_prescription_id (prescription_id),
+    INDEX idx_orders_shift_id (shift_id)
+);
+
+-- 客户健康档案（过敏史、慢性病，供结算时用药安全检查）
+CREATE TABLE IF NOT EXISTS customer_health_profiles (
+    customer_id BIGINT PRIMARY KEY,
+    allergies VARCHAR(500),
+    conditions VARCHAR(500),
+    notes VARCHAR(255),
+    updated_by BIGINT,
+    updated_at DATETIME(3)
+);
+
+-- 用药安全警示确认记录
+CREATE TABLE IF NOT EXISTS safety_acknowledgements (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    order_no VARCHAR(32),
+    customer_id BIGINT,
+    warnings TEXT,
+    acknowledged_by BIGINT,
+    created_at DATETIME(3),
+    INDEX idx_safety_ack_order (order_no),
+    INDEX idx_safety_ack_customer (customer_id),
+    INDEX idx_safety_ack_created (created_at)
+);
+
+-- 处方（处方药凭审核通过的处方销售，只增不删，作为处方档案）
+CREATE TABLE IF NOT EXISTS prescriptions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    rx_no VARCHAR(30) NOT NULL UNIQUE,
+    customer_id BIGINT NOT NULL,
+    prescriber VARCHAR(50) NOT NULL,
+    hospital VARCHAR(100) NOT NULL,
+    prescribed_date DATE NOT NULL,
+    diagnosis VARCHAR(255),
+    image_file VARCHAR(255),
+    status VARCHAR(20) DEFAULT 'pending',
+    created_by BIGINT,
+    pharmacist_id BIGINT,
+    verified_at DATETIME(3),
+    review_note VARCHAR(255),
+    order_no VARCHAR(32),
+    dispensed_at DATETIME(3),
+    created_at DATETIME(3),
+    INDEX idx_prescriptions_customer (customer_id),
+    INDEX idx_prescriptions_status (status),
+    INDEX idx_prescriptions_order_no (order_no),
+    INDEX idx_prescriptions_created (created_at)
+);
+
+-- 库存批次（每次入库生成一个批次，销售按效期先到先出扣减）
+CREATE TABLE IF NOT EXISTS stock_lots (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    medicine_id BIGINT NOT NULL,
+    inbound_id BIGINT,
+    lot_no VARCHAR(50),
+    production_date DATE,
+    expiry_date DATE,
+    quantity INT NOT NULL,
+    remaining INT NOT NULL,
+    unit_cost DECIMAL(10, 2),
+    status VARCHAR(20) DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_stock_lots_medicine (medicine_id),
+    INDEX idx_stock_lots_inbound (inbound_id),
+    INDEX idx_stock_lots_expiry (expiry_date)
+);
+
+-- 销售批次分配（一条销售明细从哪些批次出库）
+CREATE TABLE IF NOT EXISTS sale_lots (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    sale_id BIGINT NOT NULL,
+    lot_id BIGINT NOT NULL,
+    quantity INT NOT NULL,
+    returned_qty INT NOT NULL DEFAULT 0,
+    INDEX idx_sale_lots_sale (sale_id),
+    INDEX idx_sale_lots_lot (lot_id)
+);
+
+-- 库存流水（只追加，由 medicines 库存触发器写入）
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    medicine_id BIGINT NOT NULL,
+    delta INT NOT NULL,
+    balance INT NOT NULL,
+    ref_type VARCHAR(32) NOT NULL,
+    ref_id BIGINT,
+    operator_id BIGINT DEFAULT 0,
+    reason VARCHAR(255),
+    created_at DATETIME(3) DEFAULT CURRENT_TIMESTAMP(3),
+    INDEX idx_stock_movements_medicine (medicine_id, created_at)
+);
+
+-- 盘点单（创建时快照账面库存，审核后差异计入库存）
+CREATE TABLE IF NOT EXISTS stocktakes (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    scope VARCHAR(20) NOT NULL,
+    scope_value VARCHAR(100),
+    status VARCHAR(20) DEFAULT 'counting',
+    remark VARCHAR(255),
+    created_by BIGINT,
+    approved_by BIGINT,
+    created_at DATETIME(3),
+    closed_at DATETIME(3),
+    INDEX idx_stocktakes_status (status)
+);
+
+-- 盘点明细（counted_qty 为空表示尚未盘点）
+CREATE TABLE IF NOT EXISTS stocktake_items (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    stocktake_id BIGINT NOT NULL,
+    medicine_id BIGINT NOT NULL,
+    book_qty INT NOT NULL,
+    counted_qty INT,
+    reason VARCHAR(255),
+    counted_by BIGINT,
+    counted_at DATETIME(3),
+    UNIQUE INDEX idx_stocktake_medicine (stocktake_id, medicine_id)
+);
+
+-- 销售退货（支持部分退货，原销售记录保留）
+CREATE TABLE IF NOT EXISTS sales_returns (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    sale_id BIGINT NOT NULL,
+    order_id VARCHAR(50),
+    medicine_id BIGINT NOT NULL,
+    customer_id BIGINT,
+    quantity INT NOT NULL,
+    refund_amount DECIMAL(10, 2) NOT NULL,
+    cost_amount DECIMAL(10, 2),
+    reason VARCHAR(255),
+    disposition VARCHAR(20) DEFAULT 'restock',
+    operator_id BIGINT,
+    created_at DATETIME(3),
+    refund_tender VARCHAR(20),
+    tender_refund DECIMAL(10, 2),
+    shift_id BIGINT,
+    INDEX idx_sales_returns_sale (sale_id),
+    INDEX idx_sales_returns_order (order_id),
+    INDEX idx_sales_returns_medicine (medicine_id),
+    INDEX idx_sales_returns_created (created_at),
+    INDEX idx_sales_returns_shift_id (shift_id)
+);
+
+-- 采购退货单（退回供应商，原入库记录保留）
+CREATE TABLE IF NOT EXISTS purchase_returns (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    return_no VARCHAR(30) NOT NULL UNIQUE,
+    inbound_id BIGINT NOT NULL,
+    supplier_id BIGINT NOT NULL,
+    medicine_id BIGINT NOT NULL,
+    quantity INT NOT NULL,
+    unit_price DECIMAL(10, 2) NOT NULL,
+    credit_amount DECIMAL(10, 2) NOT NULL,
+    reason_code VARCHAR(20) NOT NULL,
+    remark VARCHAR(255),
+    status VARCHAR(20) DEFAULT 'pending',
+    operator_id BIGINT,
+    credited_by BIGINT,
+    credited_at DATETIME(3),
+    recall_id BIGINT,
+    created_at DATETIME(3),
+    INDEX idx_purchase_returns_inbound (inbound_id),
+    INDEX idx_purchase_returns_supplier (supplier_id),
+    INDEX idx_purchase_returns_medicine (medicine_id),
+    INDEX idx_purchase_returns_status (status),
+    INDEX idx_purchase_returns_created (created_at),
+    INDEX idx_purchase_returns_recall_id (recall_id)
+);
+
+-- 药品召回（登记时冻结符合条件的批次；未指定批号、供应商和到货日期时召回整个品种）
+CREATE TABLE IF NOT EXISTS recalls (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    recall_no VARCHAR(30) NOT NULL UNIQUE,
+    medicine_id BIGINT NOT NULL,
+    lot_no VARCHAR(50),
+    supplier_id BIGINT,
+    received_from DATE,
+    received_to DATE,
+    level INT,
+    reason VARCHAR(255),
+    status VARCHAR(20) DEFAULT 'active',
+    frozen_qty INT,
+    prev_medicine_status VARCHAR(20),
+    created_by BIGINT,
+    closed_by BIGINT,
+    closed_at DATETIME(3),
+    created_at DATETIME(3),
+    INDEX idx_recalls_medicine_id (medicine_id),
+    INDEX idx_recalls_status (status)
+);
+
+-- 召回批次（含已售完批次，用于追溯购买客户）
+CREATE TABLE IF NOT EXISTS recall_lots (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    recall_id BIGINT NOT NULL,
+    lot_id BIGINT NOT NULL,
+    inbound_id BIGINT,
+    lot_no VARCHAR(50),
+    frozen_qty INT,
+    prev_status VARCHAR(20),
+    UNIQUE INDEX idx_recall_lot (recall_id, lot_id)
+);
+
+-- 药品追溯码（每盒一码，记录当前状态：在库 / 已售 / 隔离）
+CREATE TABLE IF NOT EXISTS trace_codes (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    code VARCHAR(20) NOT NULL UNIQUE,
+    medicine_id BIGINT NOT NULL,
+    inbound_id BIGINT,
+    sale_id BIGINT,
+    status VARCHAR(20) DEFAULT 'in_stock',
+    created_at DATETIME(3),
+    updated_at DATETIME(3),
+    INDEX idx_trace_codes_medicine_id (medicine_id),
+    INDEX idx_trace_codes_inbound_id (inbound_id),
+    INDEX idx_trace_codes_sale_id (sale_id),
+    INDEX idx_trace_codes_status (status)
+);
+
+-- 追溯码流转记录（只追加：入库、销售、退货、作废）
+CREATE TABLE IF NOT EXISTS trace_events (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    code VARCHAR(20) NOT NULL,
+    event VARCHAR(20) NOT NULL,
+    medicine_id BIGINT NOT NULL,
+    ref_id BIGINT,
+    order_no VARCHAR(32),
+    operator_id BIGINT,
+    created_at DATETIME(3),
+    INDEX idx_trace_events_code (code),
+    INDEX idx_trace_event_time (event, created_at)
+);
+
+-- 药品售价历史（立即调价为 applied；定时调价为 scheduled，到期应用，可取消）
+CREATE TABLE IF NOT EXISTS medicine_prices (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    medicine_id BIGINT NOT NULL,
+    price DECIMAL(10, 2) NOT NULL,
+    prev_price DECIMAL(10, 2),
+    effective_from DATETIME(3) NOT NULL,
+    status VARCHAR(20) DEFAULT 'scheduled',
+    reason VARCHAR(255),
+    operator_id BIGINT,
+    applied_at DATETIME(3),
+    created_at DATETIME(3),
+    INDEX idx_medicine_price_effective (medicine_id, effective_from),
+    INDEX idx_medicine_prices_status (status)
+);
+
+-- 促销规则（percentage 折扣 / fixed 每件立减 / bundle 买赠 / threshold 满减）
+CREATE TABLE IF NOT EXISTS promotions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    rule_type VARCHAR(20) NOT NULL,
+    value DECIMAL(10, 2),
+    buy_qty INT,
+    free_qty INT,
+    threshold DECIMAL(10, 2),
+    start_at DATETIME(3),
+    end_at DATETIME(3),
+    member_only BOOLEAN,
+    medicine_id BIGINT,
+    medicine_type VARCHAR(20),
+    manufacturer VARCHAR(100),
+    name_keyword VARCHAR(50),
+    status VARCHAR(20) DEFAULT 'active',
+    created_by BIGINT,
+    created_at DATETIME(3),
+    updated_at DATETIME(3),
+    INDEX idx_promotions_start_at (start_at),
+    INDEX idx_promotions_end_at (end_at),
+    INDEX idx_promotions_medicine_id (medicine_id),
+    INDEX idx_promotions_status (status)
+);
+
+-- 促销使用记录（每个销售明细享受的每项优惠一行）
+CREATE TABLE IF NOT EXISTS promotion_usages (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    promotion_id BIGINT NOT NULL,
+    promotion_name VARCHAR(100),
+    rule_type VARCHAR(20),
+    order_no VARCHAR(32),
+    sale_id BIGINT,
+    medicine_id BIGINT,
+    customer_id BIGINT,
+    quantity INT,
+    discount DECIMAL(10, 2) NOT NULL,
+    created_at DATETIME(3),
+    INDEX idx_promotion_usages_promotion_id (promotion_id),
+    INDEX idx_promotion_usages_order_no (order_no),
+    INDEX idx_promotion_usages_sale_id (sale_id),
+    INDEX idx_promotion_usages_created_at (created_at)
+);
+
+-- 会员积分流水（earn 消费获得 / redeem 抵扣 / reverse 退货扣回 / refund 退货返还）
+CREATE TABLE IF NOT EXISTS point_transactions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    customer_id BIGINT NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    points INT NOT NULL,
+    balance INT NOT NULL,
+    order_no VARCHAR(32),
+    return_id BIGINT,
+    operator_id BIGINT,
+    created_at DATETIME(3),
+    INDEX idx_point_customer_time (customer_id, created_at),
+    INDEX idx_point_transactions_order_no (order_no)
+);
+
+-- 订单收款明细（一单可拆分多种支付方式：现金、银行卡、微信、支付宝、医保卡、积分）
+CREATE TABLE IF NOT EXISTS payments (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    order_no VARCHAR(32) NOT NULL,
+    tender VARCHAR(20) NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    tendered DECIMAL(10, 2),
+    change_amount DECIMAL(10, 2),
+    reference VARCHAR(64),
+    provider VARCHAR(30),
+    provider_ref VARCHAR(64),
+    created_at DATETIME(3),
+    INDEX idx_payments_order_no (order_no),
+    INDEX idx_payments_tender (tender),
+    INDEX idx_payments_created_at (created_at)
+);
+
+-- 收银班次（开班备用金、结班清点现金及差额）
+CREATE TABLE IF NOT EXISTS shifts (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    cashier_id BIGINT NOT NULL,
+    status VARCHAR(20) DEFAULT 'open',
+    opening_float DECIMAL(10, 2) NOT NULL,
+    opened_at DATETIME(3),
+    closed_at DATETIME(3),
+    closed_by BIGINT,
+    expected_cash DECIMAL(10, 2),
+    counted_cash DECIMAL(10, 2),
+    cash_variance DECIMAL(10, 2),
+    note VARCHAR(255),
+    INDEX idx_shifts_cashier_id (cashier_id),
+    INDEX idx_shifts_status (status),
+    INDEX idx_shifts_opened_at (opened_at)
+);
+
+-- 班次各支付方式对账（应收、实点、差额）
+CREATE TABLE IF NOT EXISTS shift_counts (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    shift_id BIGINT NOT NULL,
+    tender VARCHAR(20) NOT NULL,
+    sales DECIMAL(10, 2),
+    refunds DECIMAL(10, 2),
+    expected DECIMAL(10, 2),
+    counted DECIMAL(10, 2),
+    variance DECIMAL(10, 2),
+    UNIQUE INDEX idx_shift_tender (shift_id, tender)
+);
+
+-- 挂单与报价单（明细不进入 sales，不触发库存触发器；预留库存到期自动释放）
+CREATE TABLE IF NOT EXISTS draft_orders (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    draft_no VARCHAR(32) NOT NULL UNIQUE,
+    kind VARCHAR(20) NOT NULL,
+    status VARCHAR(20) DEFAULT 'open',
+    customer_id BIGINT,
+    cashier_id BIGINT,
+    reserved BOOLEAN NOT NULL DEFAULT FALSE,
+    reserved_until DATETIME(3),
+    valid_until DATETIME(3),
+    total_amount DECIMAL(10, 2),
+    discount_amount DECIMAL(10, 2),
+    note VARCHAR(255),
+    order_no VARCHAR(32),
+    created_at DATETIME(3),
+    updated_at DATETIME(3),
+    INDEX idx_draft_orders_kind (kind),
+    INDEX idx_draft_orders_status (status),
+    INDEX idx_draft_orders_cashier_id (cashier_id),
+    INDEX idx_draft_orders_reserved_until (reserved_until)
+);
+
+-- 挂单 / 报价单明细
+CREATE TABLE IF NOT EXISTS draft_order_lines (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    draft_id BIGINT NOT NULL,
+    medicine_id BIGINT NOT NULL,
+    quantity INT NOT NULL,
+    unit_price DECIMAL(10, 2),
+    discount DECIMAL(10, 2),
+    amount DECIMAL(10, 2),
+    INDEX idx_draft_order_lines_draft_id (draft_id),
+    INDEX idx_draft_order_lines_medicine_id (medicine_id)
+);
+
+-- 销售作废申请（收银员申请、管理员审批，批准后销售行标记为 voided 并恢复库存）
+CREATE TABLE IF NOT EXISTS sale_voids (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    sale_id BIGINT NOT NULL,
+    order_no VARCHAR(50),
+    medicine_id BIGINT NOT NULL,
+    quantity INT NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    cashier_id BIGINT,
+    shift_id BIGINT,
+    reason VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    requested_by BIGINT,
+    approved_by BIGINT,
+    decided_at DATETIME(3),
+    note VARCHAR(255),
+    created_at DATETIME(3),
+    refund_tender VARCHAR(20),
+    tender_refund DECIMAL(10, 2),
+    refund_shift_id BIGINT,
+    INDEX idx_sale_voids_sale_id (sale_id),
+    INDEX idx_sale_voids_order_no (order_no),
+    INDEX idx_sale_voids_cashier_id (cashier_id),
+    INDEX idx_sale_voids_shift_id (shift_id),
+    INDEX idx_sale_voids_refund_shift_id (refund_shift_id),
+    INDEX idx_sale_voids_status (status),
+    INDEX idx_sale_voids_requested_by (requested_by),
+    INDEX idx_sale_voids_created_at (created_at)
+);
+
+-- 采购订单
+CREATE TABLE IF NOT EXISTS purchase_orders (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    po_no VARCHAR(32) NOT NULL UNIQUE,
+    supplier_id BIGINT NOT NULL,
+    status VARCHAR(20) DEFAULT 'draft',
+    remark VARCHAR(255),
+    total_amount DECIMAL(12, 2),
+    created_by BIGINT,
+    approved_by BIGINT,
+    approved_at DATETIME(3),
+    closed_by BIGINT DEFAULT 0,
+    closed_at DATETIME(3),
+    created_at DATETIME(3),
+    updated_at DATETIME(3),
+    INDEX idx_purchase_orders_supplier (supplier_id),
+    INDEX idx_purchase_orders_status (status)
+);
+
+-- 采购订单行
+CREATE TABLE IF NOT EXISTS purchase_order_lines (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    purchase_order_id BIGINT NOT NULL,
+    medicine_id BIGINT NOT NULL,
+    quantity INT NOT NULL,
+    expected_price DECIMAL(10, 2) NOT NULL,
+    received_qty INT NOT NULL DEFAULT 0,
+    INDEX idx_purchase_order_lines_order (purchase_order_id),
+    INDEX idx_purchase_order_lines_medicine (medicine_id)
+);
+
+-- 系统参数（由后端从 config.json 同步，供存储过程与视图读取）
+CREATE TABLE IF NOT EXISTS settings (
+    name VARCHAR(64) PRIMARY KEY,
+    value VARCHAR(255),
+    updated_at DATETIME(3)
+);
+
 -- 插入默认管理员
 INSERT IGNORE INTO users (username, password, role) VALUES ('admin', '$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy', 'admin');
 -- 密码是 'password' (bcrypt 哈希)
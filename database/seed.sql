@@ -799,3 +799,22 @@ INSERT INTO inbounds (medicine_id, supplier_id, quantity, price, inbound_date) V
 (33, 23, 10, 25.00, NOW()),
 (34, 24, 20, 18.00, NOW()),
 (35, 25, 40, 28.00, NOW());
+
+-- ==================== 药品有效成分 (用药安全检查) ====================
+UPDATE medicines SET ingredients = '阿莫西林' WHERE code = 'MED001';
+UPDATE medicines SET ingredients = '布洛芬' WHERE code = 'MED002';
+UPDATE medicines SET ingredients = '对乙酰氨基酚,马来酸氯苯那敏,咖啡因' WHERE code = 'MED003';
+UPDATE medicines SET ingredients = '阿司匹林' WHERE code = 'MED006';
+UPDATE medicines SET ingredients = '头孢克肟' WHERE code = 'MED007';
+UPDATE medicines SET ingredients = '氯雷他定' WHERE code = 'MED008';
+UPDATE medicines SET ingredients = '蔗糖,川贝母,枇杷叶' WHERE code = 'MED016';
+UPDATE medicines SET ingredients = '丹参,三七,冰片' WHERE code = 'MED017';
+UPDATE medicines SET ingredients = '川芎,冰片' WHERE code = 'MED018';
+UPDATE medicines SET ingredients = '阿奇霉素' WHERE code = 'MED019';
+UPDATE medicines SET ingredients = '红霉素' WHERE code = 'MED020';
+
+-- ==================== 销售成本 (成本核算) ====================
+-- 示例销售未经结算流程，按入库均价补记成本（需已运行 advanced_features.sql）
+CALL sp_backfill_costs();
+CALL sp_backfill_prices();
+CALL sp_backfill_payments();
@@ -3,6 +3,7 @@ import { BrowserRouter as Router, Routes, Route, Navigate } from 'react-router-d
 import Sidebar from './components/Sidebar';
 import Navbar from './components/Navbar';
 import Toast from './components/Toast';
+import { logout } from './api';
 
 import Login from './pages/Login';
 import Dashboard from './pages/Dashboard';
@@ -42,21 +43,25 @@ function App() {
     setToast({ message, type });
   };
 
-  const handleLogin = (user, token) => {
+  const handleLogin = (user, token, refreshToken) => {
     setCurrentUser(user);
     setIsLoggedIn(true);
     // Persist to localStorage
     localStorage.setItem('isLoggedIn', 'true');
     localStorage.setItem('currentUser', JSON.stringify(user));
     if (token) localStorage.setItem('token', token);
+    if (refreshToken) localStorage.setItem('refreshToken', refreshToken);
   };
 
   const handleLogout = () => {
+    // Revoke tokens on the server; ignore failures (e.g. already expired)
+    logout({ refresh_token: localStorage.getItem('refreshToken') }).catch(() => { });
     setCurrentUser(null);
     setIsLoggedIn(false);
     localStorage.removeItem('isLoggedIn');
     localStorage.removeItem('currentUser');
     localStorage.removeItem('token'); // Clear token too
+    localStorage.removeItem('refreshToken');
   };
 
   if (!isLoggedIn) {
@@ -2,6 +2,7 @@ import request from './request';
 
 // Auth
 export const login = (data) => request.post('/login', data);
+export const logout = (data) => request.post('/logout', data);
 
 // Dashboard
 export const getStats = () => request.get('/dashboard/stats');
@@ -17,6 +18,8 @@ export const getMedicines = (search, page = 1, limit = 10, filter = 'all') => re
 export const createMedicine = (data) => request.post('/medicines', data);
 export const updateMedicine = (id, data) => request.put(`/medicines/${id}`, data);
 export const deleteMedicine = (id) => request.delete(`/medicines/${id}`);
+export const getMedicineLots = (id) => request.get(`/medicines/${id}/lots`);
+export const getMedicineMovements = (id, params = {}) => request.get(`/medicines/${id}/movements`, { params });
 
 // Customers
 export const getCustomers = (keyword, page = 1, limit = 10) => request.get('/customers', { params: { keyword, page, limit } });
@@ -40,21 +43,115 @@ export const deleteInbound = (id) => request.delete(`/inbounds/${id}`);
 export const getSales = (keyword, type, page = 1, limit = 10) => request.get('/sales', { params: { keyword, type, page, limit } });
 export const createSale = (data) => request.post('/sales', data);
 export const updateSale = (id, data) => request.put(`/sales/${id}`, data);
-export const deleteSale = (id) => request.delete(`/sales/${id}`);
+export const deleteSale = (id, reason) => request.delete(`/sales/${id}`, { params: { reason } });
+export const getOrders = (keyword, page = 1, limit = 10) => request.get('/orders', { params: { keyword, page, limit } });
+export const getOrder = (orderNo) => request.get(`/orders/${orderNo}`);
 
 // Reports
 export const getInboundReport = (startDate, endDate) => request.get('/reports/inbound', { params: { start_date: startDate, end_date: endDate } });
 export const getInventoryReport = () => request.get('/reports/inventory');
 export const getSalesReport = (startDate, endDate) => request.get('/reports/sales', { params: { start_date: startDate, end_date: endDate } });
 export const getFinancialReport = (type) => request.get('/reports/financial', { params: { type } });
+export const getExpiryReport = (horizons) => request.get('/reports/expiry', { params: { horizons } });
+export const getExpiryAlerts = (days = 30) => request.get('/alerts/expiry', { params: { days } });
+
+// Purchasing
+export const getPurchaseSuggestions = (params = {}) => request.get('/purchasing/suggestions', { params });
+export const getPurchaseOrders = (params = {}) => request.get('/purchase-orders', { params });
+export const getPurchaseOrder = (id) => request.get(`/purchase-orders/${id}`);
+export const createPurchaseOrder = (data) => request.post('/purchase-orders', data);
+export const updatePurchaseOrder = (id, data) => request.put(`/purchase-orders/${id}`, data);
+export const approvePurchaseOrder = (id) => request.post(`/purchase-orders/${id}/approve`);
+export const closePurchaseOrder = (id) => request.post(`/purchase-orders/${id}/close`);
+export const getPurchaseOrderReport = (params = {}) => request.get('/reports/purchase-orders', { params });
 
 // Returns
 export const createSalesReturn = (data) => request.post('/returns/sales', data);
+export const getSalesReturns = (params) => request.get('/returns/sales', { params });
 export const createPurchaseReturn = (data) => request.post('/returns/purchase', data);
+export const checkSale = (data) => request.post('/sales/check', data);
+export const getCustomerHealth = (id) => request.get(`/customers/${id}/health`);
+export const updateCustomerHealth = (id, data) => request.put(`/customers/${id}/health`, data);
+export const getSafetyAcknowledgements = (params) => request.get('/safety/acknowledgements', { params });
+export const getPrescriptions = (params) => request.get('/prescriptions', { params });
+export const getPrescription = (id) => request.get(`/prescriptions/${id}`);
+export const createPrescription = (data) => request.post('/prescriptions', data);
+export const uploadPrescriptionImage = (id, file) => {
+    const form = new FormData();
+    form.append('image', file);
+    return request.post(`/prescriptions/${id}/image`, form);
+};
+export const verifyPrescription = (id, data) => request.post(`/prescriptions/${id}/verify`, data);
+export const getPurchaseReturns = (params) => request.get('/returns/purchase', { params });
+export const creditPurchaseReturn = (id, data) => request.post(`/returns/purchase/${id}/credit`, data);
 
 // Stock Adjustment
 export const adjustStock = (data) => request.post('/stock/adjust', data);
 
+// Stocktake
+export const getStocktakes = (params = {}) => request.get('/stocktakes', { params });
+export const getStocktake = (id) => request.get(`/stocktakes/${id}`);
+export const createStocktake = (data) => request.post('/stocktakes', data);
+export const recordStocktakeCounts = (id, items) => request.put(`/stocktakes/${id}/counts`, { items });
+export const getStocktakeVariance = (id) => request.get(`/stocktakes/${id}/variance`);
+export const approveStocktake = (id) => request.post(`/stocktakes/${id}/approve`);
+export const cancelStocktake = (id) => request.post(`/stocktakes/${id}/cancel`);
+
+// Recalls
+export const getRecalls = (params = {}) => request.get('/recalls', { params });
+export const createRecall = (data) => request.post('/recalls', data);
+export const getRecallCustomers = (id) => request.get(`/recalls/${id}/customers`);
+export const getRecallReport = (id) => request.get(`/reports/recalls/${id}`);
+export const createRecallReturn = (id) => request.post(`/recalls/${id}/return`);
+export const closeRecall = (id) => request.post(`/recalls/${id}/close`);
+
+// Traceability Codes
+export const getTraceCode = (code) => request.get(`/trace/${code}`);
+export const exportTraceCodes = (params) => request.get('/reports/trace-codes', { params, responseType: 'blob' });
+
+// Price History
+export const getMedicinePrices = (id, params = {}) => request.get(`/medicines/${id}/prices`, { params });
+export const scheduleMedicinePrice = (id, data) => request.post(`/medicines/${id}/prices`, data);
+export const cancelMedicinePrice = (id, priceId) => request.post(`/medicines/${id}/prices/${priceId}/cancel`);
+export const getPriceChangeReport = (params = {}) => request.get('/reports/price-changes', { params });
+
+// Promotions
+export const getPromotions = (params = {}) => request.get('/promotions', { params });
+export const createPromotion = (data) => request.post('/promotions', data);
+export const updatePromotion = (id, data) => request.put(`/promotions/${id}`, data);
+export const deletePromotion = (id) => request.delete(`/promotions/${id}`);
+export const getPromotionReport = (params = {}) => request.get('/reports/promotions', { params });
+
+// Membership & Loyalty Points
+export const getCustomerPoints = (id, params = {}) => request.get(`/customers/${id}/points`, { params });
+export const refreshMemberTiers = () => request.post('/customers/tiers/refresh');
+
+// Cashier Shifts
+export const openShift = (data) => request.post('/shifts/open', data);
+export const getCurrentShift = () => request.get('/shifts/current');
+export const closeShift = (id, data) => request.post(`/shifts/${id}/close`, data);
+export const getShifts = (params = {}) => request.get('/shifts', { params });
+export const getShiftReport = (id) => request.get(`/shifts/${id}/report`);
+
+// Held Orders & Quotes
+export const getDrafts = (params = {}) => request.get('/drafts', { params });
+export const getDraft = (id) => request.get(`/drafts/${id}`);
+export const createDraft = (data) => request.post('/drafts', data);
+export const updateDraft = (id, data) => request.put(`/drafts/${id}`, data);
+export const cancelDraft = (id) => request.post(`/drafts/${id}/cancel`);
+export const checkoutDraft = (id, data = {}) => request.post(`/drafts/${id}/checkout`, data);
+
+// Sale Voids
+export const voidSale = (id, data) => request.post(`/sales/${id}/void`, data);
+export const getSaleVoids = (params = {}) => request.get('/voids', { params });
+export const approveVoid = (id, data = {}) => request.post(`/voids/${id}/approve`, data);
+export const rejectVoid = (id, data = {}) => request.post(`/voids/${id}/reject`, data);
+export const getVoidReport = (params = {}) => request.get('/reports/voids', { params });
+
+// Receipts & Invoices
+export const getSaleReceipt = (orderId, params = {}) => request.get(`/sales/${orderId}/receipt`, { params, responseType: 'blob' });
+export const getSaleInvoice = (orderId, params = {}) => request.get(`/sales/${orderId}/invoice`, { params, responseType: params.format === 'csv' ? 'blob' : 'json' });
+
 // System Maintenance
 export const backupDatabase = () => request.get('/system/backup');
 export const restoreDatabase = (data) => request.post('/system/restore', data);
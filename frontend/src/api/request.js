@@ -57,14 +57,60 @@ request.interceptors.request.use(
     }
 );
 
+// Clear the session and go back to the login page
+const forceLogout = () => {
+    localStorage.removeItem('isLoggedIn');
+    localStorage.removeItem('currentUser');
+    localStorage.removeItem('token');
+    localStorage.removeItem('refreshToken');
+    window.location.reload();
+};
+
+// Single in-flight refresh shared by concurrent 401 responses
+let refreshPromise = null;
+
+const refreshAccessToken = () => {
+    if (!refreshPromise) {
+        const refreshToken = localStorage.getItem('refreshToken');
+        refreshPromise = axios
+            .post('/api/auth/refresh', { refresh_token: refreshToken })
+            .then((res) => {
+                localStorage.setItem('token', res.data.token);
+                localStorage.setItem('refreshToken', res.data.refresh_token);
+                return res.data.token;
+            })
+            .finally(() => {
+                refreshPromise = null;
+            });
+    }
+    return refreshPromise;
+};
+
 request.interceptors.response.use(
     (response) => {
         hideLoading();
         return response.data;
     },
-    (error) => {
+    async (error) => {
         hideLoading();
         // console.error('API Error:', error);
+        const original = error.config;
+        const isAuthCall = original?.url === '/login' || original?.url === '/logout';
+        if (error.response?.status === 401 && original && !original._retry && !isAuthCall) {
+            if (!localStorage.getItem('refreshToken')) {
+                forceLogout();
+                return Promise.reject(error);
+            }
+            original._retry = true;
+            try {
+                const token = await refreshAccessToken();
+                original.headers.Authorization = `Bearer ${token}`;
+                return request(original);
+            } catch (refreshError) {
+                forceLogout();
+                return Promise.reject(refreshError);
+            }
+        }
         return Promise.reject(error);
     }
 );
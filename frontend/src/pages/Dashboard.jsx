@@ -1,12 +1,12 @@
 import React, { useState, useEffect } from 'react';
 import { useNavigate } from 'react-router-dom';
-import { Pill, ShoppingCart, AlertCircle, TrendingUp, BarChart2, ChevronRight } from 'lucide-react';
+import { Pill, ShoppingCart, AlertCircle, CalendarClock, TrendingUp, BarChart2, ChevronRight } from 'lucide-react';
 import StatCard from '../components/StatCard';
 import * as api from '../api';
 
 export default function Dashboard() {
     const navigate = useNavigate();
-    const [stats, setStats] = useState({ total_stock: 0, month_sales: 0, low_stock: 0, top_selling: [], sales_trend: [] });
+    const [stats, setStats] = useState({ total_stock: 0, month_sales: 0, low_stock: 0, expiring: 0, top_selling: [], sales_trend: [] });
 
     useEffect(() => {
         const fetchStats = async () => {
@@ -42,7 +42,7 @@ export default function Dashboard() {
 
     return (
         <div className="space-y-6">
-            <div className="grid grid-cols-1 md:grid-cols-3 gap-6">
+            <div className="grid grid-cols-1 md:grid-cols-2 xl:grid-cols-4 gap-6">
                 <div onClick={() => navigate('/inventory')} className="cursor-pointer card-hover">
                     <StatCard title="库存总量" value={stats.total_stock} trend="实时" icon={Pill} color="teal" />
                 </div>
@@ -50,7 +50,10 @@ export default function Dashboard() {
                     <StatCard title="本月销售" value={`¥ ${stats.month_sales}`} trend="实时" icon={ShoppingCart} color="emerald" />
                 </div>
                 <div onClick={() => navigate('/inventory?filter=low_stock')} className="cursor-pointer card-hover">
-                    <StatCard title="临期/缺货预警" value={stats.low_stock} trend="需要补货" icon={AlertCircle} color="amber" isAlert={stats.low_stock > 0} />
+                    <StatCard title="缺货预警" value={stats.low_stock} trend="需要补货" icon={AlertCircle} color="amber" isAlert={stats.low_stock > 0} />
+                </div>
+                <div onClick={() => navigate('/reports')} className="cursor-pointer card-hover">
+                    <StatCard title="效期预警" value={stats.expiring} trend="30天内到期/已过期批次" icon={CalendarClock} color="amber" isAlert={stats.expiring > 0} />
                 </div>
             </div>
 
@@ -13,6 +13,8 @@ export default function InboundManagement({ showToast }) {
     const [isReturnModalOpen, setIsReturnModalOpen] = useState(false);
     const [selectedReturnItem, setSelectedReturnItem] = useState(null);
     const [returnReason, setReturnReason] = useState('');
+    const [returnCode, setReturnCode] = useState('damaged');
+    const [returnQuantity, setReturnQuantity] = useState('');
 
     // Selections for Modal
     const [medicines, setMedicines] = useState([]);
@@ -24,7 +26,7 @@ export default function InboundManagement({ showToast }) {
     const [searchTerm, setSearchTerm] = useState('');
 
     // Get user role from localStorage
-    const userRole = JSON.parse(localStorage.getItem('user') || '{}').role || 'staff';
+    const userRole = JSON.parse(localStorage.getItem('currentUser') || '{}').role || 'staff';
 
     const fetchInbounds = async (page = 1, keyword = '') => {
         try {
@@ -124,18 +126,18 @@ export default function InboundManagement({ showToast }) {
     const handleReturnClick = (item) => {
         setSelectedReturnItem(item);
         setReturnReason('');
+        setReturnCode('damaged');
+        setReturnQuantity('');
         setIsReturnModalOpen(true);
     };
 
     const handleSubmitReturn = async () => {
-        if (!returnReason.trim()) {
-            if (showToast) showToast('请填写退货原因', 'error');
-            return;
-        }
         try {
             await api.createPurchaseReturn({
                 inbound_id: selectedReturnItem.id,
-                reason: returnReason
+                quantity: parseInt(returnQuantity) || 0,
+                reason_code: returnCode,
+                remark: returnReason
             });
             if (showToast) showToast('采购退货处理成功');
             setIsReturnModalOpen(false);
@@ -336,20 +338,46 @@ export default function InboundManagement({ showToast }) {
                             <p className="text-xs text-orange-600 mt-1">
                                 您正在处理药品 <b>{selectedReturnItem?.medicine_name}</b> 的入库退货。
                                 <br />
-                                数量: {selectedReturnItem?.quantity}
+                                入库数量: {selectedReturnItem?.quantity}
                                 <br />
-                                确认后库存将相应减少。
+                                确认后库存将相应减少，原入库记录保留。
                             </p>
                         </div>
                     </div>
+                    <div className="grid grid-cols-2 gap-3">
+                        <div>
+                            <label className="block text-sm font-medium text-slate-700 mb-1">退货原因</label>
+                            <select
+                                className="input-field"
+                                value={returnCode}
+                                onChange={(e) => setReturnCode(e.target.value)}
+                            >
+                                <option value="damaged">破损</option>
+                                <option value="expired">过期</option>
+                                <option value="recalled">召回</option>
+                                <option value="wrong_item">错发</option>
+                            </select>
+                        </div>
+                        <div>
+                            <label className="block text-sm font-medium text-slate-700 mb-1">退货数量</label>
+                            <input
+                                type="number"
+                                min="1"
+                                className="input-field"
+                                value={returnQuantity}
+                                onChange={(e) => setReturnQuantity(e.target.value)}
+                                placeholder="留空则全部退回"
+                            />
+                        </div>
+                    </div>
                     <div>
-                        <label className="block text-sm font-medium text-slate-700 mb-1">退货原因</label>
+                        <label className="block text-sm font-medium text-slate-700 mb-1">备注</label>
                         <textarea
                             className="w-full px-3 py-2 border border-slate-300 rounded-lg focus:ring-2 focus:ring-orange-500/20 focus:border-orange-500 outline-none transition-all resize-none"
                             rows="3"
                             value={returnReason}
                             onChange={(e) => setReturnReason(e.target.value)}
-                            placeholder="请输入退货原因..."
+                            placeholder="补充说明 (可选)..."
                         ></textarea>
                     </div>
                     <div className="pt-4 flex justify-end space-x-3">
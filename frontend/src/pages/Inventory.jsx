@@ -5,6 +5,19 @@ import * as api from '../api';
 import Modal from '../components/Modal';
 import Pagination from '../components/Pagination';
 
+// Falls back to the old fixed threshold if the server did not send one
+const isLow = (item) => item.stock < (item.effective_reorder_point ?? 50);
+
+// Empty inputs are sent as null so the configured default applies
+const stockLevels = (form) => {
+    const toInt = (v) => (v === '' || v === null || v === undefined ? null : parseInt(v));
+    return {
+        min_stock: toInt(form.min_stock),
+        max_stock: toInt(form.max_stock),
+        reorder_point: toInt(form.reorder_point),
+    };
+};
+
 export default function Inventory({ showToast }) {
     const navigate = useNavigate();
     const location = useLocation();
@@ -22,7 +35,7 @@ export default function Inventory({ showToast }) {
     const [filterStatus, setFilterStatus] = useState(getInitialFilter);
 
     const [isModalOpen, setIsModalOpen] = useState(false);
-    const [formData, setFormData] = useState({ name: '', code: '', price: '', stock: '', spec: '', manufacturer: '' });
+    const [formData, setFormData] = useState({ name: '', code: '', price: '', stock: '', spec: '', manufacturer: '', min_stock: '', max_stock: '', reorder_point: '' });
     const [editingId, setEditingId] = useState(null);
 
     // Adjust Stock Modal State
@@ -63,10 +76,12 @@ export default function Inventory({ showToast }) {
         if (!formData.name) return;
         try {
             if (editingId) {
+                // 库存只能通过库存调整或盘点修改，编辑时不回传
+                const { stock, ...fields } = formData;
                 await api.updateMedicine(editingId, {
-                    ...formData,
+                    ...fields,
                     price: parseFloat(formData.price) || 0,
-                    stock: parseInt(formData.stock) || 0,
+                    ...stockLevels(formData),
                 });
                 if (showToast) showToast('药品信息更新成功');
             } else {
@@ -76,15 +91,16 @@ export default function Inventory({ showToast }) {
                     type: 'OTC',
                     price: parseFloat(formData.price) || 0,
                     stock: parseInt(formData.stock) || 0,
+                    ...stockLevels(formData),
                 });
                 if (showToast) showToast('新药品入库成功');
             }
             setIsModalOpen(false);
-            setFormData({ name: '', code: '', price: '', stock: '', spec: '', manufacturer: '' });
+            setFormData({ name: '', code: '', price: '', stock: '', spec: '', manufacturer: '', min_stock: '', max_stock: '', reorder_point: '' });
             setEditingId(null);
             fetchData(meta.page);
         } catch (err) {
-            if (showToast) showToast('操作失败', 'error');
+            if (showToast) showToast(err.response?.data?.error || '操作失败', 'error');
         }
     };
 
@@ -95,7 +111,10 @@ export default function Inventory({ showToast }) {
             price: item.price,
             stock: item.stock,
             spec: item.spec,
-            manufacturer: item.manufacturer
+            manufacturer: item.manufacturer,
+            min_stock: item.min_stock ?? '',
+            max_stock: item.max_stock ?? '',
+            reorder_point: item.reorder_point ?? ''
         });
         setEditingId(item.id);
         setIsModalOpen(true);
@@ -172,7 +191,7 @@ export default function Inventory({ showToast }) {
                     <button
                         onClick={() => {
                             setEditingId(null);
-                            setFormData({ name: '', code: '', price: '', stock: '', spec: '', manufacturer: '' });
+                            setFormData({ name: '', code: '', price: '', stock: '', spec: '', manufacturer: '', min_stock: '', max_stock: '', reorder_point: '' });
                             setIsModalOpen(true);
                         }}
                         className="btn-primary flex items-center justify-center whitespace-nowrap w-full sm:w-auto"
@@ -216,14 +235,14 @@ export default function Inventory({ showToast }) {
                                 </td>
                                 <td className="px-6 py-4 whitespace-nowrap">
                                     <div className="flex items-center">
-                                        <span className={`font-medium ${item.stock < 50 ? 'text-red-600' : 'text-slate-600'}`}>
+                                        <span className={`font-medium ${isLow(item) ? 'text-red-600' : 'text-slate-600'}`}>
                                             {item.stock}
                                         </span>
                                     </div>
 
                                     <div className="w-24 h-1.5 bg-slate-100 rounded-full mt-1.5 overflow-hidden" title={`库存: ${item.stock}`}>
                                         <div
-                                            className={`h-full rounded-full ${item.stock < 50 ? 'bg-red-500' : 'bg-teal-500'}`}
+                                            className={`h-full rounded-full ${isLow(item) ? 'bg-red-500' : 'bg-teal-500'}`}
                                             style={{ width: `${Math.min((item.stock / 100) * 100, 100)}%` }}
                                         ></div>
                                     </div>
@@ -305,10 +324,42 @@ export default function Inventory({ showToast }) {
                                 type="number"
                                 className="w-full px-3 py-2 border border-slate-300 rounded-lg focus:ring-2 focus:ring-teal-500/20 focus:border-teal-500 outline-none transition-all"
                                 value={formData.stock}
+                                disabled={!!editingId}
+                                title={editingId ? '请使用库存调整修改库存' : undefined}
                                 onChange={(e) => setFormData({ ...formData, stock: e.target.value })}
                             />
                         </div>
                     </div>
+                    <div className="grid grid-cols-3 gap-4">
+                        <div>
+                            <label className="block text-sm font-medium text-slate-700 mb-1">安全库存</label>
+                            <input
+                                type="number"
+                                className="w-full px-3 py-2 border border-slate-300 rounded-lg focus:ring-2 focus:ring-teal-500/20 focus:border-teal-500 outline-none transition-all"
+                                value={formData.min_stock}
+                                onChange={(e) => setFormData({ ...formData, min_stock: e.target.value })}
+                            />
+                        </div>
+                        <div>
+                            <label className="block text-sm font-medium text-slate-700 mb-1">补货点</label>
+                            <input
+                                type="number"
+                                className="w-full px-3 py-2 border border-slate-300 rounded-lg focus:ring-2 focus:ring-teal-500/20 focus:border-teal-500 outline-none transition-all"
+                                value={formData.reorder_point}
+                                onChange={(e) => setFormData({ ...formData, reorder_point: e.target.value })}
+                                placeholder="默认"
+                            />
+                        </div>
+                        <div>
+                            <label className="block text-sm font-medium text-slate-700 mb-1">最高库存</label>
+                            <input
+                                type="number"
+                                className="w-full px-3 py-2 border border-slate-300 rounded-lg focus:ring-2 focus:ring-teal-500/20 focus:border-teal-500 outline-none transition-all"
+                                value={formData.max_stock}
+                                onChange={(e) => setFormData({ ...formData, max_stock: e.target.value })}
+                            />
+                        </div>
+                    </div>
                     <div className="pt-4 flex justify-end space-x-3">
                         <button
                             onClick={() => setIsModalOpen(false)}
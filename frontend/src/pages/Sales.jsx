@@ -26,7 +26,7 @@ export default function Sales({ showToast }) {
     const [showPrescriptionOnly, setShowPrescriptionOnly] = useState(false);
 
     // Get user role from localStorage
-    const userRole = JSON.parse(localStorage.getItem('user') || '{}').role || 'staff';
+    const userRole = JSON.parse(localStorage.getItem('currentUser') || '{}').role || 'staff';
 
     const fetchSales = async (page = 1, keyword = '', type = '') => {
         try {
@@ -119,14 +119,20 @@ export default function Sales({ showToast }) {
         setIsModalOpen(true);
     };
 
-    const handleDelete = async (id) => {
-        if (!window.confirm('确定要删除这条销售记录吗？库存将自动恢复。')) return;
+    // 作废：提交申请，须由另一位管理员审批
+    const handleVoid = async (id) => {
+        const reason = window.prompt('请输入作废原因（提交后需另一位管理员审批）：');
+        if (reason === null) return;
+        if (!reason.trim()) {
+            if (showToast) showToast('请填写作废原因', 'error');
+            return;
+        }
         try {
-            await api.deleteSale(id);
-            if (showToast) showToast('销售记录删除成功');
+            await api.voidSale(id, { reason: reason.trim() });
+            if (showToast) showToast('作废申请已提交，等待审批');
             fetchSales(meta.page);
         } catch (err) {
-            if (showToast) showToast('删除失败', 'error');
+            if (showToast) showToast('作废失败: ' + (err.response?.data?.error || '未知错误'), 'error');
         }
     };
 
@@ -212,9 +218,9 @@ export default function Sales({ showToast }) {
                         </thead>
                         <tbody className="divide-y divide-slate-50 text-sm">
                             {sales.map((item) => (
-                                <tr key={item.id} className="hover:bg-slate-50 transition-colors">
-                                    <td className="px-6 py-4 text-slate-500 font-mono whitespace-nowrap">{item.order_id}</td>
-                                    <td className="px-6 py-4 font-medium text-slate-700 whitespace-nowrap">
+                                <tr key={item.id} className={`hover:bg-slate-50 transition-colors ${item.status === 'voided' ? 'opacity-60' : ''}`}>
+                                    <td className={`px-6 py-4 text-slate-500 font-mono whitespace-nowrap ${item.status === 'voided' ? 'line-through' : ''}`}>{item.order_id}</td>
+                                    <td className={`px-6 py-4 font-medium text-slate-700 whitespace-nowrap ${item.status === 'voided' ? 'line-through' : ''}`}>
                                         <div className="flex items-center gap-2">
                                             {item.medicine_name || item.medicine?.name || '未知药品'}
                                             {item.medicine_type && (
@@ -227,42 +233,50 @@ export default function Sales({ showToast }) {
                                             )}
                                         </div>
                                     </td>
-                                    <td className="px-6 py-4 text-slate-600 whitespace-nowrap">
+                                    <td className={`px-6 py-4 text-slate-600 whitespace-nowrap ${item.status === 'voided' ? 'line-through' : ''}`}>
                                         <div className="flex items-center">
                                             <User size={14} className="mr-2 text-slate-300" />
                                             {item.customer_name || item.customer?.name || '未知客户'}
                                         </div>
                                     </td>
-                                    <td className="px-6 py-4 text-blue-600 font-medium whitespace-nowrap">{item.quantity}</td>
-                                    <td className="px-6 py-4 text-slate-800 font-bold whitespace-nowrap">¥{item.total_price.toFixed(2)}</td>
-                                    <td className="px-6 py-4 text-slate-400 text-xs whitespace-nowrap">
+                                    <td className={`px-6 py-4 text-blue-600 font-medium whitespace-nowrap ${item.status === 'voided' ? 'line-through' : ''}`}>{item.quantity}</td>
+                                    <td className={`px-6 py-4 text-slate-800 font-bold whitespace-nowrap ${item.status === 'voided' ? 'line-through' : ''}`}>¥{item.total_price.toFixed(2)}</td>
+                                    <td className={`px-6 py-4 text-slate-400 text-xs whitespace-nowrap ${item.status === 'voided' ? 'line-through' : ''}`}>
                                         {new Date(item.sale_date).toLocaleString()}
                                     </td>
                                     <td className="px-6 py-4 text-right whitespace-nowrap">
                                         <div className="flex items-center justify-end gap-2">
-                                            {userRole === 'admin' && (
-                                                <>
-                                                    <button
-                                                        onClick={() => handleEdit(item)}
-                                                        className="text-teal-600 hover:text-teal-800 font-medium text-xs"
-                                                    >
-                                                        编辑
-                                                    </button>
-                                                    <button
-                                                        onClick={() => handleDelete(item.id)}
-                                                        className="text-red-500 hover:text-red-700 font-medium text-xs"
-                                                    >
-                                                        删除
-                                                    </button>
-                                                </>
+                                            {item.status === 'voided' && (
+                                                <span className="px-2 py-0.5 rounded text-xs border bg-slate-50 text-slate-500 border-slate-200">已作废</span>
+                                            )}
+                                            {item.status !== 'voided' && item.void_pending && (
+                                                <span className="px-2 py-0.5 rounded text-xs border bg-amber-50 text-amber-600 border-amber-100">作废待审批</span>
+                                            )}
+                                            {item.status !== 'voided' && userRole === 'admin' && (
+                                                <button
+                                                    onClick={() => handleEdit(item)}
+                                                    className="text-teal-600 hover:text-teal-800 font-medium text-xs"
+                                                >
+                                                    编辑
+                                                </button>
+                                            )}
+                                            {item.status !== 'voided' && !item.void_pending && (
+                                                <button
+                                                    onClick={() => handleVoid(item.id)}
+                                                    className="text-red-500 hover:text-red-700 font-medium text-xs"
+                                                >
+                                                    申请作废
+                                                </button>
+                                            )}
+                                            {item.status !== 'voided' && (
+                                                <button
+                                                    onClick={() => handleReturnClick(item)}
+                                                    className="text-orange-500 hover:text-orange-700 font-medium text-xs flex items-center gap-1"
+                                                >
+                                                    <RotateCcw size={14} />
+                                                    退货
+                                                </button>
                                             )}
-                                            <button
-                                                onClick={() => handleReturnClick(item)}
-                                                className="text-orange-500 hover:text-orange-700 font-medium text-xs flex items-center gap-1"
-                                            >
-                                                <RotateCcw size={14} />
-                                                退货
-                                            </button>
                                         </div>
                                     </td>
                                 </tr>
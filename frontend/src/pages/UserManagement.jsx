@@ -118,7 +118,7 @@ export default function UserManagement({ showToast }) {
                                     <td className="px-6 py-4 whitespace-nowrap">
                                         <span className={`inline-flex items-center px-2.5 py-0.5 rounded-full text-xs font-medium ${user.role === 'admin' ? 'bg-purple-100 text-purple-800' : 'bg-blue-100 text-blue-800'}`}>
                                             {user.role === 'admin' ? <Shield size={12} className="mr-1" /> : <User size={12} className="mr-1" />}
-                                            {user.role === 'admin' ? '管理员' : '员工'}
+                                            {user.role === 'admin' ? '管理员' : user.role === 'viewer' ? '访客 (只读)' : user.role === 'pharmacist' ? '药师' : '员工'}
                                         </span>
                                     </td>
                                     <td className="px-6 py-4 text-slate-400 whitespace-nowrap">{new Date(user.created_at).toLocaleDateString()}</td>
@@ -192,6 +192,8 @@ export default function UserManagement({ showToast }) {
                             onChange={(e) => setFormData({ ...formData, role: e.target.value })}
                         >
                             <option value="staff">普通员工</option>
+                            <option value="pharmacist">药师</option>
+                            <option value="viewer">访客 (只读)</option>
                             <option value="admin">管理员</option>
                         </select>
                     </div>